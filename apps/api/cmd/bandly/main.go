@@ -0,0 +1,126 @@
+// Command bandly runs maintenance tasks against the BandLy database.
+//
+// Usage:
+//
+//	bandly admin create --email admin@example.com
+//	bandly admin set-password --email admin@example.com
+//	bandly migrate up [N]
+//	bandly migrate down [N]
+//	bandly migrate status
+//	bandly migrate adopt
+//	bandly migrate baseline [--down]
+//
+// The password is read from BANDLY_PASSWORD, or else from the first line
+// of standard input, so it never appears in the process list.
+//
+// migrate up applies all pending migrations, or the next N; migrate down
+// reverts the last one, or the last N. A database created by the old
+// AutoMigrate at boot is marked as at the baseline once with migrate adopt,
+// after checking it has every table, column and index of the baseline.
+// migrate baseline prints the baseline migration for the current models.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/sidigigroup/bandly/api/internal"
+	"gorm.io/gorm"
+)
+
+func main() {
+	_ = godotenv.Load()
+	log.SetFlags(0)
+
+	if len(os.Args) < 3 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "admin":
+		adminCommand(os.Args[2], os.Args[3:])
+	case "migrate":
+		migrateCommand(os.Args[2], os.Args[3:])
+	default:
+		usage()
+	}
+}
+
+func adminCommand(cmd string, args []string) {
+	switch cmd {
+	case "create":
+		email := parseEmail("admin create", args)
+		admin, err := internal.CreateAdmin(openDB(), email, readPassword())
+		if err != nil {
+			log.Fatalf("Failed to create admin: %v", err)
+		}
+		log.Printf("Admin %s created (id %d)", admin.Email, admin.ID)
+	case "set-password":
+		email := parseEmail("admin set-password", args)
+		if err := internal.SetPassword(openDB(), email, readPassword()); err != nil {
+			log.Fatalf("Failed to set password: %v", err)
+		}
+		log.Printf("Password of %s updated, all their sessions were signed out", email)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bandly admin create|set-password --email EMAIL")
+	fmt.Fprintln(os.Stderr, "       bandly migrate up|down [N]")
+	fmt.Fprintln(os.Stderr, "       bandly migrate status|adopt")
+	fmt.Fprintln(os.Stderr, "       bandly migrate baseline [--down]")
+	os.Exit(2)
+}
+
+// parseEmail reads the --email flag of a subcommand
+func parseEmail(name string, args []string) string {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	email := fs.String("email", "", "account email")
+	fs.Parse(args)
+	if *email == "" {
+		usage()
+	}
+	return *email
+}
+
+// readPassword takes the password from BANDLY_PASSWORD or standard input
+func readPassword() string {
+	if password := os.Getenv("BANDLY_PASSWORD"); password != "" {
+		return password
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalf("Failed to read password: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// openDB connects to DB_DSN and checks the schema is up to date
+func openDB() *gorm.DB {
+	db := connect()
+	if err := internal.PrepareDB(context.Background(), db); err != nil {
+		log.Fatalf("Database not ready: %v", err)
+	}
+	return db
+}
+
+// connect opens DB_DSN without looking at the schema
+func connect() *gorm.DB {
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		log.Fatal("DB_DSN is not set")
+	}
+	db, err := internal.OpenDB(dsn)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+	return db
+}
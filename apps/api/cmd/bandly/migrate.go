@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/sidigigroup/bandly/api/internal"
+	"github.com/sidigigroup/bandly/api/internal/migrations"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// baselineVersion is the migration that matches a schema built by AutoMigrate
+const baselineVersion = 1
+
+func migrateCommand(cmd string, args []string) {
+	ctx := context.Background()
+
+	switch cmd {
+	case "up":
+		db, runner := openRunner()
+		checkAdopted(ctx, db, runner)
+		done, err := runner.Up(ctx, parseCount(args))
+		logMigrations("Applied", done)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(done) == 0 {
+			log.Println("Database is up to date")
+		}
+	case "down":
+		_, runner := openRunner()
+		done, err := runner.Down(ctx, parseCount(args))
+		logMigrations("Reverted", done)
+		if err != nil {
+			log.Fatalf("Revert failed: %v", err)
+		}
+		if len(done) == 0 {
+			log.Println("No migrations to revert")
+		}
+	case "status":
+		_, runner := openRunner()
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migrations: %v", err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			switch {
+			case s.Missing:
+				state += " (no file in this build)"
+			case s.Modified:
+				state += " (file changed since)"
+			}
+			fmt.Printf("%-40s %s\n", s.Migration, state)
+		}
+	case "adopt":
+		db, runner := openRunner()
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migrations: %v", err)
+		}
+		if appliedCount(statuses) > 0 {
+			log.Fatal("Database already has migrations applied, nothing to adopt")
+		}
+		if !db.Migrator().HasTable("users") {
+			log.Fatal("Database has no tables, run `bandly migrate up` instead")
+		}
+		checkBaseline(ctx, runner)
+		done, err := runner.MarkApplied(ctx, baselineVersion)
+		if err != nil {
+			log.Fatalf("Adopt failed: %v", err)
+		}
+		logMigrations("Marked as applied", done)
+	case "baseline":
+		fs := flag.NewFlagSet("migrate baseline", flag.ExitOnError)
+		down := fs.Bool("down", false, "print the down migration")
+		fs.Parse(args)
+		up, downSQL, err := internal.BaselineSQL()
+		if err != nil {
+			log.Fatalf("Failed to render baseline: %v", err)
+		}
+		if *down {
+			fmt.Print(downSQL)
+		} else {
+			fmt.Print(up)
+		}
+	default:
+		usage()
+	}
+}
+
+// openRunner connects quietly, migrations log their own progress
+func openRunner() (*gorm.DB, *migrations.Runner) {
+	db := connect()
+	if internal.IsSQLite(db) {
+		log.Fatal("Migrations are for Postgres, SQLite databases get their tables when the API starts")
+	}
+	db.Logger = logger.Default.LogMode(logger.Warn)
+	runner, err := internal.MigrationRunner(db)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	return db, runner
+}
+
+// checkAdopted stops migrate up from running the baseline over tables
+// that AutoMigrate already created
+func checkAdopted(ctx context.Context, db *gorm.DB, runner *migrations.Runner) {
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		log.Fatalf("Failed to read migrations: %v", err)
+	}
+	if appliedCount(statuses) == 0 && db.Migrator().HasTable("users") {
+		log.Fatal("Database was created without migrations: run `bandly migrate adopt` once")
+	}
+}
+
+// checkBaseline refuses to adopt a database that lacks part of the
+// baseline, since marking it applied would leave those objects missing
+// for good
+func checkBaseline(ctx context.Context, runner *migrations.Runner) {
+	for _, m := range runner.Migrations {
+		if m.Version > baselineVersion {
+			break
+		}
+		missing, err := runner.Missing(ctx, m)
+		if err != nil {
+			log.Fatalf("Failed to read the schema: %v", err)
+		}
+		if len(missing) > 0 {
+			log.Fatalf("Database does not match %s, it lacks:\n  %s\nCreate them (`bandly migrate baseline` prints the DDL) and run adopt again",
+				m, strings.Join(missing, "\n  "))
+		}
+	}
+}
+
+func appliedCount(statuses []migrations.Status) int {
+	n := 0
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// parseCount reads the optional N of migrate up and down
+func parseCount(args []string) int {
+	if len(args) == 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 || len(args) > 1 {
+		usage()
+	}
+	return n
+}
+
+func logMigrations(verb string, done []migrations.Migration) {
+	for _, m := range done {
+		log.Printf("%s %s", verb, m)
+	}
+}
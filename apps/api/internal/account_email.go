@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	verifyEmailPurpose   = "email-verify"
+	resetPasswordPurpose = "password-reset"
+
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+
+	// unverifiedDailyEssays is how many essays an account can analyse per
+	// day before its email is confirmed
+	unverifiedDailyEssays = 3
+)
+
+var (
+	errEmailUnverified = errors.New("email not verified")
+	errLinkUsed        = errors.New("link already used")
+)
+
+// normalizeEmail trims an address and reports whether it is a plain
+// "user@domain.tld" address
+func normalizeEmail(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || addr.Name != "" {
+		return "", false
+	}
+	at := strings.LastIndex(s, "@")
+	if at < 1 || !strings.Contains(s[at+1:], ".") {
+		return "", false
+	}
+	return s, true
+}
+
+// tokenBinding fingerprints account state an emailed token depends on
+func tokenBinding(state string) string {
+	return hashToken(state)[:16]
+}
+
+// appLink builds a link into the web app carrying a token
+func appLink(path, token string) string {
+	return appURL(path) + "?token=" + url.QueryEscape(token)
+}
+
+// appURL returns the web app URL of a path
+func appURL(path string) string {
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:3000"
+	}
+	return baseURL + path
+}
+
+// sendVerificationEmail emails the user a link confirming their address.
+// The token is bound to the address, so changing it voids older links.
+func sendVerificationEmail(ctx context.Context, auth *AuthService, mailer Mailer, user User) error {
+	token, err := auth.IssueAction(user.ID, verifyEmailPurpose, tokenBinding(user.Email), verifyEmailTTL, time.Now())
+	if err != nil {
+		return err
+	}
+	msg, err := renderEmail("verify_email", user.Email, emailData{Link: appLink("/verify-email", token), Expires: "48 hours"})
+	if err != nil {
+		return err
+	}
+	return mailer.Send(ctx, msg)
+}
+
+// sendPasswordResetEmail emails a reset link. The token is bound to the
+// password hash, so it stops working once the password changes.
+func sendPasswordResetEmail(ctx context.Context, auth *AuthService, mailer Mailer, user User) error {
+	token, err := auth.IssueAction(user.ID, resetPasswordPurpose, tokenBinding(user.PassHash), resetPasswordTTL, time.Now())
+	if err != nil {
+		return err
+	}
+	msg, err := renderEmail("reset_password", user.Email, emailData{Link: appLink("/reset-password", token), Expires: "1 hour"})
+	if err != nil {
+		return err
+	}
+	return mailer.Send(ctx, msg)
+}
+
+// checkUserQuota limits accounts with an unconfirmed email to
+// unverifiedDailyEssays free analyses per day. Assignment submissions are
+// not counted, so classwork is never blocked.
+func checkUserQuota(db *gorm.DB, userID *uint) error {
+	if userID == nil {
+		return nil
+	}
+	var user User
+	if err := db.First(&user, *userID).Error; err != nil || user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	var used int64
+	db.Model(&Essay{}).Where("user_id = ? AND assignment_id IS NULL AND created_at >= ?", *userID, time.Now().Add(-24*time.Hour)).Count(&used)
+	if used >= unverifiedDailyEssays {
+		return errEmailUnverified
+	}
+	return nil
+}
+
+// VerifyEmail confirms an address from the token in a verification link
+func VerifyEmail(db *gorm.DB, auth *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		userID, binding, err := auth.VerifyAction(req.Token, verifyEmailPurpose)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired link"})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, userID).Error; err != nil || tokenBinding(user.Email) != binding {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired link"})
+			return
+		}
+
+		if user.EmailVerifiedAt == nil {
+			now := time.Now()
+			if err := db.Model(&user).Update("email_verified_at", now).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+				return
+			}
+			user.EmailVerifiedAt = &now
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "email verified", "user": userInfoOf(user)})
+	}
+}
+
+// ResendVerification sends the current user a new verification link
+func ResendVerification(db *gorm.DB, auth *AuthService, mailer Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+
+		if user.EmailVerifiedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "email already verified"})
+			return
+		}
+		if _, ok := normalizeEmail(user.Email); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account has no valid email address"})
+			return
+		}
+
+		if err := sendVerificationEmail(c.Request.Context(), auth, mailer, user); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to send email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
+	}
+}
+
+// ForgotPassword emails a reset link. It answers the same whether or not
+// the address has an account, so it cannot be used to probe for users.
+func ForgotPassword(db *gorm.DB, auth *AuthService, mailer Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
+		email, ok := normalizeEmail(req.Email)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email address"})
+			return
+		}
+
+		var user User
+		if err := db.Where("email = ?", email).First(&user).Error; err == nil {
+			// Send in the background so response time does not reveal the account
+			go func() {
+				if err := sendPasswordResetEmail(context.Background(), auth, mailer, user); err != nil {
+					log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+				}
+			}()
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "if an account exists for this email, a reset link has been sent"})
+	}
+}
+
+// ResetPassword sets a new password from a reset link and signs the user
+// out everywhere. Following the link also proves the address, so the email
+// counts as verified.
+func ResetPassword(db *gorm.DB, auth *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token    string `json:"token" binding:"required"`
+			Password string `json:"password" binding:"required,min=6"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token and a password of at least 6 characters are required"})
+			return
+		}
+
+		userID, binding, err := auth.VerifyAction(req.Token, resetPasswordPurpose)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired link"})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, userID).Error; err != nil || tokenBinding(user.PassHash) != binding {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired link"})
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process password"})
+			return
+		}
+
+		updates := map[string]interface{}{"pass_hash": string(hashedPassword)}
+		if user.EmailVerifiedAt == nil {
+			updates["email_verified_at"] = time.Now()
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// Only the first of two concurrent resets with the same link wins
+			result := tx.Model(&User{}).Where("id = ? AND pass_hash = ?", user.ID, user.PassHash).Updates(updates)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errLinkUsed
+			}
+			_, err := revokeSessions(tx, user.ID)
+			return err
+		})
+		if errors.Is(err, errLinkUsed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired link"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+			return
+		}
+		auditEvent(c, "auth.password_reset", &user, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "password updated, please sign in again"})
+	}
+}
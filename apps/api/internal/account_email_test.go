@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"student@example.com", "student@example.com", true},
+		{"  student@example.com ", "student@example.com", true},
+		{"Student <student@example.com>", "", false},
+		{"student@localhost", "", false},
+		{"not an email", "", false},
+		{"@example.com", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := normalizeEmail(tt.in)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("normalizeEmail(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestActionTokens(t *testing.T) {
+	auth, err := newAuthService(nil, "bandly-api", "bandly-app", []SigningKey{hmacKey("k1", "secret")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		purpose string
+		issued  time.Time
+		wantErr bool
+	}{
+		{"Same purpose", resetPasswordPurpose, now, false},
+		{"Other purpose", verifyEmailPurpose, now, true},
+		{"Access audience", "bandly-app", now, true},
+		{"Expired", resetPasswordPurpose, now.Add(-2 * resetPasswordTTL), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := auth.IssueAction(42, resetPasswordPurpose, "binding", resetPasswordTTL, tt.issued)
+			if err != nil {
+				t.Fatal(err)
+			}
+			userID, binding, err := auth.VerifyAction(token, tt.purpose)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyAction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (userID != 42 || binding != "binding") {
+				t.Errorf("VerifyAction() = %d, %q", userID, binding)
+			}
+		})
+	}
+
+	// An action token must never work as an access token
+	token, _ := auth.IssueAction(42, verifyEmailPurpose, "binding", verifyEmailTTL, now)
+	if _, err := auth.Verify(token); err == nil {
+		t.Error("Verify() accepted an action token")
+	}
+}
+
+func TestSendVerificationEmail(t *testing.T) {
+	auth, err := newAuthService(nil, "bandly-api", "bandly-app", []SigningKey{hmacKey("k1", "secret")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &MemoryMailer{}
+	user := User{ID: 7, Email: "student@example.com"}
+
+	if err := sendVerificationEmail(context.Background(), auth, mailer, user); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != user.Email {
+		t.Fatalf("sent = %+v", sent)
+	}
+	if !strings.Contains(sent[0].HTML, "<html>") || !strings.Contains(sent[0].Text, "/verify-email?token=") {
+		t.Errorf("unexpected bodies: %q / %q", sent[0].Text, sent[0].HTML)
+	}
+
+	// The link's token verifies and is bound to the address
+	link := sent[0].Text[strings.Index(sent[0].Text, "http"):]
+	link = link[:strings.IndexAny(link, "\n")]
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, binding, err := auth.VerifyAction(u.Query().Get("token"), verifyEmailPurpose)
+	if err != nil || userID != user.ID || binding != tokenBinding(user.Email) {
+		t.Errorf("VerifyAction() = %d, %q, %v", userID, binding, err)
+	}
+}
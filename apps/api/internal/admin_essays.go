@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AdminEssayResponse is an essay row in the admin essay list
+type AdminEssayResponse struct {
+	ID           uint      `json:"id"`
+	PublicID     string    `json:"publicId"`
+	UserID       *uint     `json:"userId,omitempty"`
+	UserEmail    string    `json:"userEmail,omitempty"`
+	TaskType     string    `json:"taskType"`
+	QuestionType string    `json:"questionType"`
+	Overall      float32   `json:"overall"`
+	CEFR         string    `json:"cefr"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// GetAdminEssays returns a paginated list of the tenant's essays, filtered
+// by user, task type and date range
+func GetAdminEssays(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if page < 1 {
+			page = 1
+		}
+		if limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		query := db.Model(&Essay{}).Scopes(TenantScope(currentOrgID(c)))
+		if userID := c.Query("userId"); userID != "" {
+			query = query.Where("user_id = ?", userID)
+		}
+		if taskType := c.Query("taskType"); taskType != "" {
+			query = query.Where("task_type = ?", taskType)
+		}
+		if from, err := time.Parse("2006-01-02", c.Query("from")); err == nil {
+			query = query.Where("created_at >= ?", from)
+		}
+		if to, err := time.Parse("2006-01-02", c.Query("to")); err == nil {
+			query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+		}
+
+		var total int64
+		query.Count(&total)
+
+		var essays []Essay
+		query.Offset((page - 1) * limit).Limit(limit).Order("created_at DESC").Find(&essays)
+
+		var userIDs []uint
+		for _, essay := range essays {
+			if essay.UserID != nil {
+				userIDs = append(userIDs, *essay.UserID)
+			}
+		}
+		emails := map[uint]string{}
+		if len(userIDs) > 0 {
+			var users []User
+			db.Select("id", "email").Where("id IN ?", userIDs).Find(&users)
+			for _, u := range users {
+				emails[u.ID] = u.Email
+			}
+		}
+
+		rows := make([]AdminEssayResponse, len(essays))
+		for i, essay := range essays {
+			rows[i] = AdminEssayResponse{
+				ID:           essay.ID,
+				PublicID:     essay.PublicID,
+				UserID:       essay.UserID,
+				TaskType:     essay.TaskType,
+				QuestionType: essay.QuestionType,
+				Overall:      essay.Overall,
+				CEFR:         essay.CEFR,
+				CreatedAt:    essay.CreatedAt,
+			}
+			if essay.UserID != nil {
+				rows[i].UserEmail = emails[*essay.UserID]
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"essays": rows,
+			"pagination": gin.H{
+				"total": total,
+				"page":  page,
+				"limit": limit,
+				"pages": int(math.Ceil(float64(total) / float64(limit))),
+			},
+		})
+	}
+}
+
+// GetAdminEssay returns any essay of the tenant with its full feedback
+func GetAdminEssay(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var essay Essay
+		if err := db.Scopes(TenantScope(currentOrgID(c))).First(&essay, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "essay not found"})
+			return
+		}
+
+		var annotations []Annotation
+		db.Where("essay_id = ?", essay.ID).Order("start ASC").Find(&annotations)
+
+		var author gin.H
+		if essay.UserID != nil {
+			var user User
+			if db.Select("id", "email").First(&user, *essay.UserID).Error == nil {
+				author = gin.H{"id": user.ID, "email": user.Email}
+			}
+		}
+
+		score := essayScore(essay)
+		c.JSON(http.StatusOK, gin.H{
+			"id":           essay.ID,
+			"publicId":     essay.PublicID,
+			"user":         author,
+			"assignmentId": essay.AssignmentID,
+			"taskType":     essay.TaskType,
+			"questionType": essay.QuestionType,
+			"text":         essay.Text,
+			"overall":      essay.Overall,
+			"cefr":         essay.CEFR,
+			"feedback":     essay.Feedback,
+			"createdAt":    essay.CreatedAt,
+			"annotations":  annotations,
+			"bands":        gin.H{"ta": score.TA, "cc": score.CC, "lr": score.LR, "gra": score.GRA},
+			"teacher":      essayFeedback(db, essay),
+			"peer":         peerFeedback(db, essay),
+		})
+	}
+}
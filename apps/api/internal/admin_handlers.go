@@ -1,7 +1,7 @@
 package internal
 
 import (
-	"database/sql"
+	"errors"
 	"math"
 	"net/http"
 	"strconv"
@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 // Admin Dashboard Stats
@@ -75,48 +74,12 @@ type PromptRequest struct {
 }
 
 // GetAdminDashboard returns admin dashboard statistics
-func GetAdminDashboard(db *gorm.DB) gin.HandlerFunc {
+func GetAdminDashboard(admin *AdminService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var stats AdminDashboardStats
-
-		// Total users
-		db.Model(&User{}).Count(&stats.TotalUsers)
-
-		// Total essays
-		db.Model(&Essay{}).Count(&stats.TotalEssays)
-
-		// Total feedback
-		db.Model(&UserFeedback{}).Count(&stats.TotalFeedback)
-
-		// Total blog posts
-		db.Model(&BlogPost{}).Count(&stats.TotalBlogPosts)
-
-		// Daily active users (users who submitted essays in last 24h)
-		yesterday := time.Now().AddDate(0, 0, -1)
-		db.Model(&Essay{}).Where("created_at > ?", yesterday).
-			Distinct("user_id").Count(&stats.DailyActiveUsers)
-
-		// Weekly signups
-		weekAgo := time.Now().AddDate(0, 0, -7)
-		db.Model(&User{}).Where("created_at > ?", weekAgo).Count(&stats.WeeklySignups)
-
-		// Average score
-		var avgScore sql.NullFloat64
-		db.Model(&Essay{}).Select("AVG(overall)").Scan(&avgScore)
-		if avgScore.Valid {
-			stats.AvgScore = math.Round(avgScore.Float64*100) / 100
-		}
-
-		// Conversion rate (users with essays / total users)
-		var usersWithEssays int64
-		db.Model(&User{}).
-			Joins("LEFT JOIN essays ON users.id = essays.user_id").
-			Where("essays.id IS NOT NULL").
-			Distinct("users.id").
-			Count(&usersWithEssays)
-
-		if stats.TotalUsers > 0 {
-			stats.ConversionRate = math.Round(float64(usersWithEssays)/float64(stats.TotalUsers)*10000) / 100
+		stats, err := admin.Dashboard(currentOrgID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load dashboard"})
+			return
 		}
 
 		c.JSON(http.StatusOK, stats)
@@ -124,44 +87,31 @@ func GetAdminDashboard(db *gorm.DB) gin.HandlerFunc {
 }
 
 // GetAdminUsers returns paginated list of users
-func GetAdminUsers(db *gorm.DB) gin.HandlerFunc {
+func GetAdminUsers(admin *AdminService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-		search := c.Query("search")
-		role := c.Query("role")
-
-		offset := (page - 1) * limit
 
-		query := db.Model(&User{})
-
-		if search != "" {
-			query = query.Where("email ILIKE ?", "%"+search+"%")
-		}
-
-		if role != "" {
-			query = query.Where("role = ?", role)
+		users, total, err := admin.ListUsers(currentOrgID(c), UserFilter{
+			Search: c.Query("search"),
+			Role:   c.Query("role"),
+			Offset: (page - 1) * limit,
+			Limit:  limit,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list users"})
+			return
 		}
 
-		var total int64
-		query.Count(&total)
-
-		var users []User
-		query.Offset(offset).Limit(limit).Order("created_at DESC").Find(&users)
-
-		// Get essay counts for each user
 		var userResponses []AdminUserResponse
 		for _, user := range users {
-			var essayCount int64
-			db.Model(&Essay{}).Where("user_id = ?", user.ID).Count(&essayCount)
-
 			userResponses = append(userResponses, AdminUserResponse{
 				ID:         user.ID,
 				Email:      user.Email,
 				Plan:       user.Plan,
 				Role:       user.Role,
 				CreatedAt:  user.CreatedAt,
-				EssayCount: essayCount,
+				EssayCount: user.EssayCount,
 			})
 		}
 
@@ -178,81 +128,72 @@ func GetAdminUsers(db *gorm.DB) gin.HandlerFunc {
 }
 
 // UpdateUser updates user plan or role
-func UpdateUser(db *gorm.DB) gin.HandlerFunc {
+func UpdateUser(admin *AdminService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.Param("id")
-
 		var req UserUpdateRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 			return
 		}
 
-		var user User
-		if err := db.First(&user, userID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-
-		updates := map[string]interface{}{}
-		if req.Plan != "" {
-			updates["plan"] = req.Plan
-		}
-		if req.Role != "" {
-			updates["role"] = req.Role
-		}
-
-		if err := db.Model(&user).Updates(updates).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
+		actor := c.MustGet("adminUser").(User)
+		before, user, err := admin.UpdateUser(actor, currentOrgID(c), paramID(c, "id"), req.Plan, req.Role)
+		if err != nil {
+			respondUserError(c, err, "failed to update user")
 			return
 		}
+		auditChange(c, "user.update", "user", user.ID, before, user)
 
 		c.JSON(http.StatusOK, gin.H{"message": "user updated successfully"})
 	}
 }
 
 // DeleteUser deletes a user and their essays
-func DeleteUser(db *gorm.DB) gin.HandlerFunc {
+func DeleteUser(admin *AdminService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.Param("id")
-
-		var user User
-		if err := db.First(&user, userID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-
-		// Don't allow deleting admin users
-		if user.Role == "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "cannot delete admin users"})
-			return
-		}
-
-		// Delete user essays first
-		db.Where("user_id = ?", userID).Delete(&Essay{})
-
-		// Delete user
-		if err := db.Delete(&user).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete user"})
+		actor := c.MustGet("adminUser").(User)
+		user, err := admin.DeleteUser(actor, currentOrgID(c), paramID(c, "id"))
+		if err != nil {
+			respondUserError(c, err, "failed to delete user")
 			return
 		}
+		auditChange(c, "user.delete", "user", user.ID, user, nil)
 
 		c.JSON(http.StatusOK, gin.H{"message": "user deleted successfully"})
 	}
 }
 
+// respondUserError maps AdminService errors to responses
+func respondUserError(c *gin.Context, err error, failure string) {
+	switch {
+	case errors.Is(err, errNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, errStaffAccount), errors.Is(err, errDeleteStaff), errors.Is(err, errDeleteAdmin):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, errUnknownPlan):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errUnknownRole), errors.Is(err, errCannotAssignRole), errors.Is(err, errOwnRole):
+		respondRoleError(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	}
+}
+
 // GetAdminBlogPosts returns all blog posts for admin
-func GetAdminBlogPosts(db *gorm.DB) gin.HandlerFunc {
+func GetAdminBlogPosts(blog *BlogService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var posts []BlogPost
-		db.Order("created_at DESC").Find(&posts)
+		posts, err := blog.List(currentOrgID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list blog posts"})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{"posts": posts})
 	}
 }
 
 // CreateBlogPost creates a new blog post
-func CreateBlogPost(db *gorm.DB) gin.HandlerFunc {
+func CreateBlogPost(blog *BlogService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req BlogCreateRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -260,121 +201,74 @@ func CreateBlogPost(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		adminUser := c.MustGet("adminUser").(User)
-
-		// Generate slug if not provided
-		if req.Slug == "" {
-			req.Slug = generateSlug(req.Title)
-		}
-
-		// Convert tags to JSON
-		tagsJSON := ""
-		if len(req.Tags) > 0 {
-			tagsJSON = strings.Join(req.Tags, ",")
-		}
-
-		post := BlogPost{
-			Title:       req.Title,
-			Slug:        req.Slug,
-			Excerpt:     req.Excerpt,
-			Content:     req.Content,
-			Category:    req.Category,
-			Tags:        tagsJSON,
-			ReadTime:    req.ReadTime,
-			IsPublished: req.IsPublished,
-			PublishedAt: req.PublishedAt,
-			AuthorID:    adminUser.ID,
-		}
-
-		if req.IsPublished && req.PublishedAt == nil {
-			now := time.Now()
-			post.PublishedAt = &now
-		}
-
-		if err := db.Create(&post).Error; err != nil {
+		post, err := blog.Create(c.MustGet("adminUser").(User), req)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create blog post"})
 			return
 		}
+		auditChange(c, "blog.create", "blog_post", post.ID, nil, post)
 
 		c.JSON(http.StatusCreated, gin.H{"post": post})
 	}
 }
 
 // UpdateBlogPost updates an existing blog post
-func UpdateBlogPost(db *gorm.DB) gin.HandlerFunc {
+func UpdateBlogPost(blog *BlogService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		postID := c.Param("id")
-
 		var req BlogUpdateRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 			return
 		}
 
-		var post BlogPost
-		if err := db.First(&post, postID).Error; err != nil {
+		before, post, err := blog.Update(currentOrgID(c), paramID(c, "id"), req)
+		if errors.Is(err, errNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "blog post not found"})
 			return
 		}
-
-		updates := map[string]interface{}{
-			"title":        req.Title,
-			"slug":         req.Slug,
-			"excerpt":      req.Excerpt,
-			"content":      req.Content,
-			"category":     req.Category,
-			"tags":         strings.Join(req.Tags, ","),
-			"read_time":    req.ReadTime,
-			"is_published": req.IsPublished,
-			"published_at": req.PublishedAt,
-		}
-
-		if req.IsPublished && post.PublishedAt == nil && req.PublishedAt == nil {
-			now := time.Now()
-			updates["published_at"] = &now
-		}
-
-		if err := db.Model(&post).Updates(updates).Error; err != nil {
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update blog post"})
 			return
 		}
+		auditChange(c, "blog.update", "blog_post", post.ID, before, post)
 
 		c.JSON(http.StatusOK, gin.H{"message": "blog post updated successfully"})
 	}
 }
 
 // DeleteBlogPost deletes a blog post
-func DeleteBlogPost(db *gorm.DB) gin.HandlerFunc {
+func DeleteBlogPost(blog *BlogService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		postID := c.Param("id")
-
-		var post BlogPost
-		if err := db.First(&post, postID).Error; err != nil {
+		post, err := blog.Delete(currentOrgID(c), paramID(c, "id"))
+		if errors.Is(err, errNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "blog post not found"})
 			return
 		}
-
-		if err := db.Delete(&post).Error; err != nil {
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete blog post"})
 			return
 		}
+		auditChange(c, "blog.delete", "blog_post", post.ID, post, nil)
 
 		c.JSON(http.StatusOK, gin.H{"message": "blog post deleted successfully"})
 	}
 }
 
 // GetAdminPrompts returns all admin prompts
-func GetAdminPrompts(db *gorm.DB) gin.HandlerFunc {
+func GetAdminPrompts(prompts *PromptService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var prompts []AdminPrompt
-		db.Order("created_at DESC").Find(&prompts)
+		list, err := prompts.List(currentOrgID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list prompts"})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{"prompts": prompts})
+		c.JSON(http.StatusOK, gin.H{"prompts": list})
 	}
 }
 
 // CreatePrompt creates a new admin prompt
-func CreatePrompt(db *gorm.DB) gin.HandlerFunc {
+func CreatePrompt(prompts *PromptService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req PromptRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -382,77 +276,65 @@ func CreatePrompt(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		prompt := AdminPrompt{
-			Name:        req.Name,
-			Description: req.Description,
-			Prompt:      req.Prompt,
-			Type:        req.Type,
-			IsActive:    req.IsActive,
-		}
-
-		if err := db.Create(&prompt).Error; err != nil {
+		prompt, err := prompts.Create(currentOrgID(c), req)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create prompt"})
 			return
 		}
+		auditChange(c, "prompt.create", "prompt", prompt.ID, nil, prompt)
 
 		c.JSON(http.StatusCreated, gin.H{"prompt": prompt})
 	}
 }
 
 // UpdatePrompt updates an existing prompt
-func UpdatePrompt(db *gorm.DB) gin.HandlerFunc {
+func UpdatePrompt(prompts *PromptService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		promptID := c.Param("id")
-
 		var req PromptRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 			return
 		}
 
-		var prompt AdminPrompt
-		if err := db.First(&prompt, promptID).Error; err != nil {
+		before, prompt, err := prompts.Update(currentOrgID(c), paramID(c, "id"), req)
+		if errors.Is(err, errNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "prompt not found"})
 			return
 		}
-
-		updates := map[string]interface{}{
-			"name":        req.Name,
-			"description": req.Description,
-			"prompt":      req.Prompt,
-			"type":        req.Type,
-			"is_active":   req.IsActive,
-		}
-
-		if err := db.Model(&prompt).Updates(updates).Error; err != nil {
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update prompt"})
 			return
 		}
+		auditChange(c, "prompt.update", "prompt", prompt.ID, before, prompt)
 
 		c.JSON(http.StatusOK, gin.H{"message": "prompt updated successfully"})
 	}
 }
 
 // DeletePrompt deletes a prompt
-func DeletePrompt(db *gorm.DB) gin.HandlerFunc {
+func DeletePrompt(prompts *PromptService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		promptID := c.Param("id")
-
-		var prompt AdminPrompt
-		if err := db.First(&prompt, promptID).Error; err != nil {
+		prompt, err := prompts.Delete(currentOrgID(c), paramID(c, "id"))
+		if errors.Is(err, errNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "prompt not found"})
 			return
 		}
-
-		if err := db.Delete(&prompt).Error; err != nil {
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete prompt"})
 			return
 		}
+		auditChange(c, "prompt.delete", "prompt", prompt.ID, prompt, nil)
 
 		c.JSON(http.StatusOK, gin.H{"message": "prompt deleted successfully"})
 	}
 }
 
+// paramID reads a numeric path parameter, 0 (never a row) when malformed
+func paramID(c *gin.Context, name string) uint {
+	id, _ := strconv.ParseUint(c.Param(name), 10, 32)
+	return uint(id)
+}
+
 // Helper function to generate slug from title
 func generateSlug(title string) string {
 	// Simple slug generation - convert to lowercase and replace spaces with hyphens
@@ -0,0 +1,347 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeUserRepo keeps users in a map
+type fakeUserRepo struct {
+	users   map[uint]User
+	essays  map[uint]int64
+	plans   map[string]bool
+	deleted []uint
+
+	failUpdate bool
+}
+
+func (r *fakeUserRepo) Get(_ *uint, id uint) (User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return User{}, errNotFound
+	}
+	return user, nil
+}
+
+func (r *fakeUserRepo) List(_ *uint, filter UserFilter) ([]UserSummary, int64, error) {
+	var list []UserSummary
+	for id := uint(1); id <= uint(len(r.users)); id++ {
+		if user, ok := r.users[id]; ok && (filter.Role == "" || user.Role == filter.Role) {
+			list = append(list, UserSummary{User: user, EssayCount: r.essays[id]})
+		}
+	}
+	return list, int64(len(list)), nil
+}
+
+func (r *fakeUserRepo) PlanExists(name string) bool { return r.plans[name] }
+
+func (r *fakeUserRepo) Update(id uint, role, plan string) error {
+	if r.failUpdate {
+		return errors.New("update failed")
+	}
+	user := r.users[id]
+	if role != "" {
+		user.Role = role
+	}
+	if plan != "" {
+		user.Plan = plan
+	}
+	r.users[id] = user
+	return nil
+}
+
+func (r *fakeUserRepo) Delete(user User) error {
+	delete(r.users, user.ID)
+	r.deleted = append(r.deleted, user.ID)
+	return nil
+}
+
+// fakeBlogRepo keeps posts in a slice
+type fakeBlogRepo struct{ posts []BlogPost }
+
+func (r *fakeBlogRepo) List(_ *uint) ([]BlogPost, error) { return r.posts, nil }
+
+func (r *fakeBlogRepo) Get(_ *uint, id uint) (BlogPost, error) {
+	for _, post := range r.posts {
+		if post.ID == id {
+			return post, nil
+		}
+	}
+	return BlogPost{}, errNotFound
+}
+
+func (r *fakeBlogRepo) Create(post *BlogPost) error {
+	post.ID = uint(len(r.posts) + 1)
+	r.posts = append(r.posts, *post)
+	return nil
+}
+
+func (r *fakeBlogRepo) Save(post *BlogPost) error {
+	for i := range r.posts {
+		if r.posts[i].ID == post.ID {
+			r.posts[i] = *post
+		}
+	}
+	return nil
+}
+
+func (r *fakeBlogRepo) Delete(post BlogPost) error { return nil }
+
+// fakePromptRepo keeps prompts in a slice
+type fakePromptRepo struct{ prompts []AdminPrompt }
+
+func (r *fakePromptRepo) List(_ *uint) ([]AdminPrompt, error) { return r.prompts, nil }
+
+func (r *fakePromptRepo) Get(_ *uint, id uint) (AdminPrompt, error) {
+	for _, prompt := range r.prompts {
+		if prompt.ID == id {
+			return prompt, nil
+		}
+	}
+	return AdminPrompt{}, errNotFound
+}
+
+func (r *fakePromptRepo) Create(prompt *AdminPrompt) error {
+	prompt.ID = uint(len(r.prompts) + 1)
+	r.prompts = append(r.prompts, *prompt)
+	return nil
+}
+
+func (r *fakePromptRepo) Save(prompt *AdminPrompt) error {
+	for i := range r.prompts {
+		if r.prompts[i].ID == prompt.ID {
+			r.prompts[i] = *prompt
+		}
+	}
+	return nil
+}
+
+func (r *fakePromptRepo) Delete(prompt AdminPrompt) error { return nil }
+
+// fakeAnalyticsRepo returns fixed totals
+type fakeAnalyticsRepo struct {
+	tenant TenantTotals
+	events []AnalyticsEvent
+}
+
+func (r *fakeAnalyticsRepo) RecordEvent(event *AnalyticsEvent) error {
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *fakeAnalyticsRepo) TenantTotals(_ *uint, _, _ time.Time) (TenantTotals, error) {
+	return r.tenant, nil
+}
+
+func (r *fakeAnalyticsRepo) EventTotals(_, _ time.Time) (EventTotals, error) {
+	return EventTotals{}, nil
+}
+
+var testNow = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+// serveAdmin runs a handler as the given admin and decodes the JSON reply
+func serveAdmin(t *testing.T, handler gin.HandlerFunc, actor User, method, path, route, body string) (int, map[string]interface{}) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(method, route, func(c *gin.Context) {
+		c.Set("adminUser", actor)
+		c.Next()
+	}, handler)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	var reply map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("reply %q is not JSON: %v", rec.Body.String(), err)
+	}
+	return rec.Code, reply
+}
+
+func newFakeUsers() *fakeUserRepo {
+	return &fakeUserRepo{
+		users: map[uint]User{
+			1: {ID: 1, Email: "admin@example.com", Role: RoleAdmin, Plan: "pro"},
+			2: {ID: 2, Email: "support@example.com", Role: RoleSupport, Plan: "free"},
+			3: {ID: 3, Email: "student@example.com", Role: RoleUser, Plan: "free"},
+		},
+		essays: map[uint]int64{3: 4},
+		plans:  map[string]bool{"free": true, "pro": true},
+	}
+}
+
+func TestGetAdminUsers(t *testing.T) {
+	admin := &AdminService{Users: newFakeUsers(), now: testNow}
+	code, reply := serveAdmin(t, GetAdminUsers(admin), User{ID: 1, Role: RoleAdmin},
+		http.MethodGet, "/users?role=user", "/users", "")
+
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	users := reply["users"].([]interface{})
+	if len(users) != 1 {
+		t.Fatalf("users = %v, want only the student", users)
+	}
+	if got := users[0].(map[string]interface{})["essayCount"]; got != 4.0 {
+		t.Errorf("essayCount = %v, want 4", got)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	admin := User{ID: 1, Role: RoleAdmin}
+	support := User{ID: 2, Role: RoleSupport}
+
+	tests := []struct {
+		name     string
+		actor    User
+		path     string
+		body     string
+		wantCode int
+		wantPlan string
+	}{
+		{"Plan change", support, "/users/3", `{"plan":"pro"}`, http.StatusOK, "pro"},
+		{"Unknown plan", admin, "/users/3", `{"plan":"gold"}`, http.StatusBadRequest, "free"},
+		{"Support cannot edit staff", support, "/users/1", `{"plan":"free"}`, http.StatusForbidden, ""},
+		{"Support cannot assign roles", support, "/users/3", `{"role":"teacher"}`, http.StatusForbidden, "free"},
+		{"Missing user", admin, "/users/9", `{"plan":"pro"}`, http.StatusNotFound, ""},
+		{"Malformed id", admin, "/users/abc", `{"plan":"pro"}`, http.StatusNotFound, ""},
+		{"Role and plan", admin, "/users/3", `{"role":"teacher","plan":"pro"}`, http.StatusOK, "pro"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newFakeUsers()
+			svc := &AdminService{Users: users, now: testNow}
+			code, reply := serveAdmin(t, UpdateUser(svc), tt.actor, http.MethodPut, tt.path, "/users/:id", tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d (%v), want %d", code, reply, tt.wantCode)
+			}
+			if tt.wantPlan != "" && users.users[3].Plan != tt.wantPlan {
+				t.Errorf("plan = %q, want %q", users.users[3].Plan, tt.wantPlan)
+			}
+		})
+	}
+}
+
+func TestUpdateUserFailedWrite(t *testing.T) {
+	users := newFakeUsers()
+	users.failUpdate = true
+	svc := &AdminService{Users: users, now: testNow}
+
+	code, _ := serveAdmin(t, UpdateUser(svc), User{ID: 1, Role: RoleAdmin}, http.MethodPut, "/users/3", "/users/:id",
+		`{"role":"teacher","plan":"pro"}`)
+	if code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", code)
+	}
+	if user := users.users[3]; user.Role != RoleUser || user.Plan != "free" {
+		t.Errorf("user = %+v, want role and plan unchanged", user)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		actor    User
+		path     string
+		wantCode int
+	}{
+		{"Student", User{ID: 2, Role: RoleSupport}, "/users/3", http.StatusOK},
+		{"Admins are never deleted", User{ID: 1, Role: RoleAdmin}, "/users/1", http.StatusForbidden},
+		{"Support cannot delete staff", User{ID: 2, Role: RoleSupport}, "/users/2", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newFakeUsers()
+			svc := &AdminService{Users: users, now: testNow}
+			code, _ := serveAdmin(t, DeleteUser(svc), tt.actor, http.MethodDelete, tt.path, "/users/:id", "")
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", code, tt.wantCode)
+			}
+			if deleted := len(users.deleted) == 1; deleted != (tt.wantCode == http.StatusOK) {
+				t.Errorf("deleted = %v", users.deleted)
+			}
+		})
+	}
+}
+
+func TestGetAdminDashboard(t *testing.T) {
+	avg := 6.4567
+	analytics := &fakeAnalyticsRepo{tenant: TenantTotals{Users: 3, EssayWriters: 2, Essays: 10, AverageOverall: &avg}}
+	svc := &AdminService{Analytics: analytics, now: testNow}
+
+	code, reply := serveAdmin(t, GetAdminDashboard(svc), User{ID: 1, Role: RoleAdmin}, http.MethodGet, "/dashboard", "/dashboard", "")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if reply["avgScore"] != 6.46 || reply["conversionRate"] != 66.67 || reply["totalEssays"] != 10.0 {
+		t.Errorf("dashboard = %v", reply)
+	}
+}
+
+func TestCreateBlogPost(t *testing.T) {
+	posts := &fakeBlogRepo{}
+	blog := &BlogService{Posts: posts, now: testNow}
+	orgID := uint(7)
+	author := User{ID: 1, Role: RoleAdmin, OrganizationID: &orgID}
+
+	code, _ := serveAdmin(t, CreateBlogPost(blog), author, http.MethodPost, "/blog", "/blog",
+		`{"title":"Task 2: Five Tips!","content":"...","tags":["task2","tips"],"isPublished":true}`)
+	if code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", code)
+	}
+
+	post := posts.posts[0]
+	if post.Slug != "task-2-five-tips" || post.Tags != "task2,tips" {
+		t.Errorf("slug, tags = %q, %q", post.Slug, post.Tags)
+	}
+	if post.PublishedAt == nil || !post.PublishedAt.Equal(testNow()) {
+		t.Errorf("publishedAt = %v, want %v", post.PublishedAt, testNow())
+	}
+	if post.OrganizationID == nil || *post.OrganizationID != orgID || post.AuthorID != 1 {
+		t.Errorf("post belongs to org %v, author %d", post.OrganizationID, post.AuthorID)
+	}
+}
+
+func TestPromptHandlers(t *testing.T) {
+	prompts := &PromptService{Prompts: &fakePromptRepo{}}
+	orgID := uint(7)
+	admin := User{ID: 1, Role: RoleAdmin, OrganizationID: &orgID}
+
+	code, reply := serveAdmin(t, CreatePrompt(prompts), admin, http.MethodPost, "/prompts", "/prompts",
+		`{"name":"Strict","prompt":"Mark strictly.","type":"scoring","isActive":true}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create: status = %d (%v)", code, reply)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantCode int
+	}{
+		{"Update", "/prompts/1", `{"name":"Lenient","prompt":"Mark gently."}`, http.StatusOK},
+		{"Missing prompt", "/prompts/9", `{"name":"x","prompt":"y"}`, http.StatusNotFound},
+		{"Invalid request", "/prompts/1", `{"name":"x"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reply := serveAdmin(t, UpdatePrompt(prompts), admin, http.MethodPut, tt.path, "/prompts/:id", tt.body)
+			if code != tt.wantCode {
+				t.Errorf("status = %d (%v), want %d", code, reply, tt.wantCode)
+			}
+		})
+	}
+
+	list, _ := prompts.List(&orgID)
+	if len(list) != 1 || list[0].Name != "Lenient" || list[0].IsActive || *list[0].OrganizationID != orgID {
+		t.Errorf("prompts = %+v", list)
+	}
+}
@@ -7,7 +7,8 @@ import (
 	"gorm.io/gorm"
 )
 
-// AdminMiddleware ensures only admin users can access admin routes
+// AdminMiddleware ensures only staff roles can access admin routes. Routes
+// then narrow access further with RequirePermission.
 func AdminMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// First check if user is authenticated
@@ -26,7 +27,7 @@ func AdminMiddleware(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		if user.Role != "admin" {
+		if !HasPermission(user.Role, PermStaff) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
 			c.Abort()
 			return
@@ -36,3 +37,45 @@ func AdminMiddleware(db *gorm.DB) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// TeacherMiddleware ensures only roles with classes:manage (teachers and
+// admins) can manage classes
+func TeacherMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			c.Abort()
+			return
+		}
+
+		var user User
+		if err := db.First(&user, userID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			c.Abort()
+			return
+		}
+
+		if !HasPermission(user.Role, PermClassesManage) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "teacher access required"})
+			c.Abort()
+			return
+		}
+
+		c.Set("teacherUser", user)
+		c.Next()
+	}
+}
+
+// PlatformAdminMiddleware restricts routes to admins outside any organisation.
+// It runs after AdminMiddleware.
+func PlatformAdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.MustGet("adminUser").(User).OrganizationID != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "platform admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
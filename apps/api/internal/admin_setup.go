@@ -1,41 +1,206 @@
 package internal
 
 import (
-	"log"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-// CreateDefaultAdmin creates the default admin user if it doesn't exist
-func CreateDefaultAdmin(db *gorm.DB) error {
-	// Check if admin user already exists
-	var existingUser User
-	err := db.Where("email = ?", "sidigi").First(&existingUser).Error
-	if err == nil {
-		// Admin user already exists
-		log.Println("Admin user 'sidigi' already exists")
-		return nil
+// adminPasswordMinLength is the shortest password an admin may choose
+const adminPasswordMinLength = 10
+
+// knownDefaultPasswords were shipped as, or are typical of, seeded admin
+// credentials. Admin accounts using one of them block a production start.
+var knownDefaultPasswords = []string{"Bobys123", "admin", "admin123", "password", "changeme"}
+
+var (
+	errWeakAdminPassword = fmt.Errorf("password must be at least %d characters and not a known default", adminPasswordMinLength)
+	errAdminExists       = errors.New("an admin account already exists")
+	errUserExists        = errors.New("a user with this email already exists")
+	errInvalidEmail      = errors.New("invalid email address")
+)
+
+// validAdminPassword reports whether a password is acceptable for an admin
+func validAdminPassword(password string) bool {
+	if len(password) < adminPasswordMinLength {
+		return false
+	}
+	for _, known := range knownDefaultPasswords {
+		if strings.EqualFold(password, known) {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateAdmin creates a platform admin with the given email and password
+func CreateAdmin(db *gorm.DB, email, password string) (User, error) {
+	email, ok := normalizeEmail(email)
+	if !ok {
+		return User{}, errInvalidEmail
+	}
+	if !validAdminPassword(password) {
+		return User{}, errWeakAdminPassword
+	}
+
+	var existing int64
+	db.Model(&User{}).Where("email = ?", email).Count(&existing)
+	if existing > 0 {
+		return User{}, errUserExists
 	}
 
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("Bobys123"), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return err
+		return User{}, err
 	}
 
-	// Create admin user
-	adminUser := User{
-		Email:    "sidigi",
+	now := time.Now()
+	admin := User{
+		Email:    email,
 		PassHash: string(hashedPassword),
 		Plan:     "pro",
 		Role:     "admin",
+
+		EmailVerifiedAt: &now,
+	}
+	if err := db.Create(&admin).Error; err != nil {
+		return User{}, err
+	}
+	return admin, nil
+}
+
+// SetPassword replaces a user's password and signs them out everywhere
+func SetPassword(db *gorm.DB, email, password string) error {
+	var user User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		return fmt.Errorf("user %q not found", email)
+	}
+	if user.Role == "admin" && !validAdminPassword(password) {
+		return errWeakAdminPassword
 	}
 
-	if err := db.Create(&adminUser).Error; err != nil {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
 		return err
 	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("pass_hash", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		_, err := revokeSessions(tx, user.ID)
+		return err
+	})
+}
 
-	log.Println("Default admin user 'sidigi' created successfully")
-	return nil
+// DefaultCredentialsInUse lists admin accounts, and the formerly seeded
+// "sidigi" account, whose password is a known default
+func DefaultCredentialsInUse(db *gorm.DB) ([]string, error) {
+	var users []User
+	if err := db.Where("role = ? OR email = ?", "admin", "sidigi").Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	var found []string
+	for _, user := range users {
+		for _, password := range knownDefaultPasswords {
+			if bcrypt.CompareHashAndPassword([]byte(user.PassHash), []byte(password)) == nil {
+				found = append(found, user.Email)
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+// adminExists reports whether any admin account exists
+func adminExists(db *gorm.DB) bool {
+	var count int64
+	db.Model(&User{}).Where("role = ?", "admin").Count(&count)
+	return count > 0
+}
+
+// setupToken returns SETUP_TOKEN, or "" when it is unset or too short to
+// be safe
+func setupToken() string {
+	token := os.Getenv("SETUP_TOKEN")
+	if len(token) < 16 {
+		return ""
+	}
+	return token
+}
+
+// SetupRequired reports whether the database has no admin yet, and whether
+// the first-run endpoint is available to create one
+func SetupRequired(db *gorm.DB) (required, tokenSet bool) {
+	return !adminExists(db), setupToken() != ""
+}
+
+// GetSetupStatus tells the web app whether to show the first-run page
+func GetSetupStatus(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		required, tokenSet := SetupRequired(db)
+		c.JSON(http.StatusOK, gin.H{"setupRequired": required, "available": required && tokenSet})
+	}
+}
+
+// CompleteSetup creates the first admin for the holder of SETUP_TOKEN.
+// It stops working for good once any admin exists.
+func CompleteSetup(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := setupToken()
+		if token == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "setup is not enabled"})
+			return
+		}
+
+		var req struct {
+			Token    string `json:"token" binding:"required"`
+			Email    string `json:"email" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token, email and password are required"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(req.Token), []byte(token)) != 1 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid setup token"})
+			return
+		}
+
+		var admin User
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if adminExists(tx) {
+				return errAdminExists
+			}
+			var err error
+			admin, err = CreateAdmin(tx, req.Email, req.Password)
+			return err
+		})
+		switch {
+		case errors.Is(err, errAdminExists):
+			c.JSON(http.StatusGone, gin.H{"error": "setup has already been completed"})
+			return
+		case errors.Is(err, errInvalidEmail), errors.Is(err, errWeakAdminPassword):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errUserExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create admin"})
+			return
+		}
+
+		auditEvent(c, "admin.setup_completed", &admin, nil)
+
+		c.JSON(http.StatusCreated, gin.H{"message": "admin created, you can sign in now", "user": userInfoOf(admin)})
+	}
 }
@@ -0,0 +1,23 @@
+package internal
+
+import "testing"
+
+func TestValidAdminPassword(t *testing.T) {
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"correct horse battery", true},
+		{"Bobys123", false},
+		{"PASSWORD", false},
+		{"short1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			if got := validAdminPassword(tt.password); got != tt.want {
+				t.Errorf("validAdminPassword(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Anki identifies note types and decks by ID, so fixed IDs make a repeated
+// import update the same deck instead of creating a new one
+const (
+	ankiModelID = 1690000000001
+	ankiDeckID  = 1690000000002
+)
+
+// ankiSchema is the legacy (version 11) collection schema every Anki
+// release imports
+var ankiSchema = []string{
+	`CREATE TABLE col (id integer primary key, crt integer not null, mod integer not null, scm integer not null,
+		ver integer not null, dty integer not null, usn integer not null, ls integer not null, conf text not null,
+		models text not null, decks text not null, dconf text not null, tags text not null)`,
+	`CREATE TABLE notes (id integer primary key, guid text not null, mid integer not null, mod integer not null,
+		usn integer not null, tags text not null, flds text not null, sfld integer not null, csum integer not null,
+		flags integer not null, data text not null)`,
+	`CREATE TABLE cards (id integer primary key, nid integer not null, did integer not null, ord integer not null,
+		mod integer not null, usn integer not null, type integer not null, queue integer not null, due integer not null,
+		ivl integer not null, factor integer not null, reps integer not null, lapses integer not null,
+		left integer not null, odue integer not null, odid integer not null, flags integer not null, data text not null)`,
+	`CREATE TABLE revlog (id integer primary key, cid integer not null, usn integer not null, ease integer not null,
+		ivl integer not null, lastIvl integer not null, factor integer not null, time integer not null, type integer not null)`,
+	`CREATE TABLE graves (usn integer not null, oid integer not null, type integer not null)`,
+	`CREATE INDEX ix_notes_usn ON notes (usn)`,
+	`CREATE INDEX ix_cards_usn ON cards (usn)`,
+	`CREATE INDEX ix_revlog_usn ON revlog (usn)`,
+	`CREATE INDEX ix_cards_nid ON cards (nid)`,
+	`CREATE INDEX ix_cards_sched ON cards (did, queue, due)`,
+	`CREATE INDEX ix_revlog_cid ON revlog (cid)`,
+	`CREATE INDEX ix_notes_csum ON notes (csum)`,
+}
+
+// ankiCollection renders the col row's JSON columns for one deck of
+// front/back notes
+func ankiCollection(now time.Time) (conf, models, decks, dconf string) {
+	mod := now.Unix()
+	field := func(name string, ord int) gin.H {
+		return gin.H{"name": name, "ord": ord, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{}}
+	}
+	deck := func(id int64, name string) gin.H {
+		return gin.H{
+			"id": id, "name": name, "desc": "", "conf": 1, "dyn": 0, "collapsed": false, "mod": mod, "usn": -1,
+			"extendNew": 10, "extendRev": 50,
+			"newToday": []int{0, 0}, "revToday": []int{0, 0}, "lrnToday": []int{0, 0}, "timeToday": []int{0, 0},
+		}
+	}
+
+	conf = ToJSON(gin.H{
+		"activeDecks": []int64{ankiDeckID}, "curDeck": ankiDeckID, "curModel": strconv.Itoa(ankiModelID),
+		"nextPos": 1, "newSpread": 0, "collapseTime": 1200, "timeLim": 0, "estTimes": true, "dueCounts": true,
+		"sortType": "noteFld", "sortBackwards": false, "addToCur": true,
+	})
+	models = ToJSON(gin.H{strconv.Itoa(ankiModelID): gin.H{
+		"id": ankiModelID, "name": "BandLy Vocabulary", "type": 0, "mod": mod, "usn": -1, "sortf": 0, "did": ankiDeckID,
+		"flds": []gin.H{field("Front", 0), field("Back", 1)},
+		"tmpls": []gin.H{{
+			"name": "Card 1", "ord": 0, "did": nil, "bqfmt": "", "bafmt": "",
+			"qfmt": "{{Front}}", "afmt": "{{FrontSide}}<hr id=answer>{{Back}}",
+		}},
+		"css":       ".card { font-family: arial; font-size: 20px; text-align: center; color: black; background-color: white; }",
+		"latexPre":  "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n\\setlength{\\parindent}{0in}\n\\begin{document}\n",
+		"latexPost": "\\end{document}",
+		"tags":      []string{}, "vers": []string{},
+		"req": []interface{}{[]interface{}{0, "any", []int{0}}},
+	}})
+	decks = ToJSON(gin.H{
+		"1":                      deck(1, "Default"),
+		strconv.Itoa(ankiDeckID): deck(ankiDeckID, "BandLy Vocabulary"),
+	})
+	dconf = ToJSON(gin.H{"1": gin.H{
+		"id": 1, "name": "Default", "mod": 0, "usn": 0, "dyn": false, "maxTaken": 60, "timer": 0,
+		"autoplay": true, "replayq": true,
+		"new":   gin.H{"delays": []int{1, 10}, "ints": []int{1, 4, 7}, "initialFactor": 2500, "separate": true, "order": 1, "perDay": 20, "bury": true},
+		"rev":   gin.H{"perDay": 100, "ease4": 1.3, "fuzz": 0.05, "minSpace": 1, "ivlFct": 1, "maxIvl": 36500, "bury": true},
+		"lapse": gin.H{"delays": []int{10}, "mult": 0, "minInt": 1, "leechFails": 8, "leechAction": 0},
+	}})
+	return conf, models, decks, dconf
+}
+
+// ankiChecksum is Anki's duplicate check: the first 8 hex digits of the
+// SHA-1 of the sort field as plain text
+func ankiChecksum(field string) int64 {
+	sum := sha1.Sum([]byte(field))
+	return int64(binary.BigEndian.Uint32(sum[:4]))
+}
+
+// writeAPKG writes the cards as an Anki package. Reviewed cards keep their
+// SM-2 interval, ease and due date; the rest import as new cards.
+func writeAPKG(w io.Writer, cards []VocabularyCard, now time.Time) error {
+	dir, err := os.MkdirTemp("", "bandly-apkg")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "collection.anki2")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	// Review due dates count days from the collection's creation
+	crt := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range ankiSchema {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		conf, models, decks, dconf := ankiCollection(now)
+		if err := tx.Exec(`INSERT INTO col VALUES (1, ?, ?, ?, 11, 0, 0, 0, ?, ?, ?, ?, '{}')`,
+			crt.Unix(), now.UnixMilli(), now.UnixMilli(), conf, models, decks, dconf).Error; err != nil {
+			return err
+		}
+
+		base := now.UnixMilli()
+		for i, card := range cards {
+			front := html.EscapeString(card.Word)
+			back := vocabularyBack(card)
+			id := base + int64(i)
+			if err := tx.Exec(`INSERT INTO notes VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')`,
+				id, fmt.Sprintf("bandly-%d", card.ID), ankiModelID, now.Unix(), " bandly "+card.Source+" ",
+				front+"\x1f"+back, card.Word, ankiChecksum(card.Word)).Error; err != nil {
+				return err
+			}
+
+			kind, due, ivl, factor := 0, int64(i+1), 0, 0 // new card at position i+1
+			if card.Repetitions > 0 {
+				kind, ivl, factor = 2, card.IntervalDays, int(card.EaseFactor*1000)
+				due = int64(math.Floor(card.DueAt.Sub(crt).Hours() / 24))
+			}
+			if err := tx.Exec(`INSERT INTO cards VALUES (?, ?, ?, 0, ?, -1, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, 0, '')`,
+				id, id, ankiDeckID, now.Unix(), kind, kind, due, ivl, factor,
+				card.Repetitions, card.Lapses).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.Close(); err != nil {
+		return err
+	}
+
+	collection, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"collection.anki2", collection}, {"media", []byte("{}")}} {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
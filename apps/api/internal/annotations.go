@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Annotation is a single issue the examiner flagged in an essay
+type Annotation struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	EssayID     uint      `gorm:"index" json:"essayId"`
+	UserID      *uint     `gorm:"index" json:"-"`         // copied from the essay for per-user aggregation
+	Criterion   string    `gorm:"index" json:"criterion"` // "ta" | "cc" | "lr" | "gra"
+	Category    string    `gorm:"index" json:"category"`  // see annotationCategories
+	Excerpt     string    `gorm:"type:TEXT" json:"excerpt"`
+	Suggestion  string    `gorm:"type:TEXT" json:"suggestion"`
+	Explanation string    `gorm:"type:TEXT" json:"explanation"`
+	Start       int       `json:"start"` // byte offset of Excerpt in Essay.Text, -1 if not found
+	End         int       `json:"end"`
+	CreatedAt   time.Time `gorm:"index" json:"createdAt"`
+}
+
+// ScoreAnnotation is the annotation shape returned by the AI scorer
+type ScoreAnnotation struct {
+	Criterion   string `json:"criterion"`
+	Category    string `json:"category"`
+	Excerpt     string `json:"excerpt"`
+	Suggestion  string `json:"suggestion"`
+	Explanation string `json:"explanation"`
+}
+
+// annotationCategories is the fixed error taxonomy per criterion so that
+// recurring problems can be counted across essays
+var annotationCategories = map[string][]string{
+	"ta":  {"off_topic", "unclear_position", "underdeveloped_idea", "missing_overview", "irrelevant_detail"},
+	"cc":  {"linking_misuse", "paragraphing", "referencing", "progression"},
+	"lr":  {"word_choice", "collocation", "repetition", "word_form", "spelling", "register"},
+	"gra": {"articles", "subject_verb_agreement", "tense", "prepositions", "plurals", "sentence_structure", "punctuation"},
+}
+
+// maxAnnotations caps how many annotations are kept per essay
+const maxAnnotations = 20
+
+// normalizeAnnotations drops annotations with unknown criteria and maps
+// unknown categories to "other" so the taxonomy stays closed
+func normalizeAnnotations(in []ScoreAnnotation) []ScoreAnnotation {
+	var out []ScoreAnnotation
+	for _, a := range in {
+		a.Criterion = strings.ToLower(strings.TrimSpace(a.Criterion))
+		categories, ok := annotationCategories[a.Criterion]
+		if !ok {
+			continue
+		}
+
+		a.Category = strings.ToLower(strings.TrimSpace(a.Category))
+		a.Category = strings.ReplaceAll(a.Category, " ", "_")
+		known := false
+		for _, cat := range categories {
+			if cat == a.Category {
+				known = true
+				break
+			}
+		}
+		if !known {
+			a.Category = "other"
+		}
+
+		a.Excerpt = strings.TrimSpace(a.Excerpt)
+		if a.Excerpt == "" && a.Suggestion == "" {
+			continue
+		}
+
+		out = append(out, a)
+		if len(out) == maxAnnotations {
+			break
+		}
+	}
+	return out
+}
+
+// SaveAnnotations stores the scorer annotations for a saved essay
+func SaveAnnotations(db *gorm.DB, essay Essay, annotations []ScoreAnnotation) ([]Annotation, error) {
+	if len(annotations) == 0 {
+		return nil, nil
+	}
+
+	rows := make([]Annotation, 0, len(annotations))
+	for _, a := range annotations {
+		start, end := -1, -1
+		if a.Excerpt != "" {
+			if i := strings.Index(essay.Text, a.Excerpt); i >= 0 {
+				start, end = i, i+len(a.Excerpt)
+			}
+		}
+
+		rows = append(rows, Annotation{
+			EssayID:     essay.ID,
+			UserID:      essay.UserID,
+			Criterion:   a.Criterion,
+			Category:    a.Category,
+			Excerpt:     a.Excerpt,
+			Suggestion:  a.Suggestion,
+			Explanation: a.Explanation,
+			Start:       start,
+			End:         end,
+			CreatedAt:   essay.CreatedAt,
+		})
+	}
+
+	if err := db.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
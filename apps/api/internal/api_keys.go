@@ -0,0 +1,368 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sidigigroup/bandly/api/internal/limiter"
+	"gorm.io/gorm"
+)
+
+// Scopes an API key can be granted
+const (
+	ScopeEssaysAnalyze = "essays:analyze"
+	ScopeReportsRead   = "reports:read"
+)
+
+var apiKeyScopes = map[string]bool{ScopeEssaysAnalyze: true, ScopeReportsRead: true}
+
+const (
+	apiKeyPrefix           = "bly_"
+	apiKeyDefaultRateLimit = 60  // requests per minute
+	apiKeyMaxRateLimit     = 600 // highest limit a key can be given
+	apiKeyMaxActive        = 10  // active keys per user or organisation
+)
+
+var (
+	errAPIKeyScope = errors.New("unknown scope")
+	errAPIKeyLimit = errors.New("too many active API keys")
+)
+
+// APIKey lets a server call the API without a user session. A user key
+// acts as its user; an organisation key has no user and its essays belong
+// to the organisation. Only the hash of the key is stored, Prefix is its
+// public part for telling keys apart.
+type APIKey struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Name           string     `json:"name"`
+	Prefix         string     `gorm:"uniqueIndex" json:"prefix"` // "bly_" and 8 hex characters
+	KeyHash        string     `gorm:"uniqueIndex" json:"-"`
+	UserID         *uint      `gorm:"index" json:"userId,omitempty"`         // nil for organisation keys
+	OrganizationID *uint      `gorm:"index" json:"organizationId,omitempty"` // set for organisation keys
+	CreatedBy      uint       `json:"createdBy"`
+	Scopes         string     `json:"-"`         // comma separated, see apiKeyScopes
+	RateLimit      int        `json:"rateLimit"` // requests per minute
+	LastUsedAt     *time.Time `json:"lastUsedAt"`
+	LastUsedIP     string     `json:"lastUsedIp,omitempty"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// HasScope reports whether the key was granted a scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range strings.Split(k.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyResponse is a key as listed to its owner. Secret is only set in
+// the response that creates the key.
+type APIKeyResponse struct {
+	APIKey
+	ScopeList []string `json:"scopes"`
+	Active    bool     `json:"active"`
+	Secret    string   `json:"secret,omitempty"`
+}
+
+func apiKeyResponse(k APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		APIKey:    k,
+		ScopeList: strings.Split(k.Scopes, ","),
+		Active:    k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(time.Now())),
+	}
+}
+
+// newAPIKey returns a random key and its public prefix
+func newAPIKey() (string, string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret, err := newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyPrefix + hex.EncodeToString(b)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// apiKeyScope limits a query to the keys of a user or an organisation
+func apiKeyScope(userID *uint, orgID *uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if userID != nil {
+			return db.Where("user_id = ?", *userID)
+		}
+		return db.Where("user_id IS NULL AND organization_id = ?", orgID)
+	}
+}
+
+// apiKeyOwner returns who the keys of a route belong to: the signed-in
+// user, or the admin's organisation for organisation keys
+func apiKeyOwner(c *gin.Context, forOrg bool) (*uint, *uint, bool) {
+	if forOrg {
+		orgID := currentOrgID(c)
+		return nil, orgID, orgID != nil
+	}
+	userID := c.MustGet("userID").(uint)
+	return &userID, nil, true
+}
+
+// createAPIKey stores a key for the owner and returns the key itself,
+// which is never shown again
+func createAPIKey(db *gorm.DB, key *APIKey, scopes []string) (string, error) {
+	for _, s := range scopes {
+		if !apiKeyScopes[s] {
+			return "", fmt.Errorf("%w: %s", errAPIKeyScope, s)
+		}
+	}
+	key.Scopes = strings.Join(scopes, ",")
+
+	var active int64
+	db.Model(&APIKey{}).Scopes(apiKeyScope(key.UserID, key.OrganizationID)).
+		Where("revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now()).
+		Count(&active)
+	if active >= apiKeyMaxActive {
+		return "", errAPIKeyLimit
+	}
+
+	secret, prefix, err := newAPIKey()
+	if err != nil {
+		return "", err
+	}
+	key.Prefix = prefix
+	key.KeyHash = hashToken(secret)
+	if err := db.Create(key).Error; err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// APIKeyAuth authenticates requests carrying an X-API-Key header. The key
+// needs the given scope; a user key sets userID and user like a session
+// does. Requests without the header pass through untouched.
+func APIKeyAuth(db *gorm.DB, l *limiter.Limiter, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("X-API-Key")
+		if header == "" {
+			c.Next()
+			return
+		}
+		now := time.Now()
+		var key APIKey
+		err := db.Where("key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", hashToken(header), now).
+			First(&key).Error
+		if err != nil {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid API key"})
+			return
+		}
+		if !key.HasScope(scope) {
+			c.AbortWithStatusJSON(403, gin.H{"error": "API key lacks the " + scope + " scope"})
+			return
+		}
+
+		var user User
+		if key.UserID != nil {
+			if err := db.First(&user, *key.UserID).Error; err != nil {
+				c.AbortWithStatusJSON(401, gin.H{"error": "Invalid API key"})
+				return
+			}
+		}
+
+		// Bursts up to the per-minute limit, refilled evenly over the minute
+		limit := key.RateLimit
+		if limit <= 0 {
+			limit = apiKeyDefaultRateLimit
+		}
+		res, ok := allowRequest(c, l, fmt.Sprintf("apikey:%d", key.ID), limiter.Bucket(limit, time.Minute))
+		if !ok {
+			return
+		}
+		if !res.Allowed {
+			c.AbortWithStatusJSON(429, gin.H{"error": "API key rate limit exceeded", "retryAfter": int(math.Ceil(res.RetryAfter.Seconds()))})
+			return
+		}
+
+		// Recorded at most once a minute to spare the database
+		db.Model(&APIKey{}).
+			Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.ID, now.Add(-time.Minute)).
+			Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": c.ClientIP()})
+
+		c.Set("apiKey", key)
+		if key.UserID != nil {
+			c.Set("userID", user.ID)
+			c.Set("user", user)
+		}
+		c.Next()
+	}
+}
+
+// GetAPIKeys lists the keys of the user, or of the admin's organisation
+func GetAPIKeys(db *gorm.DB, forOrg bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, orgID, ok := apiKeyOwner(c, forOrg)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "organization keys need an organization"})
+			return
+		}
+
+		var keys []APIKey
+		db.Scopes(apiKeyScope(userID, orgID)).Order("created_at DESC").Find(&keys)
+
+		out := make([]APIKeyResponse, len(keys))
+		for i, k := range keys {
+			out[i] = apiKeyResponse(k)
+		}
+		c.JSON(http.StatusOK, gin.H{"keys": out, "scopes": sortedKeys(apiKeyScopes)})
+	}
+}
+
+// CreateAPIKey creates a key for the user, or for the admin's organisation.
+// The key is in the response only.
+func CreateAPIKey(db *gorm.DB, forOrg bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name          string   `json:"name" binding:"required,max=100"`
+			Scopes        []string `json:"scopes" binding:"required,min=1"`
+			RateLimit     int      `json:"rateLimit"`     // per minute, 0 for the default
+			ExpiresInDays int      `json:"expiresInDays"` // 0 for no expiry
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name and at least one scope are required"})
+			return
+		}
+		if req.RateLimit < 0 || req.RateLimit > apiKeyMaxRateLimit || req.ExpiresInDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("rateLimit must be 0-%d and expiresInDays positive", apiKeyMaxRateLimit)})
+			return
+		}
+
+		userID, orgID, ok := apiKeyOwner(c, forOrg)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "organization keys need an organization"})
+			return
+		}
+
+		key := APIKey{
+			Name:           req.Name,
+			UserID:         userID,
+			OrganizationID: orgID,
+			CreatedBy:      c.MustGet("userID").(uint),
+			RateLimit:      req.RateLimit,
+		}
+		if key.RateLimit == 0 {
+			key.RateLimit = apiKeyDefaultRateLimit
+		}
+		if req.ExpiresInDays > 0 {
+			expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			key.ExpiresAt = &expires
+		}
+
+		secret, err := createAPIKey(db, &key, req.Scopes)
+		switch {
+		case errors.Is(err, errAPIKeyScope):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "scopes": sortedKeys(apiKeyScopes)})
+			return
+		case errors.Is(err, errAPIKeyLimit):
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("at most %d active keys, revoke one first", apiKeyMaxActive)})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create API key"})
+			return
+		}
+		auditChange(c, "api_key.create", "api_key", key.ID, nil, key)
+
+		resp := apiKeyResponse(key)
+		resp.Secret = secret
+		c.JSON(http.StatusCreated, resp)
+	}
+}
+
+// RevokeAPIKey revokes a key of the user, or of the admin's organisation
+func RevokeAPIKey(db *gorm.DB, forOrg bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, orgID, ok := apiKeyOwner(c, forOrg)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "organization keys need an organization"})
+			return
+		}
+
+		var key APIKey
+		if err := db.Scopes(apiKeyScope(userID, orgID)).Where("revoked_at IS NULL").First(&key, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+
+		before := key
+		if err := db.Model(&key).Update("revoked_at", time.Now()).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke API key"})
+			return
+		}
+		auditChange(c, "api_key.revoke", "api_key", key.ID, before, key)
+
+		c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+	}
+}
+
+// GetAPIReports lists the reports of an API key's owner, newest first, for
+// partners syncing results. Pass ?since=RFC3339 to fetch only newer ones.
+func GetAPIReports(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k, ok := c.Get("apiKey")
+		if !ok {
+			c.AbortWithStatusJSON(401, gin.H{"error": "X-API-Key header required"})
+			return
+		}
+		key := k.(APIKey)
+
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if page < 1 {
+			page = 1
+		}
+		if limit < 1 || limit > 100 {
+			limit = 50
+		}
+
+		query := db.Model(&Essay{})
+		if key.UserID != nil {
+			query = query.Where("user_id = ?", *key.UserID)
+		} else {
+			query = query.Where("organization_id = ?", key.OrganizationID)
+		}
+		if since, err := time.Parse(time.RFC3339, c.Query("since")); err == nil {
+			query = query.Where("created_at > ?", since)
+		}
+
+		var essays []Essay
+		if err := query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&essays).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to fetch reports"})
+			return
+		}
+
+		reports := make([]gin.H, len(essays))
+		for i, essay := range essays {
+			score := essayScore(essay)
+			reports[i] = gin.H{
+				"publicId":  essay.PublicID,
+				"userId":    essay.UserID,
+				"taskType":  essay.TaskType,
+				"overall":   essay.Overall,
+				"bands":     gin.H{"ta": score.TA, "cc": score.CC, "lr": score.LR, "gra": score.GRA},
+				"cefr":      essay.CEFR,
+				"createdAt": essay.CreatedAt,
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"reports": reports, "page": page, "limit": limit})
+	}
+}
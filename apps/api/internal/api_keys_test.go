@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	secret, prefix, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(prefix, apiKeyPrefix) || len(prefix) != len(apiKeyPrefix)+8 {
+		t.Errorf("prefix = %q, want %s and 8 hex characters", prefix, apiKeyPrefix)
+	}
+	if !strings.HasPrefix(secret, prefix+"_") || len(secret) < len(prefix)+40 {
+		t.Errorf("secret %q does not start with its prefix or is too short", secret)
+	}
+	if other, _, _ := newAPIKey(); other == secret {
+		t.Error("newAPIKey returned the same key twice")
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	key := APIKey{Scopes: ScopeEssaysAnalyze}
+
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{ScopeEssaysAnalyze, true},
+		{ScopeReportsRead, false},
+		{"essays", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			if got := key.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+
+	// Unknown scopes are refused before the database is touched
+	if _, err := createAPIKey(nil, &APIKey{}, []string{ScopeReportsRead, "admin"}); !errors.Is(err, errAPIKeyScope) {
+		t.Errorf("createAPIKey() error = %v, want %v", err, errAPIKeyScope)
+	}
+}
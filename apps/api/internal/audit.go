@@ -0,0 +1,366 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AuditLog is one entry of the append-only trail of privileged actions and
+// auth events. Before and After hold only the fields that changed. With the
+// hash chain on, each Hash covers the entry and the Hash before it, so an
+// edited or removed row breaks the chain.
+type AuditLog struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt      time.Time `gorm:"index" json:"createdAt"`
+	OrganizationID *uint     `gorm:"index" json:"organizationId,omitempty"` // tenant of the actor
+	ActorID        *uint     `gorm:"index" json:"actorId,omitempty"`        // nil for anonymous requests
+	ActorEmail     string    `json:"actorEmail,omitempty"`
+	Action         string    `gorm:"index" json:"action"` // e.g. user.update, auth.login_failed
+	TargetType     string    `gorm:"index:idx_audit_target" json:"targetType,omitempty"`
+	TargetID       string    `gorm:"index:idx_audit_target" json:"targetId,omitempty"`
+	Before         string    `gorm:"type:TEXT" json:"before,omitempty"` // JSON
+	After          string    `gorm:"type:TEXT" json:"after,omitempty"`  // JSON
+	IPAddress      string    `json:"ip"`
+	UserAgent      string    `json:"userAgent"`
+	Hash           string    `gorm:"index" json:"hash,omitempty"` // "" when the chain is off
+}
+
+var (
+	errAuditAppendOnly  = errors.New("audit log entries cannot be changed")
+	errAuditChainBroken = errors.New("audit chain broken")
+)
+
+// BeforeUpdate keeps entries append-only
+func (AuditLog) BeforeUpdate(*gorm.DB) error { return errAuditAppendOnly }
+
+// BeforeDelete keeps entries append-only
+func (AuditLog) BeforeDelete(*gorm.DB) error { return errAuditAppendOnly }
+
+// auditKey is where handlers queue entries for Auditor.Middleware
+const auditKey = "auditEntries"
+
+// auditChainLock is the pg advisory lock that serialises chained writes
+const auditChainLock = 0x617564 // "aud"
+
+// auditRedacted are fields never copied into a diff
+var auditRedacted = map[string]bool{
+	"PassHash": true, "passHash": true, "Secret": true, "secret": true, "CodeHash": true,
+}
+
+// auditIgnored are fields that change on every write and only add noise
+var auditIgnored = map[string]bool{"UpdatedAt": true, "updatedAt": true}
+
+// Auditor writes the audit trail
+type Auditor struct {
+	db    *gorm.DB
+	chain bool
+}
+
+// NewAuditor builds the auditor. AUDIT_HASH_CHAIN=true chains the entries
+// so GET /api/sidigi/audit/verify can detect tampering.
+func NewAuditor(db *gorm.DB) *Auditor {
+	chain, _ := strconv.ParseBool(os.Getenv("AUDIT_HASH_CHAIN"))
+	return &Auditor{db: db, chain: chain}
+}
+
+// audit queues an entry to be written once the request is done
+func audit(c *gin.Context, entry AuditLog) {
+	queued, _ := c.Get(auditKey)
+	entries, _ := queued.([]AuditLog)
+	c.Set(auditKey, append(entries, entry))
+}
+
+// auditChange queues a change to a record. before is nil for creates and
+// after is nil for deletes.
+func auditChange(c *gin.Context, action, targetType string, targetID interface{}, before, after interface{}) {
+	b, a := auditDiff(before, after)
+	audit(c, AuditLog{Action: action, TargetType: targetType, TargetID: fmt.Sprint(targetID), Before: b, After: a})
+}
+
+// auditEvent queues an auth event about a user. The user is the actor, as
+// these requests have no signed-in staff member; user may be nil when the
+// account is unknown.
+func auditEvent(c *gin.Context, action string, user *User, data gin.H) {
+	entry := AuditLog{Action: action, TargetType: "user"}
+	if user != nil && user.ID != 0 {
+		entry.ActorID = &user.ID
+		entry.ActorEmail = user.Email
+		entry.OrganizationID = user.OrganizationID
+		entry.TargetID = strconv.FormatUint(uint64(user.ID), 10)
+	}
+	if data != nil {
+		entry.After = ToJSON(data)
+	}
+	audit(c, entry)
+}
+
+// auditFields flattens a record into its JSON fields
+func auditFields(v interface{}) map[string]interface{} {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(ToJSON(v)), &fields); err != nil {
+		return nil
+	}
+	for k := range fields {
+		if auditRedacted[k] || auditIgnored[k] {
+			delete(fields, k)
+		}
+	}
+	return fields
+}
+
+// auditDiff returns the before and after JSON of the fields that differ
+func auditDiff(before, after interface{}) (string, string) {
+	b, a := auditFields(before), auditFields(after)
+	if b != nil && a != nil {
+		for k := range b {
+			if reflect.DeepEqual(b[k], a[k]) {
+				delete(b, k)
+				delete(a, k)
+			}
+		}
+	}
+	var bs, as string
+	if len(b) > 0 {
+		bs = ToJSON(b)
+	}
+	if len(a) > 0 {
+		as = ToJSON(a)
+	}
+	return bs, as
+}
+
+// auditHash chains an entry to the hash of the one before it
+func auditHash(prev string, e AuditLog) string {
+	id := func(p *uint) string {
+		if p == nil {
+			return ""
+		}
+		return strconv.FormatUint(uint64(*p), 10)
+	}
+	fields := []string{
+		prev, e.CreatedAt.UTC().Format(time.RFC3339Nano), id(e.OrganizationID), id(e.ActorID), e.ActorEmail,
+		e.Action, e.TargetType, e.TargetID, e.Before, e.After, e.IPAddress, e.UserAgent,
+	}
+	sum := sha256.Sum256([]byte(ToJSON(fields)))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyAuditChain checks chained entries in ID order, starting after prev.
+// It returns the last good hash, how many entries matched and the ID of the
+// first broken entry, 0 when all match.
+func verifyAuditChain(prev string, entries []AuditLog) (string, int, uint) {
+	for i, e := range entries {
+		if auditHash(prev, e) != e.Hash {
+			return prev, i, e.ID
+		}
+		prev = e.Hash
+	}
+	return prev, len(entries), 0
+}
+
+// Record writes an entry, chaining it when the hash chain is on
+func (a *Auditor) Record(entry AuditLog) error {
+	// Stored timestamps keep microseconds, the hash must match them
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if !a.chain {
+		return a.db.Create(&entry).Error
+	}
+
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		// One chained write at a time, across all API instances
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLock).Error; err != nil {
+				return err
+			}
+		}
+		var last AuditLog
+		if err := tx.Select("hash").Where("hash <> ''").Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		entry.Hash = auditHash(last.Hash, entry)
+		return tx.Create(&entry).Error
+	})
+}
+
+// Middleware writes the entries a request queued. A successful /api/sidigi
+// mutation that queued none still gets a generic entry, so no admin change
+// goes unrecorded.
+func (a *Auditor) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		queued, _ := c.Get(auditKey)
+		entries, _ := queued.([]AuditLog)
+		if len(entries) == 0 && adminMutation(c) {
+			path := strings.TrimPrefix(c.FullPath(), "/api/sidigi")
+			entries = append(entries, AuditLog{
+				Action:     c.Request.Method + " " + path,
+				TargetType: strings.Split(strings.TrimPrefix(path, "/"), "/")[0],
+				TargetID:   c.Param("id"),
+			})
+		}
+
+		var actor *User
+		for _, key := range []string{"adminUser", "user"} {
+			if u, ok := c.Get(key); ok {
+				user := u.(User)
+				actor = &user
+				break
+			}
+		}
+		for _, entry := range entries {
+			if entry.ActorID == nil && actor != nil {
+				entry.ActorID, entry.ActorEmail, entry.OrganizationID = &actor.ID, actor.Email, actor.OrganizationID
+			}
+			entry.IPAddress = c.ClientIP()
+			entry.UserAgent = c.Request.UserAgent()
+			if err := a.Record(entry); err != nil {
+				log.Printf("Failed to record audit entry %s: %v", entry.Action, err)
+			}
+		}
+	}
+}
+
+// adminMutation reports whether the request changed something in the admin area
+func adminMutation(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return strings.HasPrefix(c.FullPath(), "/api/sidigi/") && c.Writer.Status() < 400
+}
+
+// auditQuery applies the list filters: actorId, action, targetType,
+// targetId, and from/to dates (YYYY-MM-DD, inclusive)
+func auditQuery(db *gorm.DB, c *gin.Context) *gorm.DB {
+	query := db.Model(&AuditLog{}).Scopes(TenantScope(currentOrgID(c)))
+	for param, column := range map[string]string{
+		"actorId":    "actor_id",
+		"action":     "action",
+		"targetType": "target_type",
+		"targetId":   "target_id",
+	} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(column+" = ?", v)
+		}
+	}
+	if from, err := time.Parse("2006-01-02", c.Query("from")); err == nil {
+		query = query.Where("created_at >= ?", from)
+	}
+	if to, err := time.Parse("2006-01-02", c.Query("to")); err == nil {
+		query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+	return query
+}
+
+// GetAuditLogs returns a paginated, filtered page of the tenant's audit log
+func GetAuditLogs(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if page < 1 {
+			page = 1
+		}
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		var total int64
+		auditQuery(db, c).Count(&total)
+
+		var entries []AuditLog
+		if err := auditQuery(db, c).Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&entries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load audit log"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"entries": entries,
+			"pagination": gin.H{
+				"total": total,
+				"page":  page,
+				"limit": limit,
+				"pages": int(math.Ceil(float64(total) / float64(limit))),
+			},
+		})
+	}
+}
+
+// ExportAuditLogs streams the filtered audit log as CSV, oldest first
+func ExportAuditLogs(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", "attachment; filename=bandly-audit.csv")
+
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "createdAt", "actorId", "actorEmail", "action", "targetType", "targetId", "before", "after", "ip", "userAgent", "hash"})
+
+		var batch []AuditLog
+		auditQuery(db, c).Order("id ASC").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+			for _, e := range batch {
+				actor := ""
+				if e.ActorID != nil {
+					actor = strconv.FormatUint(uint64(*e.ActorID), 10)
+				}
+				w.Write([]string{
+					strconv.FormatUint(uint64(e.ID), 10), e.CreatedAt.UTC().Format(time.RFC3339), actor, e.ActorEmail,
+					e.Action, e.TargetType, e.TargetID, e.Before, e.After, e.IPAddress, e.UserAgent, e.Hash,
+				})
+			}
+			w.Flush()
+			return w.Error()
+		})
+		w.Flush()
+	}
+}
+
+// VerifyAuditChain recomputes the hash chain over every chained entry. The
+// returned head can be kept outside the database: removing the newest
+// entries goes unnoticed by the chain alone.
+func VerifyAuditChain(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			batch   []AuditLog
+			head    string
+			broken  uint
+			checked int
+		)
+		err := db.Where("hash <> ''").Order("id ASC").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+			var n int
+			head, n, broken = verifyAuditChain(head, batch)
+			checked += n
+			if broken != 0 {
+				return errAuditChainBroken
+			}
+			return nil
+		}).Error
+		if err != nil && !errors.Is(err, errAuditChainBroken) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify audit log"})
+			return
+		}
+
+		resp := gin.H{"ok": broken == 0, "checked": checked, "head": head}
+		if broken != 0 {
+			resp["brokenAt"] = broken
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := User{ID: 7, Email: "a@example.com", PassHash: "old", Plan: "free", Role: RoleUser}
+	after := before
+	after.Plan = "pro"
+	after.PassHash = "new"
+
+	tests := []struct {
+		name       string
+		before     interface{}
+		after      interface{}
+		wantBefore string
+		wantAfter  string
+	}{
+		{"Only changed fields, never the hash", before, after, `{"Plan":"free"}`, `{"Plan":"pro"}`},
+		{"No change", before, before, "", ""},
+		{"Create skips noise", nil, map[string]interface{}{"title": "Cities", "updatedAt": "now"}, "", `{"title":"Cities"}`},
+		{"Delete of a nil pointer", (*User)(nil), nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, a := auditDiff(tt.before, tt.after)
+			if b != tt.wantBefore || a != tt.wantAfter {
+				t.Errorf("auditDiff() = %s, %s; want %s, %s", b, a, tt.wantBefore, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	var entries []AuditLog
+	prev := ""
+	for i, action := range []string{"auth.login", "user.update", "blog.delete"} {
+		e := AuditLog{ID: uint(i + 1), CreatedAt: now.Add(time.Duration(i) * time.Minute), Action: action, TargetType: "user", TargetID: "7"}
+		e.Hash = auditHash(prev, e)
+		prev = e.Hash
+		entries = append(entries, e)
+	}
+
+	edited := append([]AuditLog{}, entries...)
+	edited[1].After = `{"Plan":"pro"}`
+
+	tests := []struct {
+		name        string
+		entries     []AuditLog
+		wantChecked int
+		wantBroken  uint
+	}{
+		{"Intact", entries, 3, 0},
+		{"Edited entry", edited, 1, 2},
+		{"Removed entry", []AuditLog{entries[0], entries[2]}, 1, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, checked, broken := verifyAuditChain("", tt.entries)
+			if checked != tt.wantChecked || broken != tt.wantBroken {
+				t.Errorf("verifyAuditChain() = %d, %d; want %d, %d", checked, broken, tt.wantChecked, tt.wantBroken)
+			}
+		})
+	}
+}
@@ -1,12 +1,11 @@
 package internal
 
 import (
+	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -15,11 +14,16 @@ type AuthRequest struct {
 	Email    string `json:"email"`
 	Username string `json:"username"`
 	Password string `json:"password" binding:"required,min=6"`
+
+	Organization string `json:"organization"` // tenant slug to join at signup, optional
 }
 
 type AuthResponse struct {
-	Token string   `json:"token"`
-	User  UserInfo `json:"user"`
+	Token        string   `json:"token"` // short-lived access token
+	RefreshToken string   `json:"refreshToken"`
+	ExpiresIn    int      `json:"expiresIn"` // access token lifetime in seconds
+	SessionID    string   `json:"sessionId"`
+	User         UserInfo `json:"user"`
 }
 
 type UserInfo struct {
@@ -27,21 +31,41 @@ type UserInfo struct {
 	Email string `json:"email"`
 	Plan  string `json:"plan"`
 	Role  string `json:"role"`
+
+	TargetBand *float32   `json:"targetBand,omitempty"`
+	ExamDate   *time.Time `json:"examDate,omitempty"`
+
+	OrganizationID *uint `json:"organizationId,omitempty"`
+	EmailVerified  bool  `json:"emailVerified"`
 }
 
-// Signup creates a new user account
-func Signup(db *gorm.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if db == nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not available"})
-			return
-		}
+// userInfoOf returns the public view of a user
+func userInfoOf(user User) UserInfo {
+	return UserInfo{
+		ID:    user.ID,
+		Email: user.Email,
+		Plan:  user.Plan,
+		Role:  user.Role,
+
+		OrganizationID: user.OrganizationID,
+		EmailVerified:  user.EmailVerifiedAt != nil,
+	}
+}
 
+// Signup creates a new user account and emails a verification link
+func Signup(db *gorm.DB, auth *AuthService, mailer Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		var req AuthRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 			return
 		}
+		email, ok := normalizeEmail(req.Email)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email address"})
+			return
+		}
+		req.Email = email
 
 		// Check if user already exists
 		var existingUser User
@@ -50,6 +74,21 @@ func Signup(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Join a tenant when a slug is given
+		var orgID *uint
+		if req.Organization != "" {
+			var org Organization
+			if err := db.Where("slug = ? AND is_active = ?", req.Organization, true).First(&org).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown organization"})
+				return
+			}
+			if !orgHasSeat(db, org) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "organization has no free seats"})
+				return
+			}
+			orgID = &org.ID
+		}
+
 		// Hash password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
@@ -62,52 +101,36 @@ func Signup(db *gorm.DB) gin.HandlerFunc {
 			Email:    req.Email,
 			PassHash: string(hashedPassword),
 			Plan:     "free",
+
+			OrganizationID: orgID,
 		}
 
 		if err := db.Create(&user).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
 			return
 		}
+		auditEvent(c, "auth.signup", &user, nil)
 
-		// Generate JWT token for immediate login
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"sub": user.ID,
-			"exp": time.Now().Add(7 * 24 * time.Hour).Unix(), // 7 days
-		})
-
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			jwtSecret = "default-secret" // fallback for development
+		// A failed email is not fatal, the user can ask for another link
+		if err := sendVerificationEmail(c.Request.Context(), auth, mailer, user); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
 		}
 
-		tokenString, err := token.SignedString([]byte(jwtSecret))
+		// Start a session for immediate login
+		resp, err := startSession(db, auth, c, user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 			return
 		}
+		resp.User = userInfoOf(user)
 
-		userInfo := UserInfo{
-			ID:    user.ID,
-			Email: user.Email,
-			Plan:  user.Plan,
-			Role:  user.Role,
-		}
-
-		c.JSON(http.StatusCreated, AuthResponse{
-			Token: tokenString,
-			User:  userInfo,
-		})
+		c.JSON(http.StatusCreated, resp)
 	}
 }
 
 // GetProfile returns the current user's profile information
 func GetProfile(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if db == nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not available"})
-			return
-		}
-
 		userID, exists := c.Get("userID")
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
@@ -121,76 +144,26 @@ func GetProfile(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		userInfo := UserInfo{
-			ID:    user.ID,
-			Email: user.Email,
-			Plan:  user.Plan,
-			Role:  user.Role,
-		}
-
-		c.JSON(http.StatusOK, userInfo)
-	}
-}
-
-// JWTMiddleware validates JWT tokens and extracts user ID
-func JWTMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
-		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization header required"})
-			c.Abort()
-			return
-		}
-
-		// Remove "Bearer " prefix if present
-		if len(tokenString) > 7 && tokenString[:7] == "Bearer " {
-			tokenString = tokenString[7:]
-		}
+			ID:         user.ID,
+			Email:      user.Email,
+			Plan:       user.Plan,
+			Role:       user.Role,
+			TargetBand: user.TargetBand,
+			ExamDate:   user.ExamDate,
 
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			jwtSecret = "default-secret"
+			OrganizationID: user.OrganizationID,
+			EmailVerified:  user.EmailVerifiedAt != nil,
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(jwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-			c.Abort()
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
-			c.Abort()
-			return
-		}
-
-		userID, ok := claims["sub"].(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user id in token"})
-			c.Abort()
-			return
-		}
-
-		c.Set("userID", uint(userID))
-		c.Next()
+		c.JSON(http.StatusOK, userInfo)
 	}
 }
 
-// Login authenticates a user and returns a JWT token
-func Login(db *gorm.DB) gin.HandlerFunc {
+// Login authenticates a user and returns a JWT token, or an MFA challenge
+// when the user has two-factor authentication enabled. Repeated failures
+// slow down, then lock, the account and the client IP.
+func Login(db *gorm.DB, auth *AuthService, guard *LoginGuard) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if db == nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not available"})
-			return
-		}
-
 		var req AuthRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
@@ -203,55 +176,59 @@ func Login(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Find user by email or username
-		var user User
-		var err error
+		identifier := req.Email
 		if req.Username != "" {
-			// Try to find by username (stored in email field for admin)
-			err = db.Where("email = ?", req.Username).First(&user).Error
-		} else {
-			// Find by email
-			err = db.Where("email = ?", req.Email).First(&user).Error
+			// Admin usernames are stored in the email field
+			identifier = req.Username
 		}
 
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		// Refuse attempts while the account or IP is backing off
+		ctx := c.Request.Context()
+		if status := guard.Check(ctx, identifier, c.ClientIP()); status.RetryAfter > 0 {
+			respondLoginBlocked(c, status)
 			return
 		}
 
-		// Check password
-		if err := bcrypt.CompareHashAndPassword([]byte(user.PassHash), []byte(req.Password)); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
-			return
+		var user User
+		err := db.Where("email = ?", identifier).First(&user).Error
+		if err == nil {
+			err = bcrypt.CompareHashAndPassword([]byte(user.PassHash), []byte(req.Password))
 		}
+		if err != nil {
+			status := guard.Fail(ctx, identifier, c.ClientIP())
 
-		// Generate JWT token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"sub": user.ID,
-			"exp": time.Now().Add(7 * 24 * time.Hour).Unix(), // 7 days
-		})
+			auditEvent(c, "auth.login_failed", &user, gin.H{"identifier": identifier})
+			if status.Locked {
+				auditEvent(c, "auth.login_locked", &user, gin.H{"identifier": identifier, "seconds": int(status.RetryAfter.Seconds())})
+			}
 
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			jwtSecret = "default-secret" // fallback for development
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials", "captchaRequired": status.CaptchaRequired})
+			return
 		}
+		guard.Succeed(ctx, identifier)
 
-		tokenString, err := token.SignedString([]byte(jwtSecret))
+		// With 2FA on, the password only earns a challenge for VerifyMFA
+		challenge, err := mfaChallenge(db, auth, user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 			return
 		}
+		if challenge != "" {
+			c.JSON(http.StatusOK, gin.H{
+				"mfaRequired": true,
+				"mfaToken":    challenge,
+				"expiresIn":   int(mfaChallengeTTL.Seconds()),
+			})
+			return
+		}
 
-		userInfo := UserInfo{
-			ID:    user.ID,
-			Email: user.Email,
-			Plan:  user.Plan,
-			Role:  user.Role,
+		resp, err := startSession(db, auth, c, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
 		}
+		resp.User = userInfoOf(user)
 
-		c.JSON(http.StatusOK, AuthResponse{
-			Token: tokenString,
-			User:  userInfo,
-		})
+		c.JSON(http.StatusOK, resp)
 	}
 }
@@ -0,0 +1,436 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// SigningKey is one key the auth service accepts, identified by its kid.
+// Private is nil for verify-only keys kept around after rotation.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey // []byte for HMAC
+	Public  crypto.PublicKey  // []byte for HMAC
+}
+
+// AccessClaims are the claims of an access token
+type AccessClaims struct {
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// ActionClaims are the claims of single-purpose tokens sent by email.
+// Binding ties a token to account state, such as the password hash, so it
+// stops working once that state changes.
+type ActionClaims struct {
+	Binding string `json:"bnd"`
+	jwt.RegisteredClaims
+}
+
+// AuthService issues and verifies access tokens. Every auth middleware is
+// built on it so all routes agree on keys, issuer and audience.
+type AuthService struct {
+	db         *gorm.DB
+	issuer     string
+	audience   string
+	keys       map[string]SigningKey
+	signingKID string
+	accessTTL  time.Duration
+	mfaRoles   map[string]bool // roles that must use two-factor authentication
+}
+
+var (
+	errNoSigningKey = errors.New("no JWT signing key configured")
+	errUnknownKID   = errors.New("unknown key id")
+)
+
+// NewAuthService configures the auth service from the environment:
+//
+//	JWT_KEYS         comma separated kid:alg:material entries, where material
+//	                 is the secret for HS256 or a PEM file path for RS256/EdDSA
+//	JWT_SIGNING_KID  kid used to sign new tokens, defaults to the first key
+//	JWT_SECRET       single HS256 key (kid "default") when JWT_KEYS is unset
+//	JWT_ISSUER       defaults to "bandly-api"
+//	JWT_AUDIENCE     defaults to "bandly-app"
+//	MFA_REQUIRED_ROLES  comma separated roles that must enrol in two-factor
+//	                 authentication, e.g. "admin,support"; unset, 2FA is
+//	                 optional for everyone
+//
+// Outside release mode a development secret is used when nothing is set.
+func NewAuthService(db *gorm.DB) (*AuthService, error) {
+	var keys []SigningKey
+	var err error
+	switch {
+	case os.Getenv("JWT_KEYS") != "":
+		keys, err = parseSigningKeys(os.Getenv("JWT_KEYS"))
+		if err != nil {
+			return nil, err
+		}
+	case os.Getenv("JWT_SECRET") != "":
+		secret := []byte(os.Getenv("JWT_SECRET"))
+		keys = []SigningKey{{ID: "default", Method: jwt.SigningMethodHS256, Private: secret, Public: secret}}
+	case gin.Mode() != gin.ReleaseMode:
+		secret := []byte("default-secret") // fallback for development
+		keys = []SigningKey{{ID: "dev", Method: jwt.SigningMethodHS256, Private: secret, Public: secret}}
+	default:
+		return nil, errNoSigningKey
+	}
+
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = "bandly-api"
+	}
+	audience := os.Getenv("JWT_AUDIENCE")
+	if audience == "" {
+		audience = "bandly-app"
+	}
+
+	s, err := newAuthService(db, issuer, audience, keys, os.Getenv("JWT_SIGNING_KID"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range strings.Split(os.Getenv("MFA_REQUIRED_ROLES"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			s.mfaRoles[role] = true
+		}
+	}
+	return s, nil
+}
+
+// newAuthService builds the service from parsed keys; signingKID "" picks the first
+func newAuthService(db *gorm.DB, issuer, audience string, keys []SigningKey, signingKID string) (*AuthService, error) {
+	if len(keys) == 0 {
+		return nil, errNoSigningKey
+	}
+	s := &AuthService{
+		db:         db,
+		issuer:     issuer,
+		audience:   audience,
+		keys:       map[string]SigningKey{},
+		signingKID: signingKID,
+		accessTTL:  accessTokenTTL,
+		mfaRoles:   map[string]bool{},
+	}
+	for _, k := range keys {
+		if _, dup := s.keys[k.ID]; dup {
+			return nil, fmt.Errorf("duplicate JWT key id %q", k.ID)
+		}
+		s.keys[k.ID] = k
+	}
+	if s.signingKID == "" {
+		s.signingKID = keys[0].ID
+	}
+	signing, ok := s.keys[s.signingKID]
+	if !ok {
+		return nil, fmt.Errorf("JWT_SIGNING_KID %q is not among the configured keys", s.signingKID)
+	}
+	if signing.Private == nil {
+		return nil, fmt.Errorf("JWT key %q has no private key and cannot sign", s.signingKID)
+	}
+	return s, nil
+}
+
+// parseSigningKeys parses the JWT_KEYS format described on NewAuthService
+func parseSigningKeys(spec string) ([]SigningKey, error) {
+	var keys []SigningKey
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid JWT key entry %q, want kid:alg:material", entry)
+		}
+		key, err := loadSigningKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// loadSigningKey reads one key. PEM files may hold a private key, or just a
+// public key to keep verifying tokens from a retired signer.
+func loadSigningKey(kid, alg, material string) (SigningKey, error) {
+	key := SigningKey{ID: kid}
+	switch alg {
+	case "HS256":
+		key.Method = jwt.SigningMethodHS256
+		key.Private, key.Public = []byte(material), []byte(material)
+		return key, nil
+	case "RS256", "EdDSA":
+	default:
+		return key, fmt.Errorf("JWT key %q: unsupported algorithm %q", kid, alg)
+	}
+
+	pem, err := os.ReadFile(material)
+	if err != nil {
+		return key, fmt.Errorf("JWT key %q: %w", kid, err)
+	}
+
+	if alg == "RS256" {
+		key.Method = jwt.SigningMethodRS256
+		if priv, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+			key.Private, key.Public = priv, &priv.PublicKey
+			return key, nil
+		}
+		pub, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return key, fmt.Errorf("JWT key %q: %w", kid, err)
+		}
+		key.Public = pub
+		return key, nil
+	}
+
+	key.Method = jwt.SigningMethodEdDSA
+	if priv, err := jwt.ParseEdPrivateKeyFromPEM(pem); err == nil {
+		key.Private, key.Public = priv, priv.(ed25519.PrivateKey).Public()
+		return key, nil
+	}
+	pub, err := jwt.ParseEdPublicKeyFromPEM(pem)
+	if err != nil {
+		return key, fmt.Errorf("JWT key %q: %w", kid, err)
+	}
+	key.Public = pub
+	return key, nil
+}
+
+// Issue signs an access token for a session with the current signing key
+func (s *AuthService) Issue(userID uint, sessionID, jti string, now time.Time) (string, error) {
+	key := s.keys[s.signingKID]
+	token := jwt.NewWithClaims(key.Method, AccessClaims{
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			Audience:  jwt.ClaimStrings{s.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL)),
+			ID:        jti,
+		},
+	})
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Verify checks a token's signature, kid, issuer, audience and lifetime
+func (s *AuthService) Verify(tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	if err := s.parse(tokenString, s.audience, claims); err != nil {
+		return nil, err
+	}
+	if claims.ID == "" || claims.SessionID == "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// IssueAction signs a single-purpose token, such as an email verification
+// link. The purpose is the audience, so it is never accepted as an access
+// token or for another purpose.
+func (s *AuthService) IssueAction(userID uint, purpose, binding string, ttl time.Duration, now time.Time) (string, error) {
+	key := s.keys[s.signingKID]
+	token := jwt.NewWithClaims(key.Method, ActionClaims{
+		Binding: binding,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			Audience:  jwt.ClaimStrings{purpose},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// VerifyAction checks a token from IssueAction and returns its user ID and binding
+func (s *AuthService) VerifyAction(tokenString, purpose string) (uint, string, error) {
+	claims := &ActionClaims{}
+	if err := s.parse(tokenString, purpose, claims); err != nil {
+		return 0, "", err
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil {
+		return 0, "", jwt.ErrTokenInvalidSubject
+	}
+	return uint(userID), claims.Binding, nil
+}
+
+// parse verifies a token signed by one of our keys for the given audience
+func (s *AuthService) parse(tokenString, audience string, claims jwt.Claims) error {
+	methods := map[string]bool{}
+	for _, k := range s.keys {
+		methods[k.Method.Alg()] = true
+	}
+	valid := make([]string, 0, len(methods))
+	for alg := range methods {
+		valid = append(valid, alg)
+	}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, errUnknownKID
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key.Public, nil
+	},
+		jwt.WithValidMethods(valid),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithAudience(audience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
+	return err
+}
+
+// authenticate resolves the bearer token of a request to a user and session.
+// The returned message is meant for the client.
+func (s *AuthService) authenticate(c *gin.Context) (User, string, string) {
+	var user User
+
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		return user, "", "Authorization header required"
+	}
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		return user, "", "Bearer token required"
+	}
+
+	claims, err := s.Verify(tokenString)
+	if err != nil {
+		return user, "", "Invalid token"
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil {
+		return user, "", "Invalid user ID in token"
+	}
+
+	if err := s.db.First(&user, uint(userID)).Error; err != nil {
+		return user, "", "User not found"
+	}
+
+	// Reject tokens whose session was logged out or revoked
+	if !sessionActive(s.db, claims.SessionID, claims.ID, user.ID) {
+		return user, "", "Session revoked or expired"
+	}
+
+	return user, claims.SessionID, ""
+}
+
+// mfaRequired reports whether users with the role must use 2FA
+func (s *AuthService) mfaRequired(role string) bool {
+	return s.mfaRoles[role]
+}
+
+// Required rejects requests without a valid access token and sets userID,
+// user and sessionID on the context. Users whose role requires 2FA are
+// turned away until they enrol.
+func (s *AuthService) Required() gin.HandlerFunc {
+	return s.required(true)
+}
+
+// RequiredForEnrolment is Required without the 2FA enrolment check, for
+// the routes a user needs to enrol
+func (s *AuthService) RequiredForEnrolment() gin.HandlerFunc {
+	return s.required(false)
+}
+
+func (s *AuthService) required(enforceMFA bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, sessionID, msg := s.authenticate(c)
+		if msg != "" {
+			c.AbortWithStatusJSON(401, gin.H{"error": msg})
+			return
+		}
+
+		if enforceMFA && s.mfaRequired(user.Role) {
+			if _, enabled := mfaEnabled(s.db, user.ID); !enabled {
+				c.AbortWithStatusJSON(403, gin.H{"error": "Two-factor authentication is required for your role", "mfaEnrollmentRequired": true})
+				return
+			}
+		}
+
+		c.Set("userID", user.ID)
+		c.Set("user", user)
+		c.Set("sessionID", sessionID)
+		c.Next()
+	}
+}
+
+// Optional sets the same context values as Required when a valid token is
+// present, and otherwise lets the request through anonymously. Requests
+// with an X-API-Key are left to APIKeyAuth.
+func (s *AuthService) Optional() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" || c.GetHeader("X-API-Key") != "" {
+			c.Next()
+			return
+		}
+
+		user, sessionID, msg := s.authenticate(c)
+		if msg == "" {
+			c.Set("userID", user.ID)
+			c.Set("user", user)
+			c.Set("sessionID", sessionID)
+		}
+		c.Next()
+	}
+}
+
+// jwk returns the public JSON Web Key of an asymmetric key, nil for HMAC keys
+func (k SigningKey) jwk() gin.H {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		return gin.H{
+			"kty": "RSA", "use": "sig", "alg": "RS256", "kid": k.ID,
+			"n": b64(pub.N.Bytes()),
+			"e": b64(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return gin.H{
+			"kty": "OKP", "use": "sig", "alg": "EdDSA", "crv": "Ed25519", "kid": k.ID,
+			"x": b64(pub),
+		}
+	}
+	return nil
+}
+
+// JWKS publishes the public keys of asymmetric signing keys so other
+// services can verify our tokens
+func (s *AuthService) JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := []gin.H{}
+		for _, k := range s.keys {
+			if jwk := k.jwk(); jwk != nil {
+				keys = append(keys, jwk)
+			}
+		}
+
+		sort.Slice(keys, func(i, j int) bool { return keys[i]["kid"].(string) < keys[j]["kid"].(string) })
+
+		c.Header("Cache-Control", "public, max-age=3600")
+		c.JSON(http.StatusOK, gin.H{"keys": keys})
+	}
+}
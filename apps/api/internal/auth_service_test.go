@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func hmacKey(kid, secret string) SigningKey {
+	return SigningKey{ID: kid, Method: jwt.SigningMethodHS256, Private: []byte(secret), Public: []byte(secret)}
+}
+
+func TestAuthServiceVerify(t *testing.T) {
+	now := time.Now()
+	old, err := newAuthService(nil, "bandly-api", "bandly-app", []SigningKey{hmacKey("k1", "first")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := newAuthService(nil, "bandly-api", "bandly-app", []SigningKey{hmacKey("k1", "first"), hmacKey("k2", "second")}, "k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherAudience, err := newAuthService(nil, "bandly-api", "partner-app", []SigningKey{hmacKey("k1", "first")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		issuer  *AuthService
+		verify  *AuthService
+		issued  time.Time
+		wantErr bool
+	}{
+		{"Round trip", rotated, rotated, now, false},
+		{"Old kid still verifies after rotation", old, rotated, now, false},
+		{"Unknown kid", rotated, old, now, true},
+		{"Wrong audience", otherAudience, old, now, true},
+		{"Expired", old, old, now.Add(-time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.issuer.Issue(7, "session-1", "jti-1", tt.issued)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := tt.verify.Verify(token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (claims.Subject != "7" || claims.SessionID != "session-1" || claims.ID != "jti-1") {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []SigningKey{
+		{ID: "rsa", Method: jwt.SigningMethodRS256, Private: rsaKey, Public: &rsaKey.PublicKey},
+		{ID: "ed", Method: jwt.SigningMethodEdDSA, Private: edPriv, Public: edPub},
+		hmacKey("hmac", "secret"),
+	}
+	s, err := newAuthService(nil, "bandly-api", "bandly-app", keys, "rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range keys {
+		t.Run(k.ID, func(t *testing.T) {
+			jwk := k.jwk()
+			if k.ID == "hmac" {
+				if jwk != nil {
+					t.Errorf("HMAC key must not be published, got %v", jwk)
+				}
+				return
+			}
+			if jwk == nil || jwk["kid"] != k.ID {
+				t.Fatalf("jwk = %v", jwk)
+			}
+
+			s.signingKID = k.ID
+			token, err := s.Issue(1, "sid", "jti", time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Verify(token); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+}
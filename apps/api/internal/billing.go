@@ -0,0 +1,596 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Subscription statuses, as payment providers report them
+const (
+	SubscriptionActive     = "active"
+	SubscriptionTrialing   = "trialing"
+	SubscriptionPastDue    = "past_due"
+	SubscriptionUnpaid     = "unpaid"
+	SubscriptionIncomplete = "incomplete"
+	SubscriptionCanceled   = "canceled"
+)
+
+// Billing events, normalised from each provider's webhooks
+const (
+	BillingCheckoutCompleted    = "checkout.completed"
+	BillingSubscriptionCreated  = "subscription.created"
+	BillingSubscriptionUpdated  = "subscription.updated"
+	BillingSubscriptionCanceled = "subscription.canceled"
+	BillingPaymentFailed        = "payment.failed"
+	BillingCreditsPurchased     = "credits.purchased"
+)
+
+// defaultBillingGrace is how long a paid plan outlives a failed payment
+const defaultBillingGrace = 7 * 24 * time.Hour
+
+// maxWebhookBytes bounds the webhook bodies we read
+const maxWebhookBytes = 1 << 20
+
+var errWebhookSignature = errors.New("invalid webhook signature")
+
+// PaymentProvider takes payments for subscription plans
+type PaymentProvider interface {
+	// Name identifies the provider on stored subscriptions, e.g. "stripe"
+	Name() string
+	// Plans are the plan names that can be bought
+	Plans() []string
+	// Packs are the credit packs that can be bought
+	Packs() []string
+	// CreateCheckout starts a hosted checkout and returns its URL
+	CreateCheckout(ctx context.Context, req CheckoutRequest) (string, error)
+	// PortalURL returns a link where the customer manages payment details
+	// and cancels
+	PortalURL(ctx context.Context, customerID, returnURL string) (string, error)
+	// Invoices lists the customer's most recent invoices, newest first
+	Invoices(ctx context.Context, customerID string) ([]Invoice, error)
+	// ParseWebhook verifies a webhook's signature and normalises it. Events
+	// we do not handle come back with an empty Type.
+	ParseWebhook(payload []byte, header http.Header) (BillingEvent, error)
+}
+
+// CheckoutRequest is a user buying a plan, or a one-off credit pack
+type CheckoutRequest struct {
+	UserID     uint
+	Email      string
+	CustomerID string // known customer, "" for a first purchase
+	Plan       string
+	Pack       string // set instead of Plan for credit packs
+	SuccessURL string
+	CancelURL  string
+}
+
+// Invoice is a provider invoice as shown on the profile
+type Invoice struct {
+	ID       string    `json:"id"`
+	Number   string    `json:"number"`
+	Status   string    `json:"status"` // draft, open, paid, uncollectible or void
+	Amount   int64     `json:"amount"` // in the currency's minor unit
+	Currency string    `json:"currency"`
+	Created  time.Time `json:"created"`
+	URL      string    `json:"url,omitempty"` // hosted invoice page
+	PDF      string    `json:"pdf,omitempty"`
+}
+
+// BillingEvent is a webhook event about a customer's subscription
+type BillingEvent struct {
+	ID                string     `json:"id"`
+	Type              string     `json:"type"` // one of the Billing* events
+	Created           time.Time  `json:"created"`
+	UserID            uint       `json:"userId,omitempty"` // from checkout metadata, 0 when absent
+	CustomerID        string     `json:"customerId"`
+	SubscriptionID    string     `json:"subscriptionId,omitempty"`
+	Plan              string     `json:"plan,omitempty"`
+	Status            string     `json:"status,omitempty"`
+	PeriodStart       *time.Time `json:"periodStart,omitempty"`
+	PeriodEnd         *time.Time `json:"periodEnd,omitempty"`
+	CancelAtPeriodEnd bool       `json:"cancelAtPeriodEnd,omitempty"`
+	Pack              string     `json:"pack,omitempty"`      // credit pack bought
+	PaymentID         string     `json:"paymentId,omitempty"` // identifies a pack purchase
+}
+
+// Subscription is a user's paid plan as the provider last reported it.
+// User.Plan follows it, see effectivePlan.
+type Subscription struct {
+	ID                 uint       `gorm:"primaryKey" json:"-"`
+	UserID             uint       `gorm:"uniqueIndex;not null" json:"-"`
+	Provider           string     `json:"provider"`
+	CustomerID         string     `gorm:"index" json:"-"`
+	SubscriptionID     string     `gorm:"index" json:"-"`
+	Plan               string     `json:"plan"`
+	Status             string     `json:"status"`
+	CurrentPeriodStart *time.Time `json:"currentPeriodStart,omitempty"`
+	CurrentPeriodEnd   *time.Time `json:"currentPeriodEnd,omitempty"`
+	CancelAtPeriodEnd  bool       `json:"cancelAtPeriodEnd"`
+	GraceUntil         *time.Time `json:"graceUntil,omitempty"` // set while a payment is failing
+	EventAt            time.Time  `json:"-"`                    // creation time of the last applied event
+	UpdatedAt          time.Time  `json:"updatedAt"`
+}
+
+// BillingWebhook records a handled webhook event, as providers deliver
+// events more than once
+type BillingWebhook struct {
+	EventID   string `gorm:"primaryKey"`
+	Provider  string `gorm:"primaryKey"`
+	Type      string
+	CreatedAt time.Time
+}
+
+// Billing connects plans to a payment provider
+type Billing struct {
+	Provider PaymentProvider
+	Grace    time.Duration
+}
+
+// NewBilling configures billing from the environment. BILLING_PROVIDER is
+// "stripe", "fake" (local development, see FakeProvider) or empty to turn
+// billing off, which returns nil. BILLING_GRACE_DAYS is how long a paid plan
+// survives failed payments, 7 by default.
+func NewBilling() (*Billing, error) {
+	grace := defaultBillingGrace
+	if s := os.Getenv("BILLING_GRACE_DAYS"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("BILLING_GRACE_DAYS must be a number of days, got %q", s)
+		}
+		grace = time.Duration(days) * 24 * time.Hour
+	}
+
+	switch name := os.Getenv("BILLING_PROVIDER"); name {
+	case "":
+		return nil, nil
+	case "stripe":
+		provider, err := NewStripeProvider()
+		if err != nil {
+			return nil, err
+		}
+		return &Billing{Provider: provider, Grace: grace}, nil
+	case "fake":
+		if gin.Mode() == gin.ReleaseMode {
+			return nil, errors.New("BILLING_PROVIDER=fake is for development only")
+		}
+		secret := os.Getenv("BILLING_FAKE_SECRET")
+		if secret == "" {
+			return nil, errors.New("BILLING_PROVIDER=fake needs BILLING_FAKE_SECRET to sign webhooks")
+		}
+		packs, err := parseEnvPairs("CREDIT_PACKS")
+		if err != nil {
+			return nil, err
+		}
+		return &Billing{Provider: NewFakeProvider(secret, []string{"pro"}, sortedKeys(packs)), Grace: grace}, nil
+	default:
+		return nil, fmt.Errorf("unknown billing provider %q", name)
+	}
+}
+
+// parseEnvPairs reads a "key=value,key=value" variable. Keys are plan or
+// pack names.
+func parseEnvPairs(name string) (map[string]string, error) {
+	pairs := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv(name), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !planNameRegex.MatchString(key) || value == "" {
+			return nil, fmt.Errorf("%s entries look like name=value, got %q", name, pair)
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+// effectivePlan is the plan a subscription grants at now. Failing payments
+// keep the plan until the grace period ends; a cancelled subscription keeps
+// it until the end of the period paid for.
+func effectivePlan(sub Subscription, now time.Time) string {
+	if sub.Plan == "" {
+		return defaultPlan
+	}
+	switch sub.Status {
+	case SubscriptionActive, SubscriptionTrialing:
+		return sub.Plan
+	case SubscriptionPastDue, SubscriptionUnpaid:
+		if sub.GraceUntil != nil && now.Before(*sub.GraceUntil) {
+			return sub.Plan
+		}
+	case SubscriptionCanceled:
+		end := sub.CurrentPeriodEnd
+		if sub.GraceUntil != nil && (end == nil || sub.GraceUntil.Before(*end)) {
+			end = sub.GraceUntil // the last period was never paid
+		}
+		if end != nil && now.Before(*end) {
+			return sub.Plan
+		}
+	}
+	return defaultPlan
+}
+
+// applyEvent moves a subscription to the state an event reports. Events
+// older than the last one applied only fill in missing IDs, as providers
+// do not deliver in order.
+func applyEvent(sub *Subscription, ev BillingEvent, grace time.Duration, now time.Time) {
+	if ev.CustomerID != "" {
+		sub.CustomerID = ev.CustomerID
+	}
+	if ev.SubscriptionID != "" && sub.SubscriptionID == "" {
+		sub.SubscriptionID = ev.SubscriptionID
+	}
+	if ev.Created.Before(sub.EventAt) {
+		return
+	}
+
+	switch ev.Type {
+	case BillingSubscriptionCreated, BillingSubscriptionUpdated, BillingSubscriptionCanceled:
+		sub.EventAt = ev.Created
+		if ev.SubscriptionID != "" && ev.SubscriptionID != sub.SubscriptionID {
+			// A new subscription after an old one ended starts clean
+			sub.SubscriptionID, sub.GraceUntil = ev.SubscriptionID, nil
+		}
+		if ev.Plan != "" {
+			sub.Plan = ev.Plan
+		}
+		sub.Status = ev.Status
+		if ev.Type == BillingSubscriptionCanceled {
+			sub.Status = SubscriptionCanceled
+		}
+		if ev.PeriodStart != nil {
+			sub.CurrentPeriodStart, sub.CurrentPeriodEnd = ev.PeriodStart, ev.PeriodEnd
+		}
+		sub.CancelAtPeriodEnd = ev.CancelAtPeriodEnd
+	case BillingPaymentFailed:
+		sub.EventAt = ev.Created
+		if sub.Status == SubscriptionActive || sub.Status == SubscriptionTrialing {
+			sub.Status = SubscriptionPastDue
+		}
+	}
+
+	switch sub.Status {
+	case SubscriptionActive, SubscriptionTrialing:
+		sub.GraceUntil = nil
+	case SubscriptionPastDue, SubscriptionUnpaid:
+		if sub.GraceUntil == nil {
+			until := now.Add(grace)
+			sub.GraceUntil = &until
+		}
+	}
+}
+
+// planChange is a user's plan moving because of billing
+type planChange struct {
+	User     User
+	From, To string
+}
+
+// syncUserPlan sets the user's plan to the one the subscription grants
+func syncUserPlan(tx *gorm.DB, sub Subscription, now time.Time) (*planChange, error) {
+	var user User
+	if err := tx.First(&user, sub.UserID).Error; err != nil {
+		return nil, err
+	}
+	change := &planChange{User: user, From: user.Plan, To: effectivePlan(sub, now)}
+
+	updates := map[string]interface{}{}
+	if change.To != change.From {
+		updates["plan"] = change.To
+	}
+	if sub.CurrentPeriodStart != nil && (user.BillingAnchor == nil || !user.BillingAnchor.Equal(*sub.CurrentPeriodStart)) {
+		updates["billing_anchor"] = sub.CurrentPeriodStart
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	return change, nil
+}
+
+// ApplyBillingEvent records a webhook event and updates the subscription
+// and plan of its user. A redelivered event changes nothing and returns a
+// nil change, as does an event about a customer we do not know.
+func (b *Billing) ApplyBillingEvent(db *gorm.DB, ev BillingEvent, now time.Time) (*planChange, error) {
+	var change *planChange
+	err := db.Transaction(func(tx *gorm.DB) error {
+		seen := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&BillingWebhook{EventID: ev.ID, Provider: b.Provider.Name(), Type: ev.Type})
+		if seen.Error != nil {
+			return seen.Error
+		}
+		if seen.RowsAffected == 0 {
+			return nil
+		}
+
+		var sub Subscription
+		find := func(column, value string) bool {
+			return value != "" && tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("provider = ? AND "+column+" = ?", b.Provider.Name(), value).Limit(1).Find(&sub).RowsAffected > 0
+		}
+		if !find("subscription_id", ev.SubscriptionID) && !find("customer_id", ev.CustomerID) {
+			if ev.UserID == 0 {
+				log.Printf("Billing event %s (%s) is about an unknown customer", ev.ID, ev.Type)
+				return nil
+			}
+			var user User
+			if err := tx.First(&user, ev.UserID).Error; err != nil {
+				log.Printf("Billing event %s names unknown user %d", ev.ID, ev.UserID)
+				return nil
+			}
+			// One subscription row per user, whichever provider it was with
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", user.ID).Limit(1).Find(&sub).Error; err != nil {
+				return err
+			}
+			sub.UserID, sub.Provider = user.ID, b.Provider.Name()
+		}
+
+		applyEvent(&sub, ev, b.Grace, now)
+		if err := tx.Save(&sub).Error; err != nil {
+			return err
+		}
+		var err error
+		change, err = syncUserPlan(tx, sub, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// ExpireSubscriptions downgrades users whose grace period or paid-for
+// period ended since the last webhook. Users an admin moved to another plan
+// keep it. It returns the changes made.
+func ExpireSubscriptions(db *gorm.DB, now time.Time) ([]planChange, error) {
+	var subs []Subscription
+	err := db.Where("status IN ?", []string{SubscriptionPastDue, SubscriptionUnpaid, SubscriptionCanceled}).
+		Where("plan <> ''").Find(&subs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []planChange
+	for _, sub := range subs {
+		var user User
+		if db.Select("id", "plan").First(&user, sub.UserID).Error != nil || user.Plan != sub.Plan || effectivePlan(sub, now) == sub.Plan {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			change, err := syncUserPlan(tx, sub, now)
+			if err == nil && change.From != change.To {
+				changes = append(changes, *change)
+			}
+			return err
+		})
+		if err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// StartBillingSweeper runs ExpireSubscriptions every interval in the
+// background, recording each downgrade in the audit log
+func StartBillingSweeper(db *gorm.DB, auditor *Auditor, interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			changes, err := ExpireSubscriptions(db, time.Now())
+			if err != nil {
+				log.Printf("Failed to expire subscriptions: %v", err)
+			}
+			for _, change := range changes {
+				if err := auditor.Record(planChangeEntry("billing.plan_expired", change)); err != nil {
+					log.Printf("Failed to record audit entry billing.plan_expired: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// planChangeEntry is the audit entry of a billing plan change
+func planChangeEntry(action string, change planChange) AuditLog {
+	return AuditLog{
+		OrganizationID: change.User.OrganizationID,
+		Action:         action,
+		TargetType:     "user",
+		TargetID:       strconv.FormatUint(uint64(change.User.ID), 10),
+		Before:         ToJSON(gin.H{"plan": change.From}),
+		After:          ToJSON(gin.H{"plan": change.To}),
+	}
+}
+
+// requireBilling answers 503 when no payment provider is configured
+func requireBilling(c *gin.Context, billing *Billing) bool {
+	if billing == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "billing is not available"})
+		return false
+	}
+	return true
+}
+
+// userSubscription loads the user's subscription, ok is false when none
+func userSubscription(db *gorm.DB, userID uint) (Subscription, bool) {
+	var sub Subscription
+	found := db.Where("user_id = ?", userID).Limit(1).Find(&sub).RowsAffected > 0
+	return sub, found
+}
+
+// GetBilling returns the current user's subscription and invoices, and the
+// plans they can buy
+func GetBilling(db *gorm.DB, billing *Billing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+		resp := gin.H{
+			"enabled":  billing != nil,
+			"plan":     user.Plan,
+			"plans":    []string{},
+			"invoices": []Invoice{},
+		}
+
+		sub, found := userSubscription(db, user.ID)
+		if found {
+			resp["subscription"] = sub
+		}
+		if billing == nil {
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		if meteredUser(user) {
+			resp["plans"] = billing.Provider.Plans()
+		}
+		if found && sub.CustomerID != "" && sub.Provider == billing.Provider.Name() {
+			invoices, err := billing.Provider.Invoices(c.Request.Context(), sub.CustomerID)
+			if err != nil {
+				log.Printf("Failed to list invoices of user %d: %v", user.ID, err)
+				resp["invoicesError"] = "invoices are unavailable right now"
+			} else {
+				resp["invoices"] = invoices
+			}
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// CreateCheckout starts the purchase of a plan
+func CreateCheckout(db *gorm.DB, billing *Billing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireBilling(c, billing) {
+			return
+		}
+		user := c.MustGet("user").(User)
+
+		var req struct {
+			Plan string `json:"plan" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "plan is required"})
+			return
+		}
+		available := false
+		for _, plan := range billing.Provider.Plans() {
+			available = available || plan == req.Plan
+		}
+		if !available {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "plan cannot be bought"})
+			return
+		}
+		if !meteredUser(user) {
+			c.JSON(http.StatusConflict, gin.H{"error": "your organisation manages your plan"})
+			return
+		}
+
+		sub, found := userSubscription(db, user.ID)
+		if found && effectivePlan(sub, time.Now()) != defaultPlan {
+			c.JSON(http.StatusConflict, gin.H{"error": "you already have a subscription, change it from the billing portal"})
+			return
+		}
+		checkout := CheckoutRequest{
+			UserID:     user.ID,
+			Email:      user.Email,
+			Plan:       req.Plan,
+			SuccessURL: appURL("/profile?checkout=success"),
+			CancelURL:  appURL("/profile?checkout=cancelled"),
+		}
+		if found && sub.Provider == billing.Provider.Name() {
+			checkout.CustomerID = sub.CustomerID
+		}
+
+		url, err := billing.Provider.CreateCheckout(c.Request.Context(), checkout)
+		if err != nil {
+			log.Printf("Failed to create checkout for user %d: %v", user.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to start checkout"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"url": url})
+	}
+}
+
+// BillingPortal returns a customer portal link for the current user
+func BillingPortal(db *gorm.DB, billing *Billing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireBilling(c, billing) {
+			return
+		}
+		user := c.MustGet("user").(User)
+
+		sub, found := userSubscription(db, user.ID)
+		if !found || sub.CustomerID == "" || sub.Provider != billing.Provider.Name() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no subscription to manage"})
+			return
+		}
+		url, err := billing.Provider.PortalURL(c.Request.Context(), sub.CustomerID, appURL("/profile"))
+		if err != nil {
+			log.Printf("Failed to create billing portal link for user %d: %v", user.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to open the billing portal"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"url": url})
+	}
+}
+
+// BillingWebhookHandler receives the payment provider's events. Failures
+// answer 500 so the provider retries.
+func BillingWebhookHandler(db *gorm.DB, billing *Billing, credits *Credits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireBilling(c, billing) {
+			return
+		}
+		payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBytes))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+			return
+		}
+
+		ev, err := billing.Provider.ParseWebhook(payload, c.Request.Header)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook"})
+			return
+		}
+		if ev.Type == "" {
+			c.JSON(http.StatusOK, gin.H{"received": true})
+			return
+		}
+
+		if ev.Type == BillingCreditsPurchased {
+			amount, err := credits.purchase(db, billing.Provider.Name(), ev)
+			if err != nil {
+				log.Printf("Failed to credit purchase %s: %v", ev.ID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply event"})
+				return
+			}
+			if amount > 0 {
+				audit(c, AuditLog{
+					Action: "credits.purchase", TargetType: "user", TargetID: strconv.FormatUint(uint64(ev.UserID), 10),
+					After: ToJSON(gin.H{"pack": ev.Pack, "credits": amount}),
+				})
+			}
+			c.JSON(http.StatusOK, gin.H{"received": true})
+			return
+		}
+
+		change, err := billing.ApplyBillingEvent(db, ev, time.Now())
+		if err != nil {
+			log.Printf("Failed to apply billing event %s: %v", ev.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply event"})
+			return
+		}
+		if change != nil && change.From != change.To {
+			audit(c, planChangeEntry("billing."+ev.Type, *change))
+		}
+		c.JSON(http.StatusOK, gin.H{"received": true})
+	}
+}
@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func stripeSign(secret string, ts int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", ts, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyStripeSignature(t *testing.T) {
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1760000000, 0)
+	good := stripeSign("whsec_test", now.Unix(), payload)
+
+	tests := []struct {
+		name    string
+		header  string
+		payload []byte
+		wantErr bool
+	}{
+		{"Valid", fmt.Sprintf("t=%d,v1=%s", now.Unix(), good), payload, false},
+		{"Any v1 may match", fmt.Sprintf("t=%d,v1=%s,v1=%s,v0=abc", now.Unix(), stripeSign("old", now.Unix(), payload), good), payload, false},
+		{"Wrong secret", fmt.Sprintf("t=%d,v1=%s", now.Unix(), stripeSign("whsec_other", now.Unix(), payload)), payload, true},
+		{"Tampered payload", fmt.Sprintf("t=%d,v1=%s", now.Unix(), good), []byte(`{"id":"evt_2"}`), true},
+		{"Timestamp not signed over", fmt.Sprintf("t=%d,v1=%s", now.Unix()-1, good), payload, true},
+		{"Too old", fmt.Sprintf("t=%d,v1=%s", now.Unix()-600, stripeSign("whsec_test", now.Unix()-600, payload)), payload, true},
+		{"No signature", fmt.Sprintf("t=%d", now.Unix()), payload, true},
+		{"Empty header", "", payload, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyStripeSignature(tt.payload, tt.header, "whsec_test", stripeWebhookTolerance, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyStripeSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errWebhookSignature) {
+				t.Errorf("error %v is not errWebhookSignature", err)
+			}
+		})
+	}
+}
+
+func TestStripeParseWebhook(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	p := &StripeProvider{WebhookSecret: "whsec_test", Prices: map[string]string{"pro": "price_pro"}, now: func() time.Time { return now }}
+	parse := func(payload string) BillingEvent {
+		t.Helper()
+		header := http.Header{}
+		header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), stripeSign("whsec_test", now.Unix(), []byte(payload))))
+		ev, err := p.ParseWebhook([]byte(payload), header)
+		if err != nil {
+			t.Fatalf("ParseWebhook() error = %v", err)
+		}
+		return ev
+	}
+
+	t.Run("Subscription with item periods", func(t *testing.T) {
+		ev := parse(`{"id":"evt_1","type":"customer.subscription.updated","created":1760000000,"data":{"object":{
+			"id":"sub_1","customer":"cus_1","status":"past_due","cancel_at_period_end":true,"metadata":{"user_id":"42"},
+			"items":{"data":[{"price":{"id":"price_pro"},"current_period_start":1759000000,"current_period_end":1761600000}]}}}}`)
+		if ev.Type != BillingSubscriptionUpdated || ev.UserID != 42 || ev.CustomerID != "cus_1" || ev.SubscriptionID != "sub_1" {
+			t.Errorf("event = %+v", ev)
+		}
+		if ev.Plan != "pro" || ev.Status != SubscriptionPastDue || !ev.CancelAtPeriodEnd {
+			t.Errorf("plan, status = %q, %q", ev.Plan, ev.Status)
+		}
+		if ev.PeriodStart == nil || ev.PeriodStart.Unix() != 1759000000 || ev.PeriodEnd.Unix() != 1761600000 {
+			t.Errorf("period = %v - %v", ev.PeriodStart, ev.PeriodEnd)
+		}
+	})
+
+	t.Run("Deleted subscription", func(t *testing.T) {
+		ev := parse(`{"id":"evt_2","type":"customer.subscription.deleted","created":1760000000,"data":{"object":{
+			"id":"sub_1","customer":"cus_1","status":"canceled","current_period_start":1759000000,"current_period_end":1761600000,
+			"items":{"data":[{"price":{"id":"price_unknown"}}]}}}}`)
+		if ev.Type != BillingSubscriptionCanceled || ev.Plan != "" || ev.PeriodEnd.Unix() != 1761600000 {
+			t.Errorf("event = %+v", ev)
+		}
+	})
+
+	t.Run("Payment failed", func(t *testing.T) {
+		ev := parse(`{"id":"evt_3","type":"invoice.payment_failed","created":1760000000,"data":{"object":{"customer":"cus_1","subscription":"sub_1"}}}`)
+		if ev.Type != BillingPaymentFailed || ev.CustomerID != "cus_1" || ev.SubscriptionID != "sub_1" {
+			t.Errorf("event = %+v", ev)
+		}
+	})
+
+	t.Run("Credit pack paid", func(t *testing.T) {
+		ev := parse(`{"id":"evt_5","type":"checkout.session.completed","created":1760000000,"data":{"object":{
+			"id":"cs_1","mode":"payment","payment_status":"paid","client_reference_id":"42","metadata":{"pack":"starter"}}}}`)
+		if ev.Type != BillingCreditsPurchased || ev.UserID != 42 || ev.Pack != "starter" || ev.PaymentID != "cs_1" {
+			t.Errorf("event = %+v", ev)
+		}
+	})
+
+	t.Run("Credit pack awaiting payment", func(t *testing.T) {
+		ev := parse(`{"id":"evt_6","type":"checkout.session.completed","created":1760000000,"data":{"object":{
+			"id":"cs_2","mode":"payment","payment_status":"unpaid","client_reference_id":"42","metadata":{"pack":"starter"}}}}`)
+		if ev.Type != "" {
+			t.Errorf("Type = %q, want empty until the payment succeeds", ev.Type)
+		}
+	})
+
+	t.Run("Unhandled event", func(t *testing.T) {
+		if ev := parse(`{"id":"evt_4","type":"customer.created","created":1760000000,"data":{"object":{}}}`); ev.Type != "" {
+			t.Errorf("Type = %q, want empty", ev.Type)
+		}
+	})
+}
+
+func TestStripeCreateCheckout(t *testing.T) {
+	var form map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/checkout/sessions" || r.Header.Get("Authorization") != "Bearer sk_test" {
+			http.Error(w, `{"error":{"message":"unexpected request"}}`, http.StatusBadRequest)
+			return
+		}
+		r.ParseForm()
+		form = map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		fmt.Fprint(w, `{"id":"cs_1","url":"https://checkout.stripe.com/c/cs_1"}`)
+	}))
+	defer srv.Close()
+
+	p := &StripeProvider{SecretKey: "sk_test", Prices: map[string]string{"pro": "price_pro"}, BaseURL: srv.URL}
+	url, err := p.CreateCheckout(context.Background(), CheckoutRequest{UserID: 42, Email: "a@example.com", Plan: "pro", SuccessURL: "s", CancelURL: "c"})
+	if err != nil || url != "https://checkout.stripe.com/c/cs_1" {
+		t.Fatalf("CreateCheckout() = %q, %v", url, err)
+	}
+	for key, want := range map[string]string{
+		"mode": "subscription", "line_items[0][price]": "price_pro", "client_reference_id": "42",
+		"subscription_data[metadata][user_id]": "42", "customer_email": "a@example.com",
+	} {
+		if form[key] != want {
+			t.Errorf("form[%s] = %q, want %q", key, form[key], want)
+		}
+	}
+
+	if _, err := p.CreateCheckout(context.Background(), CheckoutRequest{Plan: "team"}); err == nil {
+		t.Error("CreateCheckout() of a plan without a price succeeded")
+	}
+
+	p.PackPrices = map[string]string{"starter": "price_starter"}
+	if _, err := p.CreateCheckout(context.Background(), CheckoutRequest{UserID: 42, Pack: "starter", CustomerID: "cus_1"}); err != nil {
+		t.Fatalf("CreateCheckout() of a pack: %v", err)
+	}
+	for key, want := range map[string]string{
+		"mode": "payment", "line_items[0][price]": "price_starter", "metadata[pack]": "starter", "customer": "cus_1",
+		"subscription_data[metadata][user_id]": "",
+	} {
+		if form[key] != want {
+			t.Errorf("pack form[%s] = %q, want %q", key, form[key], want)
+		}
+	}
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	grace := 7 * 24 * time.Hour
+	at := func(days int) time.Time { return start.Add(time.Duration(days) * 24 * time.Hour) }
+
+	var sub Subscription
+	step := func(name string, ev BillingEvent, now time.Time, wantStatus, wantPlan string) {
+		t.Helper()
+		applyEvent(&sub, ev, grace, now)
+		if sub.Status != wantStatus || effectivePlan(sub, now) != wantPlan {
+			t.Errorf("%s: status %q plan %q, want %q %q", name, sub.Status, effectivePlan(sub, now), wantStatus, wantPlan)
+		}
+	}
+
+	step("Checkout", BillingEvent{Type: BillingCheckoutCompleted, Created: at(0), CustomerID: "cus_1", SubscriptionID: "sub_1"}, at(0), "", "free")
+	step("Created", BillingEvent{Type: BillingSubscriptionCreated, Created: at(0), SubscriptionID: "sub_1", Plan: "pro", Status: SubscriptionActive, PeriodStart: &start, PeriodEnd: &end}, at(0), SubscriptionActive, "pro")
+	step("Payment failed", BillingEvent{Type: BillingPaymentFailed, Created: at(10)}, at(10), SubscriptionPastDue, "pro")
+	if sub.GraceUntil == nil || !sub.GraceUntil.Equal(at(17)) {
+		t.Fatalf("GraceUntil = %v, want %v", sub.GraceUntil, at(17))
+	}
+	step("Stale event ignored", BillingEvent{Type: BillingSubscriptionUpdated, Created: at(5), Plan: "pro", Status: SubscriptionActive}, at(11), SubscriptionPastDue, "pro")
+	step("Still failing", BillingEvent{Type: BillingSubscriptionUpdated, Created: at(12), Plan: "pro", Status: SubscriptionPastDue}, at(12), SubscriptionPastDue, "pro")
+	if !sub.GraceUntil.Equal(at(17)) {
+		t.Errorf("retries moved the grace period to %v", sub.GraceUntil)
+	}
+	if plan := effectivePlan(sub, at(17)); plan != "free" {
+		t.Errorf("after the grace period plan = %q, want free", plan)
+	}
+	step("Paid", BillingEvent{Type: BillingSubscriptionUpdated, Created: at(18), Plan: "pro", Status: SubscriptionActive}, at(18), SubscriptionActive, "pro")
+	if sub.GraceUntil != nil {
+		t.Error("payment did not clear the grace period")
+	}
+
+	step("Cancelled", BillingEvent{Type: BillingSubscriptionCanceled, Created: at(20), Plan: "pro", Status: SubscriptionCanceled, PeriodStart: &start, PeriodEnd: &end}, at(20), SubscriptionCanceled, "pro")
+	if plan := effectivePlan(sub, end); plan != "free" {
+		t.Errorf("after the paid period plan = %q, want free", plan)
+	}
+
+	// Cancelled for non-payment: the unpaid period is not granted
+	sub = Subscription{Plan: "pro", Status: SubscriptionActive, CurrentPeriodStart: &start, CurrentPeriodEnd: &end}
+	applyEvent(&sub, BillingEvent{Type: BillingPaymentFailed, Created: at(1)}, grace, at(1))
+	applyEvent(&sub, BillingEvent{Type: BillingSubscriptionCanceled, Created: at(2), Status: SubscriptionCanceled}, grace, at(2))
+	if plan := effectivePlan(sub, at(9)); plan != "free" {
+		t.Errorf("cancelled unpaid subscription grants %q after its grace period", plan)
+	}
+}
+
+func TestFakeProviderWebhook(t *testing.T) {
+	f := NewFakeProvider("secret", []string{"pro"}, nil)
+	payload := []byte(`{"id":"evt_1","type":"subscription.created","customerId":"cus_1","plan":"pro","status":"active"}`)
+
+	header := http.Header{}
+	header.Set("X-Fake-Signature", f.Sign(payload))
+	ev, err := f.ParseWebhook(payload, header)
+	if err != nil || ev.Type != BillingSubscriptionCreated || ev.Plan != "pro" {
+		t.Errorf("ParseWebhook() = %+v, %v", ev, err)
+	}
+
+	header.Set("X-Fake-Signature", NewFakeProvider("other", nil, nil).Sign(payload))
+	if _, err := f.ParseWebhook(payload, header); !errors.Is(err, errWebhookSignature) {
+		t.Errorf("ParseWebhook() with a wrong signature = %v", err)
+	}
+}
@@ -0,0 +1,414 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"gorm.io/gorm"
+)
+
+type DecliningStudent struct {
+	StudentID       uint     `json:"studentId"`
+	Email           string   `json:"email"`
+	Essays          int      `json:"essays"`
+	Latest          float32  `json:"latest"`
+	PreviousAverage float64  `json:"previousAverage"`
+	Drop            float64  `json:"drop"`
+	SlopePerWeek    *float64 `json:"slopePerWeek,omitempty"`
+}
+
+type WeeklyResult struct {
+	WeekStart time.Time `json:"weekStart"`
+	Essays    int       `json:"essays"`
+	Overall   float64   `json:"overall"`
+}
+
+type ClassAnalytics struct {
+	Essays             int                       `json:"essays"`
+	Students           int                       `json:"students"`
+	Averages           BandAverages              `json:"averages"`
+	BandDistribution   map[string]map[string]int `json:"bandDistribution"`
+	CommonErrors       []ErrorCategoryStat       `json:"commonErrors"`
+	DecliningStudents  []DecliningStudent        `json:"decliningStudents"`
+	OverTimeByQuestion map[string][]WeeklyResult `json:"overTimeByQuestionType"`
+}
+
+// decliningDrop is how far a student's latest band must fall below their
+// earlier average to be flagged
+const decliningDrop = 0.5
+
+// weekStart returns the Monday 00:00 UTC of t's week
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+// buildClassAnalytics aggregates class essays; emails maps student IDs to emails
+func buildClassAnalytics(essays []Essay, emails map[uint]string, annotations []Annotation) ClassAnalytics {
+	sort.SliceStable(essays, func(i, j int) bool { return essays[i].CreatedAt.Before(essays[j].CreatedAt) })
+
+	scores := make([]ScoreOut, len(essays))
+	for i, e := range essays {
+		scores[i] = essayScore(e)
+	}
+
+	out := ClassAnalytics{
+		Essays:             len(essays),
+		Students:           len(emails),
+		BandDistribution:   map[string]map[string]int{},
+		CommonErrors:       aggregateErrorCategories(annotations, 1),
+		DecliningStudents:  []DecliningStudent{},
+		OverTimeByQuestion: map[string][]WeeklyResult{},
+	}
+	if len(out.CommonErrors) > 10 {
+		out.CommonErrors = out.CommonErrors[:10]
+	}
+
+	if averages := averageBands(essays, scores, func(Essay) string { return "all" }); len(averages) > 0 {
+		out.Averages = averages[0]
+	}
+
+	// Band distribution per criterion
+	for _, key := range append(append([]string{}, scoreCriteria...), "overall") {
+		dist := map[string]int{}
+		for _, s := range scores {
+			dist[formatBand(criterionBand(s, key))]++
+		}
+		out.BandDistribution[key] = dist
+	}
+
+	// Students whose results are dropping
+	byStudent := map[uint][]int{}
+	for i, e := range essays {
+		if e.UserID != nil {
+			byStudent[*e.UserID] = append(byStudent[*e.UserID], i)
+		}
+	}
+	for studentID, idx := range byStudent {
+		if len(idx) < 2 {
+			continue
+		}
+
+		var xs, ys []float64
+		var previous float64
+		first := essays[idx[0]].CreatedAt
+		for n, i := range idx {
+			xs = append(xs, essays[i].CreatedAt.Sub(first).Hours()/24)
+			ys = append(ys, float64(essays[i].Overall))
+			if n < len(idx)-1 {
+				previous += float64(essays[i].Overall)
+			}
+		}
+		previous /= float64(len(idx) - 1)
+		latest := essays[idx[len(idx)-1]].Overall
+		drop := previous - float64(latest)
+
+		d := DecliningStudent{
+			StudentID:       studentID,
+			Email:           emails[studentID],
+			Essays:          len(idx),
+			Latest:          latest,
+			PreviousAverage: roundTo(previous, 2),
+			Drop:            roundTo(drop, 2),
+		}
+		declining := drop >= decliningDrop
+		if reg, ok := fitRegression(xs, ys); ok && reg.n >= 3 {
+			slope := roundTo(reg.slope*7, 3)
+			d.SlopePerWeek = &slope
+			declining = declining || slope < 0
+		}
+		if declining {
+			out.DecliningStudents = append(out.DecliningStudents, d)
+		}
+	}
+	sort.Slice(out.DecliningStudents, func(i, j int) bool {
+		return out.DecliningStudents[i].Drop > out.DecliningStudents[j].Drop
+	})
+
+	// Weekly averages per question type
+	type bucket struct {
+		count int
+		sum   float64
+	}
+	buckets := map[string]map[time.Time]*bucket{}
+	for _, e := range essays {
+		qt := e.QuestionType
+		if qt == "" {
+			qt = "unspecified"
+		}
+		if buckets[qt] == nil {
+			buckets[qt] = map[time.Time]*bucket{}
+		}
+		week := weekStart(e.CreatedAt)
+		if buckets[qt][week] == nil {
+			buckets[qt][week] = &bucket{}
+		}
+		buckets[qt][week].count++
+		buckets[qt][week].sum += float64(e.Overall)
+	}
+	for qt, weeks := range buckets {
+		series := []WeeklyResult{}
+		for week, b := range weeks {
+			series = append(series, WeeklyResult{WeekStart: week, Essays: b.count, Overall: roundTo(b.sum/float64(b.count), 2)})
+		}
+		sort.Slice(series, func(i, j int) bool { return series[i].WeekStart.Before(series[j].WeekStart) })
+		out.OverTimeByQuestion[qt] = series
+	}
+
+	return out
+}
+
+// loadClassAnalytics gathers the class roster, the essays submitted to the
+// given assignments and their annotations
+func loadClassAnalytics(db *gorm.DB, class Classroom, assignmentIDs []uint) ClassAnalytics {
+	var students []User
+	db.Select("users.id", "users.email").
+		Joins("JOIN class_members ON class_members.user_id = users.id").
+		Where("class_members.classroom_id = ?", class.ID).
+		Find(&students)
+	emails := map[uint]string{}
+	studentIDs := make([]uint, 0, len(students))
+	for _, s := range students {
+		emails[s.ID] = s.Email
+		studentIDs = append(studentIDs, s.ID)
+	}
+
+	var essays []Essay
+	if len(assignmentIDs) > 0 && len(studentIDs) > 0 {
+		db.Where("assignment_id IN ? AND user_id IN ?", assignmentIDs, studentIDs).Find(&essays)
+	}
+
+	essayIDs := make([]uint, len(essays))
+	for i, e := range essays {
+		essayIDs[i] = e.ID
+	}
+	var annotations []Annotation
+	if len(essayIDs) > 0 {
+		db.Where("essay_id IN ?", essayIDs).Find(&annotations)
+	}
+
+	return buildClassAnalytics(essays, emails, annotations)
+}
+
+// GetClassAnalytics returns aggregate results for every assignment in a class
+func GetClassAnalytics(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		class, ok := taughtClass(db, c, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		var assignmentIDs []uint
+		db.Model(&Assignment{}).Where("classroom_id = ?", class.ID).Pluck("id", &assignmentIDs)
+
+		stats := loadClassAnalytics(db, class, assignmentIDs)
+		writeClassAnalytics(c, stats, loadBranding(db, class.OrganizationID), class.Name, fmt.Sprintf("class-%d", class.ID))
+	}
+}
+
+// GetAssignmentAnalytics returns aggregate results for one assignment. Trends
+// for declining students use the class's assignments up to this one.
+func GetAssignmentAnalytics(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var assignment Assignment
+		if err := db.First(&assignment, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "assignment not found"})
+			return
+		}
+		class, ok := taughtClass(db, c, strconv.FormatUint(uint64(assignment.ClassroomID), 10))
+		if !ok {
+			return
+		}
+
+		stats := loadClassAnalytics(db, class, []uint{assignment.ID})
+
+		var earlierIDs []uint
+		db.Model(&Assignment{}).Where("classroom_id = ? AND due_at <= ?", class.ID, assignment.DueAt).Pluck("id", &earlierIDs)
+		stats.DecliningStudents = loadClassAnalytics(db, class, earlierIDs).DecliningStudents
+
+		title := fmt.Sprintf("%s - %s", class.Name, assignment.Title)
+		writeClassAnalytics(c, stats, loadBranding(db, class.OrganizationID), title, fmt.Sprintf("assignment-%d", assignment.ID))
+	}
+}
+
+// writeClassAnalytics renders the analytics as JSON, CSV or PDF per ?format=
+func writeClassAnalytics(c *gin.Context, stats ClassAnalytics, brand Branding, title, filename string) {
+	switch c.DefaultQuery("format", "json") {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analytics.csv", filename))
+		writeClassAnalyticsCSV(csv.NewWriter(c.Writer), stats)
+	case "pdf":
+		pdf := classAnalyticsPDF(stats, brand, title)
+		c.Header("Content-Type", "application/pdf")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analytics.pdf", filename))
+		if err := pdf.Output(c.Writer); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "PDF generation failed"})
+		}
+	default:
+		c.JSON(http.StatusOK, stats)
+	}
+}
+
+// sortedBands returns distribution keys in ascending band order
+func sortedBands(dist map[string]int) []string {
+	keys := make([]string, 0, len(dist))
+	for k := range dist {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(keys[i], 64)
+		b, _ := strconv.ParseFloat(keys[j], 64)
+		return a < b
+	})
+	return keys
+}
+
+// writeClassAnalyticsCSV writes one block per section, separated by blank rows
+func writeClassAnalyticsCSV(w *csv.Writer, stats ClassAnalytics) {
+	w.Write([]string{"Band distribution"})
+	w.Write([]string{"criterion", "band", "essays"})
+	for _, key := range append(append([]string{}, scoreCriteria...), "overall") {
+		dist := stats.BandDistribution[key]
+		for _, band := range sortedBands(dist) {
+			w.Write([]string{key, band, strconv.Itoa(dist[band])})
+		}
+	}
+	w.Write(nil)
+
+	w.Write([]string{"Common errors"})
+	w.Write([]string{"criterion", "category", "count", "essays", "example"})
+	for _, e := range stats.CommonErrors {
+		w.Write([]string{e.Criterion, e.Category, strconv.Itoa(e.Count), strconv.Itoa(e.Essays), e.Example})
+	}
+	w.Write(nil)
+
+	w.Write([]string{"Declining students"})
+	w.Write([]string{"email", "essays", "latest", "previous_average", "drop"})
+	for _, d := range stats.DecliningStudents {
+		w.Write([]string{d.Email, strconv.Itoa(d.Essays), formatBand(d.Latest),
+			strconv.FormatFloat(d.PreviousAverage, 'f', 2, 64), strconv.FormatFloat(d.Drop, 'f', 2, 64)})
+	}
+	w.Write(nil)
+
+	w.Write([]string{"Results over time"})
+	w.Write([]string{"question_type", "week_start", "essays", "overall"})
+	for _, qt := range sortedKeys(stats.OverTimeByQuestion) {
+		for _, r := range stats.OverTimeByQuestion[qt] {
+			w.Write([]string{qt, r.WeekStart.Format("2006-01-02"), strconv.Itoa(r.Essays),
+				strconv.FormatFloat(r.Overall, 'f', 2, 64)})
+		}
+	}
+	w.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// classAnalyticsPDF lays the analytics out as simple tables
+func classAnalyticsPDF(stats ClassAnalytics, brand Branding, title string) *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+
+	pdf.SetFont("Arial", "B", 18)
+	pdf.SetTextColor(int(brand.Primary.R), int(brand.Primary.G), int(brand.Primary.B)) // Brand color
+	pdf.Cell(0, 12, "Class Writing Report")
+	pdf.Ln(12)
+	pdf.SetFont("Arial", "", 12)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.Cell(0, 8, fmt.Sprintf("%s | %d essays from %d students", title, stats.Essays, stats.Students))
+	pdf.Ln(14)
+
+	heading := func(text string) {
+		pdf.SetFont("Arial", "B", 13)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.Cell(0, 9, text)
+		pdf.Ln(10)
+	}
+	row := func(widths []float64, cells []string, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont("Arial", style, 10)
+		for i, cell := range cells {
+			pdf.CellFormat(widths[i], 7, cell, "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(7)
+	}
+
+	heading("Average bands")
+	widths := []float64{38, 38, 38, 38, 38}
+	row(widths, []string{"TA", "CC", "LR", "GRA", "Overall"}, true)
+	a := stats.Averages
+	row(widths, []string{fmt.Sprintf("%.2f", a.TA), fmt.Sprintf("%.2f", a.CC), fmt.Sprintf("%.2f", a.LR),
+		fmt.Sprintf("%.2f", a.GRA), fmt.Sprintf("%.2f", a.Overall)}, false)
+	pdf.Ln(6)
+
+	// One row per band, essays at that band under each criterion
+	heading("Band distribution")
+	criteria := append(append([]string{}, scoreCriteria...), "overall")
+	bands := map[string]int{}
+	for _, key := range criteria {
+		for band := range stats.BandDistribution[key] {
+			bands[band]++
+		}
+	}
+	widths = []float64{30, 32, 32, 32, 32, 32}
+	row(widths, []string{"Band", "TA", "CC", "LR", "GRA", "Overall"}, true)
+	for _, band := range sortedBands(bands) {
+		cells := []string{band}
+		for _, key := range criteria {
+			cells = append(cells, strconv.Itoa(stats.BandDistribution[key][band]))
+		}
+		row(widths, cells, false)
+	}
+	pdf.Ln(6)
+
+	heading("Most common errors")
+	widths = []float64{25, 50, 20, 20, 75}
+	row(widths, []string{"Criterion", "Category", "Count", "Essays", "Example"}, true)
+	for _, e := range stats.CommonErrors {
+		row(widths, []string{e.Criterion, e.Category, strconv.Itoa(e.Count), strconv.Itoa(e.Essays), e.Example}, false)
+	}
+	pdf.Ln(6)
+
+	heading("Students with dropping scores")
+	widths = []float64{80, 25, 25, 30, 30}
+	row(widths, []string{"Student", "Essays", "Latest", "Earlier avg", "Drop"}, true)
+	for _, d := range stats.DecliningStudents {
+		row(widths, []string{d.Email, strconv.Itoa(d.Essays), formatBand(d.Latest),
+			fmt.Sprintf("%.2f", d.PreviousAverage), fmt.Sprintf("%.2f", d.Drop)}, false)
+	}
+	pdf.Ln(6)
+
+	heading("Results over time")
+	widths = []float64{60, 40, 25, 25}
+	row(widths, []string{"Question type", "Week of", "Essays", "Overall"}, true)
+	for _, qt := range sortedKeys(stats.OverTimeByQuestion) {
+		for _, r := range stats.OverTimeByQuestion[qt] {
+			row(widths, []string{qt, r.WeekStart.Format("2 Jan 2006"), strconv.Itoa(r.Essays),
+				fmt.Sprintf("%.2f", r.Overall)}, false)
+		}
+	}
+
+	pdf.Ln(10)
+	pdf.SetFont("Arial", "", 8)
+	pdf.SetTextColor(150, 150, 150)
+	pdf.Cell(0, 4, brand.Footer)
+
+	return pdf
+}
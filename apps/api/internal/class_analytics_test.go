@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildClassAnalytics(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC) // a Monday
+	steady, dropping := uint(1), uint(2)
+
+	essay := func(user *uint, days int, qt string, overall float32) Essay {
+		return Essay{
+			UserID:       user,
+			QuestionType: qt,
+			Overall:      overall,
+			BandsJSON:    ToJSON(ScoreOut{TA: overall, CC: overall, LR: overall, GRA: overall, Overall: overall}),
+			CreatedAt:    start.AddDate(0, 0, days),
+		}
+	}
+	essays := []Essay{
+		essay(&steady, 0, "opinion", 6),
+		essay(&steady, 7, "opinion", 6.5),
+		essay(&steady, 14, "discussion", 7),
+		essay(&dropping, 1, "opinion", 7),
+		essay(&dropping, 8, "opinion", 6.5),
+		essay(&dropping, 15, "discussion", 5.5),
+	}
+	emails := map[uint]string{steady: "steady@example.com", dropping: "dropping@example.com"}
+
+	stats := buildClassAnalytics(essays, emails, nil)
+
+	if stats.Essays != 6 || stats.Students != 2 {
+		t.Fatalf("essays/students = %d/%d, want 6/2", stats.Essays, stats.Students)
+	}
+	if got := stats.BandDistribution["overall"]["6.5"]; got != 2 {
+		t.Errorf("overall 6.5 count = %d, want 2", got)
+	}
+
+	t.Run("Only the dropping student is flagged", func(t *testing.T) {
+		if len(stats.DecliningStudents) != 1 {
+			t.Fatalf("declining = %+v, want one student", stats.DecliningStudents)
+		}
+		d := stats.DecliningStudents[0]
+		if d.StudentID != dropping || d.Drop != 1.25 || d.SlopePerWeek == nil || *d.SlopePerWeek >= 0 {
+			t.Errorf("declining student = %+v", d)
+		}
+	})
+
+	t.Run("Weekly averages per question type", func(t *testing.T) {
+		opinion := stats.OverTimeByQuestion["opinion"]
+		if len(opinion) != 2 {
+			t.Fatalf("opinion weeks = %+v, want 2", opinion)
+		}
+		if !opinion[0].WeekStart.Equal(weekStart(start)) || opinion[0].Essays != 2 || opinion[0].Overall != 6.5 {
+			t.Errorf("first opinion week = %+v", opinion[0])
+		}
+	})
+	t.Run("PDF has every section", func(t *testing.T) {
+		pdf := classAnalyticsPDF(stats, defaultBranding, "IELTS 7+")
+		pdf.SetCompression(false)
+		var buf bytes.Buffer
+		if err := pdf.Output(&buf); err != nil {
+			t.Fatal(err)
+		}
+		for _, text := range []string{"Band distribution", "GRA", "Results over time", "discussion", "9 Mar 2026"} {
+			if !strings.Contains(buf.String(), text) {
+				t.Errorf("PDF does not contain %q", text)
+			}
+		}
+	})
+}
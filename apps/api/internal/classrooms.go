@@ -0,0 +1,583 @@
+package internal
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Classroom is a teacher's class that students join with a code
+type Classroom struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	TeacherID uint      `gorm:"index" json:"teacherId"`
+	JoinCode  string    `gorm:"uniqueIndex" json:"joinCode,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	OrganizationID *uint `gorm:"index" json:"organizationId,omitempty"` // the teacher's tenant
+}
+
+// ClassMember links a student to a classroom
+type ClassMember struct {
+	ID          uint      `gorm:"primaryKey"`
+	ClassroomID uint      `gorm:"uniqueIndex:idx_class_member"`
+	UserID      uint      `gorm:"uniqueIndex:idx_class_member;index"`
+	JoinedAt    time.Time `gorm:"autoCreateTime"`
+}
+
+// Assignment asks a class to answer a question-bank prompt by a due date
+type Assignment struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	ClassroomID  uint      `gorm:"index" json:"classroomId"`
+	QuestionID   uint      `gorm:"index" json:"questionId"`
+	Title        string    `json:"title"`
+	Instructions string    `gorm:"type:TEXT" json:"instructions"`
+	DueAt        time.Time `json:"dueAt"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	PeerReviewers         int        `json:"peerReviewers"` // classmates reviewing each submission after the deadline, 0 disables
+	PeerReviewsAssignedAt *time.Time `json:"peerReviewsAssignedAt,omitempty"`
+}
+
+type GradebookRow struct {
+	StudentID   uint               `json:"studentId"`
+	Email       string             `json:"email"`
+	Submitted   bool               `json:"submitted"`
+	EssayID     *uint              `json:"essayId,omitempty"`
+	PublicID    string             `json:"publicId,omitempty"`
+	SubmittedAt *time.Time         `json:"submittedAt,omitempty"`
+	Overall     *float32           `json:"overall,omitempty"`
+	Bands       map[string]float32 `json:"bands,omitempty"`
+}
+
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0/O or 1/I
+
+func generateJoinCode() (string, error) {
+	code := make([]byte, 6)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(joinCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = joinCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// taughtClass loads a classroom owned by the current teacher
+func taughtClass(db *gorm.DB, c *gin.Context, id string) (Classroom, bool) {
+	var class Classroom
+	if err := db.Where("id = ? AND teacher_id = ?", id, c.MustGet("userID").(uint)).First(&class).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "class not found"})
+		return class, false
+	}
+	return class, true
+}
+
+func isClassMember(db *gorm.DB, classID, userID uint) bool {
+	var count int64
+	db.Model(&ClassMember{}).Where("classroom_id = ? AND user_id = ?", classID, userID).Count(&count)
+	return count > 0
+}
+
+// TeacherCanViewEssay reports whether the teacher set the assignment the
+// essay was submitted to. Practice essays outside assignments stay private.
+func TeacherCanViewEssay(db *gorm.DB, teacherID uint, essay Essay) bool {
+	if essay.AssignmentID == nil || essay.UserID == nil {
+		return false
+	}
+
+	var count int64
+	db.Model(&Assignment{}).
+		Joins("JOIN classrooms ON classrooms.id = assignments.classroom_id").
+		Joins("JOIN class_members ON class_members.classroom_id = classrooms.id").
+		Where("assignments.id = ? AND classrooms.teacher_id = ? AND class_members.user_id = ?",
+			*essay.AssignmentID, teacherID, *essay.UserID).
+		Count(&count)
+	return count > 0
+}
+
+// CreateClass creates a classroom with a fresh join code
+func CreateClass(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		teacher := c.MustGet("teacherUser").(User)
+		class := Classroom{Name: strings.TrimSpace(req.Name), TeacherID: teacher.ID, OrganizationID: teacher.OrganizationID}
+
+		// Retry on the rare join code collision
+		var err error
+		for attempt := 0; attempt < 5; attempt++ {
+			if class.JoinCode, err = generateJoinCode(); err != nil {
+				break
+			}
+			if err = db.Create(&class).Error; err == nil {
+				break
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create class"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"class": class})
+	}
+}
+
+// GetClasses lists the classes the user teaches and the classes they belong to
+func GetClasses(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var teaching []Classroom
+		db.Where("teacher_id = ?", userID).Order("created_at DESC").Find(&teaching)
+
+		var enrolled []Classroom
+		db.Joins("JOIN class_members ON class_members.classroom_id = classrooms.id").
+			Where("class_members.user_id = ?", userID).
+			Order("classrooms.created_at DESC").
+			Find(&enrolled)
+		for i := range enrolled {
+			enrolled[i].JoinCode = "" // only the teacher hands out the code
+		}
+
+		c.JSON(http.StatusOK, gin.H{"teaching": teaching, "enrolled": enrolled})
+	}
+}
+
+// JoinClass adds the current user to the class with the given join code
+func JoinClass(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		// Join codes only work inside the student's own tenant
+		var class Classroom
+		code := strings.ToUpper(strings.TrimSpace(req.Code))
+		if err := db.Scopes(TenantScope(currentOrgID(c))).Where("join_code = ?", code).First(&class).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invalid join code"})
+			return
+		}
+
+		if class.TeacherID == userID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "you teach this class"})
+			return
+		}
+		if isClassMember(db, class.ID, userID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "already a member of this class"})
+			return
+		}
+
+		if err := db.Create(&ClassMember{ClassroomID: class.ID, UserID: userID}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to join class"})
+			return
+		}
+
+		class.JoinCode = ""
+		c.JSON(http.StatusOK, gin.H{"class": class})
+	}
+}
+
+// GetClass returns a class with its assignments; the teacher also sees the roster
+func GetClass(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var class Classroom
+		if err := db.First(&class, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "class not found"})
+			return
+		}
+
+		isTeacher := class.TeacherID == userID
+		if !isTeacher && !isClassMember(db, class.ID, userID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "class not found"})
+			return
+		}
+
+		var assignments []Assignment
+		db.Where("classroom_id = ?", class.ID).Order("due_at ASC").Find(&assignments)
+
+		response := gin.H{"class": class, "assignments": assignments}
+		if isTeacher {
+			students := []gin.H{}
+			rows, err := db.Table("class_members").
+				Select("users.id, users.email, class_members.joined_at").
+				Joins("JOIN users ON users.id = class_members.user_id").
+				Where("class_members.classroom_id = ?", class.ID).
+				Order("users.email").
+				Rows()
+			if err == nil {
+				defer rows.Close()
+				for rows.Next() {
+					var id uint
+					var email string
+					var joinedAt time.Time
+					if rows.Scan(&id, &email, &joinedAt) == nil {
+						students = append(students, gin.H{"id": id, "email": email, "joinedAt": joinedAt})
+					}
+				}
+			}
+			response["students"] = students
+		} else {
+			class.JoinCode = ""
+			response["class"] = class
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// RemoveClassMember removes a student from a class the teacher owns
+func RemoveClassMember(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		class, ok := taughtClass(db, c, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		result := db.Where("classroom_id = ? AND user_id = ?", class.ID, c.Param("userId")).Delete(&ClassMember{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove student"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "student not in class"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "student removed successfully"})
+	}
+}
+
+// CreateAssignment sets a question-bank prompt for a class
+func CreateAssignment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		class, ok := taughtClass(db, c, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		var req struct {
+			QuestionID    uint      `json:"questionId" binding:"required"`
+			Title         string    `json:"title"`
+			Instructions  string    `json:"instructions"`
+			DueAt         time.Time `json:"dueAt" binding:"required"`
+			PeerReviewers int       `json:"peerReviewers"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		if !req.DueAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "due date must be in the future"})
+			return
+		}
+		if req.PeerReviewers < 0 || req.PeerReviewers > maxPeerReviewers {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("peerReviewers must be between 0 and %d", maxPeerReviewers)})
+			return
+		}
+
+		var question Question
+		if err := db.Scopes(TenantScope(class.OrganizationID)).
+			Where("id = ? AND is_active = ?", req.QuestionID, true).First(&question).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "question not found"})
+			return
+		}
+
+		assignment := Assignment{
+			ClassroomID:   class.ID,
+			QuestionID:    question.ID,
+			Title:         req.Title,
+			Instructions:  req.Instructions,
+			DueAt:         req.DueAt,
+			PeerReviewers: req.PeerReviewers,
+		}
+		if assignment.Title == "" {
+			assignment.Title = question.Title
+		}
+
+		if err := db.Create(&assignment).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create assignment"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"assignment": assignment})
+	}
+}
+
+// loadAssignment loads an assignment with its class and question, and checks
+// that the current user teaches the class or belongs to it
+func loadAssignment(db *gorm.DB, c *gin.Context) (Assignment, Classroom, Question, bool) {
+	userID := c.MustGet("userID").(uint)
+
+	var assignment Assignment
+	var class Classroom
+	var question Question
+	if err := db.First(&assignment, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "assignment not found"})
+		return assignment, class, question, false
+	}
+	if err := db.First(&class, assignment.ClassroomID).Error; err != nil ||
+		(class.TeacherID != userID && !isClassMember(db, class.ID, userID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "assignment not found"})
+		return assignment, class, question, false
+	}
+	db.First(&question, assignment.QuestionID)
+	return assignment, class, question, true
+}
+
+// GetAssignment returns an assignment with its prompt and, for students, their submission
+func GetAssignment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		assignment, class, question, ok := loadAssignment(db, c)
+		if !ok {
+			return
+		}
+
+		response := gin.H{"assignment": assignment, "question": question, "className": class.Name}
+
+		userID := c.MustGet("userID").(uint)
+		if class.TeacherID != userID {
+			var essay Essay
+			if err := db.Where("assignment_id = ? AND user_id = ?", assignment.ID, userID).First(&essay).Error; err == nil {
+				response["submission"] = gin.H{
+					"essayId":   essay.ID,
+					"publicId":  essay.PublicID,
+					"overall":   essay.Overall,
+					"createdAt": essay.CreatedAt,
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// SubmitAssignment scores a student's essay for an assignment through the
+// normal scorer, metered against their plan like any other analysis
+func SubmitAssignment(db *gorm.DB, rdb *redis.Client, credits *Credits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		assignment, class, question, ok := loadAssignment(db, c)
+		if !ok {
+			return
+		}
+		if class.TeacherID == userID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "teachers cannot submit to their own assignments"})
+			return
+		}
+		if time.Now().After(assignment.DueAt) {
+			c.JSON(http.StatusConflict, gin.H{"error": "assignment is closed"})
+			return
+		}
+
+		var req struct {
+			Text string `json:"text" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		if !MinWordsOK(req.Text) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "essay must be 150-320 words"})
+			return
+		}
+
+		// Check before paying for scoring; idx_assignment_submission
+		// still catches two submissions racing past this
+		var submitted int64
+		db.Model(&Essay{}).Where("assignment_id = ? AND user_id = ?", assignment.ID, userID).Count(&submitted)
+		if submitted > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "you have already submitted this assignment"})
+			return
+		}
+
+		now := time.Now()
+		user := c.MustGet("user").(User)
+		charge, ok := meterAnalysis(c, db, credits, user, now)
+		if !ok {
+			return
+		}
+
+		essay, out, err := scoreSubmission(c.Request.Context(), db, rdb, essaySubmission{
+			UserID:         &userID,
+			OrganizationID: currentOrgID(c),
+			AssignmentID:   &assignment.ID,
+			TaskType:       question.TaskType,
+			QuestionType:   question.QuestionType,
+			Prompt:         question.Prompt,
+			Text:           req.Text,
+			Charge:         charge,
+		})
+		if err != nil && charge == nil {
+			refundQuota(db, user, MetricAnalyses, now)
+		}
+		switch {
+		case errors.Is(err, gorm.ErrDuplicatedKey):
+			c.JSON(http.StatusConflict, gin.H{"error": "you have already submitted this assignment"})
+			return
+		case errors.Is(err, errAINotConfigured):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AI service not configured"})
+			return
+		case errors.Is(err, errScoringFailed):
+			c.JSON(http.StatusBadGateway, gin.H{"error": "AI scoring failed"})
+			return
+		case errors.Is(err, errOrgQuotaExceeded):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "your organization has used its essays for this month"})
+			return
+		case errors.Is(err, errOrgInactive):
+			c.JSON(http.StatusForbidden, gin.H{"error": "your organization is inactive"})
+			return
+		case errors.Is(err, errInsufficientCredits):
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error":   "not enough credits",
+				"cost":    credits.Cost(MetricAnalyses),
+				"balance": creditBalance(db, userID),
+			})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save submission"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, AnalyzeResponse{
+			PublicID:    essay.PublicID,
+			Overall:     out.Overall,
+			Bands:       map[string]float32{"ta": out.TA, "cc": out.CC, "lr": out.LR, "gra": out.GRA},
+			CEFR:        out.CEFR,
+			Feedback:    out.Feedback,
+			CreatedAt:   essay.CreatedAt,
+			Annotations: out.Annotations,
+		})
+	}
+}
+
+// GetGradebook lists every student in the class with their bands for the assignment
+func GetGradebook(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		assignment, class, _, ok := loadAssignment(db, c)
+		if !ok {
+			return
+		}
+		if class.TeacherID != c.MustGet("userID").(uint) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "assignment not found"})
+			return
+		}
+
+		var students []User
+		db.Joins("JOIN class_members ON class_members.user_id = users.id").
+			Where("class_members.classroom_id = ?", class.ID).
+			Order("users.email").
+			Find(&students)
+
+		var essays []Essay
+		db.Where("assignment_id = ?", assignment.ID).Find(&essays)
+		byStudent := map[uint]Essay{}
+		for _, e := range essays {
+			if e.UserID != nil {
+				byStudent[*e.UserID] = e
+			}
+		}
+
+		rows := make([]GradebookRow, 0, len(students))
+		var submitted int
+		var sum float64
+		for _, s := range students {
+			row := GradebookRow{StudentID: s.ID, Email: s.Email}
+			if e, ok := byStudent[s.ID]; ok {
+				score := essayScore(e)
+				id, overall, createdAt := e.ID, e.Overall, e.CreatedAt
+				row.Submitted = true
+				row.EssayID = &id
+				row.PublicID = e.PublicID
+				row.SubmittedAt = &createdAt
+				row.Overall = &overall
+				row.Bands = map[string]float32{"ta": score.TA, "cc": score.CC, "lr": score.LR, "gra": score.GRA}
+				submitted++
+				sum += float64(overall)
+			}
+			rows = append(rows, row)
+		}
+
+		var average float64
+		if submitted > 0 {
+			average = roundTo(sum/float64(submitted), 2)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"assignment": assignment,
+			"rows":       rows,
+			"submitted":  submitted,
+			"students":   len(students),
+			"average":    average,
+		})
+	}
+}
+
+// GetSubmission returns a student's essay to the teacher who set the assignment
+func GetSubmission(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		teacherID := c.MustGet("userID").(uint)
+
+		essayID, err := strconv.ParseUint(c.Param("essayId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid essay id"})
+			return
+		}
+
+		var essay Essay
+		if err := db.Where("id = ? AND assignment_id = ?", essayID, c.Param("id")).First(&essay).Error; err != nil ||
+			!TeacherCanViewEssay(db, teacherID, essay) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "submission not found"})
+			return
+		}
+
+		var annotations []Annotation
+		db.Where("essay_id = ?", essay.ID).Order("start ASC").Find(&annotations)
+
+		var student User
+		db.Select("id", "email").First(&student, *essay.UserID)
+
+		score := essayScore(essay)
+		c.JSON(http.StatusOK, gin.H{
+			"id":           essay.ID,
+			"publicId":     essay.PublicID,
+			"student":      gin.H{"id": student.ID, "email": student.Email},
+			"taskType":     essay.TaskType,
+			"questionType": essay.QuestionType,
+			"text":         essay.Text,
+			"overall":      essay.Overall,
+			"cefr":         essay.CEFR,
+			"feedback":     essay.Feedback,
+			"createdAt":    essay.CreatedAt,
+			"annotations":  annotations,
+			"bands":        gin.H{"ta": score.TA, "cc": score.CC, "lr": score.LR, "gra": score.GRA},
+			"teacher":      essayFeedback(db, essay),
+			"peer":         peerFeedback(db, essay),
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// classFixture is a class with one student, an open and a closed
+// assignment, and users outside the class
+type classFixture struct {
+	db                                       *gorm.DB
+	teacher, otherTeacher, student, outsider User
+	class                                    Classroom
+	open, closed                             Assignment
+}
+
+func newClassFixture(t *testing.T) classFixture {
+	t.Helper()
+	db := openTestDB(t)
+	f := classFixture{db: db}
+
+	users := []*User{&f.teacher, &f.otherTeacher, &f.student, &f.outsider}
+	for i, u := range users {
+		*u = User{Email: fmt.Sprintf("user%d@example.com", i), Plan: "free", Role: RoleUser}
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.class = Classroom{Name: "IELTS 7+", TeacherID: f.teacher.ID, JoinCode: "ABC234"}
+	question := Question{TaskType: "task2", Title: "Cities", Prompt: "Discuss.", IsActive: true}
+	db.Create(&f.class)
+	db.Create(&question)
+	db.Create(&ClassMember{ClassroomID: f.class.ID, UserID: f.student.ID})
+
+	f.open = Assignment{ClassroomID: f.class.ID, QuestionID: question.ID, Title: "Week 1", DueAt: time.Now().Add(24 * time.Hour)}
+	f.closed = Assignment{ClassroomID: f.class.ID, QuestionID: question.ID, Title: "Week 0", DueAt: time.Now().Add(-time.Hour)}
+	db.Create(&f.open)
+	db.Create(&f.closed)
+	return f
+}
+
+// serve runs a handler as the user on a route with an :id parameter
+func (f classFixture) serve(handler gin.HandlerFunc, user User, method, id, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(method, "/:id", func(c *gin.Context) {
+		c.Set("userID", user.ID)
+		c.Set("user", user)
+		c.Next()
+	}, handler)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(method, "/"+id, strings.NewReader(body)))
+	return rec
+}
+
+func TestTaughtClass(t *testing.T) {
+	f := newClassFixture(t)
+	handler := func(c *gin.Context) {
+		if class, ok := taughtClass(f.db, c, c.Param("id")); ok {
+			c.JSON(http.StatusOK, class)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		user     User
+		id       string
+		wantCode int
+	}{
+		{"Teacher", f.teacher, fmt.Sprint(f.class.ID), http.StatusOK},
+		{"Student", f.student, fmt.Sprint(f.class.ID), http.StatusNotFound},
+		{"Another teacher", f.otherTeacher, fmt.Sprint(f.class.ID), http.StatusNotFound},
+		{"Missing class", f.teacher, "999", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := f.serve(handler, tt.user, http.MethodGet, tt.id, ""); rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestLoadAssignment(t *testing.T) {
+	f := newClassFixture(t)
+	handler := func(c *gin.Context) {
+		if assignment, _, question, ok := loadAssignment(f.db, c); ok {
+			c.JSON(http.StatusOK, gin.H{"assignment": assignment.ID, "question": question.ID})
+		}
+	}
+
+	tests := []struct {
+		name     string
+		user     User
+		id       string
+		wantCode int
+	}{
+		{"Teacher", f.teacher, fmt.Sprint(f.open.ID), http.StatusOK},
+		{"Class member", f.student, fmt.Sprint(f.open.ID), http.StatusOK},
+		{"Outsider", f.outsider, fmt.Sprint(f.open.ID), http.StatusNotFound},
+		{"Another teacher", f.otherTeacher, fmt.Sprint(f.open.ID), http.StatusNotFound},
+		{"Missing assignment", f.teacher, "999", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := f.serve(handler, tt.user, http.MethodGet, tt.id, ""); rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestTeacherCanViewEssay(t *testing.T) {
+	f := newClassFixture(t)
+	submission := Essay{UserID: &f.student.ID, AssignmentID: &f.open.ID}
+	byOutsider := Essay{UserID: &f.outsider.ID, AssignmentID: &f.open.ID}
+	practice := Essay{UserID: &f.student.ID}
+
+	tests := []struct {
+		name    string
+		teacher User
+		essay   Essay
+		want    bool
+	}{
+		{"Submission to own assignment", f.teacher, submission, true},
+		{"Another teacher", f.otherTeacher, submission, false},
+		{"Author not in the class", f.teacher, byOutsider, false},
+		{"Practice essay", f.teacher, practice, false},
+		{"Anonymous essay", f.teacher, Essay{AssignmentID: &f.open.ID}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TeacherCanViewEssay(f.db, tt.teacher.ID, tt.essay); got != tt.want {
+				t.Errorf("TeacherCanViewEssay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubmitAssignment(t *testing.T) {
+	t.Setenv("AI_KEY", "")
+	f := newClassFixture(t)
+	essay := strings.Repeat("word ", 200)
+	body := `{"text":"` + essay + `"}`
+
+	tests := []struct {
+		name     string
+		user     User
+		id       uint
+		body     string
+		wantCode int
+	}{
+		{"Teacher", f.teacher, f.open.ID, body, http.StatusBadRequest},
+		{"Outsider", f.outsider, f.open.ID, body, http.StatusNotFound},
+		{"Closed", f.student, f.closed.ID, body, http.StatusConflict},
+		{"Too short", f.student, f.open.ID, `{"text":"too short"}`, http.StatusBadRequest},
+		// Passes every rule and reaches the scorer
+		{"Accepted", f.student, f.open.ID, body, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.serve(SubmitAssignment(f.db, nil, &Credits{}), tt.user, http.MethodPost, fmt.Sprint(tt.id), tt.body)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("Over the plan quota", func(t *testing.T) {
+		setDaily := func(n int) {
+			f.db.Model(&Plan{}).Where("name = ?", "free").Update("daily_analyses", n)
+		}
+		setDaily(0)
+		defer setDaily(5)
+		rec := f.serve(SubmitAssignment(f.db, nil, &Credits{}), f.student, http.MethodPost, fmt.Sprint(f.open.ID), body)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d (%s), want 429", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("One submission per student", func(t *testing.T) {
+		first := Essay{UserID: &f.student.ID, AssignmentID: &f.open.ID, PublicID: "first"}
+		if err := f.db.Create(&first).Error; err != nil {
+			t.Fatal(err)
+		}
+		// Refused before the scorer, which would answer 503 without AI_KEY
+		rec := f.serve(SubmitAssignment(f.db, nil, &Credits{}), f.student, http.MethodPost, fmt.Sprint(f.open.ID), body)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("resubmission: status = %d (%s), want 409", rec.Code, rec.Body)
+		}
+		second := Essay{UserID: &f.student.ID, AssignmentID: &f.open.ID, PublicID: "second"}
+		if err := f.db.Create(&second).Error; !errors.Is(err, gorm.ErrDuplicatedKey) {
+			t.Errorf("second submission error = %v, want gorm.ErrDuplicatedKey", err)
+		}
+		practice := []Essay{{UserID: &f.student.ID, PublicID: "p1"}, {UserID: &f.student.ID, PublicID: "p2"}}
+		if err := f.db.Create(&practice).Error; err != nil {
+			t.Errorf("practice essays are not limited: %v", err)
+		}
+	})
+}
@@ -0,0 +1,410 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MetricExaminerReviews is a review of an essay by a human examiner. Like
+// rewrites it has no endpoint yet, but can be priced in credits.
+const MetricExaminerReviews = "examiner_reviews"
+
+// Credit transaction kinds
+const (
+	CreditPurchase = "purchase"
+	CreditGrant    = "grant"
+	CreditVoucher  = "voucher"
+	CreditSpend    = "spend"
+)
+
+// System accounts of the ledger. Credits are issued from the first three,
+// whose balances go negative, and spent into the last.
+const (
+	creditsFromPurchases = "system:purchases"
+	creditsFromGrants    = "system:grants"
+	creditsFromVouchers  = "system:vouchers"
+	creditsSpent         = "system:usage"
+)
+
+// defaultCreditCosts price each feature when CREDIT_COSTS is not set
+var defaultCreditCosts = map[string]int{MetricAnalyses: 1, MetricRewrites: 1, MetricExaminerReviews: 5}
+
+// maxCreditGrant bounds a single admin grant
+const maxCreditGrant = 10000
+
+var (
+	errInsufficientCredits    = errors.New("not enough credits")
+	errCreditsNotCharged      = errors.New("credits could not be charged")
+	errCreditsDuplicate       = errors.New("credit transaction already recorded")
+	errCreditsUnbalanced      = errors.New("credit transaction does not balance")
+	errCreditLedgerAppendOnly = errors.New("credit ledger entries cannot be changed")
+)
+
+// CreditAccount holds credits. Users have one account each, named
+// "user:<id>"; the system accounts are named "system:<purpose>".
+type CreditAccount struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
+	UserID    *uint     `gorm:"index" json:"userId,omitempty"`
+	Balance   int       `json:"balance"` // sum of the account's entries, kept by postCredits
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CreditTransaction is one movement of credits. Its entries sum to zero.
+type CreditTransaction struct {
+	ID        uint          `gorm:"primaryKey" json:"id"`
+	Kind      string        `gorm:"index;not null" json:"kind"`
+	Reference *string       `gorm:"uniqueIndex" json:"reference,omitempty"` // makes retries idempotent, e.g. "essay:42"
+	Memo      string        `json:"memo,omitempty"`
+	ActorID   *uint         `json:"actorId,omitempty"` // staff member who granted
+	CreatedAt time.Time     `json:"createdAt"`
+	Entries   []CreditEntry `gorm:"foreignKey:TransactionID" json:"entries,omitempty"`
+}
+
+// CreditEntry is one side of a transaction: a positive amount adds to the
+// account, a negative one takes from it
+type CreditEntry struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	TransactionID uint      `gorm:"index;not null" json:"transactionId"`
+	AccountID     uint      `gorm:"index;not null" json:"accountId"`
+	Amount        int       `gorm:"not null" json:"amount"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// BeforeUpdate keeps transactions append-only
+func (CreditTransaction) BeforeUpdate(*gorm.DB) error { return errCreditLedgerAppendOnly }
+
+// BeforeDelete keeps transactions append-only
+func (CreditTransaction) BeforeDelete(*gorm.DB) error { return errCreditLedgerAppendOnly }
+
+// BeforeUpdate keeps entries append-only
+func (CreditEntry) BeforeUpdate(*gorm.DB) error { return errCreditLedgerAppendOnly }
+
+// BeforeDelete keeps entries append-only
+func (CreditEntry) BeforeDelete(*gorm.DB) error { return errCreditLedgerAppendOnly }
+
+// userCreditAccount names a user's account
+func userCreditAccount(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// creditAccount creates the account when missing. With lock it is locked
+// for the rest of the transaction, so its balance can be checked.
+func creditAccount(tx *gorm.DB, name string, lock bool) (CreditAccount, error) {
+	account := CreditAccount{Name: name}
+	if id, ok := strings.CutPrefix(name, "user:"); ok {
+		userID := parseUserID(id)
+		account.UserID = &userID
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		return account, err
+	}
+	query := tx.Where("name = ?", name)
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	err := query.First(&account).Error
+	return account, err
+}
+
+// isSystemCreditAccount reports whether an account is one of the system
+// accounts, which have no balance floor
+func isSystemCreditAccount(name string) bool {
+	return strings.HasPrefix(name, "system:")
+}
+
+// postCredits records a transaction moving credits between accounts, given
+// as account name -> amount. The amounts must sum to zero. User accounts are
+// locked in name order so concurrent transactions cannot deadlock, and one
+// going below zero fails it with errInsufficientCredits. System accounts
+// have no floor, so they are not locked up front but updated last: spends
+// then hold the shared "system:usage" row only until they commit. A
+// transaction whose Reference was already recorded fails with
+// errCreditsDuplicate. Run it inside a database transaction.
+func postCredits(tx *gorm.DB, txn CreditTransaction, legs map[string]int) (CreditTransaction, error) {
+	sum := 0
+	names := make([]string, 0, len(legs))
+	for name, amount := range legs {
+		sum += amount
+		names = append(names, name)
+	}
+	if sum != 0 || len(legs) < 2 {
+		return txn, errCreditsUnbalanced
+	}
+	// User accounts first, then system accounts, each in name order
+	sort.Slice(names, func(i, j int) bool {
+		if si, sj := isSystemCreditAccount(names[i]), isSystemCreditAccount(names[j]); si != sj {
+			return sj
+		}
+		return names[i] < names[j]
+	})
+
+	created := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("Entries").Create(&txn)
+	if created.Error != nil {
+		return txn, created.Error
+	}
+	if created.RowsAffected == 0 {
+		return txn, errCreditsDuplicate
+	}
+
+	for _, name := range names {
+		system := isSystemCreditAccount(name)
+		account, err := creditAccount(tx, name, !system)
+		if err != nil {
+			return txn, err
+		}
+		amount := legs[name]
+		if !system && account.Balance+amount < 0 {
+			return txn, errInsufficientCredits
+		}
+		if err := tx.Model(&account).Update("balance", gorm.Expr("balance + ?", amount)).Error; err != nil {
+			return txn, err
+		}
+		entry := CreditEntry{TransactionID: txn.ID, AccountID: account.ID, Amount: amount}
+		if err := tx.Create(&entry).Error; err != nil {
+			return txn, err
+		}
+		txn.Entries = append(txn.Entries, entry)
+	}
+	return txn, nil
+}
+
+// creditBalance returns a user's balance, 0 without an account
+func creditBalance(db *gorm.DB, userID uint) int {
+	var account CreditAccount
+	db.Where("name = ?", userCreditAccount(userID)).Limit(1).Find(&account)
+	return account.Balance
+}
+
+// Credits prices features and sells packs of credits
+type Credits struct {
+	Costs map[string]int // metric -> credits; features not listed cannot be paid with credits
+	Packs map[string]int // pack name -> credits
+}
+
+// NewCredits reads CREDIT_COSTS, e.g. "analyses=1,rewrites=1,examiner_reviews=5"
+// (these are the defaults), and CREDIT_PACKS, the packs on sale, e.g.
+// "starter=10,exam=50". Packs also need a price at the payment provider.
+func NewCredits() (*Credits, error) {
+	credits := &Credits{Costs: defaultCreditCosts, Packs: map[string]int{}}
+	for env, into := range map[string]*map[string]int{"CREDIT_COSTS": &credits.Costs, "CREDIT_PACKS": &credits.Packs} {
+		if os.Getenv(env) == "" {
+			continue
+		}
+		pairs, err := parseEnvPairs(env)
+		if err != nil {
+			return nil, err
+		}
+		values := map[string]int{}
+		for name, s := range pairs {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("%s: %s must be a positive number of credits, got %q", env, name, s)
+			}
+			values[name] = n
+		}
+		*into = values
+	}
+	return credits, nil
+}
+
+// Cost returns the credits a feature costs, 0 when it cannot be bought
+func (cr *Credits) Cost(metric string) int {
+	return cr.Costs[metric]
+}
+
+// Charge returns a function that pays for one use of a feature, tied to
+// the essay it produced, or nil when the feature has no credit price. It
+// runs in the transaction that saves the essay.
+func (cr *Credits) Charge(userID uint, metric string) func(tx *gorm.DB, essay Essay) error {
+	cost := cr.Cost(metric)
+	if cost <= 0 {
+		return nil
+	}
+	return func(tx *gorm.DB, essay Essay) error {
+		ref := fmt.Sprintf("%s:essay:%d", metric, essay.ID)
+		_, err := postCredits(tx, CreditTransaction{Kind: CreditSpend, Reference: &ref, Memo: metric},
+			map[string]int{userCreditAccount(userID): -cost, creditsSpent: cost})
+		return err
+	}
+}
+
+// purchase credits the pack bought in a payment event. It returns the
+// credits added, 0 when the event was already handled or names an
+// unknown pack or user.
+func (cr *Credits) purchase(db *gorm.DB, provider string, ev BillingEvent) (int, error) {
+	amount := cr.Packs[ev.Pack]
+	if amount == 0 || ev.UserID == 0 {
+		log.Printf("Credit purchase %s names unknown pack %q or no user", ev.ID, ev.Pack)
+		return 0, nil
+	}
+	var user User
+	if err := db.Select("id").First(&user, ev.UserID).Error; err != nil {
+		log.Printf("Credit purchase %s names unknown user %d", ev.ID, ev.UserID)
+		return 0, nil
+	}
+
+	ref := provider + ":" + ev.PaymentID
+	err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := postCredits(tx, CreditTransaction{Kind: CreditPurchase, Reference: &ref, Memo: "pack " + ev.Pack},
+			map[string]int{creditsFromPurchases: -amount, userCreditAccount(user.ID): amount})
+		return err
+	})
+	if errors.Is(err, errCreditsDuplicate) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return amount, nil
+}
+
+// creditHistory lists the last movements of a user's account, newest first
+func creditHistory(db *gorm.DB, userID uint, limit int) []gin.H {
+	var rows []struct {
+		Amount    int
+		Kind      string
+		Memo      string
+		CreatedAt time.Time
+	}
+	db.Table("credit_entries").
+		Select("credit_entries.amount, credit_transactions.kind, credit_transactions.memo, credit_entries.created_at").
+		Joins("JOIN credit_transactions ON credit_transactions.id = credit_entries.transaction_id").
+		Joins("JOIN credit_accounts ON credit_accounts.id = credit_entries.account_id").
+		Where("credit_accounts.name = ?", userCreditAccount(userID)).
+		Order("credit_entries.id DESC").Limit(limit).Scan(&rows)
+
+	history := make([]gin.H, 0, len(rows))
+	for _, row := range rows {
+		history = append(history, gin.H{"amount": row.Amount, "kind": row.Kind, "memo": row.Memo, "createdAt": row.CreatedAt})
+	}
+	return history
+}
+
+// packsForSale are the configured packs the payment provider can sell
+func packsForSale(credits *Credits, billing *Billing) []gin.H {
+	packs := []gin.H{}
+	if billing == nil {
+		return packs
+	}
+	for _, name := range billing.Provider.Packs() {
+		if amount := credits.Packs[name]; amount > 0 {
+			packs = append(packs, gin.H{"name": name, "credits": amount})
+		}
+	}
+	return packs
+}
+
+// GetCredits returns the current user's balance and recent movements, what
+// each feature costs and the packs on sale
+func GetCredits(db *gorm.DB, credits *Credits, billing *Billing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+		c.JSON(http.StatusOK, gin.H{
+			"balance": creditBalance(db, user.ID),
+			"costs":   credits.Costs,
+			"packs":   packsForSale(credits, billing),
+			"history": creditHistory(db, user.ID, 50),
+		})
+	}
+}
+
+// BuyCredits starts the purchase of a credit pack
+func BuyCredits(db *gorm.DB, credits *Credits, billing *Billing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireBilling(c, billing) {
+			return
+		}
+		user := c.MustGet("user").(User)
+
+		var req struct {
+			Pack string `json:"pack" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pack is required"})
+			return
+		}
+		onSale := false
+		for _, pack := range packsForSale(credits, billing) {
+			onSale = onSale || pack["name"] == req.Pack
+		}
+		if !onSale {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pack is not on sale"})
+			return
+		}
+
+		checkout := CheckoutRequest{
+			UserID:     user.ID,
+			Email:      user.Email,
+			Pack:       req.Pack,
+			SuccessURL: appURL("/profile?credits=success"),
+			CancelURL:  appURL("/profile?credits=cancelled"),
+		}
+		if sub, found := userSubscription(db, user.ID); found && sub.Provider == billing.Provider.Name() {
+			checkout.CustomerID = sub.CustomerID
+		}
+
+		url, err := billing.Provider.CreateCheckout(c.Request.Context(), checkout)
+		if err != nil {
+			log.Printf("Failed to create credit checkout for user %d: %v", user.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to start checkout"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"url": url})
+	}
+}
+
+// GrantCredits adds credits to a user's account, e.g. as a goodwill gesture
+func GrantCredits(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := c.MustGet("adminUser").(User)
+
+		var req struct {
+			Amount int    `json:"amount" binding:"required"`
+			Memo   string `json:"memo" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "amount and memo are required"})
+			return
+		}
+		if req.Amount <= 0 || req.Amount > maxCreditGrant {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("amount must be between 1 and %d", maxCreditGrant)})
+			return
+		}
+
+		var user User
+		if err := db.Scopes(TenantScope(currentOrgID(c))).First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		var txn CreditTransaction
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			txn, err = postCredits(tx, CreditTransaction{Kind: CreditGrant, Memo: strings.TrimSpace(req.Memo), ActorID: &actor.ID},
+				map[string]int{creditsFromGrants: -req.Amount, userCreditAccount(user.ID): req.Amount})
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to grant credits"})
+			return
+		}
+		audit(c, AuditLog{
+			Action: "credits.grant", TargetType: "user", TargetID: fmt.Sprint(user.ID),
+			After: ToJSON(gin.H{"amount": req.Amount, "memo": txn.Memo, "transactionId": txn.ID}),
+		})
+
+		c.JSON(http.StatusOK, gin.H{"balance": creditBalance(db, user.ID), "transaction": txn})
+	}
+}
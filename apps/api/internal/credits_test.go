@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestNewCredits(t *testing.T) {
+	t.Setenv("CREDIT_COSTS", "")
+	t.Setenv("CREDIT_PACKS", "starter=10, exam=50")
+	credits, err := NewCredits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credits.Cost(MetricAnalyses) != 1 || credits.Cost(MetricExaminerReviews) != 5 || credits.Cost(MetricPDFExports) != 0 {
+		t.Errorf("default costs = %v", credits.Costs)
+	}
+	if credits.Packs["starter"] != 10 || credits.Packs["exam"] != 50 {
+		t.Errorf("packs = %v", credits.Packs)
+	}
+	if credits.Charge(1, MetricPDFExports) != nil {
+		t.Error("a feature without a price can be charged")
+	}
+
+	for _, bad := range []string{"analyses=0", "analyses=-1", "analyses", "Analyses=1", "analyses=one"} {
+		t.Setenv("CREDIT_COSTS", bad)
+		if _, err := NewCredits(); err == nil {
+			t.Errorf("CREDIT_COSTS=%s accepted", bad)
+		}
+	}
+}
+
+func TestPostCreditsBalances(t *testing.T) {
+	tests := []struct {
+		name string
+		legs map[string]int
+	}{
+		{"Does not sum to zero", map[string]int{creditsFromGrants: -5, userCreditAccount(1): 4}},
+		{"Single leg", map[string]int{userCreditAccount(1): 0}},
+		{"No legs", map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Unbalanced transactions are refused before touching the database
+			if _, err := postCredits(nil, CreditTransaction{Kind: CreditGrant}, tt.legs); !errors.Is(err, errCreditsUnbalanced) {
+				t.Errorf("postCredits() error = %v, want errCreditsUnbalanced", err)
+			}
+		})
+	}
+}
+
+func TestVoucherCodes(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"ABCD-EFGH-2345", "ABCDEFGH2345"},
+		{" abcd efgh 2345 ", "ABCDEFGH2345"},
+		{"welcome_2026!", "WELCOME2026"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeVoucherCode(tt.in); got != tt.want {
+			t.Errorf("normalizeVoucherCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := newVoucherCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != voucherCodeLength || normalizeVoucherCode(code) != code || strings.ContainsAny(code, "01IO") {
+			t.Errorf("newVoucherCode() = %q", code)
+		}
+		if seen[code] {
+			t.Errorf("newVoucherCode() repeated %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestPostCreditsOrder(t *testing.T) {
+	db := openTestDB(t)
+	legs := map[string]int{creditsFromGrants: -10, userCreditAccount(2): 6, userCreditAccount(1): 4}
+
+	var txn CreditTransaction
+	err := db.Transaction(func(tx *gorm.DB) (err error) {
+		txn, err = postCredits(tx, CreditTransaction{Kind: CreditGrant}, legs)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// User accounts are locked first, the system account is updated last
+	var names []string
+	for _, entry := range txn.Entries {
+		var account CreditAccount
+		db.First(&account, entry.AccountID)
+		names = append(names, account.Name)
+	}
+	want := []string{userCreditAccount(1), userCreditAccount(2), creditsFromGrants}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("posting order = %v, want %v", names, want)
+	}
+	if balance := creditBalance(db, 2); balance != 6 {
+		t.Errorf("balance = %d, want 6", balance)
+	}
+}
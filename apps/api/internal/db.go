@@ -1,19 +1,93 @@
 package internal
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"github.com/sidigigroup/bandly/api/internal/migrations"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// memoryDSN is a SQLite database that lives as long as its one connection
+const memoryDSN = "file::memory:?_pragma=foreign_keys(1)"
+
+// OpenDB connects to dsn: a Postgres URL or key=value string, "sqlite:PATH"
+// for a SQLite file, or "" for an in-memory SQLite database that is gone
+// when the process exits. SQLite serves local development, demos and tests.
 func OpenDB(dsn string) (*gorm.DB, error) {
 	config := &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
+		// Unique violations surface as gorm.ErrDuplicatedKey on every dialect
+		TranslateError: true,
 	}
 
-	return gorm.Open(postgres.Open(dsn), config)
+	path, isSQLite := strings.CutPrefix(dsn, "sqlite:")
+	if dsn != "" && !isSQLite {
+		return gorm.Open(postgres.Open(dsn), config)
+	}
+
+	if dsn == "" {
+		path = memoryDSN
+	} else {
+		// Writers wait for each other instead of failing with SQLITE_BUSY
+		path = "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	}
+	db, err := gorm.Open(sqlite.Open(path), config)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has one writer; for memory it is also the only copy of the data
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// IsSQLite reports whether db is the embedded development database
+func IsSQLite(db *gorm.DB) bool {
+	return db.Dialector.Name() == "sqlite"
 }
 
-func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(&User{}, &Essay{}, &AnalyticsEvent{}, &UserFeedback{}, &BlogPost{}, &AdminPrompt{})
+// MigrationRunner returns a runner for the embedded migrations
+func MigrationRunner(db *gorm.DB) (*migrations.Runner, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return migrations.New(sqlDB)
+}
+
+// PrepareDB refuses a database with pending migrations, then seeds the
+// default plans. The migrations are Postgres SQL, so a SQLite database
+// gets its tables from AutoMigrate instead.
+func PrepareDB(ctx context.Context, db *gorm.DB) error {
+	if IsSQLite(db) {
+		if err := db.WithContext(ctx).AutoMigrate(schemaModels...); err != nil {
+			return err
+		}
+		return seedPlans(db.WithContext(ctx))
+	}
+
+	runner, err := MigrationRunner(db)
+	if err != nil {
+		return err
+	}
+	pending, err := runner.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		names := make([]string, len(pending))
+		for i, m := range pending {
+			names[i] = m.String()
+		}
+		return fmt.Errorf("%d migrations pending (%s): run `bandly migrate up`", len(pending), strings.Join(names, ", "))
+	}
+	return seedPlans(db.WithContext(ctx))
 }
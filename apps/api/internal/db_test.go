@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB returns a fresh in-memory SQLite database with every table
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := OpenDB("")
+	if err != nil {
+		t.Fatalf("OpenDB() error = %v", err)
+	}
+	db.Logger = logger.Discard
+	if err := PrepareDB(context.Background(), db); err != nil {
+		t.Fatalf("PrepareDB() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestSQLiteStore(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	user := User{Email: "student@example.com", Plan: "free", Role: "user", CreatedAt: now}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	t.Run("Plans seeded once", func(t *testing.T) {
+		if err := PrepareDB(context.Background(), db); err != nil {
+			t.Fatalf("second PrepareDB() error = %v", err)
+		}
+		var count int64
+		db.Model(&Plan{}).Count(&count)
+		if count != int64(len(defaultPlans)) {
+			t.Errorf("plans = %d, want %d", count, len(defaultPlans))
+		}
+	})
+
+	t.Run("Quota counts up to the daily limit", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			if err := consumeQuota(db, user, MetricAnalyses, now); err != nil {
+				t.Fatalf("use %d: %v", i+1, err)
+			}
+		}
+		var quotaErr *QuotaError
+		if err := consumeQuota(db, user, MetricAnalyses, now); !errors.As(err, &quotaErr) || quotaErr.Used != 5 {
+			t.Errorf("sixth use error = %v, want a daily QuotaError at 5", err)
+		}
+	})
+
+	t.Run("Credits from a voucher", func(t *testing.T) {
+		batch := VoucherBatch{Name: "Open day", Credits: 3, MaxRedemptions: 1, Vouchers: []Voucher{{Code: "ABCDEFGH2345"}}}
+		if err := db.Create(&batch).Error; err != nil {
+			t.Fatalf("create batch: %v", err)
+		}
+		if _, err := redeemVoucher(db, user, "ABCDEFGH2345", now); err != nil {
+			t.Fatalf("redeemVoucher() error = %v", err)
+		}
+		if _, err := redeemVoucher(db, user, "ABCDEFGH2345", now); !errors.Is(err, errVoucherUsedUp) {
+			t.Errorf("second redeem error = %v, want %v", err, errVoucherUsedUp)
+		}
+		if got := creditBalance(db, user.ID); got != 3 {
+			t.Errorf("balance = %d, want 3", got)
+		}
+	})
+
+	t.Run("Billing event upgrades the plan once", func(t *testing.T) {
+		billing := &Billing{Provider: NewFakeProvider("secret", []string{"pro"}, nil), Grace: defaultBillingGrace}
+		end := now.AddDate(0, 1, 0)
+		ev := BillingEvent{ID: "evt_1", Type: BillingSubscriptionUpdated, Created: now, UserID: user.ID,
+			CustomerID: "cus_1", SubscriptionID: "sub_1", Plan: "pro", Status: SubscriptionActive, PeriodStart: &now, PeriodEnd: &end}
+		for i := 0; i < 2; i++ {
+			if _, err := billing.ApplyBillingEvent(db, ev, now); err != nil {
+				t.Fatalf("ApplyBillingEvent() error = %v", err)
+			}
+		}
+		var got User
+		db.First(&got, user.ID)
+		if got.Plan != "pro" {
+			t.Errorf("plan = %q, want pro", got.Plan)
+		}
+	})
+
+	t.Run("Chained audit entries verify", func(t *testing.T) {
+		auditor := &Auditor{db: db, chain: true}
+		for _, action := range []string{"user.update", "user.delete"} {
+			if err := auditor.Record(AuditLog{Action: action, TargetType: "user", TargetID: "1"}); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+		}
+		var entries []AuditLog
+		db.Order("id").Find(&entries)
+		if _, matched, broken := verifyAuditChain("", entries); broken != 0 || matched != 2 {
+			t.Errorf("verifyAuditChain() matched %d, broken at %d", matched, broken)
+		}
+	})
+}
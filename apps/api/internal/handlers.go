@@ -2,6 +2,8 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"time"
@@ -13,9 +15,10 @@ import (
 )
 
 type AnalyzeRequest struct {
-	Text     string `json:"text" binding:"required"`
-	TaskType string `json:"taskType"`
-	Prompt   string `json:"prompt"`
+	Text         string `json:"text" binding:"required"`
+	TaskType     string `json:"taskType"`
+	QuestionType string `json:"questionType"`
+	Prompt       string `json:"prompt"`
 }
 
 type AnalyzeResponse struct {
@@ -25,10 +28,12 @@ type AnalyzeResponse struct {
 	CEFR      string             `json:"cefr"`
 	Feedback  string             `json:"feedback"`
 	CreatedAt time.Time          `json:"createdAt"`
+
+	Annotations []ScoreAnnotation `json:"annotations,omitempty"`
 }
 
 // AnalyzeEssay handles essay analysis requests with real AI scoring, caching, and user association
-func AnalyzeEssay(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
+func AnalyzeEssay(db *gorm.DB, rdb *redis.Client, credits *Credits) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req AnalyzeRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -40,6 +45,7 @@ func AnalyzeEssay(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 		if req.TaskType != "task1" && req.TaskType != "task2" {
 			req.TaskType = "task2" // default
 		}
+		req.QuestionType = NormalizeQuestionType(req.TaskType, req.QuestionType)
 
 		// Validate word count
 		if !MinWordsOK(req.Text) {
@@ -54,39 +60,66 @@ func AnalyzeEssay(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 			userID = &id
 		}
 
-		// Check cache first
-		cached, err := GetCachedEssayAnalysis(rdb, req.Text, req.TaskType)
-		var out ScoreOut
-
-		if err == nil && cached != nil {
-			// Use cached result
-			out = *cached
-		} else {
-			// Get OpenAI API key
-			apiKey := os.Getenv("AI_KEY")
-			if apiKey == "" {
-				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AI service not configured"})
-				return
-			}
-
-			// Create context with timeout
-			ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
-			defer cancel()
+		// Accounts must confirm their email to get the full daily allowance
+		if err := checkUserQuota(db, userID); err != nil {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":             "verify your email to analyse more essays today",
+				"limit":             unverifiedDailyEssays,
+				"emailVerification": true,
+			})
+			return
+		}
 
-			// Score essay with AI
-			scoreResult, err := ScoreEssay(ctx, apiKey, req.TaskType, req.Prompt, req.Text)
-			if err != nil {
-				c.JSON(http.StatusBadGateway, gin.H{"error": "AI scoring failed"})
+		// Signed-in users are metered against their plan
+		now := time.Now()
+		var user User
+		var charge func(*gorm.DB, Essay) error
+		if u, ok := c.Get("user"); ok {
+			user = u.(User)
+			if charge, ok = meterAnalysis(c, db, credits, user, now); !ok {
 				return
 			}
-			out = scoreResult
-
-			// Cache the result
-			_ = CacheEssayAnalysis(rdb, req.Text, req.TaskType, out)
 		}
 
-		// Generate public ID
-		publicID := uuid.NewString()[:8]
+		essay, out, err := scoreSubmission(c.Request.Context(), db, rdb, essaySubmission{
+			UserID:         userID, // Will be nil for anonymous users
+			OrganizationID: currentOrgID(c),
+			TaskType:       req.TaskType,
+			QuestionType:   req.QuestionType,
+			Prompt:         req.Prompt,
+			Text:           req.Text,
+			Charge:         charge,
+		})
+		if err != nil && !errors.Is(err, errEssayNotSaved) && user.ID != 0 && charge == nil {
+			refundQuota(db, user, MetricAnalyses, now)
+		}
+		switch {
+		case errors.Is(err, errAINotConfigured):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AI service not configured"})
+			return
+		case errors.Is(err, errScoringFailed):
+			c.JSON(http.StatusBadGateway, gin.H{"error": "AI scoring failed"})
+			return
+		case errors.Is(err, errOrgQuotaExceeded):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "your organization has used its essays for this month"})
+			return
+		case errors.Is(err, errOrgInactive):
+			c.JSON(http.StatusForbidden, gin.H{"error": "your organization is inactive"})
+			return
+		case errors.Is(err, errInsufficientCredits):
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error":   "not enough credits",
+				"cost":    credits.Cost(MetricAnalyses),
+				"balance": creditBalance(db, user.ID),
+			})
+			return
+		case errors.Is(err, errCreditsNotCharged):
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save essay"})
+			return
+		case errors.Is(err, errEssayNotSaved):
+			// Log error but don't fail the request
+			c.Header("X-Warning", "Essay saved to session only")
+		}
 
 		// Create bands map for response
 		bands := map[string]float32{
@@ -96,39 +129,147 @@ func AnalyzeEssay(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 			"gra": out.GRA,
 		}
 
-		// Save to database
-		createdAt := time.Now()
-		essay := Essay{
-			UserID:    userID, // Will be nil for anonymous users
-			TaskType:  req.TaskType,
-			Text:      req.Text,
-			BandsJSON: ToJSON(out),
-			Overall:   out.Overall,
-			CEFR:      out.CEFR,
-			Feedback:  out.Feedback,
-			PublicID:  publicID,
-			CreatedAt: createdAt,
-		}
-
-		if err := db.Create(&essay).Error; err != nil {
-			// Log error but don't fail the request
-			c.Header("X-Warning", "Essay saved to session only")
-		}
-
 		// Return response
 		response := AnalyzeResponse{
-			PublicID:  publicID,
+			PublicID:  essay.PublicID,
 			Overall:   out.Overall,
 			Bands:     bands,
 			CEFR:      out.CEFR,
 			Feedback:  out.Feedback,
-			CreatedAt: createdAt,
+			CreatedAt: essay.CreatedAt,
+
+			Annotations: out.Annotations,
 		}
 
 		c.JSON(http.StatusOK, response)
 	}
 }
 
+// meterAnalysis counts one analysis against the user's plan. Past its
+// limits the essay is paid with credits, charged by the returned function
+// in the transaction saving it. When neither covers the essay it answers
+// the request and returns false.
+func meterAnalysis(c *gin.Context, db *gorm.DB, credits *Credits, user User, now time.Time) (func(*gorm.DB, Essay) error, bool) {
+	err := consumeQuota(db, user, MetricAnalyses, now)
+	if err == nil {
+		return nil, true
+	}
+	charge := credits.Charge(user.ID, MetricAnalyses)
+	if charge == nil || !errors.Is(err, errQuotaExceeded) || creditBalance(db, user.ID) < credits.Cost(MetricAnalyses) {
+		respondQuotaExceeded(c, user, err)
+		return nil, false
+	}
+	return charge, true
+}
+
+var (
+	errAINotConfigured = errors.New("AI service not configured")
+	errScoringFailed   = errors.New("AI scoring failed")
+	errEssayNotSaved   = errors.New("essay not saved")
+)
+
+// essaySubmission is a validated essay ready to be scored and stored
+type essaySubmission struct {
+	UserID         *uint
+	OrganizationID *uint
+	AssignmentID   *uint
+	TaskType       string
+	QuestionType   string
+	Prompt         string
+	Text           string
+
+	// Charge pays for the essay in the transaction that saves it, nil when
+	// the plan covers it. Without a saved, paid essay no result is returned.
+	Charge func(tx *gorm.DB, essay Essay) error
+}
+
+// scoreSubmission scores an essay (from cache when possible) and saves it
+// with its annotations. On errEssayNotSaved the returned essay and score are
+// still usable, they just were not persisted.
+func scoreSubmission(ctx context.Context, db *gorm.DB, rdb *redis.Client, sub essaySubmission) (Essay, ScoreOut, error) {
+	if err := checkOrgQuota(db, sub.OrganizationID); err != nil {
+		return Essay{}, ScoreOut{}, err
+	}
+
+	// Tenants with their own scoring prompt get their own cache entries
+	guidance := scoringGuidance(db, sub.OrganizationID)
+	cacheTask := sub.TaskType
+	if guidance != "" {
+		cacheTask = fmt.Sprintf("%s:org%d", sub.TaskType, *sub.OrganizationID)
+	}
+
+	// Check cache first
+	cached, err := GetCachedEssayAnalysis(rdb, sub.Text, cacheTask)
+	var out ScoreOut
+
+	if err == nil && cached != nil {
+		// Use cached result
+		out = *cached
+	} else {
+		// Get OpenAI API key
+		apiKey := os.Getenv("AI_KEY")
+		if apiKey == "" {
+			return Essay{}, ScoreOut{}, errAINotConfigured
+		}
+
+		// Create context with timeout
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		// Score essay with AI
+		scoreResult, err := ScoreEssay(ctx, apiKey, sub.TaskType, sub.Prompt, sub.Text, guidance)
+		if err != nil {
+			return Essay{}, ScoreOut{}, errScoringFailed
+		}
+		out = scoreResult
+
+		// Cache the result
+		_ = CacheEssayAnalysis(rdb, sub.Text, cacheTask, out)
+	}
+
+	// Save to database
+	essay := Essay{
+		UserID:       sub.UserID,
+		AssignmentID: sub.AssignmentID,
+		TaskType:     sub.TaskType,
+		QuestionType: sub.QuestionType,
+		Text:         sub.Text,
+		BandsJSON:    ToJSON(out),
+		Overall:      out.Overall,
+		CEFR:         out.CEFR,
+		Feedback:     out.Feedback,
+		PublicID:     uuid.NewString()[:8],
+		CreatedAt:    time.Now(),
+
+		OrganizationID: sub.OrganizationID,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&essay).Error; err != nil {
+			return fmt.Errorf("%w: %w", errEssayNotSaved, err)
+		}
+		if sub.Charge != nil {
+			return sub.Charge(tx, essay)
+		}
+		return nil
+	})
+	switch {
+	case err != nil && sub.Charge != nil && errors.Is(err, errInsufficientCredits):
+		return Essay{}, ScoreOut{}, err
+	case err != nil && sub.Charge != nil:
+		return Essay{}, ScoreOut{}, fmt.Errorf("%w: %w", errCreditsNotCharged, err)
+	case err != nil:
+		return essay, out, err
+	}
+
+	annotations, _ := SaveAnnotations(db, essay, out.Annotations)
+	if sub.UserID != nil {
+		AddVocabularyFromAnnotations(db, *sub.UserID, essay.Text, annotations)
+	}
+
+	return essay, out, nil
+}
+
 type FeedbackRequest struct {
 	Rating    int    `json:"rating" binding:"required,min=1,max=5"`
 	Comment   string `json:"comment"`
@@ -166,13 +307,9 @@ func SubmitFeedback(db *gorm.DB) gin.HandlerFunc {
 			CreatedAt: time.Now(),
 		}
 
-		// Try to save to database
-		if db != nil {
-			if err := db.Create(&feedback).Error; err != nil {
-				// Log error but don't fail the request
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save feedback"})
-				return
-			}
+		if err := db.Create(&feedback).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save feedback"})
+			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
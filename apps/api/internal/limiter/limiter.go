@@ -0,0 +1,116 @@
+// Package limiter implements sliding-window and token-bucket rate limits.
+//
+// With Redis every check is a single Lua script, so concurrent requests
+// from any number of API instances cannot slip past a limit. Without Redis,
+// or when a Redis call fails and the policy is FailLocal, the same
+// algorithms run in process memory.
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Algorithm selects how a Limit counts requests
+type Algorithm int
+
+const (
+	// SlidingWindow allows Limit requests in any span of Period
+	SlidingWindow Algorithm = iota
+	// TokenBucket holds Limit tokens and refills Limit tokens per Period,
+	// one at a time, so bursts are allowed up to the bucket size
+	TokenBucket
+)
+
+// Limit is one rate limit
+type Limit struct {
+	Algorithm Algorithm
+	Limit     int
+	Period    time.Duration
+}
+
+// PerWindow allows n requests in any window
+func PerWindow(n int, window time.Duration) Limit {
+	return Limit{Algorithm: SlidingWindow, Limit: n, Period: window}
+}
+
+// Bucket allows bursts of up to n requests, refilling n per period
+func Bucket(n int, period time.Duration) Limit {
+	return Limit{Algorithm: TokenBucket, Limit: n, Period: period}
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // until a denied request would pass, 0 when allowed
+	Reset      time.Duration // until the limit is fully available again
+}
+
+// Policy decides what happens when Redis fails during a check
+type Policy int
+
+const (
+	// FailLocal falls back to the in-memory limiter of this process
+	FailLocal Policy = iota
+	// FailOpen lets the request through
+	FailOpen
+	// FailClosed denies the request
+	FailClosed
+)
+
+// ParsePolicy reads "local", "open" or "closed"; "" is FailLocal
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "", "local":
+		return FailLocal, nil
+	case "open":
+		return FailOpen, nil
+	case "closed":
+		return FailClosed, nil
+	}
+	return FailLocal, fmt.Errorf("unknown rate limit fail policy %q (want local, open or closed)", s)
+}
+
+// failedRetry is the Retry-After of requests denied by FailClosed
+const failedRetry = 5 * time.Second
+
+// Limiter checks limits in Redis, or in memory when rdb is nil
+type Limiter struct {
+	rdb    *redis.Client
+	local  *memory
+	policy Policy
+}
+
+// New returns a limiter. A nil rdb keeps every limit in memory, which is
+// right for a single instance only.
+func New(rdb *redis.Client, policy Policy) *Limiter {
+	return &Limiter{rdb: rdb, local: newMemory(time.Now), policy: policy}
+}
+
+// Allow counts one request against the key's limit. The error reports a
+// Redis failure; the Result is then what the policy decided.
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Limit <= 0 || limit.Period <= 0 {
+		return Result{Limit: limit.Limit, RetryAfter: limit.Period, Reset: limit.Period}, nil
+	}
+	if l.rdb == nil {
+		return l.local.allow(key, limit), nil
+	}
+
+	res, err := redisAllow(ctx, l.rdb, key, limit)
+	if err == nil {
+		return res, nil
+	}
+	switch l.policy {
+	case FailOpen:
+		return Result{Allowed: true, Limit: limit.Limit, Remaining: limit.Limit}, err
+	case FailClosed:
+		return Result{Limit: limit.Limit, RetryAfter: failedRetry, Reset: failedRetry}, err
+	}
+	return l.local.allow(key, limit), err
+}
@@ -0,0 +1,114 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// clock is a manual time source
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newClock() *clock                   { return &clock{t: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)} }
+
+func TestSlidingWindow(t *testing.T) {
+	clk := newClock()
+	m := newMemory(clk.now)
+	limit := PerWindow(3, time.Minute)
+
+	steps := []struct {
+		name          string
+		advance       time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}{
+		{"First", 0, true, 2, 0},
+		{"Second", 20 * time.Second, true, 1, 0},
+		{"Third", 20 * time.Second, true, 0, 0},
+		{"Over the limit", 10 * time.Second, false, 0, 10 * time.Second},
+		{"Oldest hit left the window", 10 * time.Second, true, 0, 0},
+		{"Full again", time.Second, false, 0, 19 * time.Second},
+	}
+
+	for _, step := range steps {
+		clk.advance(step.advance)
+		res := m.allow("ip", limit)
+		if res.Allowed != step.wantAllowed || res.Remaining != step.wantRemaining || res.RetryAfter != step.wantRetry {
+			t.Errorf("%s: got allowed=%v remaining=%d retry=%v, want %v %d %v",
+				step.name, res.Allowed, res.Remaining, res.RetryAfter, step.wantAllowed, step.wantRemaining, step.wantRetry)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clk := newClock()
+	m := newMemory(clk.now)
+	limit := Bucket(2, time.Minute) // one token every 30s
+
+	steps := []struct {
+		name        string
+		advance     time.Duration
+		wantAllowed bool
+		wantRetry   time.Duration
+	}{
+		{"Burst 1", 0, true, 0},
+		{"Burst 2", 0, true, 0},
+		{"Empty", 0, false, 30 * time.Second},
+		{"Half refilled", 15 * time.Second, false, 15 * time.Second},
+		{"One token back", 15 * time.Second, true, 0},
+		{"Empty again", 0, false, 30 * time.Second},
+	}
+
+	for _, step := range steps {
+		clk.advance(step.advance)
+		res := m.allow("key", limit)
+		if res.Allowed != step.wantAllowed || res.RetryAfter != step.wantRetry {
+			t.Errorf("%s: got allowed=%v retry=%v, want %v %v", step.name, res.Allowed, res.RetryAfter, step.wantAllowed, step.wantRetry)
+		}
+	}
+
+	// Keys back at their initial state are swept
+	clk.advance(time.Hour)
+	m.sweep(clk.now())
+	if len(m.buckets) != 0 {
+		t.Errorf("sweep left %d buckets", len(m.buckets))
+	}
+}
+
+func TestFailPolicy(t *testing.T) {
+	// Nothing listens on port 1, so every Redis call fails
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	defer rdb.Close()
+	limit := PerWindow(1, time.Minute)
+
+	tests := []struct {
+		policy      Policy
+		wantAllowed []bool // two requests in a row
+	}{
+		{FailLocal, []bool{true, false}},
+		{FailOpen, []bool{true, true}},
+		{FailClosed, []bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		l := New(rdb, tt.policy)
+		for i, want := range tt.wantAllowed {
+			res, err := l.Allow(context.Background(), "ip", limit)
+			if err == nil {
+				t.Fatalf("policy %d: expected the Redis error to be reported", tt.policy)
+			}
+			if res.Allowed != want {
+				t.Errorf("policy %d request %d: allowed=%v, want %v", tt.policy, i+1, res.Allowed, want)
+			}
+		}
+	}
+
+	if _, err := ParsePolicy("sometimes"); err == nil {
+		t.Error("ParsePolicy accepted an unknown policy")
+	}
+}
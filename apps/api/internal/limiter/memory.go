@@ -0,0 +1,117 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many checks pass between removals of expired keys
+const sweepEvery = 1000
+
+// memory runs the algorithms in process memory
+type memory struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	windows map[string]*window
+	buckets map[string]*bucket
+	checks  int
+}
+
+type window struct {
+	hits    []time.Time // allowed requests, oldest first
+	expires time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	taken   time.Time
+	expires time.Time
+}
+
+func newMemory(now func() time.Time) *memory {
+	return &memory{now: now, windows: map[string]*window{}, buckets: map[string]*bucket{}}
+}
+
+func (m *memory) allow(key string, limit Limit) Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if m.checks++; m.checks%sweepEvery == 0 {
+		m.sweep(now)
+	}
+	if limit.Algorithm == TokenBucket {
+		return m.takeToken(key, limit, now)
+	}
+	return m.slide(key, limit, now)
+}
+
+func (m *memory) slide(key string, limit Limit, now time.Time) Result {
+	w := m.windows[key]
+	if w == nil {
+		w = &window{}
+		m.windows[key] = w
+	}
+
+	// Drop the hits that left the window
+	cutoff := now.Add(-limit.Period)
+	i := 0
+	for i < len(w.hits) && !w.hits[i].After(cutoff) {
+		i++
+	}
+	w.hits = w.hits[i:]
+
+	res := Result{Limit: limit.Limit}
+	if len(w.hits) < limit.Limit {
+		w.hits = append(w.hits, now)
+		w.expires = now.Add(limit.Period)
+		res.Allowed = true
+	} else {
+		res.RetryAfter = w.hits[0].Add(limit.Period).Sub(now)
+	}
+	res.Remaining = limit.Limit - len(w.hits)
+	res.Reset = w.hits[len(w.hits)-1].Add(limit.Period).Sub(now)
+	return res
+}
+
+func (m *memory) takeToken(key string, limit Limit, now time.Time) Result {
+	interval := float64(limit.Period) / float64(limit.Limit)
+	capacity := float64(limit.Limit)
+
+	b := m.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: capacity, taken: now}
+		m.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.taken); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/interval)
+	}
+	b.taken = now
+
+	res := Result{Limit: limit.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) * interval))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration(math.Ceil((capacity - b.tokens) * interval))
+	b.expires = now.Add(res.Reset)
+	return res
+}
+
+// sweep forgets keys that are back to their initial state
+func (m *memory) sweep(now time.Time) {
+	for key, w := range m.windows {
+		if !now.Before(w.expires) {
+			delete(m.windows, key)
+		}
+	}
+	for key, b := range m.buckets {
+		if !now.Before(b.expires) {
+			delete(m.buckets, key)
+		}
+	}
+}
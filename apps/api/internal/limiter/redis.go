@@ -0,0 +1,118 @@
+package limiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Both scripts read the clock with TIME so every API instance agrees on it,
+// and return {allowed, remaining, retry ms, reset ms}.
+
+// slidingWindowScript keeps one sorted-set member per allowed request,
+// scored by its time. Members older than the window are dropped first; the
+// key expires a window after the newest member.
+var slidingWindowScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local free = tonumber(oldest[2]) + window - now
+local retry = 0
+if allowed == 0 then
+	retry = free
+end
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+return {allowed, limit - count, retry, tonumber(newest[2]) + window - now}
+`)
+
+// tokenBucketScript stores the token count and the time it was taken. The
+// bucket refills one token per interval up to capacity.
+var tokenBucketScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) / interval)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) * interval)
+end
+
+local reset = math.ceil((capacity - tokens) * interval)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+// redisAllow runs the script of the limit's algorithm
+func redisAllow(ctx context.Context, rdb *redis.Client, key string, limit Limit) (Result, error) {
+	var (
+		reply []int64
+		err   error
+	)
+	switch limit.Algorithm {
+	case TokenBucket:
+		interval := float64(limit.Period.Milliseconds()) / float64(limit.Limit)
+		reply, err = tokenBucketScript.Run(ctx, rdb, []string{"limiter:tb:" + key}, limit.Limit, interval).Int64Slice()
+	default:
+		member, merr := windowMember()
+		if merr != nil {
+			return Result{}, merr
+		}
+		reply, err = slidingWindowScript.Run(ctx, rdb, []string{"limiter:sw:" + key}, limit.Limit, limit.Period.Milliseconds(), member).Int64Slice()
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if len(reply) != 4 {
+		return Result{}, fmt.Errorf("limiter: unexpected script reply %v", reply)
+	}
+
+	return Result{
+		Allowed:    reply[0] == 1,
+		Limit:      limit.Limit,
+		Remaining:  int(reply[1]),
+		RetryAfter: time.Duration(reply[2]) * time.Millisecond,
+		Reset:      time.Duration(reply[3]) * time.Millisecond,
+	}, nil
+}
+
+// windowMember returns a unique sorted-set member, so requests in the same
+// millisecond are all counted
+func windowMember() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
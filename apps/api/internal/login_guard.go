@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// loginLimits are the failed-attempt thresholds of one counter. The first
+// Free failures cost nothing, each further one doubles the wait up to
+// MaxBackoff, and Lockout failures lock the key for LockFor.
+type loginLimits struct {
+	Free       int64
+	MaxBackoff time.Duration
+	Lockout    int64
+	LockFor    time.Duration
+}
+
+var (
+	accountLoginLimits = loginLimits{Free: 3, MaxBackoff: 5 * time.Minute, Lockout: 10, LockFor: 15 * time.Minute}
+	ipLoginLimits      = loginLimits{Free: 10, MaxBackoff: 5 * time.Minute, Lockout: 50, LockFor: time.Hour}
+)
+
+// loginFailureWindow is how long failed attempts are remembered
+const loginFailureWindow = time.Hour
+
+// LoginGuard tracks failed logins per account and per IP in Redis. Without
+// Redis it lets every attempt through.
+type LoginGuard struct {
+	rdb          *redis.Client
+	captchaAfter int64 // account failures before captchaRequired is set, 0 to never set it
+}
+
+// LoginStatus is the state of the counters for one attempt
+type LoginStatus struct {
+	RetryAfter      time.Duration // > 0 while blocked
+	Locked          bool          // blocked by a lockout rather than a backoff
+	CaptchaRequired bool
+}
+
+// NewLoginGuard builds the guard. LOGIN_CAPTCHA_AFTER sets how many failed
+// attempts on an account make the response ask for a CAPTCHA (default 3,
+// 0 disables the flag).
+func NewLoginGuard(rdb *redis.Client) *LoginGuard {
+	captchaAfter := int64(3)
+	if v, err := strconv.ParseInt(os.Getenv("LOGIN_CAPTCHA_AFTER"), 10, 64); err == nil && v >= 0 {
+		captchaAfter = v
+	}
+	return &LoginGuard{rdb: rdb, captchaAfter: captchaAfter}
+}
+
+// loginAccountKey normalises the login identifier so case variants share
+// a counter
+func loginAccountKey(identifier string) string {
+	return "login:acct:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+func loginIPKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+// loginBackoff returns how long to block after the given number of failures
+func loginBackoff(failures int64, limits loginLimits) (time.Duration, bool) {
+	switch {
+	case failures >= limits.Lockout:
+		return limits.LockFor, true
+	case failures <= limits.Free:
+		return 0, false
+	}
+	wait := time.Duration(math.Pow(2, float64(failures-limits.Free-1))) * time.Second
+	if wait > limits.MaxBackoff {
+		wait = limits.MaxBackoff
+	}
+	return wait, false
+}
+
+// Check reports whether the account or IP is currently blocked
+func (g *LoginGuard) Check(ctx context.Context, identifier, ip string) LoginStatus {
+	var status LoginStatus
+	if g.rdb == nil {
+		return status
+	}
+
+	for _, key := range []string{loginAccountKey(identifier), loginIPKey(ip)} {
+		ttl, err := g.rdb.PTTL(ctx, key+":block").Result()
+		if err == nil && ttl > status.RetryAfter {
+			status.RetryAfter = ttl
+			status.Locked, _ = g.rdb.Get(ctx, key+":block").Bool()
+		}
+	}
+	if g.captchaAfter > 0 {
+		failures, _ := g.rdb.Get(ctx, loginAccountKey(identifier)).Int64()
+		status.CaptchaRequired = failures >= g.captchaAfter
+	}
+	return status
+}
+
+// Fail counts a failed attempt and starts a backoff or lockout when a
+// threshold is crossed
+func (g *LoginGuard) Fail(ctx context.Context, identifier, ip string) LoginStatus {
+	var status LoginStatus
+	if g.rdb == nil {
+		return status
+	}
+
+	for _, counter := range []struct {
+		key    string
+		limits loginLimits
+	}{
+		{loginAccountKey(identifier), accountLoginLimits},
+		{loginIPKey(ip), ipLoginLimits},
+	} {
+		failures, err := g.rdb.Incr(ctx, counter.key).Result()
+		if err != nil {
+			continue
+		}
+		if failures == 1 {
+			g.rdb.Expire(ctx, counter.key, loginFailureWindow)
+		}
+		if counter.key == loginAccountKey(identifier) && g.captchaAfter > 0 {
+			status.CaptchaRequired = failures >= g.captchaAfter
+		}
+
+		wait, locked := loginBackoff(failures, counter.limits)
+		if wait > 0 {
+			g.rdb.Set(ctx, counter.key+":block", locked, wait)
+		}
+		if wait > status.RetryAfter {
+			status.RetryAfter, status.Locked = wait, locked
+		}
+	}
+	return status
+}
+
+// Succeed clears an account's failures. The IP counter keeps running, so a
+// valid login does not excuse guesses at other accounts.
+func (g *LoginGuard) Succeed(ctx context.Context, identifier string) {
+	if g.rdb == nil {
+		return
+	}
+	key := loginAccountKey(identifier)
+	g.rdb.Del(ctx, key, key+":block")
+}
+
+// Unlock clears an account's failures and any lockout
+func (g *LoginGuard) Unlock(ctx context.Context, identifier string) error {
+	if g.rdb == nil {
+		return nil
+	}
+	key := loginAccountKey(identifier)
+	return g.rdb.Del(ctx, key, key+":block").Err()
+}
+
+// respondLoginBlocked answers an attempt made while blocked
+func respondLoginBlocked(c *gin.Context, status LoginStatus) {
+	seconds := int(math.Ceil(status.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	msg := "too many failed attempts, try again later"
+	if status.Locked {
+		msg = "account temporarily locked after too many failed attempts"
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":           msg,
+		"retryAfter":      seconds,
+		"locked":          status.Locked,
+		"captchaRequired": status.CaptchaRequired,
+	})
+}
+
+// UnlockUser clears a user's failed logins and lockout
+func UnlockUser(db *gorm.DB, guard *LoginGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user User
+		if err := db.Scopes(TenantScope(currentOrgID(c))).First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		if err := guard.Unlock(c.Request.Context(), user.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock user"})
+			return
+		}
+
+		audit(c, AuditLog{Action: "user.unlock", TargetType: "user", TargetID: fmt.Sprint(user.ID)})
+
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("%s unlocked", user.Email)})
+	}
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures   int64
+		wantWait   time.Duration
+		wantLocked bool
+	}{
+		{1, 0, false},
+		{3, 0, false},
+		{4, time.Second, false},
+		{5, 2 * time.Second, false},
+		{6, 4 * time.Second, false},
+		{9, 32 * time.Second, false},
+		{10, 15 * time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d failures", tt.failures), func(t *testing.T) {
+			wait, locked := loginBackoff(tt.failures, accountLoginLimits)
+			if wait != tt.wantWait || locked != tt.wantLocked {
+				t.Errorf("loginBackoff(%d) = %v, %v, want %v, %v", tt.failures, wait, locked, tt.wantWait, tt.wantLocked)
+			}
+		})
+	}
+
+	// The IP backoff is capped below its lockout
+	if wait, _ := loginBackoff(40, ipLoginLimits); wait != ipLoginLimits.MaxBackoff {
+		t.Errorf("ip backoff at 40 = %v, want cap %v", wait, ipLoginLimits.MaxBackoff)
+	}
+}
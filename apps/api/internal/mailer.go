@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Email is a message with a plain text and an HTML body
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers emails
+type Mailer interface {
+	Send(ctx context.Context, msg Email) error
+}
+
+// NewMailer picks a mailer from the environment:
+//
+//	SMTP_HOST, SMTP_PORT (587), SMTP_USERNAME, SMTP_PASSWORD  send over SMTP
+//	MAIL_FROM  sender address, defaults to "BandLy <no-reply@bandly.app>"
+//	MAIL_DIR   without SMTP, write emails to files here instead of the log
+func NewMailer() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "BandLy <no-reply@bandly.app>"
+	}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		var auth smtp.Auth
+		if user := os.Getenv("SMTP_USERNAME"); user != "" {
+			auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		return &SMTPMailer{Addr: host + ":" + port, From: from, Auth: auth}
+	}
+
+	log.Println("Warning: SMTP_HOST not set, emails are not delivered")
+	return &FileMailer{Dir: os.Getenv("MAIL_DIR"), From: from}
+}
+
+// SMTPMailer sends emails through an SMTP server, using STARTTLS when offered
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// Send delivers a message over SMTP
+func (m *SMTPMailer) Send(ctx context.Context, msg Email) error {
+	data, err := buildMIME(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, m.Auth, envelopeAddress(m.From), []string{msg.To}, data)
+}
+
+// FileMailer is the development sink. It writes each email as an .eml file
+// to Dir, or to the log when Dir is empty.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send stores or logs a message
+func (m *FileMailer) Send(ctx context.Context, msg Email) error {
+	if m.Dir == "" {
+		log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+		return nil
+	}
+
+	data, err := buildMIME(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), uuid.NewString()[:8])
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o644)
+}
+
+// MemoryMailer keeps sent emails in memory, for tests
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Email
+}
+
+// Send records a message
+func (m *MemoryMailer) Send(ctx context.Context, msg Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns the messages sent so far
+func (m *MemoryMailer) Sent() []Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Email(nil), m.sent...)
+}
+
+// envelopeAddress returns the bare address of "Name <addr>"
+func envelopeAddress(from string) string {
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		return strings.TrimSuffix(from[i+1:], ">")
+	}
+	return from
+}
+
+// buildMIME renders a multipart/alternative message with both bodies
+func buildMIME(from string, msg Email, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "From: %s\r\n", from)
+	fmt.Fprintf(&out, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&out, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&out, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&out, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&out, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
+
+// emailTemplate holds the subject and bodies of one kind of email
+type emailTemplate struct {
+	subject string
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+// emailLayout wraps every HTML body
+const emailLayout = `<!DOCTYPE html>
+<html><body style="font-family:Arial,sans-serif;color:#1f2937;max-width:560px;margin:0 auto;padding:24px">
+<h2 style="color:#3a7afe">BandLy</h2>
+{{template "body" .}}
+<p style="color:#6b7280;font-size:12px;margin-top:32px">Generated by BandLy - SidigiGroup | info@sidiginesia.com</p>
+</body></html>`
+
+// newEmailTemplate parses the text and HTML bodies of an email
+func newEmailTemplate(subject, text, html string) emailTemplate {
+	layout := htmltemplate.Must(htmltemplate.New("layout").Parse(emailLayout))
+	return emailTemplate{
+		subject: subject,
+		text:    template.Must(template.New("text").Parse(text)),
+		html:    htmltemplate.Must(layout.New("body").Parse(html)),
+	}
+}
+
+// emailTemplates are the emails we send, by name. Each receives an emailData.
+var emailTemplates = map[string]emailTemplate{
+	"verify_email": newEmailTemplate(
+		"Confirm your email address",
+		`Welcome to BandLy!
+
+Please confirm your email address by opening this link:
+{{.Link}}
+
+The link expires in {{.Expires}}. If you did not create an account, you can ignore this email.
+`,
+		`<p>Welcome to BandLy!</p>
+<p>Please confirm your email address to unlock your full daily essay allowance.</p>
+<p><a href="{{.Link}}" style="background:#3a7afe;color:#fff;padding:10px 18px;border-radius:6px;text-decoration:none">Confirm email</a></p>
+<p>The link expires in {{.Expires}}. If you did not create an account, you can ignore this email.</p>`,
+	),
+	"reset_password": newEmailTemplate(
+		"Reset your password",
+		`Someone asked to reset the password of your BandLy account.
+
+Open this link to choose a new password:
+{{.Link}}
+
+The link expires in {{.Expires}} and works once. If it wasn't you, you can ignore this email.
+`,
+		`<p>Someone asked to reset the password of your BandLy account.</p>
+<p><a href="{{.Link}}" style="background:#3a7afe;color:#fff;padding:10px 18px;border-radius:6px;text-decoration:none">Choose a new password</a></p>
+<p>The link expires in {{.Expires}} and works once. If it wasn't you, you can ignore this email.</p>`,
+	),
+}
+
+// emailData is what email templates can use
+type emailData struct {
+	Link    string
+	Expires string
+}
+
+// renderEmail builds an email from a named template
+func renderEmail(name, to string, data emailData) (Email, error) {
+	tmpl, ok := emailTemplates[name]
+	if !ok {
+		return Email{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var text, html bytes.Buffer
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return Email{}, err
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return Email{}, err
+	}
+
+	return Email{To: to, Subject: tmpl.subject, Text: text.String(), HTML: html.String()}, nil
+}
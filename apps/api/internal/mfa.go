@@ -0,0 +1,352 @@
+package internal
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UserMFA holds a user's TOTP secret. EnabledAt stays nil until the user
+// proves the authenticator works, so an abandoned setup has no effect.
+type UserMFA struct {
+	UserID      uint `gorm:"primaryKey"`
+	Secret      string
+	EnabledAt   *time.Time
+	LastStep    int64 // newest TOTP step used, to refuse replays
+	Failures    int   // wrong codes since the last success
+	LockedUntil *time.Time
+	UpdatedAt   time.Time
+}
+
+// MFARecoveryCode is a single-use code for when the authenticator is lost
+type MFARecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	CodeHash  string `gorm:"size:64"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+const (
+	mfaChallengePurpose = "mfa-challenge"
+	mfaChallengeTTL     = 5 * time.Minute
+
+	recoveryCodeCount = 10
+	mfaMaxFailures    = 5
+	mfaLockout        = 15 * time.Minute
+)
+
+var (
+	errMFAInvalid = errors.New("invalid authentication code")
+	errMFALocked  = errors.New("too many wrong codes")
+)
+
+// mfaEnabled returns the user's TOTP settings and whether they are active
+func mfaEnabled(db *gorm.DB, userID uint) (UserMFA, bool) {
+	var mfa UserMFA
+	if err := db.First(&mfa, "user_id = ?", userID).Error; err != nil {
+		return mfa, false
+	}
+	return mfa, mfa.EnabledAt != nil
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the new
+// ones. Only their hashes are stored.
+func newRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789" // no look-alike characters
+
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]MFARecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		rows[i] = MFARecoveryCode{UserID: userID, CodeHash: hashToken(codes[i])}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rows).Error
+	})
+	return codes, err
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code. After mfaMaxFailures wrong codes the user is locked out for a while.
+func checkSecondFactor(db *gorm.DB, userID uint, code, recoveryCode string) error {
+	mfa, enabled := mfaEnabled(db, userID)
+	if !enabled {
+		return errMFAInvalid
+	}
+	now := time.Now()
+	if mfa.LockedUntil != nil && now.Before(*mfa.LockedUntil) {
+		return errMFALocked
+	}
+
+	ok := false
+	switch {
+	case code != "":
+		if step, valid := verifyTOTP(mfa.Secret, code, now, mfa.LastStep); valid {
+			// Of two concurrent logins with the same code only one wins
+			result := db.Model(&UserMFA{}).Where("user_id = ? AND last_step < ?", userID, step).Update("last_step", step)
+			ok = result.Error == nil && result.RowsAffected == 1
+		}
+	case recoveryCode != "":
+		hash := hashToken(strings.ToLower(strings.TrimSpace(recoveryCode)))
+		result := db.Model(&MFARecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+			Update("used_at", now)
+		ok = result.Error == nil && result.RowsAffected == 1
+	}
+
+	if ok {
+		db.Model(&UserMFA{}).Where("user_id = ?", userID).Updates(map[string]interface{}{"failures": 0, "locked_until": nil})
+		return nil
+	}
+
+	updates := map[string]interface{}{"failures": gorm.Expr("failures + 1")}
+	if mfa.Failures+1 >= mfaMaxFailures {
+		updates = map[string]interface{}{"failures": 0, "locked_until": now.Add(mfaLockout)}
+	}
+	db.Model(&UserMFA{}).Where("user_id = ?", userID).Updates(updates)
+	return errMFAInvalid
+}
+
+// mfaChallenge returns a short-lived token standing in for a password check
+// when the user has TOTP enabled, or "" when no second step is needed. The
+// token is bound to the password hash, so a password reset voids it.
+func mfaChallenge(db *gorm.DB, auth *AuthService, user User) (string, error) {
+	if _, enabled := mfaEnabled(db, user.ID); !enabled {
+		return "", nil
+	}
+	return auth.IssueAction(user.ID, mfaChallengePurpose, tokenBinding(user.PassHash), mfaChallengeTTL, time.Now())
+}
+
+// respondMFAError maps second-factor errors to responses
+func respondMFAError(c *gin.Context, err error) {
+	if errors.Is(err, errMFALocked) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many wrong codes, try again later"})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid authentication code"})
+}
+
+// VerifyMFA finishes a two-step login: it trades the challenge token from
+// Login and a TOTP or recovery code for a session
+func VerifyMFA(db *gorm.DB, auth *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			MFAToken     string `json:"mfaToken" binding:"required"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recoveryCode"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mfaToken and a code or recoveryCode are required"})
+			return
+		}
+
+		userID, binding, err := auth.VerifyAction(req.MFAToken, mfaChallengePurpose)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, please sign in again"})
+			return
+		}
+		var user User
+		if err := db.First(&user, userID).Error; err != nil || tokenBinding(user.PassHash) != binding {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, please sign in again"})
+			return
+		}
+
+		if err := checkSecondFactor(db, user.ID, req.Code, req.RecoveryCode); err != nil {
+			auditEvent(c, "auth.mfa_failed", &user, nil)
+			respondMFAError(c, err)
+			return
+		}
+
+		resp, err := startSession(db, auth, c, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+		resp.User = userInfoOf(user)
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// GetMFAStatus reports the current user's 2FA state
+func GetMFAStatus(db *gorm.DB, auth *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+
+		_, enabled := mfaEnabled(db, user.ID)
+		var left int64
+		if enabled {
+			db.Model(&MFARecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&left)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"enabled":           enabled,
+			"required":          auth.mfaRequired(user.Role),
+			"recoveryCodesLeft": left,
+		})
+	}
+}
+
+// SetupTOTP starts enrolment with a fresh secret. The otpauth URI is meant
+// to be shown as a QR code.
+func SetupTOTP(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+
+		if _, enabled := mfaEnabled(db, user.ID); enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := newTOTPSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create secret"})
+			return
+		}
+		if err := db.Save(&UserMFA{UserID: user.ID, Secret: secret}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start enrolment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauthUri": totpURI(secret, user.Email)})
+	}
+}
+
+// EnableTOTP confirms enrolment with a code from the authenticator, returns
+// the recovery codes (shown once) and signs out the user's other sessions
+func EnableTOTP(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+			return
+		}
+
+		mfa, enabled := mfaEnabled(db, user.ID)
+		if enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+		if mfa.Secret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start the setup first"})
+			return
+		}
+		step, ok := verifyTOTP(mfa.Secret, req.Code, time.Now(), mfa.LastStep)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid authentication code"})
+			return
+		}
+
+		var codes []string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&UserMFA{}).Where("user_id = ?", user.ID).
+				Updates(map[string]interface{}{"enabled_at": time.Now(), "last_step": step}).Error
+			if err != nil {
+				return err
+			}
+			if codes, err = newRecoveryCodes(tx, user.ID); err != nil {
+				return err
+			}
+			// Sessions opened with just a password no longer count
+			return tx.Model(&Session{}).
+				Where("user_id = ? AND id <> ? AND revoked_at IS NULL", user.ID, c.GetString("sessionID")).
+				Update("revoked_at", time.Now()).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+			return
+		}
+		auditEvent(c, "auth.mfa_enabled", &user, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication enabled", "recoveryCodes": codes})
+	}
+}
+
+// DisableTOTP turns 2FA off after a final code check, unless the user's
+// role requires it
+func DisableTOTP(db *gorm.DB, auth *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+
+		var req struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recoveryCode"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code or recoveryCode is required"})
+			return
+		}
+		if auth.mfaRequired(user.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is required for your role"})
+			return
+		}
+
+		if err := checkSecondFactor(db, user.ID, req.Code, req.RecoveryCode); err != nil {
+			respondMFAError(c, err)
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("user_id = ?", user.ID).Delete(&MFARecoveryCode{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("user_id = ?", user.ID).Delete(&UserMFA{}).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+			return
+		}
+		auditEvent(c, "auth.mfa_disabled", &user, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after a TOTP check
+func RegenerateRecoveryCodes(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+			return
+		}
+		if err := checkSecondFactor(db, user.ID, req.Code, ""); err != nil {
+			respondMFAError(c, err)
+			return
+		}
+
+		codes, err := newRecoveryCodes(db, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create recovery codes"})
+			return
+		}
+		auditEvent(c, "auth.recovery_codes_regenerated", &user, nil)
+
+		c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+	}
+}
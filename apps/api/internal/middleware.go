@@ -2,210 +2,118 @@ package internal
 
 import (
 	"fmt"
+	"log"
+	"math"
 	"os"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
-	"gorm.io/gorm"
+	"github.com/sidigigroup/bandly/api/internal/limiter"
 )
 
-// JWT middleware to protect routes
-func JWTAuth(db *gorm.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(401, gin.H{"error": "Authorization header required"})
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.AbortWithStatusJSON(401, gin.H{"error": "Bearer token required"})
-			return
-		}
-
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid token"})
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid token claims"})
-			return
-		}
+// Limits of essay analysis by client IP
+var (
+	anonymousLimit = limiter.PerWindow(3, 24*time.Hour)   // free analyses without an account
+	ipAbuseLimit   = limiter.PerWindow(500, 24*time.Hour) // any requests from one IP
+)
 
-		userID, ok := claims["sub"].(float64)
-		if !ok {
-			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid user ID in token"})
-			return
-		}
+// NewRateLimiter builds the shared limiter, in memory when rdb is nil.
+// RATE_LIMIT_FAIL_POLICY decides what a check does when Redis fails:
+// local (default) counts in this process, open allows, closed denies.
+func NewRateLimiter(rdb *redis.Client) (*limiter.Limiter, error) {
+	policy, err := limiter.ParsePolicy(os.Getenv("RATE_LIMIT_FAIL_POLICY"))
+	if err != nil {
+		return nil, err
+	}
+	return limiter.New(rdb, policy), nil
+}
 
-		// Verify user exists
-		var user User
-		if err := db.First(&user, uint(userID)).Error; err != nil {
-			c.AbortWithStatusJSON(401, gin.H{"error": "User not found"})
-			return
-		}
+// allowRequest checks a limit and sets the rate limit headers. When Redis
+// failed and the fail-closed policy denied the request it answers 503 and
+// returns ok=false; a plain denial is left to the caller.
+func allowRequest(c *gin.Context, l *limiter.Limiter, key string, limit limiter.Limit) (limiter.Result, bool) {
+	res, err := l.Allow(c.Request.Context(), key, limit)
+	if err != nil {
+		log.Printf("Rate limiter: %v", err)
+	}
 
-		c.Set("userID", uint(userID))
-		c.Set("user", user)
-		c.Next()
+	now := time.Now()
+	c.Header("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(now.Add(res.Reset).Unix(), 10))
+	if !res.Allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+	}
+	if err != nil && !res.Allowed {
+		c.AbortWithStatusJSON(503, gin.H{"error": "Rate limiter unavailable, please retry shortly"})
+		return res, false
 	}
+	return res, true
 }
 
-// Enhanced rate limiting middleware with IP-based tracking to prevent account creation exploits
-func RateLimit(rdb *redis.Client) gin.HandlerFunc {
+// RateLimit guards essay analysis by client IP. Anonymous requests get a
+// few free analyses per IP; signed-in users are metered by their plan in
+// the handler instead, so a shared school or office IP does not lock them
+// out. Every request still counts towards a per-IP abuse ceiling.
+func RateLimit(l *limiter.Limiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx := c.Request.Context()
-		clientIP := c.ClientIP()
-
-		// Always track IP-based usage to prevent exploit
-		ipKey := "rate_limit:ip:" + clientIP
-		ipUsage, err := rdb.Get(ctx, ipKey).Int()
-		if err != nil && err != redis.Nil {
-			c.AbortWithStatusJSON(500, gin.H{"error": "Rate limit check failed"})
+		// API keys have their own per-key limit
+		if _, ok := c.Get("apiKey"); ok {
+			c.Next()
 			return
 		}
 
-		// Check if user is authenticated
-		var isAuthenticated bool
-		if _, exists := c.Get("userID"); exists {
-			isAuthenticated = true
-		}
-
-		// Define limits
-		const (
-			ANONYMOUS_LIMIT     = 3  // Anonymous users: 3 requests per day
-			AUTHENTICATED_LIMIT = 10 // Authenticated users: 10 requests per day (total from same IP)
-			DAILY_EXPIRY        = 24 * time.Hour
-		)
-
-		var userType string
+		clientIP := c.ClientIP()
+		_, isAuthenticated := c.Get("userID")
+		userType := "anonymous"
 		if isAuthenticated {
 			userType = "authenticated"
-		} else {
-			userType = "anonymous"
 		}
+		c.Header("X-RateLimit-UserType", userType)
 
-		// Check if IP has exceeded the maximum daily limit (10) regardless of authentication status
-		if ipUsage >= AUTHENTICATED_LIMIT {
+		res, ok := allowRequest(c, l, "essays:ip:"+clientIP, ipAbuseLimit)
+		if !ok {
+			return
+		}
+		if !res.Allowed {
 			c.AbortWithStatusJSON(429, gin.H{
 				"error":     "Daily rate limit exceeded for this IP address",
-				"limit":     AUTHENTICATED_LIMIT,
-				"used":      ipUsage,
+				"limit":     res.Limit,
+				"used":      res.Limit - res.Remaining,
 				"remaining": 0,
-				"resetTime": time.Now().Add(DAILY_EXPIRY).Unix(),
-				"message":   "Maximum daily requests reached from this location. Please try again tomorrow.",
+				"resetTime": time.Now().Add(res.RetryAfter).Unix(),
+				"message":   "Too many requests from this location. Please try again later.",
 				"userType":  userType,
 			})
 			return
 		}
 
-		// For anonymous users, check if they've exceeded their 3-request limit
-		if !isAuthenticated && ipUsage >= ANONYMOUS_LIMIT {
-			remaining := AUTHENTICATED_LIMIT - ipUsage
-			c.AbortWithStatusJSON(429, gin.H{
-				"error":        "Anonymous rate limit exceeded",
-				"limit":        ANONYMOUS_LIMIT,
-				"used":         ipUsage,
-				"remaining":    remaining,
-				"resetTime":    time.Now().Add(DAILY_EXPIRY).Unix(),
-				"message":      fmt.Sprintf("You've used your %d free analyses. Create an account to get %d more today!", ANONYMOUS_LIMIT, remaining),
-				"userType":     userType,
-				"suggestLogin": true,
-			})
-			return
-		}
-
-		// Increment IP counter
-		pipe := rdb.Pipeline()
-		pipe.Incr(ctx, ipKey)
-		pipe.Expire(ctx, ipKey, DAILY_EXPIRY)
-		_, err = pipe.Exec(ctx)
-		if err != nil {
-			c.AbortWithStatusJSON(500, gin.H{"error": "Rate limit update failed"})
-			return
-		}
-
-		// Calculate remaining requests
-		var currentLimit int
+		// Signed-in users see their plan usage at GET /api/user/usage
 		if isAuthenticated {
-			currentLimit = AUTHENTICATED_LIMIT
-		} else {
-			currentLimit = ANONYMOUS_LIMIT
-		}
-
-		remaining := currentLimit - (ipUsage + 1)
-		if remaining < 0 {
-			remaining = 0
-		}
-
-		c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", currentLimit))
-		c.Header("X-RateLimit-Used", fmt.Sprintf("%d", ipUsage+1))
-		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(DAILY_EXPIRY).Unix()))
-		c.Header("X-RateLimit-UserType", userType)
-
-		c.Next()
-	}
-} // Optional auth middleware (doesn't block if no token)
-func OptionalAuth(db *gorm.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
 			c.Next()
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.Next()
-			return
-		}
-
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.Next()
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
+		res, ok = allowRequest(c, l, "essays:anon:"+clientIP, anonymousLimit)
 		if !ok {
-			c.Next()
 			return
 		}
-
-		userID, ok := claims["sub"].(float64)
-		if !ok {
-			c.Next()
-			return
-		}
-
-		var user User
-		if err := db.First(&user, uint(userID)).Error; err != nil {
-			c.Next()
+		if !res.Allowed {
+			c.AbortWithStatusJSON(429, gin.H{
+				"error":        "Anonymous rate limit exceeded",
+				"limit":        res.Limit,
+				"used":         res.Limit - res.Remaining,
+				"remaining":    0,
+				"resetTime":    time.Now().Add(res.RetryAfter).Unix(),
+				"message":      fmt.Sprintf("You've used your %d free analyses. Create an account to keep going!", res.Limit),
+				"userType":     userType,
+				"suggestLogin": true,
+			})
 			return
 		}
 
-		c.Set("userID", uint(userID))
-		c.Set("user", user)
 		c.Next()
 	}
 }
@@ -217,7 +125,7 @@ func CORS() gin.HandlerFunc {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
-		c.Header("Access-Control-Expose-Headers", "X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset")
+		c.Header("Access-Control-Expose-Headers", "X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After")
 
 		// Security headers to prevent phishing warnings
 		c.Header("X-Content-Type-Options", "nosniff")
@@ -0,0 +1,35 @@
+-- Generated by `bandly migrate baseline` from the models
+DROP TABLE IF EXISTS "voucher_redemptions";
+DROP TABLE IF EXISTS "vouchers";
+DROP TABLE IF EXISTS "voucher_batches";
+DROP TABLE IF EXISTS "credit_entries";
+DROP TABLE IF EXISTS "credit_transactions";
+DROP TABLE IF EXISTS "credit_accounts";
+DROP TABLE IF EXISTS "billing_webhooks";
+DROP TABLE IF EXISTS "subscriptions";
+DROP TABLE IF EXISTS "usage_counters";
+DROP TABLE IF EXISTS "plans";
+DROP TABLE IF EXISTS "api_keys";
+DROP TABLE IF EXISTS "audit_logs";
+DROP TABLE IF EXISTS "peer_reviews";
+DROP TABLE IF EXISTS "notifications";
+DROP TABLE IF EXISTS "band_overrides";
+DROP TABLE IF EXISTS "essay_comments";
+DROP TABLE IF EXISTS "assignments";
+DROP TABLE IF EXISTS "class_members";
+DROP TABLE IF EXISTS "classrooms";
+DROP TABLE IF EXISTS "questions";
+DROP TABLE IF EXISTS "vocabulary_cards";
+DROP TABLE IF EXISTS "study_plans";
+DROP TABLE IF EXISTS "annotations";
+DROP TABLE IF EXISTS "admin_prompts";
+DROP TABLE IF EXISTS "blog_posts";
+DROP TABLE IF EXISTS "user_feedbacks";
+DROP TABLE IF EXISTS "analytics_events";
+DROP TABLE IF EXISTS "essays";
+DROP TABLE IF EXISTS "mfa_recovery_codes";
+DROP TABLE IF EXISTS "user_mfas";
+DROP TABLE IF EXISTS "user_identities";
+DROP TABLE IF EXISTS "sessions";
+DROP TABLE IF EXISTS "users";
+DROP TABLE IF EXISTS "organizations";
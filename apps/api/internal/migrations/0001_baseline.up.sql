@@ -0,0 +1,145 @@
+-- Generated by `bandly migrate baseline` from the models
+
+CREATE TABLE "organizations" ("id" bigserial,"name" text NOT NULL,"slug" text NOT NULL,"plan" text DEFAULT 'school',"max_users" bigint,"max_essays_per_month" bigint,"is_active" boolean DEFAULT true,"logo_url" text,"primary_color" text,"secondary_color" text,"report_footer" text,"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_organizations_slug" ON "organizations" ("slug");
+
+CREATE TABLE "users" ("id" bigserial,"email" text,"pass_hash" text,"plan" text,"role" text DEFAULT 'user',"created_at" timestamptz,"target_band" decimal,"exam_date" timestamptz,"organization_id" bigint,"email_verified_at" timestamptz,"billing_anchor" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_users_organization_id" ON "users" ("organization_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_email" ON "users" ("email");
+
+CREATE TABLE "sessions" ("id" varchar(36),"user_id" bigint,"refresh_hash" varchar(64),"prev_refresh_hash" varchar(64),"access_jti" varchar(36),"device" text,"user_agent" text,"ip" text,"created_at" timestamptz,"last_used_at" timestamptz,"expires_at" timestamptz,"revoked_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_sessions_prev_refresh_hash" ON "sessions" ("prev_refresh_hash");
+CREATE INDEX IF NOT EXISTS "idx_sessions_user_id" ON "sessions" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_sessions_refresh_hash" ON "sessions" ("refresh_hash");
+
+CREATE TABLE "user_identities" ("id" bigserial,"user_id" bigint,"provider" text,"issuer" text,"subject" text,"email" text,"created_at" timestamptz,"last_login_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_user_identities_user_id" ON "user_identities" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_identity_subject" ON "user_identities" ("issuer","subject");
+
+CREATE TABLE "user_mfas" ("user_id" bigserial,"secret" text,"enabled_at" timestamptz,"last_step" bigint,"failures" bigint,"locked_until" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("user_id"));
+
+CREATE TABLE "mfa_recovery_codes" ("id" bigserial,"user_id" bigint,"code_hash" varchar(64),"used_at" timestamptz,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_mfa_recovery_codes_user_id" ON "mfa_recovery_codes" ("user_id");
+
+CREATE TABLE "essays" ("id" bigserial,"user_id" bigint,"assignment_id" bigint,"task_type" text,"question_type" text,"text" TEXT,"bands_json" text,"overall" decimal,"cefr" text,"feedback" TEXT,"public_id" text,"created_at" timestamptz,"organization_id" bigint,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_essays_assignment_id" ON "essays" ("assignment_id");
+CREATE INDEX IF NOT EXISTS "idx_essays_organization_id" ON "essays" ("organization_id");
+CREATE INDEX IF NOT EXISTS "idx_essays_question_type" ON "essays" ("question_type");
+CREATE INDEX IF NOT EXISTS "idx_essays_user_id" ON "essays" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_essays_public_id" ON "essays" ("public_id");
+
+CREATE TABLE "analytics_events" ("id" bigserial,"event_type" text,"user_id" bigint,"session_id" text,"ip_address" text,"user_agent" text,"referrer" text,"page" text,"data" TEXT,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_analytics_events_created_at" ON "analytics_events" ("created_at");
+CREATE INDEX IF NOT EXISTS "idx_analytics_events_event_type" ON "analytics_events" ("event_type");
+CREATE INDEX IF NOT EXISTS "idx_analytics_events_ip_address" ON "analytics_events" ("ip_address");
+CREATE INDEX IF NOT EXISTS "idx_analytics_events_session_id" ON "analytics_events" ("session_id");
+CREATE INDEX IF NOT EXISTS "idx_analytics_events_user_id" ON "analytics_events" ("user_id");
+
+CREATE TABLE "user_feedbacks" ("id" bigserial,"user_id" bigint,"rating" bigint NOT NULL,"comment" TEXT,"email" text,"user_agent" text,"url" text,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_user_feedbacks_user_id" ON "user_feedbacks" ("user_id");
+
+CREATE TABLE "blog_posts" ("id" bigserial,"title" text NOT NULL,"slug" text NOT NULL,"excerpt" TEXT,"content" TEXT,"category" text DEFAULT 'general',"tags" text,"read_time" text DEFAULT '5 min',"published_at" timestamptz,"is_published" boolean DEFAULT false,"author_id" bigint,"created_at" timestamptz,"updated_at" timestamptz,"organization_id" bigint,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_blog_posts_author_id" ON "blog_posts" ("author_id");
+CREATE INDEX IF NOT EXISTS "idx_blog_posts_organization_id" ON "blog_posts" ("organization_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_blog_posts_slug" ON "blog_posts" ("slug");
+
+CREATE TABLE "admin_prompts" ("id" bigserial,"name" text NOT NULL,"description" text,"prompt" TEXT,"type" text DEFAULT 'scoring',"is_active" boolean DEFAULT true,"created_at" timestamptz,"updated_at" timestamptz,"organization_id" bigint,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_admin_prompts_organization_id" ON "admin_prompts" ("organization_id");
+
+CREATE TABLE "annotations" ("id" bigserial,"essay_id" bigint,"user_id" bigint,"criterion" text,"category" text,"excerpt" TEXT,"suggestion" TEXT,"explanation" TEXT,"start" bigint,"end" bigint,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_annotations_category" ON "annotations" ("category");
+CREATE INDEX IF NOT EXISTS "idx_annotations_created_at" ON "annotations" ("created_at");
+CREATE INDEX IF NOT EXISTS "idx_annotations_criterion" ON "annotations" ("criterion");
+CREATE INDEX IF NOT EXISTS "idx_annotations_essay_id" ON "annotations" ("essay_id");
+CREATE INDEX IF NOT EXISTS "idx_annotations_user_id" ON "annotations" ("user_id");
+
+CREATE TABLE "study_plans" ("id" bigserial,"user_id" bigint,"plan_json" TEXT,"last_essay_id" bigint,"generated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_study_plans_user_id" ON "study_plans" ("user_id");
+
+CREATE TABLE "vocabulary_cards" ("id" bigserial,"user_id" bigint,"word" text,"alternative" text,"example" TEXT,"context" TEXT,"note" TEXT,"source" text DEFAULT 'manual',"essay_id" bigint,"annotation_id" bigint,"ease_factor" decimal DEFAULT 2.5,"interval_days" bigint,"repetitions" bigint,"lapses" bigint,"due_at" timestamptz,"reviewed_at" timestamptz,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_vocabulary_cards_due_at" ON "vocabulary_cards" ("due_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_vocab_user_word" ON "vocabulary_cards" ("user_id","word");
+
+CREATE TABLE "questions" ("id" bigserial,"task_type" text,"question_type" text,"title" text,"prompt" TEXT,"image_url" text,"is_active" boolean DEFAULT true,"created_by" bigint,"organization_id" bigint,"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_questions_organization_id" ON "questions" ("organization_id");
+CREATE INDEX IF NOT EXISTS "idx_questions_question_type" ON "questions" ("question_type");
+CREATE INDEX IF NOT EXISTS "idx_questions_task_type" ON "questions" ("task_type");
+
+CREATE TABLE "classrooms" ("id" bigserial,"name" text NOT NULL,"teacher_id" bigint,"join_code" text,"created_at" timestamptz,"organization_id" bigint,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_classrooms_organization_id" ON "classrooms" ("organization_id");
+CREATE INDEX IF NOT EXISTS "idx_classrooms_teacher_id" ON "classrooms" ("teacher_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_classrooms_join_code" ON "classrooms" ("join_code");
+
+CREATE TABLE "class_members" ("id" bigserial,"classroom_id" bigint,"user_id" bigint,"joined_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_class_members_user_id" ON "class_members" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_class_member" ON "class_members" ("classroom_id","user_id");
+
+CREATE TABLE "assignments" ("id" bigserial,"classroom_id" bigint,"question_id" bigint,"title" text,"instructions" TEXT,"due_at" timestamptz,"created_at" timestamptz,"peer_reviewers" bigint,"peer_reviews_assigned_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_assignments_classroom_id" ON "assignments" ("classroom_id");
+CREATE INDEX IF NOT EXISTS "idx_assignments_question_id" ON "assignments" ("question_id");
+
+CREATE TABLE "essay_comments" ("id" bigserial,"essay_id" bigint,"author_id" bigint,"parent_id" bigint,"start_offset" bigint,"end_offset" bigint,"quote" TEXT,"body" TEXT,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_essay_comments_author_id" ON "essay_comments" ("author_id");
+CREATE INDEX IF NOT EXISTS "idx_essay_comments_essay_id" ON "essay_comments" ("essay_id");
+CREATE INDEX IF NOT EXISTS "idx_essay_comments_parent_id" ON "essay_comments" ("parent_id");
+
+CREATE TABLE "band_overrides" ("id" bigserial,"essay_id" bigint,"teacher_id" bigint,"criterion" text,"band" decimal,"reason" TEXT,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_band_overrides_essay_id" ON "band_overrides" ("essay_id");
+
+CREATE TABLE "notifications" ("id" bigserial,"user_id" bigint,"kind" text,"title" text,"body" TEXT,"essay_id" bigint,"read_at" timestamptz,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_notifications_created_at" ON "notifications" ("created_at");
+CREATE INDEX IF NOT EXISTS "idx_notifications_kind" ON "notifications" ("kind");
+CREATE INDEX IF NOT EXISTS "idx_notifications_user_id" ON "notifications" ("user_id");
+
+CREATE TABLE "peer_reviews" ("id" bigserial,"assignment_id" bigint,"essay_id" bigint,"reviewer_id" bigint,"ta" decimal,"cc" decimal,"lr" decimal,"gra" decimal,"comment" TEXT,"submitted_at" timestamptz,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_peer_reviews_assignment_id" ON "peer_reviews" ("assignment_id");
+CREATE INDEX IF NOT EXISTS "idx_peer_reviews_reviewer_id" ON "peer_reviews" ("reviewer_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_peer_review" ON "peer_reviews" ("essay_id","reviewer_id");
+
+CREATE TABLE "audit_logs" ("id" bigserial,"created_at" timestamptz,"organization_id" bigint,"actor_id" bigint,"actor_email" text,"action" text,"target_type" text,"target_id" text,"before" TEXT,"after" TEXT,"ip_address" text,"user_agent" text,"hash" text,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_audit_logs_action" ON "audit_logs" ("action");
+CREATE INDEX IF NOT EXISTS "idx_audit_logs_actor_id" ON "audit_logs" ("actor_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_logs_created_at" ON "audit_logs" ("created_at");
+CREATE INDEX IF NOT EXISTS "idx_audit_logs_hash" ON "audit_logs" ("hash");
+CREATE INDEX IF NOT EXISTS "idx_audit_logs_organization_id" ON "audit_logs" ("organization_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_target" ON "audit_logs" ("target_type","target_id");
+
+CREATE TABLE "api_keys" ("id" bigserial,"name" text,"prefix" text,"key_hash" text,"user_id" bigint,"organization_id" bigint,"created_by" bigint,"scopes" text,"rate_limit" bigint,"last_used_at" timestamptz,"last_used_ip" text,"expires_at" timestamptz,"revoked_at" timestamptz,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_api_keys_organization_id" ON "api_keys" ("organization_id");
+CREATE INDEX IF NOT EXISTS "idx_api_keys_user_id" ON "api_keys" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_api_keys_key_hash" ON "api_keys" ("key_hash");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_api_keys_prefix" ON "api_keys" ("prefix");
+
+CREATE TABLE "plans" ("id" bigserial,"name" text NOT NULL,"daily_analyses" bigint,"monthly_analyses" bigint,"daily_rewrites" bigint,"monthly_rewrites" bigint,"daily_pdf_exports" bigint,"monthly_pdf_exports" bigint,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_plans_name" ON "plans" ("name");
+
+CREATE TABLE "usage_counters" ("user_id" bigint,"metric" text,"period" text,"count" bigint,"updated_at" timestamptz,PRIMARY KEY ("user_id","metric","period"));
+
+CREATE TABLE "subscriptions" ("id" bigserial,"user_id" bigint NOT NULL,"provider" text,"customer_id" text,"subscription_id" text,"plan" text,"status" text,"current_period_start" timestamptz,"current_period_end" timestamptz,"cancel_at_period_end" boolean,"grace_until" timestamptz,"event_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_subscriptions_customer_id" ON "subscriptions" ("customer_id");
+CREATE INDEX IF NOT EXISTS "idx_subscriptions_subscription_id" ON "subscriptions" ("subscription_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_subscriptions_user_id" ON "subscriptions" ("user_id");
+
+CREATE TABLE "billing_webhooks" ("event_id" text,"provider" text,"type" text,"created_at" timestamptz,PRIMARY KEY ("event_id","provider"));
+
+CREATE TABLE "credit_accounts" ("id" bigserial,"name" text NOT NULL,"user_id" bigint,"balance" bigint,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_credit_accounts_user_id" ON "credit_accounts" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_credit_accounts_name" ON "credit_accounts" ("name");
+
+CREATE TABLE "credit_transactions" ("id" bigserial,"kind" text NOT NULL,"reference" text,"memo" text,"actor_id" bigint,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_credit_transactions_kind" ON "credit_transactions" ("kind");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_credit_transactions_reference" ON "credit_transactions" ("reference");
+
+CREATE TABLE "credit_entries" ("id" bigserial,"transaction_id" bigint NOT NULL,"account_id" bigint NOT NULL,"amount" bigint NOT NULL,"created_at" timestamptz,PRIMARY KEY ("id"),CONSTRAINT "fk_credit_transactions_entries" FOREIGN KEY ("transaction_id") REFERENCES "credit_transactions"("id"));
+CREATE INDEX IF NOT EXISTS "idx_credit_entries_account_id" ON "credit_entries" ("account_id");
+CREATE INDEX IF NOT EXISTS "idx_credit_entries_transaction_id" ON "credit_entries" ("transaction_id");
+
+CREATE TABLE "voucher_batches" ("id" bigserial,"name" text NOT NULL,"organization_id" bigint,"credits" bigint NOT NULL,"max_redemptions" bigint NOT NULL,"expires_at" timestamptz,"revoked_at" timestamptz,"created_by" bigint,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_voucher_batches_organization_id" ON "voucher_batches" ("organization_id");
+
+CREATE TABLE "vouchers" ("id" bigserial,"batch_id" bigint NOT NULL,"code" text NOT NULL,"redemptions" bigint,"created_at" timestamptz,PRIMARY KEY ("id"),CONSTRAINT "fk_voucher_batches_vouchers" FOREIGN KEY ("batch_id") REFERENCES "voucher_batches"("id"));
+CREATE INDEX IF NOT EXISTS "idx_vouchers_batch_id" ON "vouchers" ("batch_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_vouchers_code" ON "vouchers" ("code");
+
+CREATE TABLE "voucher_redemptions" ("batch_id" bigint,"user_id" bigint,"voucher_id" bigint,"transaction_id" bigint,"created_at" timestamptz,PRIMARY KEY ("batch_id","user_id"));
+CREATE INDEX IF NOT EXISTS "idx_voucher_redemptions_voucher_id" ON "voucher_redemptions" ("voucher_id");
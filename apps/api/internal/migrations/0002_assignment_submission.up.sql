@@ -0,0 +1,8 @@
+-- One submission per student and assignment. Concurrent submits could
+-- store duplicates before this index: all but the first become practice
+-- essays before it is built.
+UPDATE "essays" SET "assignment_id" = NULL
+WHERE "assignment_id" IS NOT NULL AND "id" NOT IN (
+	SELECT MIN("id") FROM "essays" WHERE "assignment_id" IS NOT NULL GROUP BY "user_id", "assignment_id"
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_assignment_submission" ON "essays" ("user_id","assignment_id");
@@ -0,0 +1,441 @@
+// Package migrations versions the database schema.
+//
+// Each change is a pair of SQL files in this directory, NNNN_name.up.sql
+// and NNNN_name.down.sql, embedded in the binary. Applied versions are
+// recorded in schema_migrations together with a checksum of the up file.
+// Every run holds a Postgres advisory lock, so replicas starting at the
+// same time apply each migration once, and every migration runs in its
+// own transaction with its schema_migrations row.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// lockKey is the advisory lock held while migrating ("bandly" in ASCII)
+const lockKey int64 = 0x62616e646c79
+
+var fileRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// The statements of a generated migration, one per line
+var (
+	createTableRegex = regexp.MustCompile(`(?m)^CREATE TABLE "(\w+)" \((.*)\);$`)
+	columnRegex      = regexp.MustCompile(`[(,]"(\w+)" `)
+	createIndexRegex = regexp.MustCompile(`(?m)^CREATE (?:UNIQUE )?INDEX IF NOT EXISTS "(\w+)"`)
+)
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the up SQL, to notice files edited after they ran
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// objects lists the tables, columns as "table.column", and indexes the
+// migration's CREATE statements make
+func (m Migration) objects() (tables, columns, indexes []string) {
+	for _, match := range createTableRegex.FindAllStringSubmatch(m.Up, -1) {
+		tables = append(tables, match[1])
+		for _, column := range columnRegex.FindAllStringSubmatch("("+match[2], -1) {
+			columns = append(columns, match[1]+"."+column[1])
+		}
+	}
+	for _, match := range createIndexRegex.FindAllStringSubmatch(m.Up, -1) {
+		indexes = append(indexes, match[1])
+	}
+	return tables, columns, indexes
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Load reads the migrations of a directory in version order. Every version
+// needs both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.up.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version", entry.Name())
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s needs both an up and a down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Embedded returns the migrations built into the binary
+func Embedded() ([]Migration, error) {
+	return Load(files)
+}
+
+// Status is a migration and whether it was applied
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	Modified  bool // the up file changed after it was applied
+	Missing   bool // applied, but no file has this version
+}
+
+// Runner applies migrations to a Postgres database
+type Runner struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// New returns a runner for the embedded migrations
+func New(db *sql.DB) (*Runner, error) {
+	migrations, err := Embedded()
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{DB: db, Migrations: migrations}, nil
+}
+
+// applied is a row of schema_migrations
+type applied struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// queryer is a connection or a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// applied reads schema_migrations, empty when the table does not exist yet
+func (r *Runner) applied(ctx context.Context, q queryer) (map[int64]applied, error) {
+	rows, err := q.QueryContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	var exists bool
+	for rows.Next() {
+		if err := rows.Scan(&exists); err != nil {
+			rows.Close()
+			return nil, err
+		}
+	}
+	rows.Close()
+	if !exists {
+		return map[int64]applied{}, nil
+	}
+
+	rows, err = q.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := map[int64]applied{}
+	for rows.Next() {
+		var version int64
+		var a applied
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		result[version] = a
+	}
+	return result, rows.Err()
+}
+
+// Status lists every migration, known or applied, in version order
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	done, err := r.applied(ctx, r.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, m := range r.Migrations {
+		s := Status{Migration: m}
+		if a, ok := done[m.Version]; ok {
+			at := a.appliedAt
+			s.AppliedAt = &at
+			s.Modified = a.checksum != m.Checksum()
+			delete(done, m.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for version, a := range done {
+		at := a.appliedAt
+		statuses = append(statuses, Status{Migration: Migration{Version: version, Name: a.name}, AppliedAt: &at, Missing: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Pending returns the migrations not applied yet
+func (r *Runner) Pending(ctx context.Context) ([]Migration, error) {
+	done, err := r.applied(ctx, r.DB)
+	if err != nil {
+		return nil, err
+	}
+	return pending(r.Migrations, done), nil
+}
+
+func pending(migrations []Migration, done map[int64]applied) []Migration {
+	var result []Migration
+	for _, m := range migrations {
+		if _, ok := done[m.Version]; !ok {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// locked runs fn on one connection holding the migration lock. Whoever
+// waits for the lock sees the migrations the holder applied.
+func (r *Runner) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := r.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		checksum text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// run executes one migration's SQL and records the result in a transaction
+func run(ctx context.Context, conn *sql.Conn, body string, finish func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if body != "" {
+		if _, err := tx.ExecContext(ctx, body); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := finish(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// record adds a migration to schema_migrations
+func record(ctx context.Context, tx *sql.Tx, m Migration) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)", m.Version, m.Name, m.Checksum())
+	return err
+}
+
+// Up applies up to n pending migrations, all of them when n <= 0, and
+// returns those it applied
+func (r *Runner) Up(ctx context.Context, n int) ([]Migration, error) {
+	var done []Migration
+	err := r.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := r.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		todo := pending(r.Migrations, applied)
+		if n > 0 && n < len(todo) {
+			todo = todo[:n]
+		}
+		for _, m := range todo {
+			err := run(ctx, conn, m.Up, func(tx *sql.Tx) error { return record(ctx, tx, m) })
+			if err != nil {
+				return fmt.Errorf("migration %s: %w", m, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the n most recently applied migrations, one when n <= 0,
+// and returns those it reverted
+func (r *Runner) Down(ctx context.Context, n int) ([]Migration, error) {
+	if n <= 0 {
+		n = 1
+	}
+	byVersion := map[int64]Migration{}
+	for _, m := range r.Migrations {
+		byVersion[m.Version] = m
+	}
+
+	var done []Migration
+	err := r.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := r.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		if n < len(versions) {
+			versions = versions[:n]
+		}
+
+		for _, version := range versions {
+			m, ok := byVersion[version]
+			if !ok {
+				return fmt.Errorf("migration %d was applied but this build has no file for it", version)
+			}
+			err := run(ctx, conn, m.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("revert %s: %w", m, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MarkApplied records migrations up to and including version as applied
+// without running them, for databases whose schema already matches
+func (r *Runner) MarkApplied(ctx context.Context, version int64) ([]Migration, error) {
+	var done []Migration
+	err := r.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := r.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending(r.Migrations, applied) {
+			if m.Version > version {
+				break
+			}
+			err := run(ctx, conn, "", func(tx *sql.Tx) error { return record(ctx, tx, m) })
+			if err != nil {
+				return fmt.Errorf("mark %s: %w", m, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Missing lists the tables, columns and indexes a migration creates that
+// the database lacks. Before marking a migration applied it shows whether
+// the schema really matches; column types are not compared.
+func (r *Runner) Missing(ctx context.Context, m Migration) ([]string, error) {
+	existing := map[string]bool{}
+	for _, query := range []string{
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()",
+		"SELECT table_name || '.' || column_name FROM information_schema.columns WHERE table_schema = current_schema()",
+		"SELECT indexname FROM pg_indexes WHERE schemaname = current_schema()",
+	} {
+		rows, err := r.DB.QueryContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			existing[name] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return missing(m, existing), nil
+}
+
+// missing returns the objects of a migration not among the existing names.
+// Columns of a missing table are left out, the table says it all.
+func missing(m Migration, existing map[string]bool) []string {
+	tables, columns, indexes := m.objects()
+	var result []string
+	for _, table := range tables {
+		if !existing[table] {
+			result = append(result, "table "+table)
+		}
+	}
+	for _, column := range columns {
+		table, _, _ := strings.Cut(column, ".")
+		if existing[table] && !existing[column] {
+			result = append(result, "column "+column)
+		}
+	}
+	for _, index := range indexes {
+		if !existing[index] {
+			result = append(result, "index "+index)
+		}
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	file := func(body string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(body)} }
+
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "Sorted by version",
+			files: fstest.MapFS{
+				"0010_add_index.up.sql":   file("CREATE INDEX"),
+				"0010_add_index.down.sql": file("DROP INDEX"),
+				"0002_users.up.sql":       file("CREATE TABLE"),
+				"0002_users.down.sql":     file("DROP TABLE"),
+			},
+			want: []string{"0002_users", "0010_add_index"},
+		},
+		{
+			name:    "Missing down",
+			files:   fstest.MapFS{"0001_baseline.up.sql": file("CREATE TABLE")},
+			wantErr: "needs both",
+		},
+		{
+			name: "Empty up",
+			files: fstest.MapFS{
+				"0001_baseline.up.sql":   file(""),
+				"0001_baseline.down.sql": file("DROP TABLE"),
+			},
+			wantErr: "needs both",
+		},
+		{
+			name: "Same version, two names",
+			files: fstest.MapFS{
+				"0001_baseline.up.sql": file("CREATE TABLE"),
+				"0001_other.down.sql":  file("DROP TABLE"),
+			},
+			wantErr: "named both",
+		},
+		{
+			name:    "Bad file name",
+			files:   fstest.MapFS{"baseline.sql": file("CREATE TABLE")},
+			wantErr: "name must look like",
+		},
+		{
+			name:    "Version zero",
+			files:   fstest.MapFS{"0000_zero.up.sql": file("SELECT 1")},
+			wantErr: "invalid version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.files)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			var names []string
+			for _, m := range got {
+				names = append(names, m.String())
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Load() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	all, err := Embedded()
+	if err != nil {
+		t.Fatalf("Embedded() error = %v", err)
+	}
+	if len(all) == 0 || all[0].Version != 1 || all[0].Name != "baseline" {
+		t.Fatalf("Embedded() should start with 0001_baseline, got %v", all)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Version == all[i-1].Version {
+			t.Errorf("duplicate version %d", all[i].Version)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	all := []Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+	got := pending(all, map[int64]applied{1: {}, 3: {}})
+	if len(got) != 1 || got[0].Version != 2 {
+		t.Errorf("pending() = %v, want only version 2", got)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	m := Migration{Up: `CREATE TABLE "users" ("id" bigserial,"email" text,"role" text DEFAULT 'user',PRIMARY KEY ("id"));
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_email" ON "users" ("email");
+CREATE TABLE "usage_counters" ("user_id" bigint,"metric" text,PRIMARY KEY ("user_id","metric"));
+CREATE INDEX IF NOT EXISTS "idx_usage_counters_metric" ON "usage_counters" ("metric");
+`}
+
+	tables, columns, indexes := m.objects()
+	if strings.Join(tables, " ") != "users usage_counters" {
+		t.Errorf("tables = %v", tables)
+	}
+	if strings.Join(columns, " ") != "users.id users.email users.role usage_counters.user_id usage_counters.metric" {
+		t.Errorf("columns = %v", columns)
+	}
+	if strings.Join(indexes, " ") != "idx_users_email idx_usage_counters_metric" {
+		t.Errorf("indexes = %v", indexes)
+	}
+
+	// An older schema: users without role, no usage counters
+	existing := map[string]bool{"users": true, "users.id": true, "users.email": true, "idx_users_email": true}
+	got := strings.Join(missing(m, existing), ", ")
+	want := "table usage_counters, column users.role, index idx_usage_counters_metric"
+	if got != want {
+		t.Errorf("missing() = %q, want %q", got, want)
+	}
+
+	all, _ := Embedded()
+	if tables, columns, indexes := all[0].objects(); len(tables) < 30 || len(columns) < len(tables) || len(indexes) == 0 {
+		t.Errorf("baseline objects: %d tables, %d columns, %d indexes", len(tables), len(columns), len(indexes))
+	}
+}
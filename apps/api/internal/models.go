@@ -6,22 +6,35 @@ type User struct {
 	ID        uint   `gorm:"primaryKey"`
 	Email     string `gorm:"uniqueIndex"`
 	PassHash  string
-	Plan      string // "free" | "pro"
-	Role      string `gorm:"default:'user'"` // "user" | "admin"
+	Plan      string // name of a Plan, "free" or "pro" by default
+	Role      string `gorm:"default:'user'"` // see rolePermissions
 	CreatedAt time.Time
+
+	TargetBand *float32   // goal overall band, nil until the user sets one
+	ExamDate   *time.Time // planned exam date, optional
+
+	OrganizationID *uint `gorm:"index"` // tenant, nil for platform users
+
+	EmailVerifiedAt *time.Time // nil until the user follows a verification link
+
+	BillingAnchor *time.Time // start of the paid subscription period, nil: CreatedAt
 }
 
 type Essay struct {
-	ID        uint   `gorm:"primaryKey"`
-	UserID    *uint  `gorm:"index"`
-	TaskType  string // "task1"|"task2"
-	Text      string `gorm:"type:TEXT"`
-	BandsJSON string // raw JSON: {"ta":7,"cc":6.5,"lr":7,"gra":7.5,"overall":7}
-	Overall   float32
-	CEFR      string
-	Feedback  string `gorm:"type:TEXT"`
-	PublicID  string `gorm:"uniqueIndex"`
-	CreatedAt time.Time
+	ID           uint   `gorm:"primaryKey"`
+	UserID       *uint  `gorm:"index;uniqueIndex:idx_assignment_submission"`
+	AssignmentID *uint  `gorm:"index;uniqueIndex:idx_assignment_submission"` // set when submitted to a class assignment, once per student
+	TaskType     string // "task1"|"task2"
+	QuestionType string `gorm:"index"` // see questionTypes, "" when not given
+	Text         string `gorm:"type:TEXT"`
+	BandsJSON    string // raw JSON: {"ta":7,"cc":6.5,"lr":7,"gra":7.5,"overall":7}
+	Overall      float32
+	CEFR         string
+	Feedback     string `gorm:"type:TEXT"`
+	PublicID     string `gorm:"uniqueIndex"`
+	CreatedAt    time.Time
+
+	OrganizationID *uint `gorm:"index"` // tenant of the author, nil for platform and anonymous essays
 }
 
 type UserFeedback struct {
@@ -49,6 +62,8 @@ type BlogPost struct {
 	AuthorID    uint `gorm:"index"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+
+	OrganizationID *uint `gorm:"index"`
 }
 
 type AdminPrompt struct {
@@ -60,4 +75,6 @@ type AdminPrompt struct {
 	IsActive    bool   `gorm:"default:true"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+
+	OrganizationID *uint `gorm:"index"` // a tenant's active scoring prompt is added to the scorer prompt
 }
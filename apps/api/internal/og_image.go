@@ -33,7 +33,7 @@ func OGImageHandler(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		// Generate the OG image
-		img, err := generateOGImage(scoreResult)
+		img, err := generateOGImage(scoreResult, loadBranding(db, essay.OrganizationID))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate image"})
 			return
@@ -55,17 +55,18 @@ func OGImageHandler(db *gorm.DB) gin.HandlerFunc {
 }
 
 // generateOGImage creates a beautiful OG image for the report
-func generateOGImage(score ScoreOut) (image.Image, error) {
+func generateOGImage(score ScoreOut, brand Branding) (image.Image, error) {
 	// Image dimensions (1200x630 for optimal OG display)
 	width, height := 1200, 630
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
 
-	// Create gradient background (brand blue to purple)
+	// Create gradient background (primary to secondary brand colour)
+	from, to := brand.Primary, brand.Secondary
 	for y := 0; y < height; y++ {
 		factor := float64(y) / float64(height)
-		r := uint8(58 + factor*30)  // 58 -> 88
-		g := uint8(122 + factor*70) // 122 -> 192
-		b := uint8(254 - factor*50) // 254 -> 204
+		r := uint8(float64(from.R) + factor*(float64(to.R)-float64(from.R)))
+		g := uint8(float64(from.G) + factor*(float64(to.G)-float64(from.G)))
+		b := uint8(float64(from.B) + factor*(float64(to.B)-float64(from.B)))
 
 		for x := 0; x < width; x++ {
 			img.Set(x, y, color.RGBA{r, g, b, 255})
@@ -0,0 +1,345 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCProvider is an OpenID Connect issuer users can sign in with. Metadata
+// and signing keys are discovered from the issuer on first use.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        *oidcMetadata
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// OIDCProviders are the configured providers by name
+type OIDCProviders map[string]*OIDCProvider
+
+// oidcMetadata is the part of the discovery document we use
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDClaims are the ID token claims we read
+type IDClaims struct {
+	Nonce         string      `json:"nonce"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"` // some issuers send "true"
+	Name          string      `json:"name"`
+	AuthorizedBy  string      `json:"azp"`
+	TenantID      string      `json:"tid"` // Microsoft multi-tenant issuers
+	jwt.RegisteredClaims
+}
+
+// VerifiedEmail returns the email when the issuer vouches for it
+func (c IDClaims) VerifiedEmail() (string, bool) {
+	verified := c.EmailVerified == true || c.EmailVerified == "true"
+	if !verified {
+		return "", false
+	}
+	return normalizeEmail(c.Email)
+}
+
+// oidcPresets are the default issuers of well-known providers
+var oidcPresets = map[string]string{
+	"google":    "https://accounts.google.com",
+	"microsoft": "https://login.microsoftonline.com/common/v2.0",
+}
+
+var errOIDCIDToken = errors.New("invalid ID token")
+
+// NewOIDCProviders configures providers from the environment. OIDC_PROVIDERS
+// lists provider names; each NAME then reads:
+//
+//	OIDC_NAME_ISSUER         issuer URL, defaults for "google" and "microsoft"
+//	OIDC_NAME_CLIENT_ID      client ID (required)
+//	OIDC_NAME_CLIENT_SECRET  client secret
+//	OIDC_NAME_SCOPES         space separated, defaults to "openid email profile"
+//
+// Callbacks go to OIDC_CALLBACK_BASE_URL/api/auth/oidc/NAME/callback, where
+// the base defaults to http://localhost:8080.
+func NewOIDCProviders() (OIDCProviders, error) {
+	providers := OIDCProviders{}
+	if os.Getenv("OIDC_PROVIDERS") == "" {
+		return providers, nil
+	}
+
+	callbackBase := strings.TrimSuffix(os.Getenv("OIDC_CALLBACK_BASE_URL"), "/")
+	if callbackBase == "" {
+		callbackBase = "http://localhost:8080"
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		env := func(key string) string { return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key) }
+
+		issuer := env("ISSUER")
+		if issuer == "" {
+			issuer = oidcPresets[name]
+		}
+		if issuer == "" || env("CLIENT_ID") == "" {
+			return nil, fmt.Errorf("OIDC provider %q needs an issuer and a client ID", name)
+		}
+		scopes := strings.Fields(env("SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers[name] = &OIDCProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(issuer, "/"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			RedirectURL:  callbackBase + "/api/auth/oidc/" + name + "/callback",
+			Scopes:       scopes,
+		}
+	}
+	return providers, nil
+}
+
+// httpClient returns the client used to talk to the issuer
+func (p *OIDCProvider) httpClient() *http.Client {
+	if p.client != nil {
+		return p.client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// getJSON fetches a JSON document from the issuer
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// metadata discovers and caches the issuer's configuration
+func (p *OIDCProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta oidcMetadata
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, fmt.Errorf("OIDC discovery for %s: %w", p.Name, err)
+	}
+	// Microsoft's multi-tenant document names a {tenantid} placeholder
+	if strings.TrimSuffix(meta.Issuer, "/") != p.Issuer && !strings.Contains(meta.Issuer, "{tenantid}") {
+		return nil, fmt.Errorf("OIDC discovery for %s: issuer %q does not match", p.Name, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery for %s: incomplete metadata", p.Name)
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// AuthCodeURL builds the authorization request with a PKCE S256 challenge
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange swaps an authorization code for tokens and returns the validated
+// ID token claims
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*IDClaims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("OIDC token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("OIDC token exchange failed: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, lifetime
+// and nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawToken, nonce string) (*IDClaims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &IDClaims{}
+	_, err = jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, meta.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errOIDCIDToken, err)
+	}
+
+	issuer := strings.Replace(meta.Issuer, "{tenantid}", claims.TenantID, 1)
+	if claims.Issuer != issuer {
+		return nil, fmt.Errorf("%w: issuer %q", errOIDCIDToken, claims.Issuer)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.ClientID {
+		return nil, fmt.Errorf("%w: azp %q", errOIDCIDToken, claims.AuthorizedBy)
+	}
+	if claims.Nonce != nonce || claims.Subject == "" {
+		return nil, fmt.Errorf("%w: nonce or subject mismatch", errOIDCIDToken)
+	}
+	return claims, nil
+}
+
+// signingKey returns the issuer key with the given kid, refetching the key
+// set at most once a minute when the kid is unknown
+func (p *OIDCProvider) signingKey(ctx context.Context, jwksURI, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < time.Minute {
+		return nil, errUnknownKID
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, err
+	}
+
+	p.keys = map[string]interface{}{}
+	p.keysFetched = time.Now()
+	b64 := base64.RawURLEncoding.DecodeString
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := b64(k.N)
+			e, errE := b64(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, errX := b64(k.X)
+			y, errY := b64(k.Y)
+			if k.Crv != "P-256" || errX != nil || errY != nil {
+				continue
+			}
+			p.keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errUnknownKID
+}
@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a user to an account at an OIDC provider. A user may
+// have several, e.g. Google and Microsoft with the same verified email.
+type UserIdentity struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"index" json:"-"`
+	Provider    string    `json:"provider"`
+	Issuer      string    `gorm:"uniqueIndex:idx_identity_subject" json:"-"`
+	Subject     string    `gorm:"uniqueIndex:idx_identity_subject" json:"-"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastLoginAt time.Time `json:"lastLoginAt"`
+}
+
+const (
+	oidcStatePurpose = "oidc-state"
+	oidcFlowTTL      = 10 * time.Minute
+	oidcFlowCookie   = "oidc_flow"
+)
+
+var errOIDCEmailTaken = errors.New("email belongs to an account that cannot be linked")
+
+// oidcNonce derives the nonce of a login flow from its PKCE verifier, so
+// the flow cookie is the only state we keep
+func oidcNonce(verifier string) string {
+	return hashToken("nonce:" + verifier)
+}
+
+// oidcResultURL is where the web app receives the outcome of a login. Values
+// travel in the fragment so they never reach server logs.
+func oidcResultURL(values url.Values) string {
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:3000"
+	}
+	return baseURL + "/auth/oidc#" + values.Encode()
+}
+
+// OIDCStart redirects the browser to the provider's login page
+func OIDCStart(auth *AuthService, providers OIDCProviders) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := providers[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+			return
+		}
+
+		verifier, err := newRefreshToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start login"})
+			return
+		}
+		state, err := auth.IssueAction(0, oidcStatePurpose, provider.Name+":"+tokenBinding(verifier), oidcFlowTTL, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start login"})
+			return
+		}
+
+		authURL, err := provider.AuthCodeURL(c.Request.Context(), state, oidcNonce(verifier), verifier)
+		if err != nil {
+			log.Printf("OIDC start failed: %v", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+			return
+		}
+
+		// The verifier stays in this browser; the callback needs it to redeem the code
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(oidcFlowCookie, verifier, int(oidcFlowTTL.Seconds()), "/api/auth/oidc", "", strings.HasPrefix(provider.RedirectURL, "https://"), true)
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallback finishes a login: it redeems the code, finds or creates the
+// user and hands our usual tokens to the web app
+func OIDCCallback(db *gorm.DB, auth *AuthService, providers OIDCProviders) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fail := func(reason string) {
+			c.Redirect(http.StatusFound, oidcResultURL(url.Values{"error": {reason}}))
+		}
+
+		provider, ok := providers[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+			return
+		}
+		if e := c.Query("error"); e != "" {
+			fail(e)
+			return
+		}
+
+		verifier, err := c.Cookie(oidcFlowCookie)
+		if err != nil {
+			fail("login_expired")
+			return
+		}
+		c.SetCookie(oidcFlowCookie, "", -1, "/api/auth/oidc", "", strings.HasPrefix(provider.RedirectURL, "https://"), true)
+
+		_, binding, err := auth.VerifyAction(c.Query("state"), oidcStatePurpose)
+		if err != nil || binding != provider.Name+":"+tokenBinding(verifier) {
+			fail("invalid_state")
+			return
+		}
+
+		claims, err := provider.Exchange(c.Request.Context(), c.Query("code"), verifier, oidcNonce(verifier))
+		if err != nil {
+			log.Printf("OIDC callback for %s failed: %v", provider.Name, err)
+			fail("provider_error")
+			return
+		}
+
+		user, err := userForIdentity(db, provider, claims)
+		if errors.Is(err, errOIDCEmailTaken) {
+			fail("email_in_use")
+			return
+		}
+		if err != nil {
+			log.Printf("OIDC sign-in for %s failed: %v", provider.Name, err)
+			fail("server_error")
+			return
+		}
+
+		// The provider replaces the password, not our second factor
+		challenge, err := mfaChallenge(db, auth, user)
+		if err != nil {
+			fail("server_error")
+			return
+		}
+		if challenge != "" {
+			c.Redirect(http.StatusFound, oidcResultURL(url.Values{"mfaToken": {challenge}}))
+			return
+		}
+
+		resp, err := startSession(db, auth, c, user)
+		if err != nil {
+			fail("server_error")
+			return
+		}
+
+		c.Redirect(http.StatusFound, oidcResultURL(url.Values{
+			"token":        {resp.Token},
+			"refreshToken": {resp.RefreshToken},
+			"expiresIn":    {strconv.Itoa(resp.ExpiresIn)},
+			"sessionId":    {resp.SessionID},
+		}))
+	}
+}
+
+// userForIdentity returns the user an ID token signs in. Known identities
+// map to their user. A new identity links to the user with the same email
+// only when the provider verified it; otherwise a new account is created.
+func userForIdentity(db *gorm.DB, provider *OIDCProvider, claims *IDClaims) (User, error) {
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var identity UserIdentity
+		err := tx.Where("issuer = ? AND subject = ?", claims.Issuer, claims.Subject).First(&identity).Error
+		if err == nil {
+			tx.Model(&identity).Update("last_login_at", now)
+			return tx.First(&user, identity.UserID).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		email, verified := claims.VerifiedEmail()
+		if verified {
+			err := tx.Where("email = ?", email).First(&user).Error
+			switch {
+			case err == nil:
+				if user.EmailVerifiedAt == nil {
+					tx.Model(&user).Update("email_verified_at", now)
+				}
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				user = User{Email: email, Plan: "free", EmailVerifiedAt: &now}
+			default:
+				return err
+			}
+		} else {
+			// An unverified address may not belong to the user, so it never
+			// links to an existing account
+			email, ok := normalizeEmail(claims.Email)
+			if ok {
+				var taken int64
+				tx.Model(&User{}).Where("email = ?", email).Count(&taken)
+				if taken > 0 {
+					return errOIDCEmailTaken
+				}
+			} else {
+				email = provider.Name + ":" + claims.Subject
+			}
+			user = User{Email: email, Plan: "free"}
+		}
+
+		// New accounts have no password: they sign in through the provider, or
+		// set one with the forgot-password flow
+		if user.ID == 0 {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&UserIdentity{
+			UserID:      user.ID,
+			Provider:    provider.Name,
+			Issuer:      claims.Issuer,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: now,
+		}).Error
+	})
+	return user, err
+}
+
+// GetOIDCProviders lists the providers the web app can offer
+func GetOIDCProviders(providers OIDCProviders) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		names := sortedKeys(providers)
+		c.JSON(http.StatusOK, gin.H{"providers": names})
+	}
+}
+
+// GetIdentities lists the current user's linked identities
+func GetIdentities(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var identities []UserIdentity
+		db.Where("user_id = ?", userID).Order("created_at").Find(&identities)
+
+		c.JSON(http.StatusOK, gin.H{"identities": identities})
+	}
+}
+
+// DeleteIdentity unlinks an identity, as long as the user keeps a way to
+// sign in
+func DeleteIdentity(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+
+		var identity UserIdentity
+		if err := db.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&identity).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "identity not found"})
+			return
+		}
+
+		var count int64
+		db.Model(&UserIdentity{}).Where("user_id = ?", user.ID).Count(&count)
+		if count == 1 && user.PassHash == "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "set a password before removing your last sign-in method"})
+			return
+		}
+
+		if err := db.Delete(&identity).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlink identity"})
+			return
+		}
+		auditChange(c, "auth.identity_unlinked", "identity", identity.ID, identity, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "identity unlinked"})
+	}
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDC is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that checks PKCE and returns the configured ID token claims
+type mockOIDC struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDC{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk := SigningKey{ID: "mock", Public: &key.PublicKey}.jwk()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []interface{}{jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims)
+		token.Header["kid"] = "mock"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func TestOIDCLogin(t *testing.T) {
+	mock := newMockOIDC(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		code    string
+		claims  func(c jwt.MapClaims)
+		wantErr bool
+	}{
+		{"Valid", "good-code", func(c jwt.MapClaims) {}, false},
+		{"Wrong nonce", "good-code", func(c jwt.MapClaims) { c["nonce"] = "other" }, true},
+		{"Wrong audience", "good-code", func(c jwt.MapClaims) { c["aud"] = "someone-else" }, true},
+		{"Wrong issuer", "good-code", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, true},
+		{"Expired", "good-code", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, true},
+		{"Rejected code", "bad-code", func(c jwt.MapClaims) {}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &OIDCProvider{Name: "mock", Issuer: mock.URL, ClientID: "bandly", RedirectURL: "http://localhost:8080/cb", Scopes: []string{"openid", "email"}}
+			verifier, nonce := "verifier-123", oidcNonce("verifier-123")
+
+			authURL, err := p.AuthCodeURL(ctx, "state", nonce, verifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse(authURL)
+			if u.Query().Get("code_challenge_method") != "S256" || u.Query().Get("nonce") != nonce {
+				t.Fatalf("authorization URL %s", authURL)
+			}
+			mock.challenge = u.Query().Get("code_challenge")
+
+			mock.claims = jwt.MapClaims{
+				"iss": mock.URL, "aud": "bandly", "sub": "user-1", "nonce": nonce,
+				"email": "student@example.com", "email_verified": true,
+				"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+			}
+			tt.claims(mock.claims)
+
+			claims, err := p.Exchange(ctx, tt.code, verifier, nonce)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exchange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if email, ok := claims.VerifiedEmail(); !ok || email != "student@example.com" || claims.Subject != "user-1" {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
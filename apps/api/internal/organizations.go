@@ -0,0 +1,594 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Organization is a tenant (e.g. a language school). Users, essays, prompts,
+// blog posts and questions with a nil OrganizationID belong to the platform.
+type Organization struct {
+	ID                uint   `gorm:"primaryKey" json:"id"`
+	Name              string `gorm:"not null" json:"name"`
+	Slug              string `gorm:"uniqueIndex;not null" json:"slug"` // used at signup to join
+	Plan              string `gorm:"default:'school'" json:"plan"`
+	MaxUsers          int    `json:"maxUsers"`          // 0 = unlimited
+	MaxEssaysPerMonth int    `json:"maxEssaysPerMonth"` // 0 = unlimited
+	IsActive          bool   `gorm:"default:true" json:"isActive"`
+
+	// Report branding, empty values fall back to the BandLy defaults
+	LogoURL        string `json:"logoUrl"`
+	PrimaryColor   string `json:"primaryColor"`   // "#RRGGBB"
+	SecondaryColor string `json:"secondaryColor"` // "#RRGGBB", end of the OG image gradient
+	ReportFooter   string `json:"reportFooter"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Branding is the resolved look of reports and share images for a tenant
+type Branding struct {
+	Name      string
+	LogoURL   string
+	Primary   color.RGBA
+	Secondary color.RGBA
+	Footer    string
+}
+
+var defaultBranding = Branding{
+	Name:      "BandLy",
+	Primary:   color.RGBA{58, 122, 254, 255},
+	Secondary: color.RGBA{88, 192, 204, 255},
+	Footer:    "Generated by BandLy - SidigiGroup | info@sidiginesia.com",
+}
+
+var (
+	errOrgQuotaExceeded = errors.New("organization essay quota exceeded")
+	errOrgInactive      = errors.New("organization is inactive")
+
+	hexColorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	orgSlugRegex  = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,48}[a-z0-9]$`)
+)
+
+// TenantScope restricts a query to one tenant's rows; nil selects the platform
+func TenantScope(orgID *uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if orgID == nil {
+			return db.Where("organization_id IS NULL")
+		}
+		return db.Where("organization_id = ?", *orgID)
+	}
+}
+
+// sameTenant reports whether two organisation IDs name the same tenant
+func sameTenant(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// currentOrgID returns the tenant of the authenticated user or organisation
+// API key, nil for the platform or anonymous requests
+func currentOrgID(c *gin.Context) *uint {
+	if k, ok := c.Get("apiKey"); ok && k.(APIKey).UserID == nil {
+		return k.(APIKey).OrganizationID
+	}
+	if u, ok := c.Get("adminUser"); ok {
+		return u.(User).OrganizationID
+	}
+	if u, ok := c.Get("user"); ok {
+		return u.(User).OrganizationID
+	}
+	return nil
+}
+
+// parseHexColor parses "#RRGGBB", returning ok=false for anything else
+func parseHexColor(s string) (color.RGBA, bool) {
+	if !hexColorRegex.MatchString(s) {
+		return color.RGBA{}, false
+	}
+	v, _ := strconv.ParseUint(s[1:], 16, 32)
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, true
+}
+
+// brandingOf merges an organisation's settings over the defaults
+func brandingOf(org *Organization) Branding {
+	b := defaultBranding
+	if org == nil {
+		return b
+	}
+	b.Name = org.Name
+	b.LogoURL = org.LogoURL
+	if c, ok := parseHexColor(org.PrimaryColor); ok {
+		b.Primary = c
+	}
+	if c, ok := parseHexColor(org.SecondaryColor); ok {
+		b.Secondary = c
+	}
+	if org.ReportFooter != "" {
+		b.Footer = org.ReportFooter
+	}
+	return b
+}
+
+// loadBranding returns the branding of a tenant, defaults for the platform
+func loadBranding(db *gorm.DB, orgID *uint) Branding {
+	if orgID == nil {
+		return defaultBranding
+	}
+	var org Organization
+	if err := db.First(&org, *orgID).Error; err != nil {
+		return defaultBranding
+	}
+	return brandingOf(&org)
+}
+
+// logoClient fetches tenant logos. Logo URLs are set by tenant admins, so
+// its dialer refuses anything but public addresses, redirects included.
+var logoClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// dialPublicOnly rejects connections to loopback, private, link-local and
+// other non-routable addresses. It runs after DNS resolution, so a public
+// name pointing inside the network is refused too.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("logo fetch: address %s is not public", ip)
+	}
+	return nil
+}
+
+// fetchLogo downloads a tenant logo for embedding in a PDF
+func fetchLogo(ctx context.Context, url string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := logoClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("logo fetch: status %d", resp.StatusCode)
+	}
+
+	var imageType string
+	switch ct := resp.Header.Get("Content-Type"); {
+	case strings.Contains(ct, "png"):
+		imageType = "PNG"
+	case strings.Contains(ct, "jpeg"), strings.Contains(ct, "jpg"):
+		imageType = "JPG"
+	default:
+		return nil, "", fmt.Errorf("logo fetch: unsupported content type %q", ct)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 2<<20))
+	return data, imageType, err
+}
+
+// scoringGuidance returns the tenant's active scoring prompt override, if any
+func scoringGuidance(db *gorm.DB, orgID *uint) string {
+	if orgID == nil {
+		return ""
+	}
+	var prompt AdminPrompt
+	if err := db.Scopes(TenantScope(orgID)).
+		Where("type = ? AND is_active = ?", "scoring", true).
+		Order("updated_at DESC").
+		First(&prompt).Error; err != nil {
+		return ""
+	}
+	return prompt.Prompt
+}
+
+// checkOrgQuota enforces the tenant's monthly essay limit
+func checkOrgQuota(db *gorm.DB, orgID *uint) error {
+	if orgID == nil {
+		return nil
+	}
+	var org Organization
+	if err := db.First(&org, *orgID).Error; err != nil {
+		return nil
+	}
+	if !org.IsActive {
+		return errOrgInactive
+	}
+	if org.MaxEssaysPerMonth == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	var used int64
+	db.Model(&Essay{}).Scopes(TenantScope(orgID)).Where("created_at >= ?", monthStart).Count(&used)
+	if used >= int64(org.MaxEssaysPerMonth) {
+		return errOrgQuotaExceeded
+	}
+	return nil
+}
+
+// orgHasSeat reports whether a tenant can take another user
+func orgHasSeat(db *gorm.DB, org Organization) bool {
+	if org.MaxUsers == 0 {
+		return true
+	}
+	var users int64
+	db.Model(&User{}).Where("organization_id = ?", org.ID).Count(&users)
+	return users < int64(org.MaxUsers)
+}
+
+type OrganizationRequest struct {
+	Name              string `json:"name" binding:"required"`
+	Slug              string `json:"slug"`
+	Plan              string `json:"plan"`
+	MaxUsers          int    `json:"maxUsers"`
+	MaxEssaysPerMonth int    `json:"maxEssaysPerMonth"`
+	IsActive          bool   `json:"isActive"`
+	BrandingRequest
+}
+
+// OrganizationUpdate changes only the fields present in the request
+type OrganizationUpdate struct {
+	Name              *string `json:"name"`
+	Plan              *string `json:"plan"`
+	MaxUsers          *int    `json:"maxUsers"`
+	MaxEssaysPerMonth *int    `json:"maxEssaysPerMonth"`
+	IsActive          *bool   `json:"isActive"`
+	LogoURL           *string `json:"logoUrl"`
+	PrimaryColor      *string `json:"primaryColor"`
+	SecondaryColor    *string `json:"secondaryColor"`
+	ReportFooter      *string `json:"reportFooter"`
+}
+
+// updates validates the request and returns the columns it sets, or a
+// message for the first problem
+func (r OrganizationUpdate) updates() (map[string]interface{}, string) {
+	updates := map[string]interface{}{}
+	if r.Name != nil {
+		if strings.TrimSpace(*r.Name) == "" {
+			return nil, "name must not be empty"
+		}
+		updates["name"] = *r.Name
+	}
+	if r.Plan != nil {
+		if *r.Plan == "" {
+			return nil, "plan must not be empty"
+		}
+		updates["plan"] = *r.Plan
+	}
+	for column, limit := range map[string]*int{"max_users": r.MaxUsers, "max_essays_per_month": r.MaxEssaysPerMonth} {
+		if limit != nil {
+			if *limit < 0 {
+				return nil, "limits must not be negative"
+			}
+			updates[column] = *limit
+		}
+	}
+	if r.IsActive != nil {
+		updates["is_active"] = *r.IsActive
+	}
+
+	var branding BrandingRequest
+	for column, field := range map[string]struct{ from, to *string }{
+		"logo_url":        {r.LogoURL, &branding.LogoURL},
+		"primary_color":   {r.PrimaryColor, &branding.PrimaryColor},
+		"secondary_color": {r.SecondaryColor, &branding.SecondaryColor},
+		"report_footer":   {r.ReportFooter, &branding.ReportFooter},
+	} {
+		if field.from != nil {
+			*field.to = *field.from
+			updates[column] = *field.from
+		}
+	}
+	if msg := branding.validate(); msg != "" {
+		return nil, msg
+	}
+	return updates, ""
+}
+
+type BrandingRequest struct {
+	LogoURL        string `json:"logoUrl"`
+	PrimaryColor   string `json:"primaryColor"`
+	SecondaryColor string `json:"secondaryColor"`
+	ReportFooter   string `json:"reportFooter"`
+}
+
+// validate checks branding values, returning a message for the first problem
+func (r BrandingRequest) validate() string {
+	for _, c := range []string{r.PrimaryColor, r.SecondaryColor} {
+		if c != "" && !hexColorRegex.MatchString(c) {
+			return "colours must be in #RRGGBB format"
+		}
+	}
+	if r.LogoURL != "" && !strings.HasPrefix(r.LogoURL, "https://") {
+		return "logoUrl must be an https URL"
+	}
+	if len(r.ReportFooter) > 200 {
+		return "reportFooter must be at most 200 characters"
+	}
+	return ""
+}
+
+// GetOrganizations lists all tenants with their user counts
+func GetOrganizations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var orgs []Organization
+		db.Order("created_at DESC").Find(&orgs)
+
+		var counts []struct {
+			OrganizationID uint
+			Users          int64
+		}
+		db.Model(&User{}).Select("organization_id, COUNT(*) AS users").
+			Where("organization_id IS NOT NULL").Group("organization_id").Scan(&counts)
+		users := map[uint]int64{}
+		for _, row := range counts {
+			users[row.OrganizationID] = row.Users
+		}
+
+		out := make([]gin.H, len(orgs))
+		for i, org := range orgs {
+			out[i] = gin.H{"organization": org, "users": users[org.ID]}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"organizations": out})
+	}
+}
+
+// CreateOrganization creates a tenant
+func CreateOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req OrganizationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		if req.Slug == "" {
+			req.Slug = generateSlug(req.Name)
+		}
+		if !orgSlugRegex.MatchString(req.Slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "slug must be 3-50 lowercase letters, digits or hyphens"})
+			return
+		}
+		if msg := req.BrandingRequest.validate(); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if req.MaxUsers < 0 || req.MaxEssaysPerMonth < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limits must not be negative"})
+			return
+		}
+
+		var existing Organization
+		if err := db.Where("slug = ?", req.Slug).First(&existing).Error; err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "slug already exists"})
+			return
+		}
+
+		org := Organization{
+			Name:              req.Name,
+			Slug:              req.Slug,
+			Plan:              req.Plan,
+			MaxUsers:          req.MaxUsers,
+			MaxEssaysPerMonth: req.MaxEssaysPerMonth,
+			IsActive:          true,
+			LogoURL:           req.LogoURL,
+			PrimaryColor:      req.PrimaryColor,
+			SecondaryColor:    req.SecondaryColor,
+			ReportFooter:      req.ReportFooter,
+		}
+		if org.Plan == "" {
+			org.Plan = "school"
+		}
+
+		if err := db.Create(&org).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create organization"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"organization": org})
+	}
+}
+
+// UpdateOrganization updates a tenant's plan, limits and branding. Fields
+// left out of the request keep their values.
+func UpdateOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req OrganizationUpdate
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		updates, msg := req.updates()
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if len(updates) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+			return
+		}
+
+		var org Organization
+		if err := db.First(&org, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+			return
+		}
+
+		if err := db.Model(&org).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update organization"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "organization updated successfully"})
+	}
+}
+
+// CreateOrganizationAdmin creates the admin account for a tenant
+func CreateOrganizationAdmin(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email    string `json:"email" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+		// Same rules as the platform admin created by setup or the CLI
+		email, ok := normalizeEmail(req.Email)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidEmail.Error()})
+			return
+		}
+		if !validAdminPassword(req.Password) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errWeakAdminPassword.Error()})
+			return
+		}
+
+		var org Organization
+		if err := db.First(&org, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+			return
+		}
+		if !orgHasSeat(db, org) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "organization has no free seats"})
+			return
+		}
+
+		var existing User
+		if err := db.Where("email = ?", email).First(&existing).Error; err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "email already exists"})
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process password"})
+			return
+		}
+
+		user := User{
+			Email:          email,
+			PassHash:       string(hash),
+			Plan:           org.Plan,
+			Role:           RoleAdmin,
+			OrganizationID: &org.ID,
+		}
+		if err := db.Create(&user).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"user": UserInfo{
+			ID:             user.ID,
+			Email:          user.Email,
+			Plan:           user.Plan,
+			Role:           user.Role,
+			OrganizationID: user.OrganizationID,
+		}})
+	}
+}
+
+// GetMyOrganization returns the tenant admin's organisation and its usage
+func GetMyOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID := currentOrgID(c)
+		if orgID == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not an organization admin"})
+			return
+		}
+
+		var org Organization
+		if err := db.First(&org, *orgID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+			return
+		}
+
+		now := time.Now()
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		var users, essays int64
+		db.Model(&User{}).Scopes(TenantScope(orgID)).Count(&users)
+		db.Model(&Essay{}).Scopes(TenantScope(orgID)).Where("created_at >= ?", monthStart).Count(&essays)
+
+		c.JSON(http.StatusOK, gin.H{
+			"organization": org,
+			"usage": gin.H{
+				"users":             users,
+				"essaysThisMonth":   essays,
+				"maxUsers":          org.MaxUsers,
+				"maxEssaysPerMonth": org.MaxEssaysPerMonth,
+			},
+		})
+	}
+}
+
+// UpdateMyBranding lets a tenant admin change their report branding
+func UpdateMyBranding(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID := currentOrgID(c)
+		if orgID == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not an organization admin"})
+			return
+		}
+
+		var req BrandingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		updates := map[string]interface{}{
+			"logo_url":        req.LogoURL,
+			"primary_color":   req.PrimaryColor,
+			"secondary_color": req.SecondaryColor,
+			"report_footer":   req.ReportFooter,
+		}
+		if err := db.Model(&Organization{}).Where("id = ?", *orgID).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update branding"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "branding updated successfully"})
+	}
+}
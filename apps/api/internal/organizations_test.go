@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBrandingOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		org     *Organization
+		primary color.RGBA
+		footer  string
+	}{
+		{
+			name:    "Platform defaults",
+			org:     nil,
+			primary: defaultBranding.Primary,
+			footer:  defaultBranding.Footer,
+		},
+		{
+			name:    "Custom colours and footer",
+			org:     &Organization{Name: "Acme English", PrimaryColor: "#10a0FF", ReportFooter: "Acme English School"},
+			primary: color.RGBA{0x10, 0xa0, 0xff, 255},
+			footer:  "Acme English School",
+		},
+		{
+			name:    "Invalid colour falls back",
+			org:     &Organization{Name: "Acme English", PrimaryColor: "blue"},
+			primary: defaultBranding.Primary,
+			footer:  defaultBranding.Footer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := brandingOf(tt.org)
+			if b.Primary != tt.primary {
+				t.Errorf("primary = %v, want %v", b.Primary, tt.primary)
+			}
+			if b.Footer != tt.footer {
+				t.Errorf("footer = %q, want %q", b.Footer, tt.footer)
+			}
+			if b.Secondary != defaultBranding.Secondary {
+				t.Errorf("secondary = %v, want default", b.Secondary)
+			}
+		})
+	}
+}
+
+func TestDialPublicOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:443", false},
+		{"10.0.0.5:443", false},
+		{"192.168.1.1:443", false},
+		{"169.254.169.254:80", false},
+		{"0.0.0.0:443", false},
+		{"[::1]:443", false},
+		{"[::ffff:127.0.0.1]:443", false},
+		{"[fd00::1]:443", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if err := dialPublicOnly("tcp", tt.address, nil); (err == nil) != tt.allowed {
+				t.Errorf("dialPublicOnly(%q) error = %v, allowed %v", tt.address, err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestFetchLogoRefusesLoopback(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	if _, _, err := fetchLogo(context.Background(), srv.URL); err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("fetchLogo(%s) error = %v, want a refused address", srv.URL, err)
+	}
+}
+
+func TestCreateOrganizationAdmin(t *testing.T) {
+	db := openTestDB(t)
+	org := Organization{Name: "Acme English", Slug: "acme", Plan: "school"}
+	if err := db.Create(&org).Error; err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/organizations/:id/admin", CreateOrganizationAdmin(db))
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"Short password", `{"email":"head@acme.test","password":"12345678"}`, http.StatusBadRequest},
+		{"Known default", `{"email":"head@acme.test","password":"ChangeMe"}`, http.StatusBadRequest},
+		{"Display name in email", `{"email":"Head <head@acme.test>","password":"a long passphrase"}`, http.StatusBadRequest},
+		{"Created", `{"email":" head@acme.test ","password":"a long passphrase"}`, http.StatusCreated},
+		{"Duplicate", `{"email":"head@acme.test","password":"a long passphrase"}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			path := fmt.Sprintf("/organizations/%d/admin", org.ID)
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, tt.wantCode)
+			}
+		})
+	}
+
+	var admin User
+	if err := db.Where("email = ?", "head@acme.test").First(&admin).Error; err != nil {
+		t.Fatalf("admin not stored under the trimmed email: %v", err)
+	}
+	if admin.Role != RoleAdmin || admin.OrganizationID == nil || *admin.OrganizationID != org.ID {
+		t.Errorf("admin = %+v", admin)
+	}
+}
+
+func TestUpdateOrganization(t *testing.T) {
+	db := openTestDB(t)
+	org := Organization{Name: "Acme English", Slug: "acme", Plan: "school", MaxUsers: 50, IsActive: true, PrimaryColor: "#112233"}
+	if err := db.Create(&org).Error; err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/organizations/:id", UpdateOrganization(db))
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"Nothing sent", `{}`, http.StatusBadRequest},
+		{"Blank name", `{"name":" "}`, http.StatusBadRequest},
+		{"Negative limit", `{"maxEssaysPerMonth":-1}`, http.StatusBadRequest},
+		{"Bad colour", `{"secondaryColor":"red"}`, http.StatusBadRequest},
+		{"Limit only", `{"maxUsers":80}`, http.StatusOK},
+		{"Footer only", `{"reportFooter":"Acme English Ltd"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			path := fmt.Sprintf("/organizations/%d", org.ID)
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, path, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, tt.wantCode)
+			}
+		})
+	}
+
+	// Fields left out of a request keep their values
+	var stored Organization
+	db.First(&stored, org.ID)
+	if !stored.IsActive || stored.Name != org.Name || stored.Plan != org.Plan || stored.PrimaryColor != org.PrimaryColor {
+		t.Errorf("untouched fields changed: %+v", stored)
+	}
+	if stored.MaxUsers != 80 || stored.ReportFooter != "Acme English Ltd" {
+		t.Errorf("maxUsers, reportFooter = %d, %q", stored.MaxUsers, stored.ReportFooter)
+	}
+
+	t.Run("Deactivate", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, fmt.Sprintf("/organizations/%d", org.ID), strings.NewReader(`{"isActive":false}`)))
+		db.First(&stored, org.ID)
+		if rec.Code != http.StatusOK || stored.IsActive {
+			t.Errorf("status = %d, isActive = %v", rec.Code, stored.IsActive)
+		}
+	})
+}
@@ -0,0 +1,370 @@
+package internal
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PeerReview is one classmate's anonymous assessment of an assignment submission
+type PeerReview struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	AssignmentID uint       `gorm:"index" json:"assignmentId"`
+	EssayID      uint       `gorm:"uniqueIndex:idx_peer_review" json:"-"`
+	ReviewerID   uint       `gorm:"uniqueIndex:idx_peer_review;index" json:"-"`
+	TA           float32    `json:"ta"`
+	CC           float32    `json:"cc"`
+	LR           float32    `json:"lr"`
+	GRA          float32    `json:"gra"`
+	Comment      string     `gorm:"type:TEXT" json:"comment"`
+	SubmittedAt  *time.Time `json:"submittedAt,omitempty"` // nil while pending
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+type ReviewerAccuracy struct {
+	ReviewerID   uint    `json:"reviewerId"`
+	Email        string  `json:"email,omitempty"`
+	Assigned     int     `json:"assigned"`
+	Completed    int     `json:"completed"`
+	MeanAbsError float64 `json:"meanAbsError"` // bands per criterion
+	Agreement    float64 `json:"agreement"`    // share of criteria within 0.5 of consensus
+	Score        int     `json:"score"`        // 0-100
+}
+
+const maxPeerReviewers = 5
+
+// allocatePeerReviews hands each submission to k other authors. Authors are
+// shuffled into a ring and each reviews the k essays after their own, so every
+// essay gets k reviews and every reviewer writes k.
+func allocatePeerReviews(essays []Essay, k int, rng *rand.Rand) []PeerReview {
+	var pool []Essay
+	for _, e := range essays {
+		if e.UserID != nil {
+			pool = append(pool, e)
+		}
+	}
+	n := len(pool)
+	if k > n-1 {
+		k = n - 1
+	}
+	if k <= 0 {
+		return nil
+	}
+
+	rng.Shuffle(n, func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	reviews := make([]PeerReview, 0, n*k)
+	for i, reviewer := range pool {
+		for offset := 1; offset <= k; offset++ {
+			essay := pool[(i+offset)%n]
+			reviews = append(reviews, PeerReview{
+				EssayID:    essay.ID,
+				ReviewerID: *reviewer.UserID,
+			})
+		}
+	}
+	return reviews
+}
+
+// ensurePeerReviews allocates reviewers once an assignment with peer review
+// enabled is past its deadline. Claiming PeerReviewsAssignedAt first makes
+// concurrent callers allocate only once.
+func ensurePeerReviews(db *gorm.DB, assignment *Assignment) error {
+	if assignment.PeerReviewers == 0 || assignment.PeerReviewsAssignedAt != nil || time.Now().Before(assignment.DueAt) {
+		return nil
+	}
+
+	var reviews []PeerReview
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		claim := tx.Model(&Assignment{}).
+			Where("id = ? AND peer_reviews_assigned_at IS NULL", assignment.ID).
+			Update("peer_reviews_assigned_at", now)
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			return claim.Error
+		}
+
+		var essays []Essay
+		if err := tx.Where("assignment_id = ?", assignment.ID).Order("id ASC").Find(&essays).Error; err != nil {
+			return err
+		}
+		reviews = allocatePeerReviews(essays, assignment.PeerReviewers, rand.New(rand.NewSource(now.UnixNano())))
+		if len(reviews) == 0 {
+			return nil
+		}
+		for i := range reviews {
+			reviews[i].AssignmentID = assignment.ID
+		}
+		return tx.Create(&reviews).Error
+	})
+	if err != nil {
+		return err
+	}
+	assignment.PeerReviewsAssignedAt = &now
+
+	notified := map[uint]bool{}
+	for _, r := range reviews {
+		if !notified[r.ReviewerID] {
+			notified[r.ReviewerID] = true
+			Notify(db, r.ReviewerID, "peer_review_assigned", "Peer review: "+assignment.Title,
+				"Classmates' essays are ready for you to review.", nil)
+		}
+	}
+	return nil
+}
+
+// consensusBands returns the AI bands with any teacher overrides applied
+func consensusBands(db *gorm.DB, essay Essay) map[string]float32 {
+	_, effective, _ := teacherBands(db, essay)
+	ai := essayScore(essay)
+	bands := map[string]float32{}
+	for _, key := range scoreCriteria {
+		if band, ok := effective[key]; ok {
+			bands[key] = band
+		} else {
+			bands[key] = criterionBand(ai, key)
+		}
+	}
+	return bands
+}
+
+// reviewBands returns the criterion bands of a submitted review
+func reviewBands(r PeerReview) map[string]float32 {
+	return map[string]float32{"ta": r.TA, "cc": r.CC, "lr": r.LR, "gra": r.GRA}
+}
+
+// scoreReviewers rates each reviewer against the consensus bands of the essays
+// they reviewed. Score falls linearly from 100 at an exact match to 0 at a
+// mean error of 3 bands.
+func scoreReviewers(reviews []PeerReview, consensus map[uint]map[string]float32) []ReviewerAccuracy {
+	type acc struct {
+		ReviewerAccuracy
+		errSum float64
+		within int
+		n      int
+	}
+	byReviewer := map[uint]*acc{}
+	var order []uint
+	for _, r := range reviews {
+		a, ok := byReviewer[r.ReviewerID]
+		if !ok {
+			a = &acc{ReviewerAccuracy: ReviewerAccuracy{ReviewerID: r.ReviewerID}}
+			byReviewer[r.ReviewerID] = a
+			order = append(order, r.ReviewerID)
+		}
+		a.Assigned++
+		if r.SubmittedAt == nil {
+			continue
+		}
+		a.Completed++
+
+		target, ok := consensus[r.EssayID]
+		if !ok {
+			continue
+		}
+		for key, band := range reviewBands(r) {
+			diff := math.Abs(float64(band - target[key]))
+			a.errSum += diff
+			if diff <= 0.5 {
+				a.within++
+			}
+			a.n++
+		}
+	}
+
+	out := make([]ReviewerAccuracy, 0, len(order))
+	for _, id := range order {
+		a := byReviewer[id]
+		if a.n > 0 {
+			a.MeanAbsError = roundTo(a.errSum/float64(a.n), 2)
+			a.Agreement = roundTo(float64(a.within)/float64(a.n), 2)
+			a.Score = int(math.Round(100 * (1 - math.Min(a.MeanAbsError, 3)/3)))
+		}
+		out = append(out, a.ReviewerAccuracy)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
+
+// peerFeedback aggregates the submitted peer reviews of an essay, or nil when
+// there are none. Reviewers stay anonymous.
+func peerFeedback(db *gorm.DB, essay Essay) gin.H {
+	var reviews []PeerReview
+	db.Where("essay_id = ? AND submitted_at IS NOT NULL", essay.ID).Order("submitted_at ASC").Find(&reviews)
+	if len(reviews) == 0 {
+		return nil
+	}
+
+	bands := map[string]float64{}
+	comments := []string{}
+	for _, r := range reviews {
+		for key, band := range reviewBands(r) {
+			bands[key] += float64(band) / float64(len(reviews))
+		}
+		if r.Comment != "" {
+			comments = append(comments, r.Comment)
+		}
+	}
+	var sum float64
+	for _, key := range scoreCriteria {
+		bands[key] = roundTo(bands[key], 2)
+		sum += bands[key]
+	}
+
+	return gin.H{
+		"reviews":  len(reviews),
+		"bands":    bands,
+		"overall":  clampBand(float32(sum / 4)),
+		"comments": comments,
+	}
+}
+
+// GetPeerReviews lists peer reviews for an assignment. Students get the
+// anonymous essays they must review; the teacher gets every review and each
+// reviewer's accuracy against the consensus bands.
+func GetPeerReviews(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		assignment, class, question, ok := loadAssignment(db, c)
+		if !ok {
+			return
+		}
+		if assignment.PeerReviewers == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "peer review is not enabled for this assignment"})
+			return
+		}
+		if time.Now().Before(assignment.DueAt) {
+			c.JSON(http.StatusOK, gin.H{"reviews": []PeerReview{}, "opensAt": assignment.DueAt})
+			return
+		}
+		if err := ensurePeerReviews(db, &assignment); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to assign peer reviews"})
+			return
+		}
+
+		if class.TeacherID == userID {
+			var reviews []PeerReview
+			db.Where("assignment_id = ?", assignment.ID).Order("essay_id ASC, id ASC").Find(&reviews)
+
+			var essays []Essay
+			db.Where("assignment_id = ?", assignment.ID).Find(&essays)
+			consensus := map[uint]map[string]float32{}
+			for _, e := range essays {
+				consensus[e.ID] = consensusBands(db, e)
+			}
+
+			var students []User
+			db.Select("id", "email").Where("id IN (?)",
+				db.Model(&ClassMember{}).Select("user_id").Where("classroom_id = ?", class.ID)).Find(&students)
+			emails := map[uint]string{}
+			for _, s := range students {
+				emails[s.ID] = s.Email
+			}
+
+			rows := make([]gin.H, len(reviews))
+			for i, r := range reviews {
+				rows[i] = gin.H{"review": r, "essayId": r.EssayID, "reviewerId": r.ReviewerID}
+			}
+			reviewers := scoreReviewers(reviews, consensus)
+			for i := range reviewers {
+				reviewers[i].Email = emails[reviewers[i].ReviewerID]
+			}
+
+			c.JSON(http.StatusOK, gin.H{"reviews": rows, "reviewers": reviewers})
+			return
+		}
+
+		var reviews []PeerReview
+		db.Where("assignment_id = ? AND reviewer_id = ?", assignment.ID, userID).Order("id ASC").Find(&reviews)
+
+		essayIDs := make([]uint, len(reviews))
+		for i, r := range reviews {
+			essayIDs[i] = r.EssayID
+		}
+		texts := map[uint]Essay{}
+		if len(essayIDs) > 0 {
+			var essays []Essay
+			db.Select("id", "text", "task_type").Where("id IN ?", essayIDs).Find(&essays)
+			for _, e := range essays {
+				texts[e.ID] = e
+			}
+		}
+
+		items := make([]gin.H, len(reviews))
+		for i, r := range reviews {
+			items[i] = gin.H{"review": r, "text": texts[r.EssayID].Text, "taskType": texts[r.EssayID].TaskType}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"reviews":     items,
+			"question":    question,
+			"descriptors": BandDescriptors,
+		})
+	}
+}
+
+// SubmitPeerReview records a student's bands and comment for an assigned review
+func SubmitPeerReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var req struct {
+			TA      *float32 `json:"ta" binding:"required"`
+			CC      *float32 `json:"cc" binding:"required"`
+			LR      *float32 `json:"lr" binding:"required"`
+			GRA     *float32 `json:"gra" binding:"required"`
+			Comment string   `json:"comment"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ta, cc, lr and gra are required"})
+			return
+		}
+		for _, band := range []float32{*req.TA, *req.CC, *req.LR, *req.GRA} {
+			if band < 0 || band > 9 || clampBand(band) != band {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bands must be between 0 and 9 in 0.5 steps"})
+				return
+			}
+		}
+
+		var review PeerReview
+		if err := db.Where("id = ? AND assignment_id = ? AND reviewer_id = ?", c.Param("reviewId"), c.Param("id"), userID).
+			First(&review).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "peer review not found"})
+			return
+		}
+		if review.SubmittedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "peer review already submitted"})
+			return
+		}
+
+		now := time.Now()
+		review.TA, review.CC, review.LR, review.GRA = *req.TA, *req.CC, *req.LR, *req.GRA
+		review.Comment = req.Comment
+		review.SubmittedAt = &now
+		// Only the first of concurrent or retried submits writes the review
+		result := db.Model(&review).Where("submitted_at IS NULL").
+			Select("ta", "cc", "lr", "gra", "comment", "submitted_at").Updates(&review)
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save peer review"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "peer review already submitted"})
+			return
+		}
+
+		var essay Essay
+		if err := db.Select("id", "user_id").First(&essay, review.EssayID).Error; err == nil && essay.UserID != nil {
+			Notify(db, *essay.UserID, "peer_review", "New peer review",
+				"A classmate has reviewed your essay.", &essay.ID)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"review": review})
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAllocatePeerReviews(t *testing.T) {
+	var essays []Essay
+	for i := uint(1); i <= 5; i++ {
+		author := i * 10
+		essays = append(essays, Essay{ID: i, UserID: &author})
+	}
+
+	tests := []struct {
+		name string
+		k    int
+		want int // reviews per essay
+	}{
+		{"Two reviewers each", 2, 2},
+		{"Capped at class size minus one", 9, 4},
+		{"Disabled", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reviews := allocatePeerReviews(append([]Essay{}, essays...), tt.k, rand.New(rand.NewSource(1)))
+
+			perEssay := map[uint]int{}
+			perReviewer := map[uint]int{}
+			for _, r := range reviews {
+				if r.ReviewerID == r.EssayID*10 {
+					t.Errorf("reviewer %d assigned their own essay", r.ReviewerID)
+				}
+				perEssay[r.EssayID]++
+				perReviewer[r.ReviewerID]++
+			}
+			if len(reviews) != tt.want*len(essays) {
+				t.Fatalf("got %d reviews, want %d", len(reviews), tt.want*len(essays))
+			}
+			for id, n := range perEssay {
+				if n != tt.want {
+					t.Errorf("essay %d has %d reviews, want %d", id, n, tt.want)
+				}
+			}
+			for id, n := range perReviewer {
+				if n != tt.want {
+					t.Errorf("reviewer %d writes %d reviews, want %d", id, n, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestScoreReviewers(t *testing.T) {
+	now := time.Now()
+	consensus := map[uint]map[string]float32{
+		1: {"ta": 7, "cc": 6.5, "lr": 6, "gra": 6},
+	}
+	reviews := []PeerReview{
+		{EssayID: 1, ReviewerID: 10, TA: 7, CC: 6.5, LR: 6, GRA: 6, SubmittedAt: &now},
+		{EssayID: 1, ReviewerID: 20, TA: 8, CC: 7.5, LR: 7, GRA: 7, SubmittedAt: &now},
+		{EssayID: 1, ReviewerID: 30},
+	}
+
+	got := scoreReviewers(reviews, consensus)
+	if len(got) != 3 {
+		t.Fatalf("got %d reviewers, want 3", len(got))
+	}
+
+	exact, off, pending := got[0], got[1], got[2]
+	if exact.ReviewerID != 10 || exact.Score != 100 || exact.Agreement != 1 {
+		t.Errorf("exact reviewer = %+v", exact)
+	}
+	if off.ReviewerID != 20 || off.MeanAbsError != 1 || off.Agreement != 0 || off.Score != 67 {
+		t.Errorf("off-by-one reviewer = %+v", off)
+	}
+	if pending.ReviewerID != 30 || pending.Completed != 0 || pending.Score != 0 {
+		t.Errorf("pending reviewer = %+v", pending)
+	}
+}
+
+func TestSubmitPeerReview(t *testing.T) {
+	f := newClassFixture(t)
+	essay := f.submitEssay(t)
+	review := PeerReview{AssignmentID: f.open.ID, EssayID: essay.ID, ReviewerID: f.outsider.ID}
+	f.db.Create(&review)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/:id/peer-reviews/:reviewId", func(c *gin.Context) {
+		c.Set("userID", f.outsider.ID)
+	}, SubmitPeerReview(f.db))
+	path := fmt.Sprintf("/%d/peer-reviews/%d", f.open.ID, review.ID)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"Not a half band", `{"ta":7.2,"cc":7,"lr":7,"gra":7}`, http.StatusBadRequest},
+		{"Submitted", `{"ta":7,"cc":6.5,"lr":7,"gra":6,"comment":"Clear"}`, http.StatusOK},
+		{"Submitted again", `{"ta":9,"cc":9,"lr":9,"gra":9}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, tt.wantCode)
+			}
+		})
+	}
+
+	var stored PeerReview
+	f.db.First(&stored, review.ID)
+	if stored.TA != 7 || stored.Comment != "Clear" {
+		t.Errorf("review = %+v, want the first submission kept", stored)
+	}
+}
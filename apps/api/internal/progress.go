@@ -0,0 +1,450 @@
+package internal
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// scoreCriteria are the four IELTS writing criteria in report order
+var scoreCriteria = []string{"ta", "cc", "lr", "gra"}
+
+// criterionBand returns the band for a criterion key ("overall" included)
+func criterionBand(s ScoreOut, key string) float32 {
+	switch key {
+	case "ta":
+		return s.TA
+	case "cc":
+		return s.CC
+	case "lr":
+		return s.LR
+	case "gra":
+		return s.GRA
+	default:
+		return s.Overall
+	}
+}
+
+// essayScore parses the stored bands of an essay, falling back to Overall
+func essayScore(e Essay) ScoreOut {
+	var s ScoreOut
+	FromJSON(e.BandsJSON, &s)
+	if s.Overall == 0 {
+		s.Overall = e.Overall
+	}
+	return s
+}
+
+type ProgressPoint struct {
+	EssayID       uint      `json:"essayId"`
+	PublicID      string    `json:"publicId"`
+	Date          time.Time `json:"date"`
+	Band          float32   `json:"band"`
+	MovingAverage float64   `json:"movingAverage"`
+}
+
+type TrendLine struct {
+	SlopePerWeek float64 `json:"slopePerWeek"`
+	Intercept    float64 `json:"intercept"`
+	R2           float64 `json:"r2"`
+	Samples      int     `json:"samples"`
+}
+
+type ConfidencePoint struct {
+	Date   time.Time `json:"date"`
+	Fitted float64   `json:"fitted"`
+	Lower  float64   `json:"lower"`
+	Upper  float64   `json:"upper"`
+}
+
+type CriterionProgress struct {
+	Points         []ProgressPoint   `json:"points"`
+	Trend          *TrendLine        `json:"trend"`
+	ConfidenceBand []ConfidencePoint `json:"confidenceBand"`
+}
+
+type BandAverages struct {
+	Key     string  `json:"key"`
+	Essays  int     `json:"essays"`
+	TA      float64 `json:"ta"`
+	CC      float64 `json:"cc"`
+	LR      float64 `json:"lr"`
+	GRA     float64 `json:"gra"`
+	Overall float64 `json:"overall"`
+}
+
+type ErrorCategoryStat struct {
+	Criterion  string    `json:"criterion"`
+	Category   string    `json:"category"`
+	Count      int       `json:"count"`
+	Essays     int       `json:"essays"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Example    string    `json:"example,omitempty"`
+	Suggestion string    `json:"suggestion,omitempty"`
+}
+
+type TargetProjection struct {
+	TargetBand    float64    `json:"targetBand"`
+	CurrentBand   float64    `json:"currentBand"`
+	SlopePerWeek  float64    `json:"slopePerWeek"`
+	Reached       bool       `json:"reached"`
+	WeeksToTarget *float64   `json:"weeksToTarget,omitempty"`
+	ProjectedDate *time.Time `json:"projectedDate,omitempty"`
+	Reason        string     `json:"reason,omitempty"`
+}
+
+// regression is an ordinary least squares fit of y on x
+type regression struct {
+	slope     float64
+	intercept float64
+	r2        float64
+	n         int
+	meanX     float64
+	sxx       float64
+	se        float64 // residual standard error
+}
+
+// fitRegression fits a line through the points; ok is false when there are
+// fewer than two points or all x values are equal
+func fitRegression(xs, ys []float64) (regression, bool) {
+	n := len(xs)
+	if n < 2 || n != len(ys) {
+		return regression{}, false
+	}
+
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/float64(n), sumY/float64(n)
+
+	var sxx, sxy, syy float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		return regression{}, false
+	}
+
+	r := regression{n: n, meanX: meanX, sxx: sxx}
+	r.slope = sxy / sxx
+	r.intercept = meanY - r.slope*meanX
+
+	var sse float64
+	for i := range xs {
+		res := ys[i] - r.at(xs[i])
+		sse += res * res
+	}
+	if syy > 0 {
+		r.r2 = 1 - sse/syy
+	} else {
+		r.r2 = 1
+	}
+	if n > 2 {
+		r.se = math.Sqrt(sse / float64(n-2))
+	}
+	return r, true
+}
+
+func (r regression) at(x float64) float64 {
+	return r.intercept + r.slope*x
+}
+
+// interval returns the 95% confidence interval of the fitted mean at x
+func (r regression) interval(x float64) (lower, upper float64) {
+	fitted := r.at(x)
+	if r.n < 3 {
+		return fitted, fitted
+	}
+	dx := x - r.meanX
+	margin := tCritical95(r.n-2) * r.se * math.Sqrt(1/float64(r.n)+dx*dx/r.sxx)
+	return fitted - margin, fitted + margin
+}
+
+// tCritical95 returns the two-sided 95% Student t critical value
+func tCritical95(df int) float64 {
+	table := []float64{12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+		2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+		2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042}
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if df <= len(table) {
+		return table[df-1]
+	}
+	return 1.96
+}
+
+// movingAverage returns the trailing average over window values
+func movingAverage(values []float64, window int) []float64 {
+	if window < 1 {
+		window = 1
+	}
+	out := make([]float64, len(values))
+	var sum float64
+	for i, v := range values {
+		sum += v
+		if i >= window {
+			sum -= values[i-window]
+		}
+		count := i + 1
+		if count > window {
+			count = window
+		}
+		out[i] = roundTo(sum/float64(count), 2)
+	}
+	return out
+}
+
+func roundTo(x float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(x*p) / p
+}
+
+// buildCriterionProgress builds the series, trend and confidence band for
+// one criterion; essays must be sorted oldest first
+func buildCriterionProgress(essays []Essay, scores []ScoreOut, key string, window int) (CriterionProgress, *regression) {
+	progress := CriterionProgress{Points: []ProgressPoint{}, ConfidenceBand: []ConfidencePoint{}}
+	if len(essays) == 0 {
+		return progress, nil
+	}
+
+	first := essays[0].CreatedAt
+	xs := make([]float64, len(essays))
+	ys := make([]float64, len(essays))
+	for i, e := range essays {
+		xs[i] = e.CreatedAt.Sub(first).Hours() / 24
+		ys[i] = float64(criterionBand(scores[i], key))
+	}
+
+	averages := movingAverage(ys, window)
+	for i, e := range essays {
+		progress.Points = append(progress.Points, ProgressPoint{
+			EssayID:       e.ID,
+			PublicID:      e.PublicID,
+			Date:          e.CreatedAt,
+			Band:          float32(ys[i]),
+			MovingAverage: averages[i],
+		})
+	}
+
+	reg, ok := fitRegression(xs, ys)
+	if !ok {
+		return progress, nil
+	}
+
+	progress.Trend = &TrendLine{
+		SlopePerWeek: roundTo(reg.slope*7, 3),
+		Intercept:    roundTo(reg.intercept, 3),
+		R2:           roundTo(reg.r2, 3),
+		Samples:      reg.n,
+	}
+	for i, e := range essays {
+		lower, upper := reg.interval(xs[i])
+		progress.ConfidenceBand = append(progress.ConfidenceBand, ConfidencePoint{
+			Date:   e.CreatedAt,
+			Fitted: roundTo(reg.at(xs[i]), 2),
+			Lower:  roundTo(math.Max(lower, 0), 2),
+			Upper:  roundTo(math.Min(upper, 9), 2),
+		})
+	}
+	return progress, &reg
+}
+
+// averageBands groups essays by key and averages every criterion
+func averageBands(essays []Essay, scores []ScoreOut, keyOf func(Essay) string) []BandAverages {
+	groups := map[string]*BandAverages{}
+	var order []string
+	for i, e := range essays {
+		key := keyOf(e)
+		g, ok := groups[key]
+		if !ok {
+			g = &BandAverages{Key: key}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.Essays++
+		g.TA += float64(scores[i].TA)
+		g.CC += float64(scores[i].CC)
+		g.LR += float64(scores[i].LR)
+		g.GRA += float64(scores[i].GRA)
+		g.Overall += float64(scores[i].Overall)
+	}
+
+	out := make([]BandAverages, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		n := float64(g.Essays)
+		g.TA, g.CC, g.LR, g.GRA = roundTo(g.TA/n, 2), roundTo(g.CC/n, 2), roundTo(g.LR/n, 2), roundTo(g.GRA/n, 2)
+		g.Overall = roundTo(g.Overall/n, 2)
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Essays > out[j].Essays })
+	return out
+}
+
+// aggregateErrorCategories counts annotations per category and keeps those
+// seen in at least minEssays different essays, most frequent first
+func aggregateErrorCategories(annotations []Annotation, minEssays int) []ErrorCategoryStat {
+	type agg struct {
+		stat   ErrorCategoryStat
+		essays map[uint]bool
+	}
+	groups := map[string]*agg{}
+	for _, a := range annotations {
+		key := a.Criterion + ":" + a.Category
+		g, ok := groups[key]
+		if !ok {
+			g = &agg{stat: ErrorCategoryStat{Criterion: a.Criterion, Category: a.Category}, essays: map[uint]bool{}}
+			groups[key] = g
+		}
+		g.stat.Count++
+		g.essays[a.EssayID] = true
+		if !a.CreatedAt.Before(g.stat.LastSeen) {
+			g.stat.LastSeen = a.CreatedAt
+			g.stat.Example = a.Excerpt
+			g.stat.Suggestion = a.Suggestion
+		}
+	}
+
+	out := []ErrorCategoryStat{}
+	for _, g := range groups {
+		g.stat.Essays = len(g.essays)
+		if g.stat.Essays >= minEssays {
+			out = append(out, g.stat)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].LastSeen.After(out[j].LastSeen)
+	})
+	return out
+}
+
+// projectTarget estimates when the overall trend reaches the target band
+func projectTarget(reg *regression, first, last time.Time, target float64) TargetProjection {
+	p := TargetProjection{TargetBand: target}
+	if reg == nil || reg.n < 3 {
+		p.Reason = "at least 3 essays on different days are needed for a projection"
+		return p
+	}
+
+	lastX := last.Sub(first).Hours() / 24
+	current := reg.at(lastX)
+	p.CurrentBand = roundTo(current, 2)
+	p.SlopePerWeek = roundTo(reg.slope*7, 3)
+
+	if current >= target {
+		p.Reached = true
+		return p
+	}
+	if reg.slope <= 0 {
+		p.Reason = "scores are not trending upwards yet"
+		return p
+	}
+
+	days := (target - current) / reg.slope
+	weeks := roundTo(days/7, 1)
+	date := last.Add(time.Duration(days * 24 * float64(time.Hour)))
+	p.WeeksToTarget = &weeks
+	p.ProjectedDate = &date
+	return p
+}
+
+// GetUserProgress returns per-criterion progress analytics for the current user
+func GetUserProgress(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		window, err := strconv.Atoi(c.DefaultQuery("window", "3"))
+		if err != nil || window < 1 || window > 20 {
+			c.AbortWithStatusJSON(400, gin.H{"error": "window must be between 1 and 20"})
+			return
+		}
+
+		// Default to the profile target band, then to band 7
+		target := 7.0
+		if user, ok := c.Get("user"); ok {
+			if tb := user.(User).TargetBand; tb != nil {
+				target = float64(*tb)
+			}
+		}
+		if t := c.Query("target"); t != "" {
+			target, err = strconv.ParseFloat(t, 64)
+			if err != nil || target < 1 || target > 9 {
+				c.AbortWithStatusJSON(400, gin.H{"error": "target must be a band between 1 and 9"})
+				return
+			}
+		}
+
+		query := db.Where("user_id = ?", userID)
+		if taskType := c.Query("taskType"); taskType != "" {
+			query = query.Where("task_type = ?", taskType)
+		}
+
+		// Latest 200 essays, processed oldest first
+		var essays []Essay
+		if err := query.Order("created_at DESC").Limit(200).Find(&essays).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to fetch essays"})
+			return
+		}
+		for i, j := 0, len(essays)-1; i < j; i, j = i+1, j-1 {
+			essays[i], essays[j] = essays[j], essays[i]
+		}
+
+		scores := make([]ScoreOut, len(essays))
+		essayIDs := make([]uint, len(essays))
+		for i, e := range essays {
+			scores[i] = essayScore(e)
+			essayIDs[i] = e.ID
+		}
+
+		criteria := gin.H{}
+		for _, key := range scoreCriteria {
+			progress, _ := buildCriterionProgress(essays, scores, key, window)
+			criteria[key] = progress
+		}
+		overall, overallReg := buildCriterionProgress(essays, scores, "overall", window)
+		criteria["overall"] = overall
+
+		var annotations []Annotation
+		if len(essayIDs) > 0 {
+			db.Where("essay_id IN ?", essayIDs).Find(&annotations)
+		}
+
+		byTaskType := averageBands(essays, scores, func(e Essay) string { return e.TaskType })
+		byQuestionType := averageBands(essays, scores, func(e Essay) string {
+			if e.QuestionType == "" {
+				return "unspecified"
+			}
+			return e.QuestionType
+		})
+
+		var projection TargetProjection
+		if len(essays) > 0 {
+			projection = projectTarget(overallReg, essays[0].CreatedAt, essays[len(essays)-1].CreatedAt, target)
+		} else {
+			projection = TargetProjection{TargetBand: target, Reason: "no essays yet"}
+		}
+
+		c.JSON(200, gin.H{
+			"totalEssays":     len(essays),
+			"window":          window,
+			"criteria":        criteria,
+			"byTaskType":      byTaskType,
+			"byQuestionType":  byQuestionType,
+			"recurringErrors": aggregateErrorCategories(annotations, 2),
+			"projection":      projection,
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFitRegression(t *testing.T) {
+	tests := []struct {
+		name      string
+		xs, ys    []float64
+		ok        bool
+		slope     float64
+		intercept float64
+	}{
+		{
+			name:      "Perfect upward line",
+			xs:        []float64{0, 7, 14, 21},
+			ys:        []float64{5, 5.5, 6, 6.5},
+			ok:        true,
+			slope:     0.5 / 7,
+			intercept: 5,
+		},
+		{
+			name: "Single point",
+			xs:   []float64{0},
+			ys:   []float64{6},
+			ok:   false,
+		},
+		{
+			name: "Same day essays",
+			xs:   []float64{0, 0, 0},
+			ys:   []float64{6, 6.5, 7},
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg, ok := fitRegression(tt.xs, tt.ys)
+			if ok != tt.ok {
+				t.Fatalf("fitRegression() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if math.Abs(reg.slope-tt.slope) > 1e-9 || math.Abs(reg.intercept-tt.intercept) > 1e-9 {
+				t.Errorf("fitRegression() = %v + %v*x, want %v + %v*x", reg.intercept, reg.slope, tt.intercept, tt.slope)
+			}
+		})
+	}
+}
+
+func TestRegressionInterval(t *testing.T) {
+	reg, _ := fitRegression([]float64{0, 1, 2, 3, 4}, []float64{5, 6, 5.5, 6.5, 6})
+	lower, upper := reg.interval(2)
+	fitted := reg.at(2)
+	if !(lower < fitted && fitted < upper) {
+		t.Errorf("interval(2) = [%v, %v], want it to contain %v", lower, upper, fitted)
+	}
+
+	// The band widens away from the mean of x
+	edgeLower, edgeUpper := reg.interval(4)
+	if edgeUpper-edgeLower <= upper-lower {
+		t.Errorf("interval at edge (%v) should be wider than at centre (%v)", edgeUpper-edgeLower, upper-lower)
+	}
+}
+
+func TestMovingAverage(t *testing.T) {
+	got := movingAverage([]float64{5, 6, 7, 8}, 3)
+	want := []float64{5, 5.5, 6, 7}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("movingAverage()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestProjectTarget(t *testing.T) {
+	first := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 0, 21)
+
+	rising, _ := fitRegression([]float64{0, 7, 14, 21}, []float64{5, 5.5, 6, 6.5})
+	p := projectTarget(&rising, first, last, 7)
+	if p.WeeksToTarget == nil || *p.WeeksToTarget != 1 {
+		t.Fatalf("projectTarget() weeks = %v, want 1", p.WeeksToTarget)
+	}
+
+	falling, _ := fitRegression([]float64{0, 7, 14, 21}, []float64{6.5, 6, 6, 5.5})
+	p = projectTarget(&falling, first, last, 7)
+	if p.ProjectedDate != nil || p.Reason == "" {
+		t.Errorf("projectTarget() with falling scores should not project, got %+v", p)
+	}
+
+	p = projectTarget(&rising, first, last, 6)
+	if !p.Reached {
+		t.Errorf("projectTarget() should report the target as reached, got %+v", p)
+	}
+}
+
+func TestNormalizeAnnotations(t *testing.T) {
+	got := normalizeAnnotations([]ScoreAnnotation{
+		{Criterion: "LR", Category: "Collocation", Excerpt: "do a mistake", Suggestion: "make a mistake"},
+		{Criterion: "gra", Category: "made up", Excerpt: "he go"},
+		{Criterion: "style", Category: "word_choice", Excerpt: "thing"},
+	})
+
+	if len(got) != 2 {
+		t.Fatalf("normalizeAnnotations() kept %d annotations, want 2", len(got))
+	}
+	if got[0].Criterion != "lr" || got[0].Category != "collocation" {
+		t.Errorf("normalizeAnnotations()[0] = %+v", got[0])
+	}
+	if got[1].Category != "other" {
+		t.Errorf("unknown category should map to other, got %q", got[1].Category)
+	}
+}
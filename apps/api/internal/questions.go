@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Question is a writing prompt in the shared question bank
+type Question struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	TaskType       string    `gorm:"index" json:"taskType"`     // "task1" | "task2"
+	QuestionType   string    `gorm:"index" json:"questionType"` // see questionTypes
+	Title          string    `json:"title"`
+	Prompt         string    `gorm:"type:TEXT" json:"prompt"`
+	ImageURL       string    `json:"imageUrl,omitempty"` // chart or diagram for task 1
+	IsActive       bool      `gorm:"default:true" json:"isActive"`
+	CreatedBy      uint      `json:"createdBy"`
+	OrganizationID *uint     `gorm:"index" json:"organizationId,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// questionTypes lists the IELTS question types we recognise per task
+var questionTypes = map[string][]string{
+	"task1": {"line_graph", "bar_chart", "pie_chart", "table", "process", "map", "mixed_chart"},
+	"task2": {"opinion", "discussion", "advantages_disadvantages", "problem_solution", "two_part"},
+}
+
+// NormalizeQuestionType returns the canonical question type for a task,
+// or "" when the value is empty or not valid for that task
+func NormalizeQuestionType(taskType, questionType string) string {
+	qt := strings.ToLower(strings.TrimSpace(questionType))
+	qt = strings.ReplaceAll(qt, " ", "_")
+	qt = strings.ReplaceAll(qt, "-", "_")
+	for _, t := range questionTypes[taskType] {
+		if t == qt {
+			return t
+		}
+	}
+	return ""
+}
+
+type QuestionRequest struct {
+	TaskType     string `json:"taskType" binding:"required"`
+	QuestionType string `json:"questionType"`
+	Title        string `json:"title"`
+	Prompt       string `json:"prompt" binding:"required"`
+	ImageURL     string `json:"imageUrl"`
+	IsActive     bool   `json:"isActive"`
+}
+
+// GetQuestions lists active questions, optionally filtered by task and question type
+func GetQuestions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Scopes(TenantScope(currentOrgID(c))).Where("is_active = ?", true)
+		if taskType := c.Query("taskType"); taskType != "" {
+			query = query.Where("task_type = ?", taskType)
+		}
+		if questionType := c.Query("questionType"); questionType != "" {
+			query = query.Where("question_type = ?", questionType)
+		}
+
+		var questions []Question
+		query.Order("created_at DESC").Find(&questions)
+
+		c.JSON(http.StatusOK, gin.H{"questions": questions})
+	}
+}
+
+// GetAdminQuestions returns the whole question bank for admin
+func GetAdminQuestions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var questions []Question
+		db.Scopes(TenantScope(currentOrgID(c))).Order("created_at DESC").Find(&questions)
+
+		c.JSON(http.StatusOK, gin.H{"questions": questions})
+	}
+}
+
+// CreateQuestion adds a question to the bank
+func CreateQuestion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req QuestionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		if req.TaskType != "task1" && req.TaskType != "task2" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "taskType must be task1 or task2"})
+			return
+		}
+
+		adminUser := c.MustGet("adminUser").(User)
+
+		question := Question{
+			TaskType:       req.TaskType,
+			QuestionType:   NormalizeQuestionType(req.TaskType, req.QuestionType),
+			Title:          req.Title,
+			Prompt:         req.Prompt,
+			ImageURL:       req.ImageURL,
+			IsActive:       req.IsActive,
+			CreatedBy:      adminUser.ID,
+			OrganizationID: adminUser.OrganizationID,
+		}
+
+		if err := db.Create(&question).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create question"})
+			return
+		}
+		auditChange(c, "question.create", "question", question.ID, nil, question)
+
+		c.JSON(http.StatusCreated, gin.H{"question": question})
+	}
+}
+
+// UpdateQuestion updates a question in the bank
+func UpdateQuestion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		questionID := c.Param("id")
+
+		var req QuestionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		if req.TaskType != "task1" && req.TaskType != "task2" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "taskType must be task1 or task2"})
+			return
+		}
+
+		var question Question
+		if err := db.Scopes(TenantScope(currentOrgID(c))).First(&question, questionID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "question not found"})
+			return
+		}
+
+		updates := map[string]interface{}{
+			"task_type":     req.TaskType,
+			"question_type": NormalizeQuestionType(req.TaskType, req.QuestionType),
+			"title":         req.Title,
+			"prompt":        req.Prompt,
+			"image_url":     req.ImageURL,
+			"is_active":     req.IsActive,
+		}
+
+		before := question
+		if err := db.Model(&question).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update question"})
+			return
+		}
+		auditChange(c, "question.update", "question", question.ID, before, question)
+
+		c.JSON(http.StatusOK, gin.H{"message": "question updated successfully"})
+	}
+}
+
+// DeleteQuestion removes a question that no assignment uses
+func DeleteQuestion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		questionID := c.Param("id")
+
+		var question Question
+		if err := db.Scopes(TenantScope(currentOrgID(c))).First(&question, questionID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "question not found"})
+			return
+		}
+
+		var used int64
+		db.Model(&Assignment{}).Where("question_id = ?", question.ID).Count(&used)
+		if used > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "question is used by assignments, deactivate it instead"})
+			return
+		}
+
+		if err := db.Delete(&question).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete question"})
+			return
+		}
+		auditChange(c, "question.delete", "question", question.ID, question, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "question deleted successfully"})
+	}
+}
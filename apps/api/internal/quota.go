@@ -0,0 +1,335 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Metered features. Rewrites have no endpoint yet; the metric exists so
+// plans can price them before the feature ships.
+const (
+	MetricAnalyses   = "analyses"
+	MetricRewrites   = "rewrites"
+	MetricPDFExports = "pdf_exports"
+)
+
+var quotaMetrics = []string{MetricAnalyses, MetricRewrites, MetricPDFExports}
+
+// defaultPlan is used for users whose plan has no definition
+const defaultPlan = "free"
+
+var planNameRegex = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+var errQuotaExceeded = errors.New("plan quota exceeded")
+
+// Plan holds the usage limits of a subscription plan. A nil limit means
+// unlimited, 0 means the feature is not included.
+type Plan struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `gorm:"uniqueIndex;not null" json:"name"` // matches User.Plan
+
+	DailyAnalyses     *int `json:"dailyAnalyses"`
+	MonthlyAnalyses   *int `json:"monthlyAnalyses"`
+	DailyRewrites     *int `json:"dailyRewrites"`
+	MonthlyRewrites   *int `json:"monthlyRewrites"`
+	DailyPDFExports   *int `json:"dailyPdfExports"`
+	MonthlyPDFExports *int `json:"monthlyPdfExports"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// limits returns the daily and monthly limit of a metric
+func (p Plan) limits(metric string) (*int, *int) {
+	switch metric {
+	case MetricAnalyses:
+		return p.DailyAnalyses, p.MonthlyAnalyses
+	case MetricRewrites:
+		return p.DailyRewrites, p.MonthlyRewrites
+	case MetricPDFExports:
+		return p.DailyPDFExports, p.MonthlyPDFExports
+	}
+	return nil, nil
+}
+
+func quotaLimit(n int) *int { return &n }
+
+// defaultPlans are created when missing, admins can edit them afterwards
+var defaultPlans = []Plan{
+	{
+		Name:          "free",
+		DailyAnalyses: quotaLimit(5), MonthlyAnalyses: quotaLimit(30),
+		DailyRewrites: quotaLimit(2), MonthlyRewrites: quotaLimit(10),
+		DailyPDFExports: quotaLimit(3), MonthlyPDFExports: quotaLimit(20),
+	},
+	{
+		Name:          "pro",
+		DailyAnalyses: quotaLimit(50), MonthlyAnalyses: quotaLimit(500),
+		DailyRewrites: quotaLimit(20), MonthlyRewrites: quotaLimit(200),
+	},
+}
+
+// seedPlans adds the default plans that do not exist yet
+func seedPlans(db *gorm.DB) error {
+	for _, plan := range defaultPlans {
+		if err := db.Where(Plan{Name: plan.Name}).FirstOrCreate(&plan).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UsageCounter counts a user's use of a metric in one period: a UTC day
+// ("d2026-03-01") or a billing month named by its first day ("m2026-02-15")
+type UsageCounter struct {
+	UserID    uint   `gorm:"primaryKey;autoIncrement:false"`
+	Metric    string `gorm:"primaryKey"`
+	Period    string `gorm:"primaryKey"`
+	Count     int
+	UpdatedAt time.Time
+}
+
+// QuotaPeriod is the use of a metric in one period
+type QuotaPeriod struct {
+	Used    int       `json:"used"`
+	Limit   *int      `json:"limit"` // nil when unlimited
+	ResetAt time.Time `json:"resetAt"`
+}
+
+// QuotaError tells which limit a request ran into
+type QuotaError struct {
+	Metric string
+	Period string // "daily" or "monthly"
+	QuotaPeriod
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s %s limit of %d reached", errQuotaExceeded, e.Period, e.Metric, *e.Limit)
+}
+
+func (e *QuotaError) Unwrap() error { return errQuotaExceeded }
+
+// billingAnchor is the instant a user's billing months are counted from:
+// their subscription's period start, or their signup
+func billingAnchor(user User) time.Time {
+	if user.BillingAnchor != nil {
+		return *user.BillingAnchor
+	}
+	return user.CreatedAt
+}
+
+// billingPeriod returns the billing month containing now. Months start on
+// the anchor's day, or the last day of shorter months.
+func billingPeriod(anchor, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	startIn := func(year int, month time.Month) time.Time {
+		first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		day := anchor.UTC().Day()
+		if last := first.AddDate(0, 1, -1).Day(); day > last {
+			day = last
+		}
+		return first.AddDate(0, 0, day-1)
+	}
+	start := startIn(now.Year(), now.Month())
+	if start.After(now) {
+		start = startIn(now.Year(), now.Month()-1)
+	}
+	next := time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	return start, startIn(next.Year(), next.Month())
+}
+
+// quotaPeriods returns the counter keys and reset times of the day and the
+// billing month containing now
+func quotaPeriods(user User, now time.Time) (day, month string, dayReset, monthReset time.Time) {
+	now = now.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart, monthEnd := billingPeriod(billingAnchor(user), now)
+	return "d" + dayStart.Format("2006-01-02"), "m" + monthStart.Format("2006-01-02"), dayStart.AddDate(0, 0, 1), monthEnd
+}
+
+// planFor loads a user's plan, falling back to the default plan
+func planFor(db *gorm.DB, name string) Plan {
+	var plan Plan
+	if db.Where("name = ?", name).First(&plan).Error == nil {
+		return plan
+	}
+	if db.Where("name = ?", defaultPlan).First(&plan).Error == nil {
+		return plan
+	}
+	// Before the plans are seeded nothing is limited
+	return Plan{Name: defaultPlan}
+}
+
+// meteredUser reports whether a user's personal plan applies. Members of
+// an organisation are limited by its MaxEssaysPerMonth instead.
+func meteredUser(user User) bool {
+	return user.OrganizationID == nil
+}
+
+// consumeQuota counts one use of a metric against the user's daily and
+// monthly limits. Over a limit nothing is counted and a *QuotaError is
+// returned. Concurrent requests cannot both take the last unit: the
+// increments lock the counter rows and the transaction rolls back.
+func consumeQuota(db *gorm.DB, user User, metric string, now time.Time) error {
+	if !meteredUser(user) {
+		return nil
+	}
+	daily, monthly := planFor(db, user.Plan).limits(metric)
+	day, month, dayReset, monthReset := quotaPeriods(user, now)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, p := range []struct {
+			name, key string
+			limit     *int
+			reset     time.Time
+		}{
+			{"daily", day, daily, dayReset},
+			{"monthly", month, monthly, monthReset},
+		} {
+			var used int
+			err := tx.Raw(`INSERT INTO usage_counters (user_id, metric, period, count, updated_at) VALUES (?, ?, ?, 1, ?)
+				ON CONFLICT (user_id, metric, period) DO UPDATE SET count = usage_counters.count + 1, updated_at = excluded.updated_at
+				RETURNING count`, user.ID, metric, p.key, now).Scan(&used).Error
+			if err != nil {
+				return err
+			}
+			if p.limit != nil && used > *p.limit {
+				return &QuotaError{Metric: metric, Period: p.name, QuotaPeriod: QuotaPeriod{Used: used - 1, Limit: p.limit, ResetAt: p.reset}}
+			}
+		}
+		return nil
+	})
+}
+
+// refundQuota gives back a use whose request failed after consumeQuota
+func refundQuota(db *gorm.DB, user User, metric string, now time.Time) {
+	if !meteredUser(user) {
+		return
+	}
+	day, month, _, _ := quotaPeriods(user, now)
+	db.Model(&UsageCounter{}).
+		Where("user_id = ? AND metric = ? AND period IN ? AND count > 0", user.ID, metric, []string{day, month}).
+		Update("count", gorm.Expr("count - 1"))
+}
+
+// respondQuotaExceeded answers a request over the plan's limits, in the
+// shape the web app expects from rate limits
+func respondQuotaExceeded(c *gin.Context, user User, err error) {
+	var qe *QuotaError
+	if !errors.As(err, &qe) {
+		c.AbortWithStatusJSON(500, gin.H{"error": "Quota check failed"})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":     "Plan quota exceeded",
+		"metric":    qe.Metric,
+		"period":    qe.Period,
+		"limit":     *qe.Limit,
+		"used":      qe.Used,
+		"remaining": 0,
+		"resetTime": qe.ResetAt.Unix(),
+		"message":   fmt.Sprintf("Your %s plan's %s limit is used up. It resets on %s.", user.Plan, qe.Period, qe.ResetAt.Format("2 Jan 15:04 MST")),
+		"userType":  "authenticated",
+		"plan":      user.Plan,
+	})
+}
+
+// GetUserUsage reports the current user's use of each metric against
+// their plan, for today and the current billing month
+func GetUserUsage(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+		now := time.Now()
+		day, month, dayReset, monthReset := quotaPeriods(user, now)
+		monthStart, _ := billingPeriod(billingAnchor(user), now)
+
+		var counters []UsageCounter
+		db.Where("user_id = ? AND period IN ?", user.ID, []string{day, month}).Find(&counters)
+		used := map[string]int{}
+		for _, counter := range counters {
+			used[counter.Metric+counter.Period] = counter.Count
+		}
+
+		plan := planFor(db, user.Plan)
+		metered := meteredUser(user)
+		usage := gin.H{}
+		for _, metric := range quotaMetrics {
+			daily, monthly := plan.limits(metric)
+			if !metered {
+				daily, monthly = nil, nil
+			}
+			usage[metric] = gin.H{
+				"daily":   QuotaPeriod{Used: used[metric+day], Limit: daily, ResetAt: dayReset},
+				"monthly": QuotaPeriod{Used: used[metric+month], Limit: monthly, ResetAt: monthReset},
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"plan":        plan.Name,
+			"metered":     metered,
+			"periodStart": monthStart,
+			"periodEnd":   monthReset,
+			"usage":       usage,
+		})
+	}
+}
+
+// GetPlans lists the plan definitions
+func GetPlans(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var plans []Plan
+		db.Order("name ASC").Find(&plans)
+		c.JSON(http.StatusOK, gin.H{"plans": plans, "metrics": quotaMetrics})
+	}
+}
+
+// SavePlan creates or replaces the limits of a plan. Omitted or null
+// limits are unlimited.
+func SavePlan(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !planNameRegex.MatchString(c.Param("name")) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "plan names are 1-32 lowercase letters, digits, - or _"})
+			return
+		}
+
+		var req Plan
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		for _, metric := range quotaMetrics {
+			daily, monthly := req.limits(metric)
+			if (daily != nil && *daily < 0) || (monthly != nil && *monthly < 0) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limits cannot be negative"})
+				return
+			}
+		}
+
+		var plan Plan
+		err := db.Where("name = ?", c.Param("name")).First(&plan).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load plan"})
+			return
+		}
+		before := plan
+		created := plan.ID == 0
+
+		req.ID, req.Name = plan.ID, c.Param("name")
+		if err := db.Save(&req).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save plan"})
+			return
+		}
+		if created {
+			auditChange(c, "plan.create", "plan", req.Name, nil, req)
+		} else {
+			auditChange(c, "plan.update", "plan", req.Name, before, req)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"plan": req})
+	}
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBillingPeriod(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name      string
+		anchor    string
+		now       string
+		wantStart string
+		wantEnd   string
+	}{
+		{"Mid period", "2025-06-15 09:30", "2026-03-20 12:00", "2026-03-15 00:00", "2026-04-15 00:00"},
+		{"Before the anchor day", "2025-06-15 09:30", "2026-03-10 12:00", "2026-02-15 00:00", "2026-03-15 00:00"},
+		{"Anchor day starts the period", "2025-06-15 09:30", "2026-03-15 00:00", "2026-03-15 00:00", "2026-04-15 00:00"},
+		{"Short month clamps", "2025-01-31 08:00", "2026-02-28 10:00", "2026-02-28 00:00", "2026-03-31 00:00"},
+		{"Across the new year", "2025-01-20 08:00", "2026-01-05 10:00", "2025-12-20 00:00", "2026-01-20 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := billingPeriod(day(tt.anchor), day(tt.now))
+			if !start.Equal(day(tt.wantStart)) || !end.Equal(day(tt.wantEnd)) {
+				t.Errorf("billingPeriod() = %v - %v, want %s - %s", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestQuotaPeriods(t *testing.T) {
+	user := User{CreatedAt: time.Date(2025, 6, 15, 9, 30, 0, 0, time.UTC)}
+	now := time.Date(2026, 3, 20, 23, 0, 0, 0, time.FixedZone("UTC-2", -2*3600))
+
+	day, month, dayReset, _ := quotaPeriods(user, now)
+	if day != "d2026-03-21" || month != "m2026-03-15" {
+		t.Errorf("quotaPeriods() = %s, %s; want d2026-03-21, m2026-03-15", day, month)
+	}
+	if !dayReset.Equal(time.Date(2026, 3, 22, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("day resets at %v, want midnight UTC", dayReset)
+	}
+}
+
+func TestQuotaError(t *testing.T) {
+	err := error(&QuotaError{Metric: MetricAnalyses, Period: "daily", QuotaPeriod: QuotaPeriod{Limit: quotaLimit(5)}})
+	if !errors.Is(err, errQuotaExceeded) {
+		t.Error("QuotaError does not match errQuotaExceeded")
+	}
+	if daily, monthly := defaultPlans[1].limits(MetricPDFExports); daily != nil || monthly != nil {
+		t.Error("pro PDF exports should be unlimited")
+	}
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles a user can have
+const (
+	RoleUser          = "user"
+	RoleTeacher       = "teacher"
+	RoleSupport       = "support"
+	RoleExaminer      = "examiner"
+	RoleContentEditor = "content_editor"
+	RoleAdmin         = "admin"
+)
+
+// Permissions checked by RequirePermission. PermStaff opens the /api/sidigi
+// area; the others guard individual routes inside it.
+const (
+	PermStaff          = "staff:access"
+	PermDashboardRead  = "dashboard:read"
+	PermUsersRead      = "users:read"
+	PermUsersWrite     = "users:write"
+	PermRolesAssign    = "roles:assign"
+	PermBlogPublish    = "blog:publish"
+	PermPromptsEdit    = "prompts:edit"
+	PermQuestionsEdit  = "questions:edit"
+	PermEssaysReadAll  = "essays:read_all"
+	PermOrgManage      = "org:manage"
+	PermClassesManage  = "classes:manage"
+	PermAuditRead      = "audit:read"
+	PermPlansEdit      = "plans:edit"
+	PermCreditsGrant   = "credits:grant"
+	PermVouchersManage = "vouchers:manage"
+)
+
+// rolePermissions maps each role to what it may do
+var rolePermissions = map[string][]string{
+	RoleUser:    {},
+	RoleTeacher: {PermClassesManage},
+	RoleSupport: {
+		PermStaff, PermDashboardRead, PermUsersRead, PermUsersWrite, PermCreditsGrant,
+	},
+	RoleExaminer: {
+		PermStaff, PermDashboardRead, PermPromptsEdit, PermQuestionsEdit, PermEssaysReadAll,
+	},
+	RoleContentEditor: {
+		PermStaff, PermDashboardRead, PermBlogPublish, PermQuestionsEdit,
+	},
+	RoleAdmin: {
+		PermStaff, PermDashboardRead, PermUsersRead, PermUsersWrite, PermRolesAssign,
+		PermBlogPublish, PermPromptsEdit, PermQuestionsEdit, PermEssaysReadAll,
+		PermOrgManage, PermClassesManage, PermAuditRead, PermPlansEdit,
+		PermCreditsGrant, PermVouchersManage,
+	},
+}
+
+var (
+	errUnknownRole      = errors.New("unknown role")
+	errCannotAssignRole = errors.New("not allowed to assign this role")
+	errOwnRole          = errors.New("cannot change your own role")
+)
+
+// HasPermission reports whether a role grants a permission
+func HasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// validRole reports whether a role exists
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RequirePermission rejects staff whose role lacks any of the permissions.
+// It runs after AdminMiddleware.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.MustGet("adminUser").(User).Role
+		for _, p := range permissions {
+			if !HasPermission(role, p) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "permission required", "permission": p})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// checkRoleAssignment reports whether the actor may give the user a role.
+// Only roles:assign holders may change roles, and nobody may change their own.
+func checkRoleAssignment(actor, user User, role string) error {
+	if !validRole(role) {
+		return errUnknownRole
+	}
+	if !HasPermission(actor.Role, PermRolesAssign) {
+		return errCannotAssignRole
+	}
+	if actor.ID == user.ID {
+		return errOwnRole
+	}
+	return nil
+}
+
+// canManageUser reports whether staff may edit or delete a user. Accounts
+// with admin area access are reserved for roles:assign holders, so support
+// cannot touch the admins they answer to.
+func canManageUser(actor, user User) bool {
+	return !HasPermission(user.Role, PermStaff) || HasPermission(actor.Role, PermRolesAssign)
+}
+
+// respondRoleError maps checkRoleAssignment errors to responses
+func respondRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errUnknownRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role", "roles": sortedKeys(rolePermissions)})
+	case errors.Is(err, errCannotAssignRole), errors.Is(err, errOwnRole):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update role"})
+	}
+}
+
+// GetRoles lists the roles and their permissions
+func GetRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roles := make([]gin.H, 0, len(rolePermissions))
+		for _, role := range sortedKeys(rolePermissions) {
+			permissions := append([]string{}, rolePermissions[role]...)
+			sort.Strings(permissions)
+			roles = append(roles, gin.H{"role": role, "permissions": permissions})
+		}
+		c.JSON(http.StatusOK, gin.H{"roles": roles})
+	}
+}
+
+// UpdateUserRole assigns a role to a user of the admin's tenant
+func UpdateUserRole(admin *AdminService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Role string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role is required"})
+			return
+		}
+
+		before, user, err := admin.SetRole(c.MustGet("adminUser").(User), currentOrgID(c), paramID(c, "id"), req.Role)
+		if errors.Is(err, errNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			respondRoleError(c, err)
+			return
+		}
+		auditChange(c, "user.role", "user", user.ID, before, user)
+
+		c.JSON(http.StatusOK, gin.H{"message": "role updated", "role": req.Role})
+	}
+}
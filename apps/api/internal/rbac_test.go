@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckRoleAssignment(t *testing.T) {
+	admin := User{ID: 1, Role: RoleAdmin}
+	support := User{ID: 2, Role: RoleSupport}
+	student := User{ID: 3, Role: RoleUser}
+
+	tests := []struct {
+		name  string
+		actor User
+		user  User
+		role  string
+		want  error
+	}{
+		{"Unknown role", admin, student, "superuser", errUnknownRole},
+		{"Support cannot assign", support, student, RoleTeacher, errCannotAssignRole},
+		{"Own role", admin, admin, RoleUser, errOwnRole},
+		{"Admin promotes a student", admin, student, RoleTeacher, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkRoleAssignment(tt.actor, tt.user, tt.role); !errors.Is(err, tt.want) {
+				t.Errorf("checkRoleAssignment() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{RoleContentEditor, PermBlogPublish, true},
+		{RoleContentEditor, PermUsersWrite, false},
+		{RoleExaminer, PermEssaysReadAll, true},
+		{RoleSupport, PermRolesAssign, false},
+		{RoleTeacher, PermStaff, false},
+		{"unknown", PermStaff, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.role+" "+tt.permission, func(t *testing.T) {
+			if got := HasPermission(tt.role, tt.permission); got != tt.want {
+				t.Errorf("HasPermission(%q, %q) = %v, want %v", tt.role, tt.permission, got, tt.want)
+			}
+		})
+	}
+
+	// Support may manage students but not the staff above them
+	if !canManageUser(User{Role: RoleSupport}, User{Role: RoleUser}) || canManageUser(User{Role: RoleSupport}, User{Role: RoleAdmin}) {
+		t.Error("canManageUser gives support the wrong reach")
+	}
+}
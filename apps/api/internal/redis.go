@@ -90,38 +90,3 @@ func HashEssay(text, taskType string) string {
 	hash := sha256.Sum256([]byte(content))
 	return hex.EncodeToString(hash[:])[:16] // Use first 16 chars
 }
-
-// Store user session info
-func SetUserSession(rdb *redis.Client, userID uint, sessionData map[string]interface{}) error {
-	if rdb == nil {
-		return nil
-	}
-
-	key := fmt.Sprintf("session:user:%d", userID)
-	data, err := json.Marshal(sessionData)
-	if err != nil {
-		return err
-	}
-
-	ctx := context.Background()
-	return rdb.Set(ctx, key, data, 24*time.Hour).Err()
-}
-
-// Get user session
-func GetUserSession(rdb *redis.Client, userID uint) (map[string]interface{}, error) {
-	if rdb == nil {
-		return nil, fmt.Errorf("redis not available")
-	}
-
-	key := fmt.Sprintf("session:user:%d", userID)
-	ctx := context.Background()
-
-	data, err := rdb.Get(ctx, key).Result()
-	if err != nil {
-		return nil, err
-	}
-
-	var sessionData map[string]interface{}
-	err = json.Unmarshal([]byte(data), &sessionData)
-	return sessionData, err
-}
@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jung-kurt/gofpdf"
@@ -15,11 +17,6 @@ func ReportPDF(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		publicId := c.Param("publicId")
 
-		if db == nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not available"})
-			return
-		}
-
 		var essay Essay
 		if err := db.First(&essay, "public_id = ?", publicId).Error; err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
@@ -33,6 +30,18 @@ func ReportPDF(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Exports by signed-in users count against their plan; anonymous
+		// visitors of a shared link are not metered
+		now := time.Now()
+		var user User
+		if u, ok := c.Get("user"); ok {
+			user = u.(User)
+			if err := consumeQuota(db, user, MetricPDFExports, now); err != nil {
+				respondQuotaExceeded(c, user, err)
+				return
+			}
+		}
+
 		// Generate share URL
 		baseURL := os.Getenv("PUBLIC_BASE_URL")
 		if baseURL == "" {
@@ -40,13 +49,29 @@ func ReportPDF(db *gorm.DB) gin.HandlerFunc {
 		}
 		shareURL := fmt.Sprintf("%s/r/%s", baseURL, essay.PublicID)
 
+		// Reports carry the branding of the essay's organisation
+		brand := loadBranding(db, essay.OrganizationID)
+		primary := brand.Primary
+
 		// Create PDF
 		pdf := gofpdf.New("P", "mm", "A4", "")
 		pdf.AddPage()
 
+		if brand.LogoURL != "" {
+			if data, imageType, err := fetchLogo(c.Request.Context(), brand.LogoURL); err == nil {
+				opts := gofpdf.ImageOptions{ImageType: imageType}
+				pdf.RegisterImageOptionsReader("logo", opts, bytes.NewReader(data))
+				if pdf.Ok() {
+					pdf.ImageOptions("logo", 160, 10, 0, 15, false, opts, 0, "")
+				} else {
+					pdf.ClearError() // an unreadable logo should not break the report
+				}
+			}
+		}
+
 		// Header
 		pdf.SetFont("Arial", "B", 20)
-		pdf.SetTextColor(58, 122, 254) // Brand color
+		pdf.SetTextColor(int(primary.R), int(primary.G), int(primary.B)) // Brand color
 		pdf.Cell(0, 15, "IELTS Writing Band Report")
 		pdf.Ln(20)
 
@@ -56,7 +81,7 @@ func ReportPDF(db *gorm.DB) gin.HandlerFunc {
 		pdf.Cell(0, 10, "Overall Band Score")
 		pdf.Ln(12)
 		pdf.SetFont("Arial", "B", 36)
-		pdf.SetTextColor(58, 122, 254)
+		pdf.SetTextColor(int(primary.R), int(primary.G), int(primary.B))
 		pdf.Cell(0, 20, formatBand(essay.Overall))
 		pdf.Ln(15)
 
@@ -88,7 +113,7 @@ func ReportPDF(db *gorm.DB) gin.HandlerFunc {
 			pdf.SetFont("Arial", "B", 11)
 			pdf.Cell(45, 8, band.name+":")
 			pdf.SetFont("Arial", "B", 14)
-			pdf.SetTextColor(58, 122, 254)
+			pdf.SetTextColor(int(primary.R), int(primary.G), int(primary.B))
 			pdf.Cell(15, 8, formatBand(band.score))
 			pdf.SetFont("Arial", "", 10)
 			pdf.SetTextColor(100, 100, 100)
@@ -123,7 +148,7 @@ func ReportPDF(db *gorm.DB) gin.HandlerFunc {
 
 			// We'll add a simple text URL instead of embedding QR image for simplicity
 			pdf.SetFont("Arial", "", 10)
-			pdf.SetTextColor(58, 122, 254)
+			pdf.SetTextColor(int(primary.R), int(primary.G), int(primary.B))
 			pdf.Cell(0, 6, shareURL)
 			pdf.Ln(8)
 		}
@@ -132,13 +157,23 @@ func ReportPDF(db *gorm.DB) gin.HandlerFunc {
 		pdf.SetY(280)
 		pdf.SetFont("Arial", "", 8)
 		pdf.SetTextColor(150, 150, 150)
-		pdf.Cell(0, 4, "Generated by BandLy - SidigiGroup | info@sidiginesia.com")
+		pdf.Cell(0, 4, brand.Footer)
+
+		// Optional teacher feedback section, only for the author and their
+		// teacher: share links are public
+		if c.Query("comments") == "true" && user.ID != 0 && canDiscussEssay(db, user.ID, essay) {
+			_, effective, _ := teacherBands(db, essay)
+			writeTeacherCommentsPDF(pdf, loadCommentThreads(db, essay.ID), effective)
+		}
 
 		// Set headers and output PDF
 		c.Header("Content-Type", "application/pdf")
 		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=ielts-report-%s.pdf", essay.PublicID))
 
 		if err := pdf.Output(c.Writer); err != nil {
+			if user.ID != 0 {
+				refundQuota(db, user, MetricPDFExports, now)
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "PDF generation failed"})
 			return
 		}
@@ -150,11 +185,6 @@ func GetReport(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		publicId := c.Param("publicId")
 
-		if db == nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not available"})
-			return
-		}
-
 		var essay Essay
 		if err := db.First(&essay, "public_id = ?", publicId).Error; err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
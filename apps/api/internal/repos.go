@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// errNotFound is returned by repositories for a missing row, or one outside
+// the caller's tenant
+var errNotFound = errors.New("not found")
+
+// UserFilter narrows a user listing
+type UserFilter struct {
+	Search string // part of the email, any case
+	Role   string
+	Offset int
+	Limit  int
+}
+
+// UserSummary is a user with the number of essays they wrote
+type UserSummary struct {
+	User
+	EssayCount int64
+}
+
+// UserRepo stores users
+type UserRepo interface {
+	Get(orgID *uint, id uint) (User, error)
+	List(orgID *uint, filter UserFilter) ([]UserSummary, int64, error)
+	PlanExists(name string) bool
+	// Update sets the user's role and plan in one write, leaving empty values
+	// unchanged
+	Update(id uint, role, plan string) error
+	// Delete removes the user with their essays, classes and everything
+	// hanging off them. Their credit ledger account is kept, detached.
+	Delete(user User) error
+}
+
+// EssayCounts counts a user's essays, in total and since a time
+type EssayCounts struct {
+	Total  int64
+	Recent int64
+}
+
+// EssayRepo stores a user's essays
+type EssayRepo interface {
+	ListByUser(userID uint, limit int) ([]Essay, error)
+	CountByUser(userID uint, since time.Time) (EssayCounts, error)
+	// DeleteForUser removes an essay of the user with its annotations,
+	// comments and peer reviews
+	DeleteForUser(userID, id uint) error
+}
+
+// BlogRepo stores a tenant's blog posts
+type BlogRepo interface {
+	List(orgID *uint) ([]BlogPost, error)
+	Get(orgID *uint, id uint) (BlogPost, error)
+	Create(post *BlogPost) error
+	// Save writes the editable fields of an existing post
+	Save(post *BlogPost) error
+	Delete(post BlogPost) error
+}
+
+// PromptRepo stores a tenant's prompt overrides
+type PromptRepo interface {
+	List(orgID *uint) ([]AdminPrompt, error)
+	Get(orgID *uint, id uint) (AdminPrompt, error)
+	Create(prompt *AdminPrompt) error
+	// Save writes the editable fields of an existing prompt
+	Save(prompt *AdminPrompt) error
+	Delete(prompt AdminPrompt) error
+}
+
+// TenantTotals are the counts behind the admin dashboard
+type TenantTotals struct {
+	Users          int64
+	NewUsers       int64 // signed up since the window start
+	Essays         int64
+	EssayWriters   int64 // users with at least one essay
+	ActiveWriters  int64 // users with an essay since the activity start
+	AverageOverall *float64
+	Feedback       int64
+	BlogPosts      int64
+}
+
+// EventTotals are the counts behind the site analytics
+type EventTotals struct {
+	Users          int64
+	Essays         int64
+	PageViews      int64 // since the window start
+	Analyses       int64 // essay_analyze events since the window start
+	ActiveSessions int64 // sessions with an event since the activity start
+}
+
+// AnalyticsRepo records events and computes dashboard totals
+type AnalyticsRepo interface {
+	RecordEvent(event *AnalyticsEvent) error
+	TenantTotals(orgID *uint, since, activeSince time.Time) (TenantTotals, error)
+	EventTotals(since, activeSince time.Time) (EventTotals, error)
+}
+
+// Repos are the repositories of one database
+type Repos struct {
+	Users     UserRepo
+	Essays    EssayRepo
+	Blog      BlogRepo
+	Prompts   PromptRepo
+	Analytics AnalyticsRepo
+}
+
+// NewRepos returns the GORM repositories of db
+func NewRepos(db *gorm.DB) Repos {
+	return Repos{
+		Users:     gormUserRepo{db},
+		Essays:    gormEssayRepo{db},
+		Blog:      gormBlogRepo{db},
+		Prompts:   gormPromptRepo{db},
+		Analytics: gormAnalyticsRepo{db},
+	}
+}
+
+// notFound maps GORM's missing record error to errNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errNotFound
+	}
+	return err
+}
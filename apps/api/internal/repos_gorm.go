@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type gormUserRepo struct{ db *gorm.DB }
+
+func (r gormUserRepo) Get(orgID *uint, id uint) (User, error) {
+	var user User
+	err := r.db.Scopes(TenantScope(orgID)).First(&user, id).Error
+	return user, notFound(err)
+}
+
+func (r gormUserRepo) List(orgID *uint, filter UserFilter) ([]UserSummary, int64, error) {
+	query := r.db.Model(&User{}).Scopes(TenantScope(orgID))
+	if filter.Search != "" {
+		query = query.Where("LOWER(email) LIKE ?", "%"+strings.ToLower(filter.Search)+"%")
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []User
+	if err := query.Offset(filter.Offset).Limit(filter.Limit).Order("created_at DESC").Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	if len(users) == 0 {
+		return nil, total, nil
+	}
+
+	// One grouped count for the page instead of a query per user
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	var counts []struct {
+		UserID uint
+		Count  int64
+	}
+	if err := r.db.Model(&Essay{}).Select("user_id, COUNT(*) AS count").
+		Where("user_id IN ?", ids).Group("user_id").Scan(&counts).Error; err != nil {
+		return nil, 0, err
+	}
+	byUser := make(map[uint]int64, len(counts))
+	for _, row := range counts {
+		byUser[row.UserID] = row.Count
+	}
+
+	summaries := make([]UserSummary, len(users))
+	for i, user := range users {
+		summaries[i] = UserSummary{User: user, EssayCount: byUser[user.ID]}
+	}
+	return summaries, total, nil
+}
+
+func (r gormUserRepo) PlanExists(name string) bool {
+	var count int64
+	r.db.Model(&Plan{}).Where("name = ?", name).Count(&count)
+	return count > 0
+}
+
+func (r gormUserRepo) Update(id uint, role, plan string) error {
+	updates := map[string]interface{}{}
+	if role != "" {
+		updates["role"] = role
+	}
+	if plan != "" {
+		updates["plan"] = plan
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return r.db.Model(&User{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (r gormUserRepo) Delete(user User) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		owned := []interface{}{
+			&Annotation{}, &VocabularyCard{}, &StudyPlan{},
+			&Session{}, &UserIdentity{}, &UserMFA{}, &MFARecoveryCode{},
+			&APIKey{}, &ClassMember{}, &UsageCounter{}, &Subscription{}, &VoucherRedemption{},
+		}
+		for _, model := range owned {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		// The credit ledger is append-only, so the account keeps its entries.
+		// It is renamed for a later user with the same ID to start from zero.
+		if err := tx.Model(&CreditAccount{}).Where("user_id = ?", user.ID).
+			Updates(map[string]interface{}{"user_id": nil, "name": gorm.Expr("'deleted:' || id")}).Error; err != nil {
+			return err
+		}
+		// Analytics and feedback stay, anonymously
+		for _, model := range []interface{}{&AnalyticsEvent{}, &UserFeedback{}} {
+			if err := tx.Model(model).Where("user_id = ?", user.ID).Update("user_id", nil).Error; err != nil {
+				return err
+			}
+		}
+
+		// Their classes go with them. Students keep their submissions as
+		// practice essays.
+		classes := tx.Model(&Classroom{}).Select("id").Where("teacher_id = ?", user.ID)
+		assignments := tx.Model(&Assignment{}).Select("id").Where("classroom_id IN (?)", classes)
+		if err := tx.Where("assignment_id IN (?)", assignments).Delete(&PeerReview{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Essay{}).Where("assignment_id IN (?)", assignments).Update("assignment_id", nil).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&ClassMember{}, &Assignment{}} {
+			if err := tx.Where("classroom_id IN (?)", classes).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("teacher_id = ?", user.ID).Delete(&Classroom{}).Error; err != nil {
+			return err
+		}
+
+		essays := tx.Model(&Essay{}).Select("id").Where("user_id = ?", user.ID)
+		if err := tx.Where("reviewer_id = ? OR essay_id IN (?)", user.ID, essays).Delete(&PeerReview{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("teacher_id = ? OR essay_id IN (?)", user.ID, essays).Delete(&BandOverride{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? OR essay_id IN (?)", user.ID, essays).Delete(&Notification{}).Error; err != nil {
+			return err
+		}
+
+		// Their comments and those on their essays, with every reply below
+		var comments []uint
+		if err := tx.Model(&EssayComment{}).Where("author_id = ? OR essay_id IN (?)", user.ID, essays).
+			Pluck("id", &comments).Error; err != nil {
+			return err
+		}
+		if len(comments) > 0 {
+			if err := tx.Where("id IN ?", commentSubtree(tx, comments)).Delete(&EssayComment{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&Essay{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+}
+
+type gormEssayRepo struct{ db *gorm.DB }
+
+func (r gormEssayRepo) ListByUser(userID uint, limit int) ([]Essay, error) {
+	var essays []Essay
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&essays).Error
+	return essays, err
+}
+
+func (r gormEssayRepo) CountByUser(userID uint, since time.Time) (EssayCounts, error) {
+	var counts EssayCounts
+	err := r.db.Model(&Essay{}).
+		Select("COUNT(*) AS total, COUNT(CASE WHEN created_at > ? THEN 1 END) AS recent", since).
+		Where("user_id = ?", userID).
+		Scan(&counts).Error
+	return counts, err
+}
+
+func (r gormEssayRepo) DeleteForUser(userID, id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&Essay{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errNotFound
+		}
+		for _, model := range []interface{}{&Annotation{}, &EssayComment{}, &PeerReview{}, &BandOverride{}, &Notification{}} {
+			if err := tx.Where("essay_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type gormBlogRepo struct{ db *gorm.DB }
+
+// blogEditable are the columns Save writes
+var blogEditable = []string{"title", "slug", "excerpt", "content", "category", "tags", "read_time", "is_published", "published_at", "updated_at"}
+
+func (r gormBlogRepo) List(orgID *uint) ([]BlogPost, error) {
+	var posts []BlogPost
+	err := r.db.Scopes(TenantScope(orgID)).Order("created_at DESC").Find(&posts).Error
+	return posts, err
+}
+
+func (r gormBlogRepo) Get(orgID *uint, id uint) (BlogPost, error) {
+	var post BlogPost
+	err := r.db.Scopes(TenantScope(orgID)).First(&post, id).Error
+	return post, notFound(err)
+}
+
+func (r gormBlogRepo) Create(post *BlogPost) error {
+	return r.db.Create(post).Error
+}
+
+func (r gormBlogRepo) Save(post *BlogPost) error {
+	return r.db.Model(post).Select(blogEditable).Updates(post).Error
+}
+
+func (r gormBlogRepo) Delete(post BlogPost) error {
+	return r.db.Delete(&post).Error
+}
+
+type gormPromptRepo struct{ db *gorm.DB }
+
+// promptEditable are the columns Save writes
+var promptEditable = []string{"name", "description", "prompt", "type", "is_active", "updated_at"}
+
+func (r gormPromptRepo) List(orgID *uint) ([]AdminPrompt, error) {
+	var prompts []AdminPrompt
+	err := r.db.Scopes(TenantScope(orgID)).Order("created_at DESC").Find(&prompts).Error
+	return prompts, err
+}
+
+func (r gormPromptRepo) Get(orgID *uint, id uint) (AdminPrompt, error) {
+	var prompt AdminPrompt
+	err := r.db.Scopes(TenantScope(orgID)).First(&prompt, id).Error
+	return prompt, notFound(err)
+}
+
+func (r gormPromptRepo) Create(prompt *AdminPrompt) error {
+	return r.db.Create(prompt).Error
+}
+
+func (r gormPromptRepo) Save(prompt *AdminPrompt) error {
+	return r.db.Model(prompt).Select(promptEditable).Updates(prompt).Error
+}
+
+func (r gormPromptRepo) Delete(prompt AdminPrompt) error {
+	return r.db.Delete(&prompt).Error
+}
+
+type gormAnalyticsRepo struct{ db *gorm.DB }
+
+func (r gormAnalyticsRepo) RecordEvent(event *AnalyticsEvent) error {
+	return r.db.Create(event).Error
+}
+
+// TenantTotals reads every count in one statement: a grouped count per
+// table, joined into a single row
+func (r gormAnalyticsRepo) TenantTotals(orgID *uint, since, activeSince time.Time) (TenantTotals, error) {
+	tenant := TenantScope(orgID)
+	users := r.db.Model(&User{}).Scopes(tenant).
+		Select("COUNT(*) AS users, COUNT(CASE WHEN created_at > ? THEN 1 END) AS new_users", since)
+	essays := r.db.Model(&Essay{}).Scopes(tenant).
+		Select(`COUNT(*) AS essays, COUNT(DISTINCT user_id) AS essay_writers,
+			COUNT(DISTINCT CASE WHEN created_at > ? THEN user_id END) AS active_writers,
+			AVG(overall) AS average_overall`, activeSince)
+
+	// Anonymous feedback counts towards the platform
+	tenantUsers := r.db.Model(&User{}).Select("id").Scopes(tenant)
+	feedback := r.db.Model(&UserFeedback{}).Select("COUNT(*)")
+	if orgID == nil {
+		feedback = feedback.Where("user_id IS NULL OR user_id IN (?)", tenantUsers)
+	} else {
+		feedback = feedback.Where("user_id IN (?)", tenantUsers)
+	}
+	posts := r.db.Model(&BlogPost{}).Scopes(tenant).Select("COUNT(*)")
+
+	var totals TenantTotals
+	err := r.db.Raw(`SELECT u.users, u.new_users, e.essays, e.essay_writers, e.active_writers, e.average_overall,
+		(?) AS feedback, (?) AS blog_posts FROM (?) AS u, (?) AS e`, feedback, posts, users, essays).
+		Scan(&totals).Error
+	return totals, err
+}
+
+// EventTotals reads every count in one statement
+func (r gormAnalyticsRepo) EventTotals(since, activeSince time.Time) (EventTotals, error) {
+	from := since
+	if activeSince.Before(from) {
+		from = activeSince
+	}
+	events := r.db.Model(&AnalyticsEvent{}).
+		Select(`COUNT(CASE WHEN event_type = ? AND created_at > ? THEN 1 END) AS page_views,
+			COUNT(CASE WHEN event_type = ? AND created_at > ? THEN 1 END) AS analyses,
+			COUNT(DISTINCT CASE WHEN created_at > ? THEN session_id END) AS active_sessions`,
+			"page_view", since, "essay_analyze", since, activeSince).
+		Where("created_at > ?", from)
+	users := r.db.Model(&User{}).Select("COUNT(*)")
+	essays := r.db.Model(&Essay{}).Select("COUNT(*)")
+
+	var totals EventTotals
+	err := r.db.Raw(`SELECT (?) AS users, (?) AS essays, ev.page_views, ev.analyses, ev.active_sessions FROM (?) AS ev`,
+		users, essays, events).
+		Scan(&totals).Error
+	return totals, err
+}
@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestGormRepos(t *testing.T) {
+	db := openTestDB(t)
+	repos := NewRepos(db)
+	now := time.Now()
+	orgID := uint(1)
+
+	users := []User{
+		{Email: "a@example.com", Plan: "free", Role: RoleUser, CreatedAt: now.AddDate(0, 0, -30), OrganizationID: &orgID},
+		{Email: "b@example.com", Plan: "free", Role: RoleUser, CreatedAt: now.AddDate(0, 0, -2), OrganizationID: &orgID},
+		{Email: "c@example.com", Plan: "free", Role: RoleUser, CreatedAt: now, OrganizationID: &orgID},
+		{Email: "other@example.com", Plan: "free", Role: RoleUser, CreatedAt: now},
+	}
+	if err := db.Create(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	essay := func(user User, overall float32, age time.Duration) Essay {
+		e := Essay{UserID: &user.ID, Overall: overall, CreatedAt: now.Add(-age), OrganizationID: user.OrganizationID,
+			PublicID: fmt.Sprintf("%d-%v-%v", user.ID, overall, age)}
+		if err := db.Create(&e).Error; err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	essay(users[0], 6, 72*time.Hour)
+	essay(users[0], 7, time.Hour)
+	first := essay(users[1], 8, 72*time.Hour)
+	essay(users[3], 5, time.Hour)
+	db.Create(&Annotation{EssayID: first.ID, UserID: &users[1].ID})
+
+	t.Run("TenantTotals", func(t *testing.T) {
+		totals, err := repos.Analytics.TenantTotals(&orgID, now.AddDate(0, 0, -7), now.AddDate(0, 0, -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totals.Users != 3 || totals.NewUsers != 2 || totals.Essays != 3 || totals.EssayWriters != 2 || totals.ActiveWriters != 1 {
+			t.Errorf("totals = %+v", totals)
+		}
+		if totals.AverageOverall == nil || *totals.AverageOverall != 7 {
+			t.Errorf("average = %v, want 7", totals.AverageOverall)
+		}
+	})
+
+	t.Run("List counts essays per user", func(t *testing.T) {
+		list, total, err := repos.Users.List(&orgID, UserFilter{Search: "EXAMPLE", Limit: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 3 || len(list) != 3 {
+			t.Fatalf("total, len = %d, %d, want 3, 3", total, len(list))
+		}
+		counts := map[string]int64{}
+		for _, user := range list {
+			counts[user.Email] = user.EssayCount
+		}
+		if counts["a@example.com"] != 2 || counts["b@example.com"] != 1 || counts["c@example.com"] != 0 {
+			t.Errorf("essay counts = %v", counts)
+		}
+	})
+
+	t.Run("Get stays in the tenant", func(t *testing.T) {
+		if _, err := repos.Users.Get(&orgID, users[3].ID); !errors.Is(err, errNotFound) {
+			t.Errorf("Get() error = %v, want errNotFound", err)
+		}
+	})
+
+	t.Run("Update writes role and plan", func(t *testing.T) {
+		if err := repos.Users.Update(users[2].ID, RoleTeacher, "pro"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Users.Update(users[2].ID, "", ""); err != nil {
+			t.Fatal(err)
+		}
+		user, _ := repos.Users.Get(&orgID, users[2].ID)
+		if user.Role != RoleTeacher || user.Plan != "pro" {
+			t.Errorf("role, plan = %q, %q", user.Role, user.Plan)
+		}
+	})
+
+	t.Run("DeleteForUser", func(t *testing.T) {
+		if err := repos.Essays.DeleteForUser(users[0].ID, first.ID); !errors.Is(err, errNotFound) {
+			t.Fatalf("deleting another user's essay: error = %v, want errNotFound", err)
+		}
+		if err := repos.Essays.DeleteForUser(users[1].ID, first.ID); err != nil {
+			t.Fatal(err)
+		}
+		var left int64
+		db.Model(&Annotation{}).Where("essay_id = ?", first.ID).Count(&left)
+		if left != 0 {
+			t.Errorf("%d annotations left behind", left)
+		}
+	})
+
+	t.Run("EventTotals", func(t *testing.T) {
+		events := []AnalyticsEvent{
+			{EventType: "page_view", SessionID: "s1", CreatedAt: now.Add(-time.Hour)},
+			{EventType: "page_view", SessionID: "s2", CreatedAt: now.AddDate(0, 0, -3)},
+			{EventType: "essay_analyze", SessionID: "s1", CreatedAt: now.Add(-time.Hour)},
+			{EventType: "page_view", SessionID: "s3", CreatedAt: now.AddDate(0, 0, -30)},
+		}
+		for i := range events {
+			if err := repos.Analytics.RecordEvent(&events[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		totals, err := repos.Analytics.EventTotals(now.AddDate(0, 0, -7), now.AddDate(0, 0, -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := EventTotals{Users: 4, Essays: 3, PageViews: 2, Analyses: 1, ActiveSessions: 1}
+		if totals != want {
+			t.Errorf("totals = %+v, want %+v", totals, want)
+		}
+	})
+}
+
+func TestUserDeleteCascade(t *testing.T) {
+	f := newClassFixture(t)
+	essay := f.submitEssay(t)
+	users := NewRepos(f.db).Users
+
+	root := EssayComment{EssayID: essay.ID, AuthorID: f.teacher.ID, Body: "Which city?"}
+	f.db.Create(&root)
+	f.db.Create(&EssayComment{EssayID: essay.ID, AuthorID: f.student.ID, ParentID: &root.ID, Body: "Lagos"})
+	f.db.Create(&BandOverride{EssayID: essay.ID, TeacherID: f.teacher.ID, Criterion: "ta", Band: 7})
+	Notify(f.db, f.student.ID, "comment", "New comment from your teacher", root.Body, &essay.ID)
+	Notify(f.db, f.teacher.ID, "comment", "A student replied to your comment", "Lagos", &essay.ID)
+
+	count := func(model interface{}) int64 {
+		var n int64
+		f.db.Model(model).Count(&n)
+		return n
+	}
+
+	if err := users.Delete(f.teacher); err != nil {
+		t.Fatal(err)
+	}
+	// The teacher's thread goes with the student's reply in it
+	if n := count(&EssayComment{}); n != 0 {
+		t.Errorf("%d comments left after deleting the teacher", n)
+	}
+	if n := count(&BandOverride{}); n != 0 {
+		t.Errorf("%d overrides left after deleting the teacher", n)
+	}
+	if n := f.notifications(f.teacher.ID, "comment"); n != 0 {
+		t.Errorf("%d teacher notifications left", n)
+	}
+
+	if err := users.Delete(f.student); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(&Notification{}); n != 0 {
+		t.Errorf("%d notifications left after deleting the student", n)
+	}
+	if n := count(&Essay{}); n != 0 {
+		t.Errorf("%d essays left after deleting the student", n)
+	}
+}
+
+func TestUserDeleteOwnedRows(t *testing.T) {
+	f := newClassFixture(t)
+	submission := f.submitEssay(t)
+	db, teacher := f.db, f.teacher
+
+	// The teacher owns one of everything, and is a student in another class
+	other := Classroom{Name: "Other", TeacherID: f.otherTeacher.ID, JoinCode: "XYZ789"}
+	db.Create(&other)
+	db.Create(&ClassMember{ClassroomID: other.ID, UserID: teacher.ID})
+	db.Create(&PeerReview{AssignmentID: f.open.ID, EssayID: submission.ID, ReviewerID: f.outsider.ID})
+	db.Create(&APIKey{Name: "ci", Prefix: "bly_00000001", KeyHash: "h1", UserID: &teacher.ID, CreatedBy: teacher.ID})
+	db.Create(&UsageCounter{UserID: teacher.ID, Metric: MetricAnalyses, Period: "d2026-03-01", Count: 1})
+	db.Create(&Subscription{UserID: teacher.ID, Provider: "fake", Plan: "pro", Status: "active"})
+	db.Create(&VoucherRedemption{BatchID: 1, UserID: teacher.ID, VoucherID: 1, TransactionID: 1})
+	db.Create(&AnalyticsEvent{EventType: "page_view", UserID: &teacher.ID})
+	db.Create(&UserFeedback{UserID: &teacher.ID, Rating: 5})
+	err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := postCredits(tx, CreditTransaction{Kind: CreditGrant},
+			map[string]int{userCreditAccount(teacher.ID): 5, creditsFromGrants: -5})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewRepos(db).Users.Delete(teacher); err != nil {
+		t.Fatal(err)
+	}
+
+	// No table keeps a row pointing at the teacher
+	for _, model := range schemaModels {
+		for _, column := range []string{"user_id", "teacher_id"} {
+			if !db.Migrator().HasColumn(model, column) {
+				continue
+			}
+			var n int64
+			db.Model(model).Where(column+" = ?", teacher.ID).Count(&n)
+			if n != 0 {
+				t.Errorf("%T: %d rows with %s = %d", model, n, column, teacher.ID)
+			}
+		}
+	}
+	var assignments, reviews, classes int64
+	db.Model(&Assignment{}).Where("classroom_id = ?", f.class.ID).Count(&assignments)
+	db.Model(&PeerReview{}).Count(&reviews)
+	db.Model(&Classroom{}).Count(&classes)
+	if assignments != 0 || reviews != 0 || classes != 1 {
+		t.Errorf("assignments, peer reviews, classes = %d, %d, %d, want 0, 0, 1", assignments, reviews, classes)
+	}
+
+	var essay Essay
+	if err := db.First(&essay, submission.ID).Error; err != nil || essay.AssignmentID != nil {
+		t.Errorf("submission should stay as a practice essay: %+v, %v", essay, err)
+	}
+	var account CreditAccount
+	db.Where("name LIKE ?", "deleted:%").First(&account)
+	if account.Balance != 5 {
+		t.Errorf("renamed credit account = %+v, want the balance kept", account)
+	}
+	if creditBalance(db, teacher.ID) != 0 {
+		t.Error("a new user with the same ID would inherit the credits")
+	}
+}
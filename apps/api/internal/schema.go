@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// schemaModels are the tables of the baseline migration, parents first
+var schemaModels = []interface{}{
+	&Organization{},
+	&User{}, &Session{}, &UserIdentity{}, &UserMFA{}, &MFARecoveryCode{}, &Essay{}, &AnalyticsEvent{}, &UserFeedback{}, &BlogPost{}, &AdminPrompt{},
+	&Annotation{}, &StudyPlan{}, &VocabularyCard{},
+	&Question{}, &Classroom{}, &ClassMember{}, &Assignment{},
+	&EssayComment{}, &BandOverride{}, &Notification{},
+	&PeerReview{}, &AuditLog{}, &APIKey{},
+	&Plan{}, &UsageCounter{}, &Subscription{}, &BillingWebhook{},
+	&CreditAccount{}, &CreditTransaction{}, &CreditEntry{}, &VoucherBatch{}, &Voucher{}, &VoucherRedemption{},
+}
+
+// ddlRecorder collects the statements of a dry run
+type ddlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *ddlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// BaselineSQL renders the up and down migrations that create the tables of
+// the current models exactly as GORM's AutoMigrate did. It runs the Postgres
+// migrator in dry-run mode, so no database is needed.
+func BaselineSQL() (up, down string, err error) {
+	recorder := &ddlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               recorder,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	var upSQL, downSQL strings.Builder
+	upSQL.WriteString("-- Generated by `bandly migrate baseline` from the models\n")
+	downSQL.WriteString("-- Generated by `bandly migrate baseline` from the models\n")
+
+	var tables []string
+	for _, model := range schemaModels {
+		recorder.statements = nil
+		if err := db.Migrator().CreateTable(model); err != nil {
+			return "", "", err
+		}
+		if len(recorder.statements) == 0 {
+			return "", "", fmt.Errorf("no DDL for %T", model)
+		}
+		// CREATE TABLE comes first, indexes follow in map order
+		create, indexes := recorder.statements[0], recorder.statements[1:]
+		sort.Strings(indexes)
+
+		upSQL.WriteString("\n" + create + ";\n")
+		for _, index := range indexes {
+			upSQL.WriteString(index + ";\n")
+		}
+
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return "", "", err
+		}
+		tables = append(tables, stmt.Schema.Table)
+	}
+
+	for i := len(tables) - 1; i >= 0; i-- {
+		fmt.Fprintf(&downSQL, "DROP TABLE IF EXISTS %q;\n", tables[i])
+	}
+	return upSQL.String(), downSQL.String(), nil
+}
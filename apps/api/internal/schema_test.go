@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestBaselineSQL(t *testing.T) {
+	up, down, err := BaselineSQL()
+	if err != nil {
+		t.Fatalf("BaselineSQL() error = %v", err)
+	}
+	again, _, _ := BaselineSQL()
+	if up != again {
+		t.Error("BaselineSQL() should render the same SQL every time")
+	}
+
+	db, _ := gorm.Open(nil, &gorm.Config{DryRun: true})
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		table := stmt.Schema.Table
+		if !strings.Contains(up, `CREATE TABLE "`+table+`"`) {
+			t.Errorf("up migration does not create %s", table)
+		}
+		if !strings.Contains(down, `DROP TABLE IF EXISTS "`+table+`"`) {
+			t.Errorf("down migration does not drop %s", table)
+		}
+	}
+}
@@ -19,6 +19,8 @@ type ScoreOut struct {
 	Overall  float32 `json:"overall"`
 	Feedback string  `json:"feedback"`
 	CEFR     string  `json:"cefr"`
+
+	Annotations []ScoreAnnotation `json:"annotations,omitempty"`
 }
 
 // clampBand ensures band scores are in valid 0.5 increments between 0-9
@@ -58,6 +60,81 @@ func MinWordsOK(text string) bool {
 	return words >= 150 && words <= 320
 }
 
+// BandDescriptor is one band level of a criterion
+type BandDescriptor struct {
+	Band float32 `json:"band"`
+	Text string  `json:"text"`
+}
+
+// CriterionDescriptor lists the band descriptors for one criterion
+type CriterionDescriptor struct {
+	Key   string           `json:"key"`
+	Title string           `json:"title"`
+	Bands []BandDescriptor `json:"bands"` // highest band first
+}
+
+// BandDescriptors are the band descriptors shared by the AI scorer prompt and
+// the peer review form
+var BandDescriptors = []CriterionDescriptor{
+	{
+		Key:   "ta",
+		Title: "TASK ACHIEVEMENT (Task 2) / TASK RESPONSE (Task 1)",
+		Bands: []BandDescriptor{
+			{9, "Fully addresses all parts, clear position throughout, fully extended ideas"},
+			{8, "Sufficiently addresses all parts, clear position, well-developed ideas"},
+			{7, "Addresses all parts, clear position throughout, main ideas extended"},
+			{6, "Addresses all parts but some more fully, relevant position, some unclear ideas"},
+			{5, "Addresses task only partially, position unclear, limited idea development"},
+		},
+	},
+	{
+		Key:   "cc",
+		Title: "COHERENCE AND COHESION",
+		Bands: []BandDescriptor{
+			{9, "Cohesion natural, no effort required by reader, wide range of devices"},
+			{8, "Sequences information logically, wide range of cohesive devices"},
+			{7, "Logically organizes information, clear progression, range of devices"},
+			{6, "Arranges information coherently, overall progression, some inappropriate linking"},
+			{5, "Presents information with some organization, inadequate/inaccurate linking"},
+		},
+	},
+	{
+		Key:   "lr",
+		Title: "LEXICAL RESOURCE",
+		Bands: []BandDescriptor{
+			{9, "Wide range with full flexibility, natural/sophisticated usage, rare errors"},
+			{8, "Wide range with flexibility, less common items, occasional inappropriacies"},
+			{7, "Sufficient range with flexibility, less common vocabulary, some errors"},
+			{6, "Adequate range for task, attempts less common vocabulary, some errors"},
+			{5, "Limited range, repetition, inappropriate word choice, errors may impede"},
+		},
+	},
+	{
+		Key:   "gra",
+		Title: "GRAMMATICAL RANGE AND ACCURACY",
+		Bands: []BandDescriptor{
+			{9, "Full range with full flexibility, accurate usage, error-free"},
+			{8, "Wide range with flexibility, majority error-free, occasional slips"},
+			{7, "Range of complex structures, frequent error-free sentences, good control"},
+			{6, "Mix of simple/complex sentences, some errors but communication clear"},
+			{5, "Limited range, attempts complex but with errors, frequent errors"},
+		},
+	},
+}
+
+// BandDescriptorsText renders BandDescriptors in the layout used by the scorer prompt
+func BandDescriptorsText() string {
+	var b strings.Builder
+	for _, c := range BandDescriptors {
+		fmt.Fprintf(&b, "%s:\n", c.Title)
+		for _, d := range c.Bands {
+			fmt.Fprintf(&b, "- Band %s: %s\n", formatBand(d.Band), d.Text)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
 // BuildPrompt creates the system and user prompts for OpenAI with expert-level IELTS assessment
 func BuildPrompt(taskType, promptText, essayText string) (system, user string) {
 	system = `You are a Senior IELTS Writing Examiner with 25 years of experience, holding Band 8.5-9.0 proficiency yourself. You have assessed over 50,000 essays and are known for your strict but fair evaluation standards.
@@ -76,35 +153,7 @@ ASSESSMENT METHODOLOGY:
 
 BAND DESCRIPTORS (be extremely precise):
 
-TASK ACHIEVEMENT (Task 2) / TASK RESPONSE (Task 1):
-- Band 9: Fully addresses all parts, clear position throughout, fully extended ideas
-- Band 8: Sufficiently addresses all parts, clear position, well-developed ideas
-- Band 7: Addresses all parts, clear position throughout, main ideas extended
-- Band 6: Addresses all parts but some more fully, relevant position, some unclear ideas
-- Band 5: Addresses task only partially, position unclear, limited idea development
-
-COHERENCE AND COHESION:
-- Band 9: Cohesion natural, no effort required by reader, wide range of devices
-- Band 8: Sequences information logically, wide range of cohesive devices
-- Band 7: Logically organizes information, clear progression, range of devices
-- Band 6: Arranges information coherently, overall progression, some inappropriate linking
-- Band 5: Presents information with some organization, inadequate/inaccurate linking
-
-LEXICAL RESOURCE:
-- Band 9: Wide range with full flexibility, natural/sophisticated usage, rare errors
-- Band 8: Wide range with flexibility, less common items, occasional inappropriacies
-- Band 7: Sufficient range with flexibility, less common vocabulary, some errors
-- Band 6: Adequate range for task, attempts less common vocabulary, some errors
-- Band 5: Limited range, repetition, inappropriate word choice, errors may impede
-
-GRAMMATICAL RANGE AND ACCURACY:
-- Band 9: Full range with full flexibility, accurate usage, error-free
-- Band 8: Wide range with flexibility, majority error-free, occasional slips
-- Band 7: Range of complex structures, frequent error-free sentences, good control
-- Band 6: Mix of simple/complex sentences, some errors but communication clear
-- Band 5: Limited range, attempts complex but with errors, frequent errors
-
-CRITICAL ASSESSMENT PRINCIPLES:
+` + BandDescriptorsText() + `CRITICAL ASSESSMENT PRINCIPLES:
 - Be STRICT: Band 7+ requires genuine proficiency, not just adequate responses
 - Penalize heavily: Repetitive vocabulary, basic grammar, unclear arguments
 - Reward excellence: Sophisticated language, nuanced arguments, flawless execution
@@ -127,8 +176,18 @@ FEEDBACK REQUIREMENTS:
 - Use **bold** formatting for key terms and scores (e.g., **Task Achievement**, **Band 6.5**)
 - Use *italic* formatting for emphasis where appropriate
 
+ANNOTATION REQUIREMENTS:
+- List up to 12 concrete errors as annotations, most serious first
+- "excerpt" must be copied EXACTLY from the essay (a word or short phrase)
+- "suggestion" is the corrected or better wording; "explanation" is one short sentence
+- "criterion" is one of ta, cc, lr, gra and "category" must come from its list:
+  ta: off_topic, unclear_position, underdeveloped_idea, missing_overview, irrelevant_detail
+  cc: linking_misuse, paragraphing, referencing, progression
+  lr: word_choice, collocation, repetition, word_form, spelling, register
+  gra: articles, subject_verb_agreement, tense, prepositions, plurals, sentence_structure, punctuation
+
 Return ONLY this JSON structure:
-{"ta":number,"cc":number,"lr":number,"gra":number,"overall":number,"feedback":"...","cefr":"A1|A2|B1|B2|C1|C2"}
+{"ta":number,"cc":number,"lr":number,"gra":number,"overall":number,"feedback":"...","cefr":"A1|A2|B1|B2|C1|C2","annotations":[{"criterion":"lr","category":"collocation","excerpt":"...","suggestion":"...","explanation":"..."}]}
 
 REMEMBER: You are NOT being helpful - you are being ACCURATE to IELTS standards. Many essays that seem "okay" are actually Band 6.0-6.5. Be rigorous.`
 
@@ -181,7 +240,7 @@ func CallOpenAI(ctx context.Context, apiKey, system, user string) (string, error
 	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
 		Model:       openai.GPT4TurboPreview, // Use GPT-4 Turbo for superior reasoning
 		Temperature: 0.1,                     // Lower temperature for more consistent scoring
-		MaxTokens:   800,                     // More tokens for detailed feedback
+		MaxTokens:   1400,                    // Room for detailed feedback and annotations
 		TopP:        0.95,                    // Slightly focused responses
 		Messages: []openai.ChatCompletionMessage{
 			{
@@ -206,9 +265,13 @@ func CallOpenAI(ctx context.Context, apiKey, system, user string) (string, error
 	return resp.Choices[0].Message.Content, nil
 }
 
-// ScoreEssay performs the complete essay analysis with enhanced accuracy
-func ScoreEssay(ctx context.Context, apiKey, taskType, promptText, essayText string) (ScoreOut, error) {
+// ScoreEssay performs the complete essay analysis with enhanced accuracy.
+// guidance is an organisation's scoring prompt override, "" for none.
+func ScoreEssay(ctx context.Context, apiKey, taskType, promptText, essayText, guidance string) (ScoreOut, error) {
 	system, user := BuildPrompt(taskType, promptText, essayText)
+	if guidance != "" {
+		system += "\n\nINSTITUTION GUIDANCE (apply within the official band descriptors and keep the JSON format):\n" + guidance
+	}
 
 	// Primary assessment with GPT-4
 	raw, err := CallOpenAI(ctx, apiKey, system, user)
@@ -318,6 +381,9 @@ func validateAndEnhanceScore(score ScoreOut, essayText, taskType string) ScoreOu
 	// Set CEFR based on final overall score
 	score.CEFR = MapOverallToCEFR(score.Overall)
 
+	// Keep only annotations that fit the error taxonomy
+	score.Annotations = normalizeAnnotations(score.Annotations)
+
 	// Enhance feedback if too generic
 	if len(score.Feedback) < 50 || strings.Contains(score.Feedback, "good essay") {
 		score.Feedback = enhanceFeedback(score, essayText, taskType)
@@ -0,0 +1,391 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	errUnknownPlan  = errors.New("unknown plan")
+	errStaffAccount = errors.New("cannot modify staff accounts")
+	errDeleteStaff  = errors.New("cannot delete staff accounts")
+	errDeleteAdmin  = errors.New("cannot delete admin users")
+)
+
+// Services hold the business rules of the handlers built on repositories
+type Services struct {
+	Admin     *AdminService
+	Essays    *EssayService
+	Blog      *BlogService
+	Prompts   *PromptService
+	Analytics *AnalyticsService
+}
+
+// NewServices builds the services over repos. Redis is optional and only
+// keeps the live analytics counters.
+func NewServices(repos Repos, rdb *redis.Client) Services {
+	return Services{
+		Admin:     &AdminService{Users: repos.Users, Analytics: repos.Analytics, now: time.Now},
+		Essays:    &EssayService{Essays: repos.Essays, now: time.Now},
+		Blog:      &BlogService{Posts: repos.Blog, now: time.Now},
+		Prompts:   &PromptService{Prompts: repos.Prompts},
+		Analytics: &AnalyticsService{Events: repos.Analytics, rdb: rdb, now: time.Now},
+	}
+}
+
+// AdminService manages a tenant's users and its dashboard
+type AdminService struct {
+	Users     UserRepo
+	Analytics AnalyticsRepo
+	now       func() time.Time
+}
+
+// Dashboard returns the tenant's totals, with daily activity and weekly
+// signups
+func (s *AdminService) Dashboard(orgID *uint) (AdminDashboardStats, error) {
+	now := s.now()
+	totals, err := s.Analytics.TenantTotals(orgID, now.AddDate(0, 0, -7), now.AddDate(0, 0, -1))
+	if err != nil {
+		return AdminDashboardStats{}, err
+	}
+
+	stats := AdminDashboardStats{
+		TotalUsers:       totals.Users,
+		TotalEssays:      totals.Essays,
+		TotalFeedback:    totals.Feedback,
+		TotalBlogPosts:   totals.BlogPosts,
+		DailyActiveUsers: totals.ActiveWriters,
+		WeeklySignups:    totals.NewUsers,
+	}
+	if totals.AverageOverall != nil {
+		stats.AvgScore = math.Round(*totals.AverageOverall*100) / 100
+	}
+	// Share of users who wrote at least one essay
+	if totals.Users > 0 {
+		stats.ConversionRate = math.Round(float64(totals.EssayWriters)/float64(totals.Users)*10000) / 100
+	}
+	return stats, nil
+}
+
+// ListUsers returns a page of the tenant's users and the total matching
+func (s *AdminService) ListUsers(orgID *uint, filter UserFilter) ([]UserSummary, int64, error) {
+	return s.Users.List(orgID, filter)
+}
+
+// UpdateUser changes a user's plan and role, returning the user before and
+// after. Staff accounts are reserved for roles:assign holders.
+func (s *AdminService) UpdateUser(actor User, orgID *uint, id uint, plan, role string) (User, User, error) {
+	user, err := s.Users.Get(orgID, id)
+	if err != nil {
+		return User{}, User{}, err
+	}
+	if !canManageUser(actor, user) {
+		return User{}, User{}, errStaffAccount
+	}
+	if plan != "" && !s.Users.PlanExists(plan) {
+		return User{}, User{}, errUnknownPlan
+	}
+
+	// Role changes go through the same checks as PUT /users/:id/role
+	if role == user.Role {
+		role = ""
+	}
+	if role != "" {
+		if err := checkRoleAssignment(actor, user, role); err != nil {
+			return User{}, User{}, err
+		}
+	}
+
+	// Both fields are written together so a failure changes neither
+	if err := s.Users.Update(user.ID, role, plan); err != nil {
+		return User{}, User{}, err
+	}
+	before := user
+	if role != "" {
+		user.Role = role
+	}
+	if plan != "" {
+		user.Plan = plan
+	}
+	return before, user, nil
+}
+
+// SetRole assigns a role to a user of the tenant
+func (s *AdminService) SetRole(actor User, orgID *uint, id uint, role string) (User, User, error) {
+	user, err := s.Users.Get(orgID, id)
+	if err != nil {
+		return User{}, User{}, err
+	}
+	if err := checkRoleAssignment(actor, user, role); err != nil {
+		return User{}, User{}, err
+	}
+	if err := s.Users.Update(user.ID, role, ""); err != nil {
+		return User{}, User{}, err
+	}
+	before := user
+	user.Role = role
+	return before, user, nil
+}
+
+// DeleteUser removes a user and their data. Admins cannot be deleted, and
+// staff only by roles:assign holders.
+func (s *AdminService) DeleteUser(actor User, orgID *uint, id uint) (User, error) {
+	user, err := s.Users.Get(orgID, id)
+	if err != nil {
+		return User{}, err
+	}
+	if user.Role == RoleAdmin {
+		return User{}, errDeleteAdmin
+	}
+	if !canManageUser(actor, user) {
+		return User{}, errDeleteStaff
+	}
+	return user, s.Users.Delete(user)
+}
+
+// UserDashboardStats sums up a student's recent essays
+type UserDashboardStats struct {
+	TotalEssays  int64     `json:"totalEssays"`
+	AverageScore float32   `json:"averageScore"`
+	MonthlyCount int64     `json:"monthlyCount"`
+	Improvement  string    `json:"improvement"` // "improving", "declining" or "stable"
+	RecentScores []float32 `json:"recentScores"`
+}
+
+// EssayService serves a student's own essays
+type EssayService struct {
+	Essays EssayRepo
+	now    func() time.Time
+}
+
+// History returns the user's last 50 essays, newest first
+func (s *EssayService) History(userID uint) ([]Essay, error) {
+	return s.Essays.ListByUser(userID, 50)
+}
+
+// Dashboard averages the last 10 essays and compares the newest with the
+// oldest of them
+func (s *EssayService) Dashboard(userID uint) (UserDashboardStats, error) {
+	counts, err := s.Essays.CountByUser(userID, s.now().AddDate(0, 0, -30))
+	if err != nil {
+		return UserDashboardStats{}, err
+	}
+	recent, err := s.Essays.ListByUser(userID, 10)
+	if err != nil {
+		return UserDashboardStats{}, err
+	}
+
+	stats := UserDashboardStats{TotalEssays: counts.Total, MonthlyCount: counts.Recent, Improvement: "stable"}
+	for _, essay := range recent {
+		stats.AverageScore += essay.Overall
+		stats.RecentScores = append(stats.RecentScores, essay.Overall)
+	}
+	if len(recent) > 0 {
+		stats.AverageScore /= float32(len(recent))
+	}
+	if n := len(stats.RecentScores); n >= 3 {
+		newest, oldest := stats.RecentScores[0], stats.RecentScores[n-1]
+		if newest > oldest+0.5 {
+			stats.Improvement = "improving"
+		} else if newest < oldest-0.5 {
+			stats.Improvement = "declining"
+		}
+	}
+	return stats, nil
+}
+
+// Delete removes one of the user's essays
+func (s *EssayService) Delete(userID, id uint) error {
+	return s.Essays.DeleteForUser(userID, id)
+}
+
+// BlogService edits a tenant's blog
+type BlogService struct {
+	Posts BlogRepo
+	now   func() time.Time
+}
+
+// List returns the tenant's posts, newest first
+func (s *BlogService) List(orgID *uint) ([]BlogPost, error) {
+	return s.Posts.List(orgID)
+}
+
+// Create adds a post by the admin to their tenant. The slug defaults to
+// one made from the title, and publishing stamps the post.
+func (s *BlogService) Create(author User, req BlogCreateRequest) (BlogPost, error) {
+	post := BlogPost{
+		Title:       req.Title,
+		Slug:        req.Slug,
+		Excerpt:     req.Excerpt,
+		Content:     req.Content,
+		Category:    req.Category,
+		Tags:        strings.Join(req.Tags, ","),
+		ReadTime:    req.ReadTime,
+		IsPublished: req.IsPublished,
+		PublishedAt: req.PublishedAt,
+		AuthorID:    author.ID,
+
+		OrganizationID: author.OrganizationID,
+	}
+	if post.Slug == "" {
+		post.Slug = generateSlug(req.Title)
+	}
+	if post.IsPublished && post.PublishedAt == nil {
+		now := s.now()
+		post.PublishedAt = &now
+	}
+	return post, s.Posts.Create(&post)
+}
+
+// Update replaces the editable fields of a post, returning it before and
+// after. A post published for the first time is stamped.
+func (s *BlogService) Update(orgID *uint, id uint, req BlogUpdateRequest) (BlogPost, BlogPost, error) {
+	post, err := s.Posts.Get(orgID, id)
+	if err != nil {
+		return BlogPost{}, BlogPost{}, err
+	}
+	before := post
+
+	post.Title = req.Title
+	post.Slug = req.Slug
+	post.Excerpt = req.Excerpt
+	post.Content = req.Content
+	post.Category = req.Category
+	post.Tags = strings.Join(req.Tags, ",")
+	post.ReadTime = req.ReadTime
+	post.IsPublished = req.IsPublished
+	post.PublishedAt = req.PublishedAt
+	if req.IsPublished && before.PublishedAt == nil && req.PublishedAt == nil {
+		now := s.now()
+		post.PublishedAt = &now
+	}
+	return before, post, s.Posts.Save(&post)
+}
+
+// Delete removes a post of the tenant
+func (s *BlogService) Delete(orgID *uint, id uint) (BlogPost, error) {
+	post, err := s.Posts.Get(orgID, id)
+	if err != nil {
+		return BlogPost{}, err
+	}
+	return post, s.Posts.Delete(post)
+}
+
+// PromptService edits a tenant's prompt overrides
+type PromptService struct {
+	Prompts PromptRepo
+}
+
+// List returns the tenant's prompts, newest first
+func (s *PromptService) List(orgID *uint) ([]AdminPrompt, error) {
+	return s.Prompts.List(orgID)
+}
+
+// Create adds a prompt to the tenant
+func (s *PromptService) Create(orgID *uint, req PromptRequest) (AdminPrompt, error) {
+	prompt := AdminPrompt{
+		Name:        req.Name,
+		Description: req.Description,
+		Prompt:      req.Prompt,
+		Type:        req.Type,
+		IsActive:    req.IsActive,
+
+		OrganizationID: orgID,
+	}
+	return prompt, s.Prompts.Create(&prompt)
+}
+
+// Update replaces the editable fields of a prompt, returning it before and
+// after
+func (s *PromptService) Update(orgID *uint, id uint, req PromptRequest) (AdminPrompt, AdminPrompt, error) {
+	prompt, err := s.Prompts.Get(orgID, id)
+	if err != nil {
+		return AdminPrompt{}, AdminPrompt{}, err
+	}
+	before := prompt
+
+	prompt.Name = req.Name
+	prompt.Description = req.Description
+	prompt.Prompt = req.Prompt
+	prompt.Type = req.Type
+	prompt.IsActive = req.IsActive
+	return before, prompt, s.Prompts.Save(&prompt)
+}
+
+// Delete removes a prompt of the tenant
+func (s *PromptService) Delete(orgID *uint, id uint) (AdminPrompt, error) {
+	prompt, err := s.Prompts.Get(orgID, id)
+	if err != nil {
+		return AdminPrompt{}, err
+	}
+	return prompt, s.Prompts.Delete(prompt)
+}
+
+// SiteStats are the site-wide analytics of a period
+type SiteStats struct {
+	TotalUsers       int64   `json:"totalUsers"`
+	TotalEssays      int64   `json:"totalEssays"`
+	TotalPageViews   int64   `json:"totalPageViews"`
+	ConversionRate   float64 `json:"conversionRate"`
+	DailyActiveUsers int64   `json:"dailyActiveUsers"`
+}
+
+// AnalyticsService records events and reports on them
+type AnalyticsService struct {
+	Events AnalyticsRepo
+	rdb    *redis.Client
+	now    func() time.Time
+}
+
+// Track stores an event and bumps the live daily and hourly counters
+func (s *AnalyticsService) Track(ctx context.Context, event AnalyticsEvent) error {
+	event.CreatedAt = s.now()
+	if err := s.Events.RecordEvent(&event); err != nil {
+		return err
+	}
+
+	if s.rdb != nil {
+		daily := "analytics:daily:" + event.CreatedAt.Format("2006-01-02") + ":" + event.EventType
+		s.rdb.Incr(ctx, daily)
+		s.rdb.Expire(ctx, daily, 30*24*time.Hour)
+
+		hourly := "analytics:hourly:" + event.CreatedAt.Format("2006-01-02-15") + ":" + event.EventType
+		s.rdb.Incr(ctx, hourly)
+		s.rdb.Expire(ctx, hourly, 48*time.Hour)
+	}
+	return nil
+}
+
+// Stats reports on a period of "7d", "30d" or "90d", 7 days for anything
+// else. Conversion is analyses per page view; active users are sessions
+// seen in the last 24 hours.
+func (s *AnalyticsService) Stats(period string) (SiteStats, error) {
+	days := 7
+	switch period {
+	case "30d":
+		days = 30
+	case "90d":
+		days = 90
+	}
+
+	now := s.now()
+	totals, err := s.Events.EventTotals(now.AddDate(0, 0, -days), now.AddDate(0, 0, -1))
+	if err != nil {
+		return SiteStats{}, err
+	}
+	stats := SiteStats{
+		TotalUsers:       totals.Users,
+		TotalEssays:      totals.Essays,
+		TotalPageViews:   totals.PageViews,
+		DailyActiveUsers: totals.ActiveSessions,
+	}
+	if totals.PageViews > 0 {
+		stats.ConversionRate = float64(totals.Analyses) / float64(totals.PageViews) * 100
+	}
+	return stats, nil
+}
@@ -0,0 +1,296 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Session is a signed-in device. It holds the hash of the current refresh
+// token; access tokens carry its ID in the "sid" claim.
+type Session struct {
+	ID              string     `gorm:"primaryKey;size:36" json:"id"`
+	UserID          uint       `gorm:"index" json:"-"`
+	RefreshHash     string     `gorm:"uniqueIndex;size:64" json:"-"`
+	PrevRefreshHash string     `gorm:"index;size:64" json:"-"` // last rotated-out token, to detect reuse
+	AccessJTI       string     `gorm:"size:36" json:"-"`       // jti of the newest access token
+	Device          string     `json:"device"`
+	UserAgent       string     `json:"userAgent"`
+	IP              string     `json:"ip"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	LastUsedAt      time.Time  `json:"lastUsedAt"`
+	ExpiresAt       time.Time  `json:"expiresAt"`
+	RevokedAt       *time.Time `json:"revokedAt,omitempty"`
+}
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// hashToken returns the hex SHA-256 of a refresh token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken returns a random opaque refresh token
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// describeDevice turns a user agent into a short "Browser on OS" label
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/"), strings.Contains(ua, "opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/"), strings.Contains(ua, "crios/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"), strings.Contains(ua, "postman"), strings.Contains(ua, "okhttp"):
+		browser = "API client"
+	}
+
+	platform := "unknown OS"
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		platform = "iOS"
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"), strings.Contains(ua, "macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+
+	return browser + " on " + platform
+}
+
+// startSession records a new session for the request's device and returns
+// its tokens
+func startSession(db *gorm.DB, auth *AuthService, c *gin.Context, user User) (AuthResponse, error) {
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	now := time.Now()
+	session := Session{
+		ID:          uuid.NewString(),
+		UserID:      user.ID,
+		RefreshHash: hashToken(refresh),
+		AccessJTI:   uuid.NewString(),
+		Device:      describeDevice(c.Request.UserAgent()),
+		UserAgent:   c.Request.UserAgent(),
+		IP:          c.ClientIP(),
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(refreshTokenTTL),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return AuthResponse{}, err
+	}
+
+	access, err := auth.Issue(user.ID, session.ID, session.AccessJTI, now)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	auditEvent(c, "auth.login", &user, gin.H{"sessionId": session.ID, "via": c.FullPath()})
+
+	return AuthResponse{
+		Token:        access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		SessionID:    session.ID,
+	}, nil
+}
+
+// sessionActive reports whether an access token's session is live and its
+// jti is the session's newest. Logging out revokes every jti of the session;
+// a refresh revokes the jti it replaces.
+func sessionActive(db *gorm.DB, sid, jti string, userID uint) bool {
+	var count int64
+	db.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND access_jti = ? AND revoked_at IS NULL AND expires_at > ?", sid, userID, jti, time.Now()).
+		Count(&count)
+	return count == 1
+}
+
+// revokeSessions revokes the given user's sessions, all of them when no IDs
+// are given
+func revokeSessions(db *gorm.DB, userID uint, ids ...string) (int64, error) {
+	query := db.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	result := query.Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// RefreshToken swaps a refresh token for a new access and refresh token pair.
+// Presenting an already rotated token revokes the session, since it means the
+// token was copied.
+func RefreshToken(db *gorm.DB, auth *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			RefreshToken string `json:"refreshToken" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refreshToken is required"})
+			return
+		}
+
+		now := time.Now()
+		hash := hashToken(req.RefreshToken)
+
+		var session Session
+		if err := db.Where("refresh_hash = ?", hash).First(&session).Error; err != nil {
+			if db.Where("prev_refresh_hash = ?", hash).First(&session).Error == nil {
+				revokeSessions(db, session.UserID, session.ID)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token reused, session revoked"})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session expired"})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, session.UserID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		refresh, err := newRefreshToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+		jti := uuid.NewString()
+
+		// Only one of two concurrent refreshes with the same token wins
+		result := db.Model(&Session{}).
+			Where("id = ? AND refresh_hash = ?", session.ID, hash).
+			Updates(map[string]interface{}{
+				"refresh_hash":      hashToken(refresh),
+				"prev_refresh_hash": hash,
+				"access_jti":        jti,
+				"last_used_at":      now,
+				"ip":                c.ClientIP(),
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+
+		access, err := auth.Issue(user.ID, session.ID, jti, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, AuthResponse{
+			Token:        access,
+			RefreshToken: refresh,
+			ExpiresIn:    int(accessTokenTTL.Seconds()),
+			SessionID:    session.ID,
+			User:         userInfoOf(user),
+		})
+	}
+}
+
+// Logout revokes the current session
+func Logout(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		if _, err := revokeSessions(db, userID, c.GetString("sessionID")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+			return
+		}
+		audit(c, AuditLog{Action: "auth.logout", TargetType: "session", TargetID: c.GetString("sessionID")})
+
+		c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+	}
+}
+
+// LogoutAll revokes every session of the current user, on all devices
+func LogoutAll(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		revoked, err := revokeSessions(db, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+			return
+		}
+		audit(c, AuditLog{Action: "auth.logout_all", TargetType: "user", TargetID: fmt.Sprint(userID)})
+
+		c.JSON(http.StatusOK, gin.H{"message": "logged out of all devices", "revoked": revoked})
+	}
+}
+
+// GetSessions lists the current user's active sessions
+func GetSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var sessions []Session
+		db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+			Order("last_used_at DESC").
+			Find(&sessions)
+
+		current := c.GetString("sessionID")
+		out := make([]gin.H, len(sessions))
+		for i, s := range sessions {
+			out[i] = gin.H{"session": s, "current": s.ID == current}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"sessions": out})
+	}
+}
+
+// RevokeSession signs out one of the current user's sessions
+func RevokeSession(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		revoked, err := revokeSessions(db, userID, c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+			return
+		}
+		if revoked == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		audit(c, AuditLog{Action: "auth.session_revoked", TargetType: "session", TargetID: c.Param("id")})
+
+		c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+	}
+}
@@ -0,0 +1,380 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeAPIVersion pins the shape of API responses
+const stripeAPIVersion = "2024-06-20"
+
+// stripeWebhookTolerance is how old a signed webhook may be, against replays
+const stripeWebhookTolerance = 5 * time.Minute
+
+// StripeProvider takes payments with Stripe Checkout and Billing
+type StripeProvider struct {
+	SecretKey     string
+	WebhookSecret string
+	Prices        map[string]string // plan name -> Stripe price ID
+	PackPrices    map[string]string // credit pack name -> one-off price ID
+	BaseURL       string
+
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewStripeProvider reads STRIPE_SECRET_KEY, STRIPE_WEBHOOK_SECRET,
+// STRIPE_PRICES, which maps plans to recurring prices, and
+// STRIPE_PACK_PRICES, which maps credit packs to one-off prices:
+//
+//	STRIPE_PRICES=pro=price_1Nxxx,team=price_1Nyyy
+//	STRIPE_PACK_PRICES=starter=price_1Nzzz
+func NewStripeProvider() (*StripeProvider, error) {
+	p := &StripeProvider{
+		SecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+		WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+	}
+	if p.SecretKey == "" || p.WebhookSecret == "" {
+		return nil, errors.New("Stripe billing needs STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET")
+	}
+	var err error
+	if p.Prices, err = parseEnvPairs("STRIPE_PRICES"); err != nil {
+		return nil, err
+	}
+	if p.PackPrices, err = parseEnvPairs("STRIPE_PACK_PRICES"); err != nil {
+		return nil, err
+	}
+	if len(p.Prices) == 0 && len(p.PackPrices) == 0 {
+		return nil, errors.New("Stripe billing needs STRIPE_PRICES or STRIPE_PACK_PRICES")
+	}
+	return p, nil
+}
+
+// Name identifies Stripe subscriptions
+func (p *StripeProvider) Name() string { return "stripe" }
+
+// Plans are the plans with a configured price
+func (p *StripeProvider) Plans() []string { return sortedKeys(p.Prices) }
+
+// Packs are the credit packs with a configured price
+func (p *StripeProvider) Packs() []string { return sortedKeys(p.PackPrices) }
+
+// planOfPrice maps a price back to its plan, "" when not configured
+func (p *StripeProvider) planOfPrice(price string) string {
+	for plan, id := range p.Prices {
+		if id == price {
+			return plan
+		}
+	}
+	return ""
+}
+
+// call sends a form-encoded request to the Stripe API and decodes the reply
+func (p *StripeProvider) call(ctx context.Context, method, path string, form url.Values, out interface{}) error {
+	base := p.BaseURL
+	if base == "" {
+		base = "https://api.stripe.com"
+	}
+	endpoint := base + path
+	var body *strings.Reader
+	if method == http.MethodGet {
+		endpoint += "?" + form.Encode()
+		body = strings.NewReader("")
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.SecretKey)
+	req.Header.Set("Stripe-Version", stripeAPIVersion)
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	client := p.client
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("stripe %s %s: %s: %s %s", method, path, resp.Status, failure.Error.Type, failure.Error.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// CreateCheckout creates a Checkout Session, in subscription mode for plans
+// and payment mode for credit packs. The user ID travels in the metadata so
+// webhooks can find the user.
+func (p *StripeProvider) CreateCheckout(ctx context.Context, req CheckoutRequest) (string, error) {
+	userID := strconv.FormatUint(uint64(req.UserID), 10)
+	form := url.Values{
+		"line_items[0][quantity]": {"1"},
+		"success_url":             {req.SuccessURL},
+		"cancel_url":              {req.CancelURL},
+		"client_reference_id":     {userID},
+		"metadata[user_id]":       {userID},
+	}
+	if req.Pack != "" {
+		price, ok := p.PackPrices[req.Pack]
+		if !ok {
+			return "", fmt.Errorf("no Stripe price for credit pack %q", req.Pack)
+		}
+		form.Set("mode", "payment")
+		form.Set("line_items[0][price]", price)
+		form.Set("metadata[pack]", req.Pack)
+	} else {
+		price, ok := p.Prices[req.Plan]
+		if !ok {
+			return "", fmt.Errorf("no Stripe price for plan %q", req.Plan)
+		}
+		form.Set("mode", "subscription")
+		form.Set("line_items[0][price]", price)
+		form.Set("subscription_data[metadata][user_id]", userID)
+		form.Set("subscription_data[metadata][plan]", req.Plan)
+	}
+	if req.CustomerID != "" {
+		form.Set("customer", req.CustomerID)
+	} else {
+		form.Set("customer_email", req.Email)
+	}
+
+	var session struct {
+		URL string `json:"url"`
+	}
+	if err := p.call(ctx, http.MethodPost, "/v1/checkout/sessions", form, &session); err != nil {
+		return "", err
+	}
+	return session.URL, nil
+}
+
+// PortalURL creates a Billing Portal session
+func (p *StripeProvider) PortalURL(ctx context.Context, customerID, returnURL string) (string, error) {
+	var session struct {
+		URL string `json:"url"`
+	}
+	form := url.Values{"customer": {customerID}, "return_url": {returnURL}}
+	if err := p.call(ctx, http.MethodPost, "/v1/billing_portal/sessions", form, &session); err != nil {
+		return "", err
+	}
+	return session.URL, nil
+}
+
+// Invoices lists the customer's last 24 invoices
+func (p *StripeProvider) Invoices(ctx context.Context, customerID string) ([]Invoice, error) {
+	var list struct {
+		Data []struct {
+			ID               string `json:"id"`
+			Number           string `json:"number"`
+			Status           string `json:"status"`
+			Total            int64  `json:"total"`
+			Currency         string `json:"currency"`
+			Created          int64  `json:"created"`
+			HostedInvoiceURL string `json:"hosted_invoice_url"`
+			InvoicePDF       string `json:"invoice_pdf"`
+		} `json:"data"`
+	}
+	form := url.Values{"customer": {customerID}, "limit": {"24"}}
+	if err := p.call(ctx, http.MethodGet, "/v1/invoices", form, &list); err != nil {
+		return nil, err
+	}
+
+	invoices := make([]Invoice, 0, len(list.Data))
+	for _, inv := range list.Data {
+		if inv.Status == "draft" {
+			continue
+		}
+		invoices = append(invoices, Invoice{
+			ID:       inv.ID,
+			Number:   inv.Number,
+			Status:   inv.Status,
+			Amount:   inv.Total,
+			Currency: inv.Currency,
+			Created:  time.Unix(inv.Created, 0).UTC(),
+			URL:      inv.HostedInvoiceURL,
+			PDF:      inv.InvoicePDF,
+		})
+	}
+	return invoices, nil
+}
+
+// verifyStripeSignature checks a Stripe-Signature header: "t=<unix>" and
+// one or more "v1=<hex HMAC-SHA256 of t.payload>"
+func verifyStripeSignature(payload []byte, header, secret string, tolerance time.Duration, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errWebhookSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", errWebhookSignature)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, sig := range signatures {
+		if got, err := hex.DecodeString(sig); err == nil && hmac.Equal(got, expected) {
+			return nil
+		}
+	}
+	return errWebhookSignature
+}
+
+// stripeSubscription is the part of a Subscription object we read. Newer
+// API versions moved the current period onto the items.
+type stripeSubscription struct {
+	ID                 string            `json:"id"`
+	Customer           string            `json:"customer"`
+	Status             string            `json:"status"`
+	CurrentPeriodStart int64             `json:"current_period_start"`
+	CurrentPeriodEnd   int64             `json:"current_period_end"`
+	CancelAtPeriodEnd  bool              `json:"cancel_at_period_end"`
+	Metadata           map[string]string `json:"metadata"`
+	Items              struct {
+		Data []struct {
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+			CurrentPeriodStart int64 `json:"current_period_start"`
+			CurrentPeriodEnd   int64 `json:"current_period_end"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// ParseWebhook verifies and normalises checkout.session.completed and
+// async_payment_succeeded, customer.subscription.created/updated/deleted
+// and invoice.payment_failed
+func (p *StripeProvider) ParseWebhook(payload []byte, header http.Header) (BillingEvent, error) {
+	now := time.Now()
+	if p.now != nil {
+		now = p.now()
+	}
+	if err := verifyStripeSignature(payload, header.Get("Stripe-Signature"), p.WebhookSecret, stripeWebhookTolerance, now); err != nil {
+		return BillingEvent{}, err
+	}
+
+	var event struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Created int64  `json:"created"`
+		Data    struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return BillingEvent{}, err
+	}
+	ev := BillingEvent{ID: event.ID, Created: time.Unix(event.Created, 0).UTC()}
+
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		var session struct {
+			ID                string            `json:"id"`
+			Mode              string            `json:"mode"`
+			PaymentStatus     string            `json:"payment_status"`
+			ClientReferenceID string            `json:"client_reference_id"`
+			Customer          string            `json:"customer"`
+			Subscription      string            `json:"subscription"`
+			Metadata          map[string]string `json:"metadata"`
+		}
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return BillingEvent{}, err
+		}
+		ev.UserID = parseUserID(session.ClientReferenceID)
+		ev.CustomerID = session.Customer
+		switch {
+		case session.Mode == "subscription" && event.Type == "checkout.session.completed":
+			ev.Type = BillingCheckoutCompleted
+			ev.SubscriptionID = session.Subscription
+		case session.Mode == "payment" && session.PaymentStatus == "paid":
+			// Delayed payment methods complete unpaid and succeed later
+			ev.Type = BillingCreditsPurchased
+			ev.Pack, ev.PaymentID = session.Metadata["pack"], session.ID
+		}
+
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var sub stripeSubscription
+		if err := json.Unmarshal(event.Data.Object, &sub); err != nil {
+			return BillingEvent{}, err
+		}
+		ev.Type = map[string]string{
+			"customer.subscription.created": BillingSubscriptionCreated,
+			"customer.subscription.updated": BillingSubscriptionUpdated,
+			"customer.subscription.deleted": BillingSubscriptionCanceled,
+		}[event.Type]
+		ev.UserID = parseUserID(sub.Metadata["user_id"])
+		ev.CustomerID, ev.SubscriptionID = sub.Customer, sub.ID
+		ev.Status, ev.CancelAtPeriodEnd = sub.Status, sub.CancelAtPeriodEnd
+
+		start, end := sub.CurrentPeriodStart, sub.CurrentPeriodEnd
+		if len(sub.Items.Data) > 0 {
+			item := sub.Items.Data[0]
+			ev.Plan = p.planOfPrice(item.Price.ID)
+			if start == 0 {
+				start, end = item.CurrentPeriodStart, item.CurrentPeriodEnd
+			}
+		}
+		if start != 0 {
+			periodStart, periodEnd := time.Unix(start, 0).UTC(), time.Unix(end, 0).UTC()
+			ev.PeriodStart, ev.PeriodEnd = &periodStart, &periodEnd
+		}
+
+	case "invoice.payment_failed":
+		var invoice struct {
+			Customer     string `json:"customer"`
+			Subscription string `json:"subscription"`
+		}
+		if err := json.Unmarshal(event.Data.Object, &invoice); err != nil {
+			return BillingEvent{}, err
+		}
+		ev.Type = BillingPaymentFailed
+		ev.CustomerID, ev.SubscriptionID = invoice.Customer, invoice.Subscription
+	}
+	return ev, nil
+}
+
+// parseUserID reads a user ID from provider metadata, 0 when absent
+func parseUserID(s string) uint {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(id)
+}
@@ -0,0 +1,349 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// StudyPlan is the latest generated plan for a user
+type StudyPlan struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"uniqueIndex"`
+	PlanJSON    string `gorm:"type:TEXT"` // GeneratedPlan
+	LastEssayID uint   // newest essay the plan was built from
+	GeneratedAt time.Time
+}
+
+type PlanArticle struct {
+	Title    string `json:"title"`
+	Slug     string `json:"slug"`
+	Category string `json:"category"`
+}
+
+type PlanWeek struct {
+	Week          int           `json:"week"`
+	StartDate     time.Time     `json:"startDate"`
+	FocusCriteria []string      `json:"focusCriteria"`
+	QuestionTypes []string      `json:"questionTypes"`
+	TargetEssays  int           `json:"targetEssays"`
+	Goals         []string      `json:"goals"`
+	Articles      []PlanArticle `json:"articles"`
+}
+
+type GeneratedPlan struct {
+	TargetBand    float32             `json:"targetBand"`
+	ExamDate      *time.Time          `json:"examDate,omitempty"`
+	BasedOnEssays int                 `json:"basedOnEssays"`
+	CurrentBands  map[string]float64  `json:"currentBands"`
+	Gaps          map[string]float64  `json:"gaps"`
+	Weaknesses    []ErrorCategoryStat `json:"weaknesses"`
+	Weeks         []PlanWeek          `json:"weeks"`
+	GeneratedAt   time.Time           `json:"generatedAt"`
+}
+
+var criterionNames = map[string]string{
+	"ta":  "Task Achievement",
+	"cc":  "Coherence & Cohesion",
+	"lr":  "Lexical Resource",
+	"gra": "Grammar Range & Accuracy",
+}
+
+// criterionTopics are the blog categories and tags that cover a criterion
+var criterionTopics = map[string][]string{
+	"ta":  {"task-achievement", "task-response", "essay-structure"},
+	"cc":  {"coherence", "cohesion", "linking-words", "paragraphing"},
+	"lr":  {"vocabulary", "lexical-resource", "collocations"},
+	"gra": {"grammar", "sentence-structure", "punctuation"},
+}
+
+// ErrNoTargetBand is returned when a plan is requested before the user has set a goal
+var ErrNoTargetBand = errors.New("target band not set")
+
+const (
+	defaultPlanWeeks = 6
+	maxPlanWeeks     = 12
+	planEssayWindow  = 10 // recent essays used for current bands
+)
+
+// RefreshStudyPlan regenerates and stores the study plan for a user
+func RefreshStudyPlan(db *gorm.DB, userID uint) (*GeneratedPlan, error) {
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if user.TargetBand == nil {
+		return nil, ErrNoTargetBand
+	}
+
+	var essays []Essay
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Limit(100).Find(&essays).Error; err != nil {
+		return nil, err
+	}
+
+	essayIDs := make([]uint, len(essays))
+	for i, e := range essays {
+		essayIDs[i] = e.ID
+	}
+	var annotations []Annotation
+	if len(essayIDs) > 0 {
+		db.Where("essay_id IN ?", essayIDs).Find(&annotations)
+	}
+
+	var posts []BlogPost
+	db.Scopes(TenantScope(user.OrganizationID)).Where("is_published = ?", true).Order("published_at DESC").Find(&posts)
+
+	now := time.Now()
+	plan := buildStudyPlan(user, essays, annotations, posts, now)
+
+	record := StudyPlan{UserID: userID}
+	if len(essays) > 0 {
+		record.LastEssayID = essays[0].ID
+	}
+	err := db.Where(StudyPlan{UserID: userID}).
+		Assign(StudyPlan{PlanJSON: ToJSON(plan), LastEssayID: record.LastEssayID, GeneratedAt: now}).
+		FirstOrCreate(&record).Error
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// buildStudyPlan turns essay history into a week-by-week plan; essays are
+// newest first
+func buildStudyPlan(user User, essays []Essay, annotations []Annotation, posts []BlogPost, now time.Time) GeneratedPlan {
+	target := *user.TargetBand
+	plan := GeneratedPlan{
+		TargetBand:    target,
+		ExamDate:      user.ExamDate,
+		BasedOnEssays: len(essays),
+		CurrentBands:  map[string]float64{},
+		Gaps:          map[string]float64{},
+		Weaknesses:    aggregateErrorCategories(annotations, 2),
+		GeneratedAt:   now,
+	}
+
+	// Current level from the most recent essays
+	recent := essays
+	if len(recent) > planEssayWindow {
+		recent = recent[:planEssayWindow]
+	}
+	for _, key := range scoreCriteria {
+		var sum float64
+		for _, e := range recent {
+			sum += float64(criterionBand(essayScore(e), key))
+		}
+		current := 0.0
+		if len(recent) > 0 {
+			current = roundTo(sum/float64(len(recent)), 2)
+		}
+		plan.CurrentBands[key] = current
+		plan.Gaps[key] = roundTo(math.Max(float64(target)-current, 0), 2)
+	}
+
+	focus := rankFocusCriteria(plan.Gaps, plan.Weaknesses)
+	practice := rankQuestionTypes(essays)
+
+	weeks := defaultPlanWeeks
+	if user.ExamDate != nil {
+		days := user.ExamDate.Sub(now).Hours() / 24
+		weeks = int(math.Ceil(days / 7))
+		if weeks < 1 {
+			weeks = 1
+		}
+		if weeks > maxPlanWeeks {
+			weeks = maxPlanWeeks
+		}
+	}
+
+	for i := 0; i < weeks; i++ {
+		week := PlanWeek{
+			Week:         i + 1,
+			StartDate:    now.AddDate(0, 0, 7*i),
+			TargetEssays: 3,
+		}
+
+		finalWeek := user.ExamDate != nil && i == weeks-1 && weeks > 1
+		if finalWeek {
+			week.FocusCriteria = append([]string{}, scoreCriteria...)
+			week.TargetEssays = 2
+			week.Goals = []string{"Write full timed mock tests (Task 1 in 20 minutes, Task 2 in 40 minutes)",
+				"Re-read your teacher and examiner feedback instead of learning new material"}
+		} else {
+			week.FocusCriteria = []string{focus[i%len(focus)]}
+			if len(focus) > 1 {
+				week.FocusCriteria = append(week.FocusCriteria, focus[(i+1)%len(focus)])
+			}
+			week.Goals = weekGoals(week.FocusCriteria, plan, week.TargetEssays)
+		}
+
+		for task, types := range practice {
+			if len(types) > 0 && (task == "task2" || i%2 == 1) {
+				week.QuestionTypes = append(week.QuestionTypes, types[i%len(types)])
+			}
+		}
+		sort.Strings(week.QuestionTypes)
+
+		week.Articles = matchArticles(posts, week.FocusCriteria, plan.Weaknesses, 3)
+		plan.Weeks = append(plan.Weeks, week)
+	}
+
+	return plan
+}
+
+// rankFocusCriteria orders criteria by gap to target, breaking ties by how
+// many recurring errors fall under each criterion
+func rankFocusCriteria(gaps map[string]float64, weaknesses []ErrorCategoryStat) []string {
+	errorCounts := map[string]int{}
+	for _, w := range weaknesses {
+		errorCounts[w.Criterion] += w.Count
+	}
+
+	ranked := append([]string{}, scoreCriteria...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if gaps[ranked[i]] != gaps[ranked[j]] {
+			return gaps[ranked[i]] > gaps[ranked[j]]
+		}
+		return errorCounts[ranked[i]] > errorCounts[ranked[j]]
+	})
+
+	// Drop criteria already at target unless nothing is left
+	var open []string
+	for _, key := range ranked {
+		if gaps[key] > 0 {
+			open = append(open, key)
+		}
+	}
+	if len(open) == 0 {
+		return ranked
+	}
+	return open
+}
+
+// rankQuestionTypes lists question types per task, unpractised first and
+// then by lowest average overall band
+func rankQuestionTypes(essays []Essay) map[string][]string {
+	type stat struct {
+		count int
+		sum   float64
+	}
+	stats := map[string]*stat{}
+	for _, e := range essays {
+		if e.QuestionType == "" {
+			continue
+		}
+		s, ok := stats[e.QuestionType]
+		if !ok {
+			s = &stat{}
+			stats[e.QuestionType] = s
+		}
+		s.count++
+		s.sum += float64(e.Overall)
+	}
+
+	out := map[string][]string{}
+	for task, types := range questionTypes {
+		ranked := append([]string{}, types...)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			a, b := stats[ranked[i]], stats[ranked[j]]
+			if a == nil || b == nil {
+				return a == nil && b != nil
+			}
+			return a.sum/float64(a.count) < b.sum/float64(b.count)
+		})
+		out[task] = ranked
+	}
+	return out
+}
+
+func weekGoals(focus []string, plan GeneratedPlan, essays int) []string {
+	goals := []string{fmt.Sprintf("Write %d essays and review the feedback on each", essays)}
+	for _, key := range focus {
+		goals = append(goals, fmt.Sprintf("Raise %s from %.1f towards %.1f",
+			criterionNames[key], plan.CurrentBands[key], plan.TargetBand))
+		for _, w := range plan.Weaknesses {
+			if w.Criterion != key {
+				continue
+			}
+			goal := fmt.Sprintf("Fix recurring %s errors", strings.ReplaceAll(w.Category, "_", " "))
+			if w.Example != "" && w.Suggestion != "" {
+				goal += fmt.Sprintf(" (e.g. \"%s\" → \"%s\")", w.Example, w.Suggestion)
+			}
+			goals = append(goals, goal)
+			break
+		}
+	}
+	return goals
+}
+
+// matchArticles picks published posts whose category or tags match the
+// focus criteria or recurring error categories
+func matchArticles(posts []BlogPost, focus []string, weaknesses []ErrorCategoryStat, limit int) []PlanArticle {
+	topics := map[string]bool{}
+	for _, key := range focus {
+		for _, t := range criterionTopics[key] {
+			topics[t] = true
+		}
+		for _, w := range weaknesses {
+			if w.Criterion == key {
+				topics[strings.ReplaceAll(w.Category, "_", "-")] = true
+			}
+		}
+	}
+
+	articles := []PlanArticle{}
+	for _, p := range posts {
+		match := topics[strings.ToLower(p.Category)]
+		for _, tag := range strings.Split(p.Tags, ",") {
+			if topics[strings.ToLower(strings.TrimSpace(tag))] {
+				match = true
+			}
+		}
+		if match {
+			articles = append(articles, PlanArticle{Title: p.Title, Slug: p.Slug, Category: p.Category})
+			if len(articles) == limit {
+				break
+			}
+		}
+	}
+	return articles
+}
+
+// GetStudyPlan returns the user's study plan, regenerating it when new
+// essays have arrived since it was built
+func GetStudyPlan(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var latest Essay
+		db.Where("user_id = ?", userID).Order("created_at DESC").Select("id").First(&latest)
+
+		var record StudyPlan
+		err := db.Where("user_id = ?", userID).First(&record).Error
+		if err == nil && record.LastEssayID == latest.ID && c.Query("refresh") == "" {
+			var plan GeneratedPlan
+			if FromJSON(record.PlanJSON, &plan) == nil {
+				c.JSON(200, plan)
+				return
+			}
+		}
+
+		plan, err := RefreshStudyPlan(db, userID)
+		if err == ErrNoTargetBand {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Set a target band on your profile first"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to generate study plan"})
+			return
+		}
+
+		c.JSON(200, plan)
+	}
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildStudyPlan(t *testing.T) {
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	target := float32(7)
+	exam := now.AddDate(0, 0, 25)
+	user := User{ID: 1, TargetBand: &target, ExamDate: &exam}
+
+	essays := []Essay{
+		{ID: 2, TaskType: "task2", QuestionType: "opinion", Overall: 6, BandsJSON: `{"ta":6.5,"cc":6,"lr":5.5,"gra":6,"overall":6}`},
+		{ID: 1, TaskType: "task2", QuestionType: "opinion", Overall: 6, BandsJSON: `{"ta":6.5,"cc":6.5,"lr":5.5,"gra":6,"overall":6}`},
+	}
+	posts := []BlogPost{
+		{Title: "Collocations that score", Slug: "collocations", Category: "vocabulary"},
+		{Title: "Paragraph basics", Slug: "paragraphs", Category: "paragraphing"},
+	}
+
+	plan := buildStudyPlan(user, essays, nil, posts, now)
+
+	if len(plan.Weeks) != 4 {
+		t.Fatalf("buildStudyPlan() made %d weeks, want 4 for an exam 25 days away", len(plan.Weeks))
+	}
+	if got := plan.Weeks[0].FocusCriteria[0]; got != "lr" {
+		t.Errorf("first focus criterion = %q, want lr (largest gap)", got)
+	}
+	if len(plan.Weeks[0].Articles) == 0 || plan.Weeks[0].Articles[0].Slug != "collocations" {
+		t.Errorf("week 1 articles = %+v, want the vocabulary post", plan.Weeks[0].Articles)
+	}
+	if len(plan.Weeks[3].FocusCriteria) != 4 {
+		t.Errorf("final week should rehearse all criteria, got %v", plan.Weeks[3].FocusCriteria)
+	}
+	for _, qt := range plan.Weeks[0].QuestionTypes {
+		if qt == "opinion" {
+			t.Errorf("week 1 should recommend unpractised question types before opinion essays")
+		}
+	}
+}
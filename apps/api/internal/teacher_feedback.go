@@ -0,0 +1,475 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"gorm.io/gorm"
+)
+
+// EssayComment is a teacher or student comment on an essay, optionally
+// anchored to a byte range of Essay.Text and optionally a reply
+type EssayComment struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	EssayID     uint      `gorm:"index" json:"essayId"`
+	AuthorID    uint      `gorm:"index" json:"authorId"`
+	ParentID    *uint     `gorm:"index" json:"parentId,omitempty"`
+	StartOffset *int      `json:"start,omitempty"`
+	EndOffset   *int      `json:"end,omitempty"`
+	Quote       string    `gorm:"type:TEXT" json:"quote,omitempty"` // Essay.Text[start:end] when written
+	Body        string    `gorm:"type:TEXT" json:"body"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// BandOverride is a teacher-adjusted band for one criterion. Rows are only
+// ever appended; the newest per criterion is the effective teacher band and
+// the AI bands on the essay are never modified.
+type BandOverride struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	EssayID   uint      `gorm:"index" json:"essayId"`
+	TeacherID uint      `json:"teacherId"`
+	Criterion string    `json:"criterion"` // "ta" | "cc" | "lr" | "gra"
+	Band      float32   `json:"band"`
+	Reason    string    `gorm:"type:TEXT" json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Notification is an in-app message for a user
+type Notification struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index" json:"-"`
+	Kind      string     `gorm:"index" json:"kind"` // "comment" | "band_override" | ...
+	Title     string     `json:"title"`
+	Body      string     `gorm:"type:TEXT" json:"body"`
+	EssayID   *uint      `json:"essayId,omitempty"`
+	ReadAt    *time.Time `json:"readAt,omitempty"`
+	CreatedAt time.Time  `gorm:"index" json:"createdAt"`
+}
+
+type CommentThread struct {
+	EssayComment
+	AuthorEmail string          `json:"authorEmail"`
+	AuthorRole  string          `json:"authorRole"`
+	Replies     []CommentThread `json:"replies"`
+}
+
+// Notify stores a notification for a user
+func Notify(db *gorm.DB, userID uint, kind, title, body string, essayID *uint) {
+	db.Create(&Notification{
+		UserID:  userID,
+		Kind:    kind,
+		Title:   title,
+		Body:    body,
+		EssayID: essayID,
+	})
+}
+
+// essayAccess loads an essay the current user may discuss: their own, or a
+// submission to an assignment they set. isTeacher reports the latter.
+func essayAccess(db *gorm.DB, c *gin.Context) (essay Essay, isTeacher bool, ok bool) {
+	userID := c.MustGet("userID").(uint)
+
+	essayID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid essay ID"})
+		return essay, false, false
+	}
+	if err := db.First(&essay, uint(essayID)).Error; err != nil {
+		c.AbortWithStatusJSON(404, gin.H{"error": "Essay not found"})
+		return essay, false, false
+	}
+
+	if essay.UserID != nil && *essay.UserID == userID {
+		return essay, false, true
+	}
+	if TeacherCanViewEssay(db, userID, essay) {
+		return essay, true, true
+	}
+
+	c.AbortWithStatusJSON(404, gin.H{"error": "Essay not found"})
+	return essay, false, false
+}
+
+// canDiscussEssay reports whether the user may read the essay's comments:
+// its author, or the teacher who set its assignment
+func canDiscussEssay(db *gorm.DB, userID uint, essay Essay) bool {
+	if essay.UserID != nil && *essay.UserID == userID {
+		return true
+	}
+	return TeacherCanViewEssay(db, userID, essay)
+}
+
+// loadCommentThreads returns the essay's comments as threads, oldest first
+func loadCommentThreads(db *gorm.DB, essayID uint) []CommentThread {
+	var comments []EssayComment
+	db.Where("essay_id = ?", essayID).Order("created_at ASC").Find(&comments)
+
+	authorIDs := make([]uint, 0, len(comments))
+	for _, cm := range comments {
+		authorIDs = append(authorIDs, cm.AuthorID)
+	}
+	authors := map[uint]User{}
+	if len(authorIDs) > 0 {
+		var users []User
+		db.Select("id", "email", "role").Where("id IN ?", authorIDs).Find(&users)
+		for _, u := range users {
+			authors[u.ID] = u
+		}
+	}
+
+	children := map[uint][]EssayComment{}
+	var roots []EssayComment
+	for _, cm := range comments {
+		if cm.ParentID == nil {
+			roots = append(roots, cm)
+		} else {
+			children[*cm.ParentID] = append(children[*cm.ParentID], cm)
+		}
+	}
+
+	var build func(cm EssayComment) CommentThread
+	build = func(cm EssayComment) CommentThread {
+		t := CommentThread{
+			EssayComment: cm,
+			AuthorEmail:  authors[cm.AuthorID].Email,
+			AuthorRole:   authors[cm.AuthorID].Role,
+			Replies:      []CommentThread{},
+		}
+		for _, child := range children[cm.ID] {
+			t.Replies = append(t.Replies, build(child))
+		}
+		return t
+	}
+
+	threads := []CommentThread{}
+	for _, root := range roots {
+		threads = append(threads, build(root))
+	}
+	return threads
+}
+
+// commentSubtree returns the comments with every reply below them
+func commentSubtree(db *gorm.DB, ids []uint) []uint {
+	for frontier := ids; len(frontier) > 0; {
+		var children []uint
+		db.Model(&EssayComment{}).Where("parent_id IN ?", frontier).Pluck("id", &children)
+		ids = append(ids, children...)
+		frontier = children
+	}
+	return ids
+}
+
+// teacherBands returns the newest override per criterion and the overall
+// band recomputed from them (AI bands fill criteria without an override)
+func teacherBands(db *gorm.DB, essay Essay) (history []BandOverride, effective map[string]float32, overall *float32) {
+	db.Where("essay_id = ?", essay.ID).Order("created_at ASC, id ASC").Find(&history)
+	if len(history) == 0 {
+		return history, nil, nil
+	}
+
+	effective = map[string]float32{}
+	for _, o := range history {
+		effective[o.Criterion] = o.Band
+	}
+
+	ai := essayScore(essay)
+	var sum float32
+	for _, key := range scoreCriteria {
+		if band, ok := effective[key]; ok {
+			sum += band
+		} else {
+			sum += criterionBand(ai, key)
+		}
+	}
+	o := clampBand(sum / 4)
+	return history, effective, &o
+}
+
+// essayFeedback bundles teacher comments and bands for an essay response
+func essayFeedback(db *gorm.DB, essay Essay) gin.H {
+	history, effective, overall := teacherBands(db, essay)
+	return gin.H{
+		"comments":       loadCommentThreads(db, essay.ID),
+		"teacherBands":   effective,
+		"teacherOverall": overall,
+		"bandOverrides":  history,
+	}
+}
+
+// GetEssayComments returns the comment threads on an essay
+func GetEssayComments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		essay, _, ok := essayAccess(db, c)
+		if !ok {
+			return
+		}
+
+		c.JSON(200, gin.H{"comments": loadCommentThreads(db, essay.ID)})
+	}
+}
+
+// AddEssayComment adds a comment. Teachers may anchor comments to a text
+// range or leave general ones; students may reply to existing threads.
+func AddEssayComment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		essay, isTeacher, ok := essayAccess(db, c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Body     string `json:"body" binding:"required"`
+			ParentID *uint  `json:"parentId"`
+			Start    *int   `json:"start"`
+			End      *int   `json:"end"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Body) == "" {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Comment body is required"})
+			return
+		}
+
+		comment := EssayComment{
+			EssayID:  essay.ID,
+			AuthorID: userID,
+			Body:     strings.TrimSpace(req.Body),
+		}
+
+		if req.ParentID != nil {
+			var parent EssayComment
+			if err := db.Where("id = ? AND essay_id = ?", *req.ParentID, essay.ID).First(&parent).Error; err != nil {
+				c.AbortWithStatusJSON(400, gin.H{"error": "Parent comment not found"})
+				return
+			}
+			comment.ParentID = &parent.ID
+		} else if !isTeacher {
+			c.AbortWithStatusJSON(403, gin.H{"error": "Students can only reply to teacher comments"})
+			return
+		}
+
+		if req.Start != nil || req.End != nil {
+			if req.Start == nil || req.End == nil || *req.Start < 0 || *req.End > len(essay.Text) || *req.Start >= *req.End {
+				c.AbortWithStatusJSON(400, gin.H{"error": "Invalid text range"})
+				return
+			}
+			if comment.ParentID != nil {
+				c.AbortWithStatusJSON(400, gin.H{"error": "Replies cannot be anchored to text"})
+				return
+			}
+			comment.StartOffset, comment.EndOffset = req.Start, req.End
+			comment.Quote = essay.Text[*req.Start:*req.End]
+		}
+
+		if err := db.Create(&comment).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to save comment"})
+			return
+		}
+
+		// Tell the other side of the conversation
+		if isTeacher {
+			Notify(db, *essay.UserID, "comment", "New comment from your teacher", comment.Body, &essay.ID)
+		} else if comment.ParentID != nil {
+			var parent EssayComment
+			if db.First(&parent, *comment.ParentID).Error == nil && parent.AuthorID != userID {
+				Notify(db, parent.AuthorID, "comment", "A student replied to your comment", comment.Body, &essay.ID)
+			}
+		}
+
+		c.JSON(201, comment)
+	}
+}
+
+// DeleteEssayComment deletes the author's own comment and its replies
+func DeleteEssayComment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		essay, _, ok := essayAccess(db, c)
+		if !ok {
+			return
+		}
+
+		var comment EssayComment
+		if err := db.Where("id = ? AND essay_id = ? AND author_id = ?", c.Param("commentId"), essay.ID, userID).
+			First(&comment).Error; err != nil {
+			c.AbortWithStatusJSON(404, gin.H{"error": "Comment not found"})
+			return
+		}
+
+		// Delete the whole subtree so no reply is left orphaned
+		if err := db.Where("id IN ?", commentSubtree(db, []uint{comment.ID})).Delete(&EssayComment{}).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to delete comment"})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": "Comment deleted successfully"})
+	}
+}
+
+// GetBandOverrides returns the teacher band history next to the AI bands
+func GetBandOverrides(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		essay, _, ok := essayAccess(db, c)
+		if !ok {
+			return
+		}
+
+		ai := essayScore(essay)
+		history, effective, overall := teacherBands(db, essay)
+		c.JSON(200, gin.H{
+			"aiBands":        gin.H{"ta": ai.TA, "cc": ai.CC, "lr": ai.LR, "gra": ai.GRA, "overall": ai.Overall},
+			"teacherBands":   effective,
+			"teacherOverall": overall,
+			"history":        history,
+		})
+	}
+}
+
+// AddBandOverride records a teacher-adjusted band for one criterion
+func AddBandOverride(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		essay, isTeacher, ok := essayAccess(db, c)
+		if !ok {
+			return
+		}
+		if !isTeacher {
+			c.AbortWithStatusJSON(403, gin.H{"error": "Only the assignment's teacher can adjust bands"})
+			return
+		}
+
+		var req struct {
+			Criterion string   `json:"criterion" binding:"required"`
+			Band      *float32 `json:"band" binding:"required"`
+			Reason    string   `json:"reason"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		if _, known := criterionNames[req.Criterion]; !known {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Criterion must be one of ta, cc, lr, gra"})
+			return
+		}
+		if *req.Band < 0 || *req.Band > 9 || clampBand(*req.Band) != *req.Band {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Band must be between 0 and 9 in 0.5 steps"})
+			return
+		}
+
+		override := BandOverride{
+			EssayID:   essay.ID,
+			TeacherID: userID,
+			Criterion: req.Criterion,
+			Band:      *req.Band,
+			Reason:    strings.TrimSpace(req.Reason),
+		}
+		if err := db.Create(&override).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to save band"})
+			return
+		}
+
+		Notify(db, *essay.UserID, "band_override", "Your teacher adjusted a band",
+			fmt.Sprintf("%s: %s", criterionNames[req.Criterion], formatBand(*req.Band)), &essay.ID)
+
+		c.JSON(201, override)
+	}
+}
+
+// GetNotifications lists the user's notifications, newest first
+func GetNotifications(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		query := db.Where("user_id = ?", userID)
+		if c.Query("unread") == "true" {
+			query = query.Where("read_at IS NULL")
+		}
+
+		var notifications []Notification
+		if err := query.Order("created_at DESC").Limit(100).Find(&notifications).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to fetch notifications"})
+			return
+		}
+
+		var unread int64
+		db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&unread)
+
+		c.JSON(200, gin.H{"items": notifications, "unread": unread})
+	}
+}
+
+// MarkNotificationRead marks one notification, or all with id "all", as read
+func MarkNotificationRead(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		query := db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+		if id := c.Param("id"); id != "all" {
+			query = query.Where("id = ?", id)
+		}
+		if err := query.Update("read_at", time.Now()).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to update notifications"})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": "Notifications marked as read"})
+	}
+}
+
+// writeTeacherCommentsPDF appends the teacher feedback section to a report
+func writeTeacherCommentsPDF(pdf *gofpdf.Fpdf, threads []CommentThread, effective map[string]float32) {
+	if len(threads) == 0 && len(effective) == 0 {
+		return
+	}
+
+	pdf.AddPage()
+	pdf.SetFont("Arial", "B", 14)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.Cell(0, 10, "Teacher Feedback")
+	pdf.Ln(12)
+
+	if len(effective) > 0 {
+		pdf.SetFont("Arial", "B", 11)
+		pdf.Cell(0, 8, "Teacher-adjusted bands")
+		pdf.Ln(8)
+		pdf.SetFont("Arial", "", 11)
+		for _, key := range scoreCriteria {
+			if band, ok := effective[key]; ok {
+				pdf.Cell(0, 6, fmt.Sprintf("%s: %s", criterionNames[key], formatBand(band)))
+				pdf.Ln(6)
+			}
+		}
+		pdf.Ln(6)
+	}
+
+	var write func(t CommentThread, indent float64)
+	write = func(t CommentThread, indent float64) {
+		pdf.SetX(10 + indent)
+		pdf.SetFont("Arial", "B", 10)
+		pdf.SetTextColor(100, 100, 100)
+		pdf.Cell(0, 6, fmt.Sprintf("%s - %s", t.AuthorEmail, t.CreatedAt.Format("2006-01-02")))
+		pdf.Ln(6)
+		if t.Quote != "" {
+			pdf.SetX(10 + indent)
+			pdf.SetFont("Arial", "I", 10)
+			pdf.MultiCell(0, 5, fmt.Sprintf("\"%s\"", t.Quote), "", "", false)
+		}
+		pdf.SetX(10 + indent)
+		pdf.SetFont("Arial", "", 11)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.MultiCell(0, 6, t.Body, "", "", false)
+		pdf.Ln(3)
+		for _, reply := range t.Replies {
+			write(reply, indent+8)
+		}
+	}
+	for _, t := range threads {
+		write(t, 0)
+	}
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// submitEssay stores the student's submission to the open assignment
+func (f classFixture) submitEssay(t *testing.T) Essay {
+	t.Helper()
+	essay := Essay{
+		UserID:       &f.student.ID,
+		AssignmentID: &f.open.ID,
+		Text:         "The city grows fast.",
+		BandsJSON:    `{"ta":6,"cc":6,"lr":6,"gra":6,"overall":6}`,
+		Overall:      6,
+		PublicID:     "sub1",
+	}
+	if err := f.db.Create(&essay).Error; err != nil {
+		t.Fatal(err)
+	}
+	return essay
+}
+
+func (f classFixture) notifications(userID uint, kind string) int64 {
+	var count int64
+	f.db.Model(&Notification{}).Where("user_id = ? AND kind = ?", userID, kind).Count(&count)
+	return count
+}
+
+func TestAddEssayComment(t *testing.T) {
+	f := newClassFixture(t)
+	essay := f.submitEssay(t)
+	id := fmt.Sprint(essay.ID)
+
+	rec := f.serve(AddEssayComment(f.db), f.teacher, http.MethodPost, id, `{"body":"Which city?","start":4,"end":8}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("teacher comment: status = %d (%s)", rec.Code, rec.Body)
+	}
+	var root EssayComment
+	json.Unmarshal(rec.Body.Bytes(), &root)
+	if root.Quote != "city" {
+		t.Errorf("quote = %q, want %q", root.Quote, "city")
+	}
+	if n := f.notifications(f.student.ID, "comment"); n != 1 {
+		t.Errorf("student has %d comment notifications, want 1", n)
+	}
+
+	other := Essay{UserID: &f.student.ID, Text: "Practice.", PublicID: "practice"}
+	f.db.Create(&other)
+	foreign := EssayComment{EssayID: other.ID, AuthorID: f.student.ID, Body: "note"}
+	f.db.Create(&foreign)
+
+	tests := []struct {
+		name     string
+		user     User
+		body     string
+		wantCode int
+	}{
+		{"Student opens a thread", f.student, `{"body":"Hello"}`, http.StatusForbidden},
+		{"Student replies", f.student, fmt.Sprintf(`{"body":"Lagos","parentId":%d}`, root.ID), http.StatusCreated},
+		{"Anchored reply", f.teacher, fmt.Sprintf(`{"body":"x","parentId":%d,"start":0,"end":3}`, root.ID), http.StatusBadRequest},
+		{"Parent on another essay", f.student, fmt.Sprintf(`{"body":"x","parentId":%d}`, foreign.ID), http.StatusBadRequest},
+		{"Range past the text", f.teacher, `{"body":"x","start":4,"end":99}`, http.StatusBadRequest},
+		{"Empty range", f.teacher, `{"body":"x","start":4,"end":4}`, http.StatusBadRequest},
+		{"Only a start", f.teacher, `{"body":"x","start":4}`, http.StatusBadRequest},
+		{"Blank body", f.teacher, `{"body":"  "}`, http.StatusBadRequest},
+		{"Outsider", f.outsider, `{"body":"x"}`, http.StatusNotFound},
+		{"Another teacher", f.otherTeacher, `{"body":"x"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := f.serve(AddEssayComment(f.db), tt.user, http.MethodPost, id, tt.body); rec.Code != tt.wantCode {
+				t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, tt.wantCode)
+			}
+		})
+	}
+
+	if n := f.notifications(f.teacher.ID, "comment"); n != 1 {
+		t.Errorf("teacher has %d comment notifications, want 1 for the reply", n)
+	}
+
+	threads := loadCommentThreads(f.db, essay.ID)
+	if len(threads) != 1 || len(threads[0].Replies) != 1 {
+		t.Fatalf("threads = %+v, want one thread with one reply", threads)
+	}
+	if threads[0].AuthorEmail != f.teacher.Email || threads[0].Replies[0].AuthorEmail != f.student.Email {
+		t.Errorf("authors = %q, %q", threads[0].AuthorEmail, threads[0].Replies[0].AuthorEmail)
+	}
+
+	t.Run("Deleting a thread removes its replies", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.DELETE("/:id/comments/:commentId", func(c *gin.Context) {
+			c.Set("userID", f.teacher.ID)
+		}, DeleteEssayComment(f.db))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/%d/comments/%d", essay.ID, root.ID), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d (%s)", rec.Code, rec.Body)
+		}
+		var left int64
+		f.db.Model(&EssayComment{}).Where("essay_id = ?", essay.ID).Count(&left)
+		if left != 0 {
+			t.Errorf("%d comments left on the essay", left)
+		}
+	})
+}
+
+func TestAddBandOverride(t *testing.T) {
+	f := newClassFixture(t)
+	essay := f.submitEssay(t)
+	id := fmt.Sprint(essay.ID)
+
+	tests := []struct {
+		name     string
+		user     User
+		body     string
+		wantCode int
+	}{
+		{"Teacher", f.teacher, `{"criterion":"ta","band":7,"reason":"Clear position"}`, http.StatusCreated},
+		{"Teacher revises", f.teacher, `{"criterion":"ta","band":8}`, http.StatusCreated},
+		{"Second criterion", f.teacher, `{"criterion":"lr","band":6.5}`, http.StatusCreated},
+		{"Student", f.student, `{"criterion":"ta","band":9}`, http.StatusForbidden},
+		{"Unknown criterion", f.teacher, `{"criterion":"xx","band":7}`, http.StatusBadRequest},
+		{"Not a half band", f.teacher, `{"criterion":"cc","band":7.3}`, http.StatusBadRequest},
+		{"Above 9", f.teacher, `{"criterion":"cc","band":9.5}`, http.StatusBadRequest},
+		{"Missing band", f.teacher, `{"criterion":"cc"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := f.serve(AddBandOverride(f.db), tt.user, http.MethodPost, id, tt.body); rec.Code != tt.wantCode {
+				t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, tt.wantCode)
+			}
+		})
+	}
+
+	// Every override is kept; the newest per criterion wins
+	history, effective, overall := teacherBands(f.db, essay)
+	if len(history) != 3 {
+		t.Errorf("history has %d overrides, want 3", len(history))
+	}
+	if effective["ta"] != 8 || effective["lr"] != 6.5 || len(effective) != 2 {
+		t.Errorf("effective = %v", effective)
+	}
+	// (8 + 6 + 6.5 + 6) / 4 = 6.625, rounded to the half band
+	if overall == nil || *overall != 6.5 {
+		t.Errorf("overall = %v, want 6.5", overall)
+	}
+
+	var stored Essay
+	f.db.First(&stored, essay.ID)
+	if stored.BandsJSON != essay.BandsJSON || stored.Overall != 6 {
+		t.Errorf("AI bands changed to %s / %v", stored.BandsJSON, stored.Overall)
+	}
+	if n := f.notifications(f.student.ID, "band_override"); n != 3 {
+		t.Errorf("student has %d band notifications, want 3", n)
+	}
+}
+
+func TestCanDiscussEssay(t *testing.T) {
+	f := newClassFixture(t)
+	essay := f.submitEssay(t)
+
+	for _, tt := range []struct {
+		user User
+		want bool
+	}{
+		{f.student, true},
+		{f.teacher, true},
+		{f.otherTeacher, false},
+		{f.outsider, false},
+	} {
+		if got := canDiscussEssay(f.db, tt.user.ID, essay); got != tt.want {
+			t.Errorf("canDiscussEssay(%s) = %v, want %v", tt.user.Email, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 with the ASCII secret "12345678901234567890"
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := hotp(key, totpStep(time.Unix(tt.unix, 0)), 8); got != tt.want {
+				t.Errorf("hotp at %d = %s, want %s", tt.unix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantOK   bool
+	}{
+		{"Current code", "050471", 0, true},
+		{"Previous step within skew", "081804", 0, true},
+		{"Replayed code", "050471", step, false},
+		{"Wrong code", "123456", 0, false},
+		{"Wrong length", "50471", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := verifyTOTP(secret, tt.code, now, tt.lastStep); ok != tt.wantOK {
+				t.Errorf("verifyTOTP(%q) = %v, want %v", tt.code, ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("JBSWY3DPEHPK3PXP", "student@example.com")
+	for _, part := range []string{"otpauth://totp/BandLy:student@example.com?", "secret=JBSWY3DPEHPK3PXP", "issuer=BandLy", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("totpURI() = %s, missing %s", uri, part)
+		}
+	}
+}
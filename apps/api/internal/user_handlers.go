@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"errors"
+	"log"
 	"strconv"
 	"time"
 
@@ -9,34 +11,30 @@ import (
 )
 
 // Get user's essay history
-func GetUserHistory(db *gorm.DB) gin.HandlerFunc {
+func GetUserHistory(essays *EssayService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.MustGet("userID").(uint)
 
-		var essays []Essay
-		result := db.Where("user_id = ?", userID).
-			Order("created_at DESC").
-			Limit(50).
-			Find(&essays)
-
-		if result.Error != nil {
+		list, err := essays.History(userID)
+		if err != nil {
 			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to fetch history"})
 			return
 		}
 
 		// Transform for frontend
 		var history []gin.H
-		for _, essay := range essays {
+		for _, essay := range list {
 			var scores ScoreOut
 			FromJSON(essay.BandsJSON, &scores)
 
 			history = append(history, gin.H{
-				"id":        essay.ID,
-				"publicId":  essay.PublicID,
-				"taskType":  essay.TaskType,
-				"overall":   essay.Overall,
-				"cefr":      essay.CEFR,
-				"createdAt": essay.CreatedAt,
+				"id":           essay.ID,
+				"publicId":     essay.PublicID,
+				"taskType":     essay.TaskType,
+				"questionType": essay.QuestionType,
+				"overall":      essay.Overall,
+				"cefr":         essay.CEFR,
+				"createdAt":    essay.CreatedAt,
 				"bands": gin.H{
 					"ta":  scores.TA,
 					"cc":  scores.CC,
@@ -55,67 +53,26 @@ func GetUserHistory(db *gorm.DB) gin.HandlerFunc {
 }
 
 // Get user dashboard stats
-func GetUserDashboard(db *gorm.DB) gin.HandlerFunc {
+func GetUserDashboard(essays *EssayService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.MustGet("userID").(uint)
 		user := c.MustGet("user").(User)
 
-		// Count total essays
-		var totalEssays int64
-		db.Model(&Essay{}).Where("user_id = ?", userID).Count(&totalEssays)
-
-		// Get recent essays for trend analysis
-		var recentEssays []Essay
-		db.Where("user_id = ?", userID).
-			Order("created_at DESC").
-			Limit(10).
-			Find(&recentEssays)
-
-		// Calculate stats
-		var avgOverall float32
-		var recentScores []float32
-		var monthlyCount int64
-
-		for _, essay := range recentEssays {
-			avgOverall += essay.Overall
-			recentScores = append(recentScores, essay.Overall)
-		}
-
-		if len(recentEssays) > 0 {
-			avgOverall = avgOverall / float32(len(recentEssays))
-		}
-
-		// Count essays from last 30 days
-		thirtyDaysAgo := time.Now().AddDate(0, 0, -30)
-		db.Model(&Essay{}).
-			Where("user_id = ? AND created_at > ?", userID, thirtyDaysAgo).
-			Count(&monthlyCount)
-
-		// Get improvement trend
-		var improvement string = "stable"
-		if len(recentScores) >= 3 {
-			recent := recentScores[0]
-			older := recentScores[len(recentScores)-1]
-			if recent > older+0.5 {
-				improvement = "improving"
-			} else if recent < older-0.5 {
-				improvement = "declining"
-			}
+		stats, err := essays.Dashboard(userID)
+		if err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to fetch dashboard"})
+			return
 		}
 
 		c.JSON(200, gin.H{
 			"user": gin.H{
-				"email":    user.Email,
-				"plan":     user.Plan,
-				"joinedAt": user.CreatedAt,
-			},
-			"stats": gin.H{
-				"totalEssays":  totalEssays,
-				"averageScore": avgOverall,
-				"monthlyCount": monthlyCount,
-				"improvement":  improvement,
-				"recentScores": recentScores,
+				"email":      user.Email,
+				"plan":       user.Plan,
+				"joinedAt":   user.CreatedAt,
+				"targetBand": user.TargetBand,
+				"examDate":   user.ExamDate,
 			},
+			"stats": stats,
 		})
 	}
 }
@@ -146,15 +103,22 @@ func GetEssayDetails(db *gorm.DB) gin.HandlerFunc {
 		var scores ScoreOut
 		FromJSON(essay.BandsJSON, &scores)
 
+		var annotations []Annotation
+		db.Where("essay_id = ?", essay.ID).Order("start ASC").Find(&annotations)
+
 		c.JSON(200, gin.H{
-			"id":        essay.ID,
-			"publicId":  essay.PublicID,
-			"taskType":  essay.TaskType,
-			"text":      essay.Text,
-			"overall":   essay.Overall,
-			"cefr":      essay.CEFR,
-			"feedback":  essay.Feedback,
-			"createdAt": essay.CreatedAt,
+			"teacher":      essayFeedback(db, essay),
+			"peer":         peerFeedback(db, essay),
+			"id":           essay.ID,
+			"publicId":     essay.PublicID,
+			"taskType":     essay.TaskType,
+			"questionType": essay.QuestionType,
+			"text":         essay.Text,
+			"overall":      essay.Overall,
+			"cefr":         essay.CEFR,
+			"feedback":     essay.Feedback,
+			"createdAt":    essay.CreatedAt,
+			"annotations":  annotations,
 			"bands": gin.H{
 				"ta":  scores.TA,
 				"cc":  scores.CC,
@@ -166,7 +130,7 @@ func GetEssayDetails(db *gorm.DB) gin.HandlerFunc {
 }
 
 // Delete user essay
-func DeleteEssay(db *gorm.DB) gin.HandlerFunc {
+func DeleteEssay(essays *EssayService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.MustGet("userID").(uint)
 		essayIDStr := c.Param("id")
@@ -177,14 +141,13 @@ func DeleteEssay(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		result := db.Where("id = ? AND user_id = ?", uint(essayID), userID).Delete(&Essay{})
-		if result.Error != nil {
-			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to delete essay"})
+		err = essays.Delete(userID, uint(essayID))
+		if errors.Is(err, errNotFound) {
+			c.AbortWithStatusJSON(404, gin.H{"error": "Essay not found"})
 			return
 		}
-
-		if result.RowsAffected == 0 {
-			c.AbortWithStatusJSON(404, gin.H{"error": "Essay not found"})
+		if err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to delete essay"})
 			return
 		}
 
@@ -193,12 +156,14 @@ func DeleteEssay(db *gorm.DB) gin.HandlerFunc {
 }
 
 // Update user profile
-func UpdateProfile(db *gorm.DB) gin.HandlerFunc {
+func UpdateProfile(db *gorm.DB, auth *AuthService, mailer Mailer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.MustGet("userID").(uint)
 
 		var req struct {
-			Email string `json:"email"`
+			Email      string   `json:"email"`
+			TargetBand *float32 `json:"targetBand"`
+			ExamDate   *string  `json:"examDate"` // YYYY-MM-DD, "" clears it
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -206,21 +171,76 @@ func UpdateProfile(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Check if email is already taken by another user
-		var existingUser User
-		result := db.Where("email = ? AND id != ?", req.Email, userID).First(&existingUser)
-		if result.Error == nil {
-			c.AbortWithStatusJSON(400, gin.H{"error": "Email already taken"})
+		updates := map[string]interface{}{}
+
+		user := c.MustGet("user").(User)
+		emailChanged := false
+		if req.Email != "" {
+			email, ok := normalizeEmail(req.Email)
+			if !ok {
+				c.AbortWithStatusJSON(400, gin.H{"error": "Invalid email address"})
+				return
+			}
+
+			// Check if email is already taken by another user
+			var existingUser User
+			result := db.Where("email = ? AND id != ?", email, userID).First(&existingUser)
+			if result.Error == nil {
+				c.AbortWithStatusJSON(400, gin.H{"error": "Email already taken"})
+				return
+			}
+			updates["email"] = email
+			if email != user.Email {
+				updates["email_verified_at"] = nil // the new address must be confirmed
+				user.Email, emailChanged = email, true
+			}
+		}
+
+		if req.TargetBand != nil {
+			band := *req.TargetBand
+			if band < 1 || band > 9 || band*2 != float32(int(band*2)) {
+				c.AbortWithStatusJSON(400, gin.H{"error": "Target band must be between 1 and 9 in 0.5 steps"})
+				return
+			}
+			updates["target_band"] = band
+		}
+
+		if req.ExamDate != nil {
+			if *req.ExamDate == "" {
+				updates["exam_date"] = nil
+			} else {
+				examDate, err := time.Parse("2006-01-02", *req.ExamDate)
+				if err != nil {
+					c.AbortWithStatusJSON(400, gin.H{"error": "Exam date must be in YYYY-MM-DD format"})
+					return
+				}
+				updates["exam_date"] = examDate
+			}
+		}
+
+		if len(updates) == 0 {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Nothing to update"})
 			return
 		}
 
 		// Update user
-		updateResult := db.Model(&User{}).Where("id = ?", userID).Update("email", req.Email)
+		updateResult := db.Model(&User{}).Where("id = ?", userID).Updates(updates)
 		if updateResult.Error != nil {
 			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to update profile"})
 			return
 		}
 
+		if emailChanged {
+			if err := sendVerificationEmail(c.Request.Context(), auth, mailer, user); err != nil {
+				log.Printf("Failed to send verification email to user %d: %v", userID, err)
+			}
+		}
+
+		// Goals changed, so an existing study plan is out of date
+		if req.TargetBand != nil || req.ExamDate != nil {
+			_, _ = RefreshStudyPlan(db, userID)
+		}
+
 		c.JSON(200, gin.H{"message": "Profile updated successfully"})
 	}
 }
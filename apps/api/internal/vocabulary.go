@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// VocabularyCard is one entry in a user's vocabulary notebook, scheduled
+// for review with SM-2
+type VocabularyCard struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"uniqueIndex:idx_vocab_user_word" json:"-"`
+	Word         string     `gorm:"uniqueIndex:idx_vocab_user_word" json:"word"` // lower-cased term or misused phrase
+	Alternative  string     `json:"alternative"`                                 // better word or correct form
+	Example      string     `gorm:"type:TEXT" json:"example"`                    // sentence using the alternative
+	Context      string     `gorm:"type:TEXT" json:"context,omitempty"`          // original sentence from the essay
+	Note         string     `gorm:"type:TEXT" json:"note,omitempty"`
+	Source       string     `gorm:"default:'manual'" json:"source"` // "feedback" | "manual"
+	EssayID      *uint      `json:"essayId,omitempty"`
+	AnnotationID *uint      `json:"annotationId,omitempty"`
+	EaseFactor   float64    `gorm:"default:2.5" json:"easeFactor"`
+	IntervalDays int        `json:"intervalDays"` // days until the next review
+	Repetitions  int        `json:"repetitions"`
+	Lapses       int        `json:"lapses"`
+	DueAt        time.Time  `gorm:"index" json:"dueAt"`
+	ReviewedAt   *time.Time `json:"reviewedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// vocabularyCategories are the LR annotation categories that become cards
+var vocabularyCategories = map[string]bool{
+	"word_choice": true,
+	"collocation": true,
+	"word_form":   true,
+	"spelling":    true,
+	"register":    true,
+	"repetition":  true,
+}
+
+const minEaseFactor = 1.3
+
+// reviewSM2 applies one SM-2 review with quality 0-5 to a card
+func reviewSM2(card VocabularyCard, quality int, now time.Time) VocabularyCard {
+	if card.EaseFactor == 0 {
+		card.EaseFactor = 2.5
+	}
+
+	if quality < 3 {
+		// Failed recall restarts the repetition sequence
+		card.Repetitions = 0
+		card.IntervalDays = 1
+		card.Lapses++
+	} else {
+		switch card.Repetitions {
+		case 0:
+			card.IntervalDays = 1
+		case 1:
+			card.IntervalDays = 6
+		default:
+			card.IntervalDays = int(math.Round(float64(card.IntervalDays) * card.EaseFactor))
+		}
+		card.Repetitions++
+	}
+
+	q := float64(5 - quality)
+	card.EaseFactor += 0.1 - q*(0.08+q*0.02)
+	if card.EaseFactor < minEaseFactor {
+		card.EaseFactor = minEaseFactor
+	}
+	card.EaseFactor = roundTo(card.EaseFactor, 2)
+
+	card.DueAt = now.AddDate(0, 0, card.IntervalDays)
+	card.ReviewedAt = &now
+	return card
+}
+
+// sentenceAround returns the sentence of text that contains [start, end)
+func sentenceAround(text string, start, end int) string {
+	if start < 0 || end > len(text) || start >= end {
+		return ""
+	}
+	from := strings.LastIndexAny(text[:start], ".!?\n") + 1
+	to := strings.IndexAny(text[end:], ".!?\n")
+	if to < 0 {
+		to = len(text)
+	} else {
+		to += end + 1
+	}
+	return strings.TrimSpace(text[from:to])
+}
+
+// AddVocabularyFromAnnotations turns lexical annotations into notebook cards,
+// skipping words the user already has
+func AddVocabularyFromAnnotations(db *gorm.DB, userID uint, essayText string, annotations []Annotation) {
+	now := time.Now()
+	for _, a := range annotations {
+		if a.Criterion != "lr" || !vocabularyCategories[a.Category] || a.Excerpt == "" || a.Suggestion == "" {
+			continue
+		}
+
+		context := sentenceAround(essayText, a.Start, a.End)
+		example := ""
+		if context != "" {
+			example = strings.Replace(context, a.Excerpt, a.Suggestion, 1)
+		}
+
+		essayID, annotationID := a.EssayID, a.ID
+		card := VocabularyCard{
+			UserID:       userID,
+			Word:         strings.ToLower(a.Excerpt),
+			Alternative:  a.Suggestion,
+			Example:      example,
+			Context:      context,
+			Note:         a.Explanation,
+			Source:       "feedback",
+			EssayID:      &essayID,
+			AnnotationID: &annotationID,
+			EaseFactor:   2.5,
+			DueAt:        now,
+		}
+		db.Where(VocabularyCard{UserID: userID, Word: card.Word}).FirstOrCreate(&card)
+	}
+}
+
+// GetVocabulary lists the user's vocabulary notebook
+func GetVocabulary(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var cards []VocabularyCard
+		query := db.Where("user_id = ?", userID)
+		if source := c.Query("source"); source != "" {
+			query = query.Where("source = ?", source)
+		}
+		if err := query.Order("created_at DESC").Limit(500).Find(&cards).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to fetch vocabulary"})
+			return
+		}
+
+		var due int64
+		db.Model(&VocabularyCard{}).Where("user_id = ? AND due_at <= ?", userID, time.Now()).Count(&due)
+
+		c.JSON(200, gin.H{
+			"items": cards,
+			"total": len(cards),
+			"due":   due,
+		})
+	}
+}
+
+// AddVocabulary adds a card to the notebook by hand
+func AddVocabulary(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var req struct {
+			Word        string `json:"word" binding:"required"`
+			Alternative string `json:"alternative"`
+			Example     string `json:"example"`
+			Note        string `json:"note"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		word := strings.ToLower(strings.TrimSpace(req.Word))
+		if word == "" {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Word is required"})
+			return
+		}
+
+		var existing VocabularyCard
+		if err := db.Where("user_id = ? AND word = ?", userID, word).First(&existing).Error; err == nil {
+			c.AbortWithStatusJSON(409, gin.H{"error": "Word already in your notebook", "id": existing.ID})
+			return
+		}
+
+		card := VocabularyCard{
+			UserID:      userID,
+			Word:        word,
+			Alternative: strings.TrimSpace(req.Alternative),
+			Example:     strings.TrimSpace(req.Example),
+			Note:        strings.TrimSpace(req.Note),
+			Source:      "manual",
+			EaseFactor:  2.5,
+			DueAt:       time.Now(),
+		}
+		if err := db.Create(&card).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to add word"})
+			return
+		}
+
+		c.JSON(201, card)
+	}
+}
+
+// DeleteVocabulary removes a card from the notebook
+func DeleteVocabulary(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		result := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&VocabularyCard{})
+		if result.Error != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to delete word"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.AbortWithStatusJSON(404, gin.H{"error": "Word not found"})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": "Word deleted successfully"})
+	}
+}
+
+// GetDueVocabulary returns the cards due for review, oldest first
+func GetDueVocabulary(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		var cards []VocabularyCard
+		if err := db.Where("user_id = ? AND due_at <= ?", userID, time.Now()).
+			Order("due_at ASC").
+			Limit(limit).
+			Find(&cards).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to fetch due cards"})
+			return
+		}
+
+		c.JSON(200, gin.H{"items": cards, "total": len(cards)})
+	}
+}
+
+// ReviewVocabulary records a review answer (quality 0-5) and reschedules the card
+func ReviewVocabulary(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var req struct {
+			Quality *int `json:"quality" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || *req.Quality < 0 || *req.Quality > 5 {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Quality must be between 0 and 5"})
+			return
+		}
+
+		var card VocabularyCard
+		if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&card).Error; err != nil {
+			c.AbortWithStatusJSON(404, gin.H{"error": "Word not found"})
+			return
+		}
+
+		card = reviewSM2(card, *req.Quality, time.Now())
+		if err := db.Model(&card).Select("ease_factor", "interval_days", "repetitions", "lapses", "due_at", "reviewed_at").
+			Updates(&card).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to save review"})
+			return
+		}
+
+		c.JSON(200, card)
+	}
+}
+
+// vocabularyBack renders the answer side of a card as Anki HTML
+func vocabularyBack(card VocabularyCard) string {
+	back := html.EscapeString(card.Alternative)
+	if card.Example != "" {
+		back += "<br><i>" + html.EscapeString(card.Example) + "</i>"
+	}
+	if card.Note != "" {
+		back += "<br>" + html.EscapeString(card.Note)
+	}
+	return back
+}
+
+// ExportVocabulary writes the notebook as an Anki-importable CSV file, or
+// as an Anki package with the review schedule for ?format=apkg
+func ExportVocabulary(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var cards []VocabularyCard
+		if err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&cards).Error; err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to export vocabulary"})
+			return
+		}
+
+		if c.Query("format") == "apkg" {
+			var buf bytes.Buffer
+			if err := writeAPKG(&buf, cards, time.Now()); err != nil {
+				c.AbortWithStatusJSON(500, gin.H{"error": "Failed to export vocabulary"})
+				return
+			}
+			c.Header("Content-Disposition", "attachment; filename=bandly-vocabulary.apkg")
+			c.Data(200, "application/apkg", buf.Bytes())
+			return
+		}
+
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", "attachment; filename=bandly-vocabulary.csv")
+
+		// Anki reads these header lines to configure the import
+		fmt.Fprint(c.Writer, "#separator:comma\n#html:true\n#columns:Front,Back,Tags\n#tags column:3\n")
+
+		w := csv.NewWriter(c.Writer)
+		for _, card := range cards {
+			tags := "bandly " + card.Source
+			w.Write([]string{html.EscapeString(card.Word), vocabularyBack(card), tags})
+		}
+		w.Flush()
+	}
+}
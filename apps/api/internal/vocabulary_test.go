@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestReviewSM2(t *testing.T) {
+	now := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	card := VocabularyCard{EaseFactor: 2.5}
+
+	// Three good answers in a row follow the 1, 6, 6*EF schedule
+	wantIntervals := []int{1, 6, 16}
+	for i, want := range wantIntervals {
+		card = reviewSM2(card, 5, now)
+		if card.IntervalDays != want {
+			t.Fatalf("review %d interval = %d, want %d", i+1, card.IntervalDays, want)
+		}
+	}
+	if card.EaseFactor != 2.8 {
+		t.Errorf("ease factor after three perfect answers = %v, want 2.8", card.EaseFactor)
+	}
+	if !card.DueAt.Equal(now.AddDate(0, 0, 16)) {
+		t.Errorf("due date = %v, want 16 days later", card.DueAt)
+	}
+
+	// A failed answer resets repetitions but keeps the ease floor
+	card = reviewSM2(card, 1, now)
+	if card.Repetitions != 0 || card.IntervalDays != 1 || card.Lapses != 1 {
+		t.Errorf("after lapse got repetitions=%d interval=%d lapses=%d", card.Repetitions, card.IntervalDays, card.Lapses)
+	}
+
+	for i := 0; i < 10; i++ {
+		card = reviewSM2(card, 0, now)
+	}
+	if card.EaseFactor != minEaseFactor {
+		t.Errorf("ease factor = %v, want floor %v", card.EaseFactor, minEaseFactor)
+	}
+}
+
+func TestSentenceAround(t *testing.T) {
+	text := "Cars are popular. People do a mistake when they buy them! Prices rise."
+	start := len("Cars are popular. People ")
+	end := start + len("do a mistake")
+
+	got := sentenceAround(text, start, end)
+	want := "People do a mistake when they buy them!"
+	if got != want {
+		t.Errorf("sentenceAround() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteAPKG(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cards := []VocabularyCard{
+		{ID: 1, Word: "make a research", Alternative: "do research", Example: "We did research.", Source: "feedback"},
+		{ID: 2, Word: "affect", Alternative: "effect", Source: "manual", Repetitions: 2, IntervalDays: 6,
+			EaseFactor: 2.36, Lapses: 1, DueAt: now.AddDate(0, 0, 4)},
+	}
+
+	var buf bytes.Buffer
+	if err := writeAPKG(&buf, cards, now); err != nil {
+		t.Fatalf("writeAPKG() error = %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "collection.anki2")
+	for _, f := range zr.File {
+		if f.Name != "collection.anki2" {
+			continue
+		}
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		os.WriteFile(path, data, 0o600)
+	}
+
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	db.Raw("SELECT ver FROM col").Scan(&version)
+	if version != 11 {
+		t.Errorf("collection version = %d, want 11", version)
+	}
+
+	var notes []struct {
+		GUID string
+		Flds string
+		Tags string
+	}
+	db.Raw("SELECT guid, flds, tags FROM notes ORDER BY id").Scan(&notes)
+	if len(notes) != 2 || notes[0].GUID != "bandly-1" || notes[0].Tags != " bandly feedback " {
+		t.Fatalf("notes = %+v", notes)
+	}
+	if want := "make a research\x1fdo research<br><i>We did research.</i>"; notes[0].Flds != want {
+		t.Errorf("fields = %q, want %q", notes[0].Flds, want)
+	}
+
+	var scheduled []struct {
+		Type, Due, Ivl, Factor, Reps, Lapses int
+	}
+	db.Raw("SELECT type, due, ivl, factor, reps, lapses FROM cards ORDER BY id").Scan(&scheduled)
+	if len(scheduled) != 2 || scheduled[0].Type != 0 || scheduled[0].Due != 1 {
+		t.Fatalf("cards = %+v, want a new card first", scheduled)
+	}
+	if review := scheduled[1]; review.Type != 2 || review.Due != 4 || review.Ivl != 6 || review.Factor != 2360 || review.Lapses != 1 {
+		t.Errorf("review card = %+v", review)
+	}
+}
@@ -0,0 +1,364 @@
+package internal
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sidigigroup/bandly/api/internal/limiter"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// voucherAlphabet leaves out characters that are easy to misread
+const voucherAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// voucherCodeLength is the length of generated codes, printed in groups of 4
+const voucherCodeLength = 12
+
+// maxVoucherBatch bounds the codes created at once
+const maxVoucherBatch = 1000
+
+// voucherAttemptLimit slows down guessing codes
+var voucherAttemptLimit = limiter.PerWindow(10, time.Hour)
+
+var (
+	errVoucherUnknown  = errors.New("unknown voucher code")
+	errVoucherExpired  = errors.New("voucher expired")
+	errVoucherUsedUp   = errors.New("voucher fully redeemed")
+	errVoucherRedeemed = errors.New("voucher already redeemed")
+	errVoucherOrg      = errors.New("voucher belongs to another organisation")
+)
+
+// VoucherBatch is a set of voucher codes worth the same credits, e.g. a
+// promotion or the codes handed to one school
+type VoucherBatch struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Name           string     `gorm:"not null" json:"name"`
+	OrganizationID *uint      `gorm:"index" json:"organizationId,omitempty"` // only its members may redeem, nil: anyone
+	Credits        int        `gorm:"not null" json:"credits"`               // per redemption
+	MaxRedemptions int        `gorm:"not null" json:"maxRedemptions"`        // per code
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	CreatedBy      uint       `json:"createdBy"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	Vouchers       []Voucher  `gorm:"foreignKey:BatchID" json:"vouchers,omitempty"`
+}
+
+// Voucher is one redeemable code
+type Voucher struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	BatchID     uint      `gorm:"index;not null" json:"batchId"`
+	Code        string    `gorm:"uniqueIndex;not null" json:"code"` // normalised, see normalizeVoucherCode
+	Redemptions int       `json:"redemptions"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// VoucherRedemption records a user redeeming a voucher. A user redeems at
+// most one code of each batch.
+type VoucherRedemption struct {
+	BatchID       uint `gorm:"primaryKey;autoIncrement:false"`
+	UserID        uint `gorm:"primaryKey;autoIncrement:false"`
+	VoucherID     uint `gorm:"index"`
+	TransactionID uint
+	CreatedAt     time.Time
+}
+
+// normalizeVoucherCode uppercases a code and drops everything but letters
+// and digits, so "abcd-efgh 2345" matches ABCDEFGH2345
+func normalizeVoucherCode(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// newVoucherCode generates a random code
+func newVoucherCode() (string, error) {
+	code := make([]byte, voucherCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(voucherAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = voucherAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// redeemVoucher credits a voucher to a user, checking the batch's expiry,
+// organisation and limits under a lock on the code
+func redeemVoucher(db *gorm.DB, user User, code string, now time.Time) (VoucherBatch, error) {
+	var batch VoucherBatch
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var voucher Voucher
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", code).First(&voucher).Error; err != nil {
+			return errVoucherUnknown
+		}
+		if err := tx.First(&batch, voucher.BatchID).Error; err != nil {
+			return err
+		}
+
+		switch {
+		case batch.RevokedAt != nil:
+			return errVoucherUnknown
+		case batch.ExpiresAt != nil && !now.Before(*batch.ExpiresAt):
+			return errVoucherExpired
+		case batch.OrganizationID != nil && (user.OrganizationID == nil || *user.OrganizationID != *batch.OrganizationID):
+			return errVoucherOrg
+		case voucher.Redemptions >= batch.MaxRedemptions:
+			return errVoucherUsedUp
+		}
+
+		redemption := VoucherRedemption{BatchID: batch.ID, UserID: user.ID, VoucherID: voucher.ID}
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&redemption)
+		if created.Error != nil {
+			return created.Error
+		}
+		if created.RowsAffected == 0 {
+			return errVoucherRedeemed
+		}
+
+		ref := fmt.Sprintf("voucher:%d:user:%d", batch.ID, user.ID)
+		txn, err := postCredits(tx, CreditTransaction{Kind: CreditVoucher, Reference: &ref, Memo: batch.Name},
+			map[string]int{creditsFromVouchers: -batch.Credits, userCreditAccount(user.ID): batch.Credits})
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&redemption).Where("batch_id = ? AND user_id = ?", batch.ID, user.ID).Update("transaction_id", txn.ID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&voucher).Update("redemptions", gorm.Expr("redemptions + 1")).Error
+	})
+	return batch, err
+}
+
+// RedeemVoucher adds a voucher's credits to the current user's balance
+func RedeemVoucher(db *gorm.DB, l *limiter.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(User)
+
+		res, ok := allowRequest(c, l, fmt.Sprintf("vouchers:user:%d", user.ID), voucherAttemptLimit)
+		if !ok {
+			return
+		}
+		if !res.Allowed {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, try again later"})
+			return
+		}
+
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+			return
+		}
+
+		batch, err := redeemVoucher(db, user, normalizeVoucherCode(req.Code), time.Now())
+		switch {
+		case errors.Is(err, errVoucherUnknown), errors.Is(err, errVoucherOrg):
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown voucher code"})
+			return
+		case errors.Is(err, errVoucherExpired), errors.Is(err, errVoucherUsedUp), errors.Is(err, errVoucherRedeemed):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to redeem voucher"})
+			return
+		}
+		auditEvent(c, "credits.voucher_redeemed", &user, gin.H{"batchId": batch.ID, "credits": batch.Credits})
+
+		c.JSON(http.StatusOK, gin.H{"credits": batch.Credits, "balance": creditBalance(db, user.ID)})
+	}
+}
+
+// CreateVoucherBatch creates a batch of voucher codes. Give code for a
+// single promo code of your choice. Tenant admins create batches for their
+// own organisation only.
+func CreateVoucherBatch(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := c.MustGet("adminUser").(User)
+
+		var req struct {
+			Name           string     `json:"name" binding:"required"`
+			Credits        int        `json:"credits" binding:"required"`
+			Count          int        `json:"count"`
+			MaxRedemptions int        `json:"maxRedemptions"`
+			ExpiresAt      *time.Time `json:"expiresAt"`
+			OrganizationID *uint      `json:"organizationId"`
+			Code           string     `json:"code"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name and credits are required"})
+			return
+		}
+		if req.Count == 0 {
+			req.Count = 1
+		}
+		if req.MaxRedemptions == 0 {
+			req.MaxRedemptions = 1
+		}
+		code := normalizeVoucherCode(req.Code)
+		switch {
+		case req.Credits <= 0 || req.Credits > maxCreditGrant:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("credits must be between 1 and %d", maxCreditGrant)})
+			return
+		case req.Count < 1 || req.Count > maxVoucherBatch:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("count must be between 1 and %d", maxVoucherBatch)})
+			return
+		case req.MaxRedemptions < 1:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "maxRedemptions must be at least 1"})
+			return
+		case req.Code != "" && (req.Count != 1 || len(code) < 4 || len(code) > 32):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a chosen code has 4-32 letters or digits and makes a batch of one"})
+			return
+		case req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiresAt must be in the future"})
+			return
+		}
+
+		// Tenant admins cannot pick another organisation
+		if orgID := currentOrgID(c); orgID != nil {
+			req.OrganizationID = orgID
+		} else if req.OrganizationID != nil {
+			if err := db.First(&Organization{}, *req.OrganizationID).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "organization not found"})
+				return
+			}
+		}
+
+		batch := VoucherBatch{
+			Name:           strings.TrimSpace(req.Name),
+			OrganizationID: req.OrganizationID,
+			Credits:        req.Credits,
+			MaxRedemptions: req.MaxRedemptions,
+			ExpiresAt:      req.ExpiresAt,
+			CreatedBy:      actor.ID,
+		}
+		for i := 0; i < req.Count; i++ {
+			if code == "" || i > 0 {
+				generated, err := newVoucherCode()
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate codes"})
+					return
+				}
+				code = generated
+			}
+			batch.Vouchers = append(batch.Vouchers, Voucher{Code: code})
+		}
+
+		if req.Code != "" {
+			var existing Voucher
+			if err := db.Where("code = ?", code).First(&existing).Error; err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "code already exists"})
+				return
+			}
+		}
+		if err := db.Create(&batch).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vouchers"})
+			return
+		}
+		summary := batch
+		summary.Vouchers = nil
+		auditChange(c, "voucher_batch.create", "voucher_batch", batch.ID, nil, summary)
+
+		c.JSON(http.StatusCreated, gin.H{"batch": batch})
+	}
+}
+
+// voucherBatchScope limits tenant admins to their organisation's batches.
+// Platform admins see every batch, as they create them for organisations.
+func voucherBatchScope(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if orgID := currentOrgID(c); orgID != nil {
+			return db.Where("organization_id = ?", *orgID)
+		}
+		return db
+	}
+}
+
+// GetVoucherBatches lists the batches with their redemption counts
+func GetVoucherBatches(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var batches []VoucherBatch
+		db.Scopes(voucherBatchScope(c)).Order("id DESC").Find(&batches)
+
+		type batchRow struct {
+			VoucherBatch
+			Codes       int `json:"codes"`
+			Redemptions int `json:"redemptions"`
+		}
+		ids := make([]uint, 0, len(batches))
+		for _, batch := range batches {
+			ids = append(ids, batch.ID)
+		}
+		var counts []struct {
+			BatchID     uint
+			Codes       int
+			Redemptions int
+		}
+		db.Model(&Voucher{}).Where("batch_id IN ?", ids).Select("batch_id, COUNT(*) AS codes, COALESCE(SUM(redemptions), 0) AS redemptions").
+			Group("batch_id").Scan(&counts)
+		byBatch := map[uint]int{}
+		for i, count := range counts {
+			byBatch[count.BatchID] = i
+		}
+
+		rows := make([]batchRow, 0, len(batches))
+		for _, batch := range batches {
+			row := batchRow{VoucherBatch: batch}
+			if i, ok := byBatch[batch.ID]; ok {
+				row.Codes, row.Redemptions = counts[i].Codes, counts[i].Redemptions
+			}
+			rows = append(rows, row)
+		}
+		c.JSON(http.StatusOK, gin.H{"batches": rows})
+	}
+}
+
+// GetVoucherBatch returns a batch with its codes, for handing them out
+func GetVoucherBatch(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var batch VoucherBatch
+		err := db.Scopes(voucherBatchScope(c)).Preload("Vouchers", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id ASC")
+		}).First(&batch, c.Param("id")).Error
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "voucher batch not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"batch": batch})
+	}
+}
+
+// RevokeVoucherBatch stops a batch's codes from being redeemed. Credits
+// already redeemed stay with the users.
+func RevokeVoucherBatch(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var batch VoucherBatch
+		if err := db.Scopes(voucherBatchScope(c)).First(&batch, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "voucher batch not found"})
+			return
+		}
+		if batch.RevokedAt == nil {
+			before := batch
+			now := time.Now()
+			batch.RevokedAt = &now
+			if err := db.Model(&batch).Update("revoked_at", now).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke vouchers"})
+				return
+			}
+			auditChange(c, "voucher_batch.revoke", "voucher_batch", batch.ID, before, batch)
+		}
+		c.JSON(http.StatusOK, gin.H{"batch": batch})
+	}
+}
@@ -1,52 +1,115 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/sidigigroup/bandly/api/internal"
-	"gorm.io/gorm"
 )
 
 func main() {
 	// Load environment variables
 	_ = godotenv.Load()
 
-	// Connect to database
-	var db *gorm.DB
+	// Connect to database, an in-memory SQLite one without DB_DSN
 	dsn := os.Getenv("DB_DSN")
 	if dsn == "" {
-		log.Println("Warning: DB_DSN not set, using in-memory mode")
-	} else {
-		database, err := internal.OpenDB(dsn)
-		if err != nil {
-			log.Printf("Database connection failed: %v (continuing without DB)", err)
-		} else {
-			if err := internal.AutoMigrate(database); err != nil {
-				log.Printf("Database migration failed: %v", err)
-			} else {
-				log.Println("Database connected and migrated successfully")
+		if gin.Mode() == gin.ReleaseMode {
+			log.Fatal("Refusing to start: DB_DSN is not set, data would only be kept in memory")
+		}
+		log.Println("Warning: DB_DSN not set, using an in-memory SQLite database")
+	}
+	db, err := internal.OpenDB(dsn)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+	// Postgres schemas are changed by `bandly migrate up`, never at boot
+	if err := internal.PrepareDB(context.Background(), db); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	log.Printf("Database connected (%s), schema up to date", db.Dialector.Name())
 
-				// Create default admin user
-				if err := internal.CreateDefaultAdmin(database); err != nil {
-					log.Printf("Failed to create default admin user: %v", err)
-				}
+	// Known default passwords must be changed before going live
+	defaults, err := internal.DefaultCredentialsInUse(db)
+	if err != nil {
+		log.Fatalf("Failed to check admin credentials: %v", err)
+	}
+	if len(defaults) > 0 {
+		msg := fmt.Sprintf("accounts with a known default password: %s (change them with `bandly admin set-password`)", strings.Join(defaults, ", "))
+		if gin.Mode() == gin.ReleaseMode {
+			log.Fatalf("Refusing to start: %s", msg)
+		}
+		log.Printf("Warning: %s", msg)
+	}
 
-				db = database
-			}
+	// A fresh database gets its first admin from the CLI or the setup endpoint
+	if required, tokenSet := internal.SetupRequired(db); required {
+		if tokenSet {
+			log.Println("No admin account yet: create one with POST /api/setup and SETUP_TOKEN")
+		} else {
+			log.Println("No admin account yet: run `bandly admin create` or set SETUP_TOKEN")
 		}
 	}
 
 	// Initialize Redis
 	rdb := internal.InitRedis()
 	if rdb == nil {
-		log.Println("Warning: Redis connection failed, rate limiting and caching disabled")
+		log.Println("Warning: Redis connection failed, rate limits kept in memory, caching and login lockout disabled")
 	} else {
 		log.Println("Redis connected successfully")
 	}
 
+	// One auth service signs and verifies every token
+	authService, err := internal.NewAuthService(db)
+	if err != nil {
+		log.Fatalf("Auth configuration failed: %v", err)
+	}
+
+	// Sign-in through external identity providers (Google, Microsoft, ...)
+	oidcProviders, err := internal.NewOIDCProviders()
+	if err != nil {
+		log.Fatalf("OIDC configuration failed: %v", err)
+	}
+
+	// Request rate limits (in memory without Redis)
+	rateLimiter, err := internal.NewRateLimiter(rdb)
+	if err != nil {
+		log.Fatalf("Rate limit configuration failed: %v", err)
+	}
+
+	// Failed login tracking (needs Redis)
+	loginGuard := internal.NewLoginGuard(rdb)
+
+	// Append-only trail of admin changes and auth events
+	auditor := internal.NewAuditor(db)
+
+	// Paid plans (nil when BILLING_PROVIDER is not set)
+	billing, err := internal.NewBilling()
+	if err != nil {
+		log.Fatalf("Billing configuration failed: %v", err)
+	}
+	if billing != nil {
+		internal.StartBillingSweeper(db, auditor, time.Hour)
+	}
+
+	// Credit prices and packs
+	credits, err := internal.NewCredits()
+	if err != nil {
+		log.Fatalf("Credits configuration failed: %v", err)
+	}
+
+	// Repositories and the services built on them
+	services := internal.NewServices(internal.NewRepos(db), rdb)
+
+	// Outgoing email (SMTP, or the log in development)
+	mailer := internal.NewMailer()
+
 	// Initialize Gin router
 	r := gin.Default()
 
@@ -55,32 +118,67 @@ func main() {
 
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
-		status := gin.H{"ok": true, "database": db != nil, "redis": rdb != nil}
+		status := gin.H{"ok": true, "database": db.Dialector.Name(), "redis": rdb != nil}
 		c.JSON(200, status)
 	})
 
+	// Public keys for verifying our tokens (RS256/EdDSA keys only)
+	r.GET("/.well-known/jwks.json", authService.JWKS())
+
 	// API routes
 	api := r.Group("/api")
+	api.Use(auditor.Middleware())
 	{
+		// First-run setup of the initial admin
+		api.GET("/setup", internal.GetSetupStatus(db))
+		api.POST("/setup", internal.CompleteSetup(db))
+
 		// Auth endpoints
 		auth := api.Group("/auth")
 		{
-			auth.POST("/signup", internal.Signup(db))
-			auth.POST("/login", internal.Login(db))
-			auth.GET("/profile", internal.JWTMiddleware(), internal.GetProfile(db))
+			auth.POST("/signup", internal.Signup(db, authService, mailer))
+			auth.POST("/login", internal.Login(db, authService, loginGuard))
+			auth.GET("/profile", authService.RequiredForEnrolment(), internal.GetProfile(db))
+			auth.POST("/refresh", internal.RefreshToken(db, authService))
+			auth.POST("/logout", authService.RequiredForEnrolment(), internal.Logout(db))
+			auth.POST("/logout-all", authService.Required(), internal.LogoutAll(db))
+			auth.GET("/sessions", authService.Required(), internal.GetSessions(db))
+			auth.DELETE("/sessions/:id", authService.Required(), internal.RevokeSession(db))
+
+			// Email verification and password reset
+			auth.POST("/verify-email", internal.VerifyEmail(db, authService))
+			auth.POST("/verify-email/resend", authService.Required(), internal.ResendVerification(db, authService, mailer))
+			auth.POST("/forgot-password", internal.ForgotPassword(db, authService, mailer))
+			auth.POST("/reset-password", internal.ResetPassword(db, authService))
+
+			// Single sign-on
+			auth.GET("/oidc", internal.GetOIDCProviders(oidcProviders))
+			auth.GET("/oidc/:provider/start", internal.OIDCStart(authService, oidcProviders))
+			auth.GET("/oidc/:provider/callback", internal.OIDCCallback(db, authService, oidcProviders))
+			auth.GET("/identities", authService.Required(), internal.GetIdentities(db))
+			auth.DELETE("/identities/:id", authService.Required(), internal.DeleteIdentity(db))
+
+			// Two-factor authentication
+			auth.POST("/mfa/verify", internal.VerifyMFA(db, authService))
+			auth.GET("/mfa", authService.RequiredForEnrolment(), internal.GetMFAStatus(db, authService))
+			auth.POST("/mfa/totp/setup", authService.RequiredForEnrolment(), internal.SetupTOTP(db))
+			auth.POST("/mfa/totp/enable", authService.RequiredForEnrolment(), internal.EnableTOTP(db))
+			auth.POST("/mfa/totp/disable", authService.Required(), internal.DisableTOTP(db, authService))
+			auth.POST("/mfa/recovery-codes", authService.Required(), internal.RegenerateRecoveryCodes(db))
 		} // Essay analysis (with optional auth and rate limiting)
 		essays := api.Group("/essays")
-		essays.Use(internal.OptionalAuth(db)) // Optional authentication
-		if rdb != nil {
-			essays.Use(internal.RateLimit(rdb)) // Rate limiting if Redis available
-		}
+		essays.Use(authService.Optional())                                            // Optional authentication
+		essays.Use(internal.APIKeyAuth(db, rateLimiter, internal.ScopeEssaysAnalyze)) // Or an API key
+		essays.Use(internal.RateLimit(rateLimiter))                                   // Per-IP limits
 		{
-			essays.POST("/analyze", internal.AnalyzeEssay(db, rdb))
+			essays.POST("/analyze", internal.AnalyzeEssay(db, rdb, credits))
 		}
 
-		// Public reports
+		// Public reports, and the report list of an API key's owner
 		reports := api.Group("/reports")
+		reports.Use(authService.Optional(), internal.APIKeyAuth(db, rateLimiter, internal.ScopeReportsRead))
 		{
+			reports.GET("", internal.GetAPIReports(db))
 			reports.GET("/:publicId/pdf", internal.ReportPDF(db))
 			reports.GET("/:publicId", internal.GetReport(db))
 			reports.GET("/:publicId/og-image", internal.OGImageHandler(db))
@@ -88,51 +186,164 @@ func main() {
 
 		// Analytics (with optional auth)
 		analytics := api.Group("/analytics")
-		analytics.Use(internal.OptionalAuth(db))
+		analytics.Use(authService.Optional())
 		{
-			analytics.POST("/event", internal.TrackEvent(db, rdb))
-			analytics.GET("/stats", internal.GetAnalytics(db, rdb))
+			analytics.POST("/event", internal.TrackEvent(services.Analytics))
+			analytics.GET("/stats", internal.GetAnalytics(services.Analytics))
 		}
 
 		// Feedback (with optional auth)
-		api.POST("/feedback", internal.OptionalAuth(db), internal.SubmitFeedback(db))
+		api.POST("/feedback", authService.Optional(), internal.SubmitFeedback(db))
 
 		// Protected user routes (require authentication)
-		if db != nil {
-			user := api.Group("/user")
-			user.Use(internal.JWTAuth(db)) // Require authentication
-			{
-				user.GET("/dashboard", internal.GetUserDashboard(db))
-				user.GET("/history", internal.GetUserHistory(db))
-				user.GET("/essays/:id", internal.GetEssayDetails(db))
-				user.DELETE("/essays/:id", internal.DeleteEssay(db))
-				user.PUT("/profile", internal.UpdateProfile(db))
-			}
+		user := api.Group("/user")
+		user.Use(authService.Required()) // Require authentication
+		{
+			user.GET("/dashboard", internal.GetUserDashboard(services.Essays))
+			user.GET("/history", internal.GetUserHistory(services.Essays))
+			user.GET("/progress", internal.GetUserProgress(db))
+			user.GET("/plan", internal.GetStudyPlan(db))
+			user.GET("/usage", internal.GetUserUsage(db))
+
+			// Subscription, invoices and plan purchases
+			user.GET("/billing", internal.GetBilling(db, billing))
+			user.POST("/billing/checkout", internal.CreateCheckout(db, billing))
+			user.POST("/billing/portal", internal.BillingPortal(db, billing))
+
+			// Pay-per-essay credits
+			user.GET("/credits", internal.GetCredits(db, credits, billing))
+			user.POST("/credits/checkout", internal.BuyCredits(db, credits, billing))
+			user.POST("/credits/redeem", internal.RedeemVoucher(db, rateLimiter))
+
+			// Vocabulary notebook
+			user.GET("/vocabulary", internal.GetVocabulary(db))
+			user.POST("/vocabulary", internal.AddVocabulary(db))
+			user.GET("/vocabulary/due", internal.GetDueVocabulary(db))
+			user.GET("/vocabulary/export", internal.ExportVocabulary(db))
+			user.POST("/vocabulary/:id/review", internal.ReviewVocabulary(db))
+			user.DELETE("/vocabulary/:id", internal.DeleteVocabulary(db))
+			user.GET("/essays/:id", internal.GetEssayDetails(db))
+			user.DELETE("/essays/:id", internal.DeleteEssay(services.Essays))
+			user.PUT("/profile", internal.UpdateProfile(db, authService, mailer))
+
+			// Personal API keys
+			user.GET("/api-keys", internal.GetAPIKeys(db, false))
+			user.POST("/api-keys", internal.CreateAPIKey(db, false))
+			user.DELETE("/api-keys/:id", internal.RevokeAPIKey(db, false))
+
+			// Teacher comments and bands (student owner or assignment teacher)
+			user.GET("/essays/:id/comments", internal.GetEssayComments(db))
+			user.POST("/essays/:id/comments", internal.AddEssayComment(db))
+			user.DELETE("/essays/:id/comments/:commentId", internal.DeleteEssayComment(db))
+			user.GET("/essays/:id/bands", internal.GetBandOverrides(db))
+			user.POST("/essays/:id/bands", internal.AddBandOverride(db))
+
+			user.GET("/notifications", internal.GetNotifications(db))
+			user.POST("/notifications/:id/read", internal.MarkNotificationRead(db))
+		}
+
+		// Payment provider events (authenticated by their signature)
+		api.POST("/billing/webhook", internal.BillingWebhookHandler(db, billing, credits))
+
+		// Question bank for teachers picking assignment prompts
+		api.GET("/questions", authService.Required(), internal.GetQuestions(db))
+
+		// Classrooms
+		classes := api.Group("/classes")
+		classes.Use(authService.Required())
+		{
+			classes.GET("", internal.GetClasses(db))
+			classes.POST("", internal.TeacherMiddleware(db), internal.CreateClass(db))
+			classes.POST("/join", internal.JoinClass(db))
+			classes.GET("/:id", internal.GetClass(db))
+			classes.DELETE("/:id/members/:userId", internal.TeacherMiddleware(db), internal.RemoveClassMember(db))
+			classes.POST("/:id/assignments", internal.TeacherMiddleware(db), internal.CreateAssignment(db))
+			classes.GET("/:id/analytics", internal.TeacherMiddleware(db), internal.GetClassAnalytics(db))
+		}
+
+		assignments := api.Group("/assignments")
+		assignments.Use(authService.Required())
+		{
+			assignments.GET("/:id", internal.GetAssignment(db))
+			assignments.POST("/:id/submit", internal.RateLimit(rateLimiter), internal.SubmitAssignment(db, rdb, credits))
+			assignments.GET("/:id/gradebook", internal.TeacherMiddleware(db), internal.GetGradebook(db))
+			assignments.GET("/:id/submissions/:essayId", internal.TeacherMiddleware(db), internal.GetSubmission(db))
+			assignments.GET("/:id/analytics", internal.TeacherMiddleware(db), internal.GetAssignmentAnalytics(db))
+			assignments.GET("/:id/peer-reviews", internal.GetPeerReviews(db))
+			assignments.POST("/:id/peer-reviews/:reviewId", internal.SubmitPeerReview(db))
+		}
+
+		// Admin routes (require admin authentication)
+		admin := api.Group("/sidigi")
+		admin.Use(authService.Required())       // Require authentication
+		admin.Use(internal.AdminMiddleware(db)) // Require a staff role
+		{
+			perm := internal.RequirePermission
+
+			// Dashboard
+			admin.GET("/dashboard", perm(internal.PermDashboardRead), internal.GetAdminDashboard(services.Admin))
+
+			// User management
+			admin.GET("/users", perm(internal.PermUsersRead), internal.GetAdminUsers(services.Admin))
+			admin.PUT("/users/:id", perm(internal.PermUsersWrite), internal.UpdateUser(services.Admin))
+			admin.DELETE("/users/:id", perm(internal.PermUsersWrite), internal.DeleteUser(services.Admin))
+			admin.POST("/users/:id/unlock", perm(internal.PermUsersWrite), internal.UnlockUser(db, loginGuard))
+			admin.PUT("/users/:id/role", perm(internal.PermRolesAssign), internal.UpdateUserRole(services.Admin))
+			admin.GET("/roles", perm(internal.PermUsersRead), internal.GetRoles())
+			admin.POST("/users/:id/credits", perm(internal.PermCreditsGrant), internal.GrantCredits(db))
+
+			// Voucher codes (tenant admins manage their organisation's)
+			admin.GET("/vouchers", perm(internal.PermVouchersManage), internal.GetVoucherBatches(db))
+			admin.POST("/vouchers", perm(internal.PermVouchersManage), internal.CreateVoucherBatch(db))
+			admin.GET("/vouchers/:id", perm(internal.PermVouchersManage), internal.GetVoucherBatch(db))
+			admin.DELETE("/vouchers/:id", perm(internal.PermVouchersManage), internal.RevokeVoucherBatch(db))
+
+			// Essays of all users
+			admin.GET("/essays", perm(internal.PermEssaysReadAll), internal.GetAdminEssays(db))
+			admin.GET("/essays/:id", perm(internal.PermEssaysReadAll), internal.GetAdminEssay(db))
+
+			// Blog management
+			admin.GET("/blog", perm(internal.PermBlogPublish), internal.GetAdminBlogPosts(services.Blog))
+			admin.POST("/blog", perm(internal.PermBlogPublish), internal.CreateBlogPost(services.Blog))
+			admin.PUT("/blog/:id", perm(internal.PermBlogPublish), internal.UpdateBlogPost(services.Blog))
+			admin.DELETE("/blog/:id", perm(internal.PermBlogPublish), internal.DeleteBlogPost(services.Blog))
+
+			// Prompt management
+			admin.GET("/prompts", perm(internal.PermPromptsEdit), internal.GetAdminPrompts(services.Prompts))
+			admin.POST("/prompts", perm(internal.PermPromptsEdit), internal.CreatePrompt(services.Prompts))
+			admin.PUT("/prompts/:id", perm(internal.PermPromptsEdit), internal.UpdatePrompt(services.Prompts))
+			admin.DELETE("/prompts/:id", perm(internal.PermPromptsEdit), internal.DeletePrompt(services.Prompts))
+
+			// Question bank management
+			admin.GET("/questions", perm(internal.PermQuestionsEdit), internal.GetAdminQuestions(db))
+			admin.POST("/questions", perm(internal.PermQuestionsEdit), internal.CreateQuestion(db))
+			admin.PUT("/questions/:id", perm(internal.PermQuestionsEdit), internal.UpdateQuestion(db))
+			admin.DELETE("/questions/:id", perm(internal.PermQuestionsEdit), internal.DeleteQuestion(db))
+
+			// Plans and their quotas (platform-wide)
+			admin.GET("/plans", perm(internal.PermPlansEdit), internal.PlatformAdminMiddleware(), internal.GetPlans(db))
+			admin.PUT("/plans/:name", perm(internal.PermPlansEdit), internal.PlatformAdminMiddleware(), internal.SavePlan(db))
+
+			// Audit log (verify walks the chain of every tenant)
+			admin.GET("/audit", perm(internal.PermAuditRead), internal.GetAuditLogs(db))
+			admin.GET("/audit/export", perm(internal.PermAuditRead), internal.ExportAuditLogs(db))
+			admin.GET("/audit/verify", perm(internal.PermAuditRead), internal.PlatformAdminMiddleware(), internal.VerifyAuditChain(db))
+
+			// Own organisation (tenant admins)
+			admin.GET("/organization", perm(internal.PermDashboardRead), internal.GetMyOrganization(db))
+			admin.PUT("/organization/branding", perm(internal.PermOrgManage), internal.UpdateMyBranding(db))
+			admin.GET("/organization/api-keys", perm(internal.PermOrgManage), internal.GetAPIKeys(db, true))
+			admin.POST("/organization/api-keys", perm(internal.PermOrgManage), internal.CreateAPIKey(db, true))
+			admin.DELETE("/organization/api-keys/:id", perm(internal.PermOrgManage), internal.RevokeAPIKey(db, true))
 
-			// Admin routes (require admin authentication)
-			admin := api.Group("/sidigi")
-			admin.Use(internal.JWTAuth(db))         // Require authentication
-			admin.Use(internal.AdminMiddleware(db)) // Require admin role
+			// Organisation management (platform admins)
+			orgs := admin.Group("/organizations")
+			orgs.Use(perm(internal.PermOrgManage), internal.PlatformAdminMiddleware())
 			{
-				// Dashboard
-				admin.GET("/dashboard", internal.GetAdminDashboard(db))
-
-				// User management
-				admin.GET("/users", internal.GetAdminUsers(db))
-				admin.PUT("/users/:id", internal.UpdateUser(db))
-				admin.DELETE("/users/:id", internal.DeleteUser(db))
-
-				// Blog management
-				admin.GET("/blog", internal.GetAdminBlogPosts(db))
-				admin.POST("/blog", internal.CreateBlogPost(db))
-				admin.PUT("/blog/:id", internal.UpdateBlogPost(db))
-				admin.DELETE("/blog/:id", internal.DeleteBlogPost(db))
-
-				// Prompt management
-				admin.GET("/prompts", internal.GetAdminPrompts(db))
-				admin.POST("/prompts", internal.CreatePrompt(db))
-				admin.PUT("/prompts/:id", internal.UpdatePrompt(db))
-				admin.DELETE("/prompts/:id", internal.DeletePrompt(db))
+				orgs.GET("", internal.GetOrganizations(db))
+				orgs.POST("", internal.CreateOrganization(db))
+				orgs.PUT("/:id", internal.UpdateOrganization(db))
+				orgs.POST("/:id/admins", internal.CreateOrganizationAdmin(db))
 			}
 		}
 	}
@@ -144,7 +355,7 @@ func main() {
 	}
 
 	log.Printf("API server starting on port %s", port)
-	log.Printf("Features: DB=%v, Redis=%v, Auth=%v", db != nil, rdb != nil, db != nil)
+	log.Printf("Features: DB=%s, Redis=%v", db.Dialector.Name(), rdb != nil)
 
 	if err := r.Run(":" + port); err != nil {
 		log.Fatal("Failed to start server:", err)
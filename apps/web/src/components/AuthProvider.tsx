@@ -1,6 +1,6 @@
 import { useState, useEffect, ReactNode } from 'react'
 import { User, AuthContext } from '../hooks/useAuth'
-import { apiConfig } from '../utils/api'
+import { apiConfig, saveSession, clearSession } from '../utils/api'
 
 interface AuthProviderProps {
   children: ReactNode
@@ -34,11 +34,11 @@ export function AuthProvider({ children }: AuthProviderProps) {
           role: userData.role
         })
       } else {
-        localStorage.removeItem('token')
+        clearSession()
       }
     } catch (error) {
       console.error('Failed to fetch user profile:', error)
-      localStorage.removeItem('token')
+      clearSession()
     } finally {
       setLoading(false)
     }
@@ -62,7 +62,7 @@ export function AuthProvider({ children }: AuthProviderProps) {
     }
 
     const data = await response.json()
-    localStorage.setItem('token', data.token)
+    saveSession(data)
     setUser({
       id: data.user.id,
       email: data.user.email,
@@ -83,7 +83,7 @@ export function AuthProvider({ children }: AuthProviderProps) {
     }
 
     const data = await response.json()
-    localStorage.setItem('token', data.token)
+    saveSession(data)
     setUser({
       id: data.user.id,
       email: data.user.email,
@@ -93,7 +93,9 @@ export function AuthProvider({ children }: AuthProviderProps) {
   }
 
   function logout() {
-    localStorage.removeItem('token')
+    // Revoke the session so its refresh token stops working
+    apiConfig.fetch('api/auth/logout', { method: 'POST' }).catch(() => {})
+    clearSession()
     setUser(null)
   }
 
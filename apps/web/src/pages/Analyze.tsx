@@ -73,7 +73,7 @@ export default function Analyze() {
         if (data.suggestLogin) {
           setNotification({
             type: 'info',
-            message: data.message || "You've used your 3 free analyses. Create an account to get 7 more today!",
+            message: data.message || "You've used your 3 free analyses. Create an account to keep going!",
             showLoginSuggestion: true
           })
         } else {
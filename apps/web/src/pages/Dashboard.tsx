@@ -1,6 +1,6 @@
 import { useState, useEffect } from 'react'
 import { useNavigate } from 'react-router-dom'
-import { apiConfig } from '../utils/api'
+import { apiConfig, clearSession } from '../utils/api'
 
 interface EssayHistory {
   id: number
@@ -65,7 +65,7 @@ export default function Dashboard() {
         const data = await response.json()
         setDashboard(data)
       } else if (response.status === 401) {
-        localStorage.removeItem('token')
+        clearSession()
         navigate('/login')
       } else {
         setError('Failed to load dashboard')
@@ -98,7 +98,7 @@ export default function Dashboard() {
   }
 
   const logout = () => {
-    localStorage.removeItem('token')
+    clearSession()
     navigate('/')
   }
 
@@ -1,6 +1,6 @@
 import { useState, useEffect } from 'react'
 import { useNavigate } from 'react-router-dom'
-import { apiConfig } from '../utils/api'
+import { apiConfig, clearSession } from '../utils/api'
 import analytics from '../utils/analytics'
 
 interface EssayHistory {
@@ -50,7 +50,7 @@ export default function History() {
         const data = await response.json()
         setEssays(data.items || [])
       } else if (response.status === 401) {
-        localStorage.removeItem('token')
+        clearSession()
         navigate('/login')
       } else {
         setError('Failed to load essay history')
@@ -1,7 +1,7 @@
 import { useState, useEffect } from 'react'
 import { useNavigate } from 'react-router-dom'
 import analytics from '../utils/analytics'
-import { apiConfig } from '../utils/api'
+import { apiConfig, clearSession } from '../utils/api'
 
 interface UserProfile {
   id: number
@@ -10,8 +10,36 @@ interface UserProfile {
   joinedAt: string
 }
 
+interface Invoice {
+  id: string
+  number: string
+  status: string
+  amount: number
+  currency: string
+  created: string
+  url?: string
+  pdf?: string
+}
+
+interface BillingInfo {
+  enabled: boolean
+  plan: string
+  plans: string[]
+  subscription?: {
+    plan: string
+    status: string
+    currentPeriodEnd?: string
+    cancelAtPeriodEnd: boolean
+    graceUntil?: string
+  }
+  invoices: Invoice[]
+  invoicesError?: string
+}
+
 export default function Profile() {
   const [profile, setProfile] = useState<UserProfile | null>(null)
+  const [billing, setBilling] = useState<BillingInfo | null>(null)
+  const [billingBusy, setBillingBusy] = useState(false)
   const [loading, setLoading] = useState(true)
   const [saving, setSaving] = useState(false)
   const [error, setError] = useState('')
@@ -28,8 +56,58 @@ export default function Profile() {
   useEffect(() => {
     analytics.trackPageView('/profile')
     fetchProfile()
+    fetchBilling()
   }, [])
 
+  const fetchBilling = async () => {
+    try {
+      const token = localStorage.getItem('token')
+      if (!token) return
+
+      const response = await apiConfig.fetch('api/user/billing', {
+        headers: {
+          'Authorization': `Bearer ${token}`
+        }
+      })
+      if (response.ok) {
+        setBilling(await response.json())
+      }
+    } catch (err) {
+      // The profile works without billing details
+    }
+  }
+
+  // openBilling asks the API for a checkout or portal link and follows it
+  const openBilling = async (path: string, body?: object) => {
+    setBillingBusy(true)
+    setError('')
+    try {
+      const token = localStorage.getItem('token')
+      const response = await apiConfig.fetch(path, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          'Authorization': `Bearer ${token}`
+        },
+        body: body ? JSON.stringify(body) : undefined
+      })
+      const data = await response.json()
+      if (response.ok && data.url) {
+        window.location.href = data.url
+        return
+      }
+      setError(data.error || 'Billing is unavailable right now')
+    } catch (err) {
+      setError('Network error. Please try again.')
+    }
+    setBillingBusy(false)
+  }
+
+  const formatAmount = (invoice: Invoice) => {
+    return new Intl.NumberFormat('en-US', { style: 'currency', currency: invoice.currency.toUpperCase() })
+      .format(invoice.amount / 100)
+  }
+
   const fetchProfile = async () => {
     try {
       const token = localStorage.getItem('token')
@@ -49,7 +127,7 @@ export default function Profile() {
         setProfile(data.user)
         setEmail(data.user.email)
       } else if (response.status === 401) {
-        localStorage.removeItem('token')
+        clearSession()
         navigate('/login')
       } else {
         setError('Failed to load profile')
@@ -151,7 +229,7 @@ export default function Profile() {
 
       if (response.ok) {
         analytics.trackFunnelStep('account_deleted')
-        localStorage.removeItem('token')
+        clearSession()
         alert('Your account has been deleted successfully.')
         navigate('/')
       } else {
@@ -164,7 +242,7 @@ export default function Profile() {
   }
 
   const logout = () => {
-    localStorage.removeItem('token')
+    clearSession()
     navigate('/')
   }
 
@@ -251,6 +329,81 @@ export default function Profile() {
           </div>
         </div>
 
+        {/* Billing */}
+        {billing?.enabled && (
+          <div className="bg-white rounded-xl shadow-sm border border-slate-100 p-6 mb-8">
+            <h2 className="text-xl font-semibold text-slate-800 mb-4">Billing</h2>
+
+            {billing.subscription && (
+              <div className="mb-4 text-slate-700">
+                <div>
+                  {billing.subscription.plan.charAt(0).toUpperCase() + billing.subscription.plan.slice(1)} subscription
+                  {' '}<span className="text-slate-500">({billing.subscription.status.replace('_', ' ')})</span>
+                </div>
+                {billing.subscription.graceUntil && billing.subscription.status !== 'canceled' && (
+                  <div className="text-amber-700 text-sm mt-1">
+                    Your last payment failed. Update your payment details before {formatDate(billing.subscription.graceUntil)} to keep your plan.
+                  </div>
+                )}
+                {billing.subscription.currentPeriodEnd && (billing.subscription.cancelAtPeriodEnd || billing.subscription.status === 'canceled') && (
+                  <div className="text-slate-500 text-sm mt-1">
+                    Ends on {formatDate(billing.subscription.currentPeriodEnd)}
+                  </div>
+                )}
+              </div>
+            )}
+
+            <div className="flex gap-4 mb-6">
+              {billing.plan === 'free' && billing.plans.map((plan) => (
+                <button
+                  key={plan}
+                  disabled={billingBusy}
+                  onClick={() => openBilling('api/user/billing/checkout', { plan })}
+                  className="px-4 py-2 bg-brand text-white rounded-lg hover:bg-brand/90 disabled:opacity-50 font-medium"
+                >
+                  Upgrade to {plan.charAt(0).toUpperCase() + plan.slice(1)}
+                </button>
+              ))}
+              {billing.subscription && (
+                <button
+                  disabled={billingBusy}
+                  onClick={() => openBilling('api/user/billing/portal')}
+                  className="px-4 py-2 border border-slate-200 text-slate-700 rounded-lg hover:bg-slate-50 disabled:opacity-50 font-medium"
+                >
+                  Manage Subscription
+                </button>
+              )}
+            </div>
+
+            <h3 className="text-lg font-medium text-slate-800 mb-2">Invoices</h3>
+            {billing.invoicesError && <div className="text-sm text-slate-500">{billing.invoicesError}</div>}
+            {!billing.invoicesError && billing.invoices.length === 0 && (
+              <div className="text-sm text-slate-500">No invoices yet</div>
+            )}
+            {billing.invoices.length > 0 && (
+              <table className="w-full text-sm">
+                <tbody>
+                  {billing.invoices.map((invoice) => (
+                    <tr key={invoice.id} className="border-t border-slate-100">
+                      <td className="py-2 text-slate-700">{formatDate(invoice.created)}</td>
+                      <td className="py-2 text-slate-500">{invoice.number}</td>
+                      <td className="py-2 text-slate-700">{formatAmount(invoice)}</td>
+                      <td className="py-2 text-slate-500 capitalize">{invoice.status}</td>
+                      <td className="py-2 text-right">
+                        {invoice.url && (
+                          <a href={invoice.url} target="_blank" rel="noopener noreferrer" className="text-brand hover:underline">
+                            View
+                          </a>
+                        )}
+                      </td>
+                    </tr>
+                  ))}
+                </tbody>
+              </table>
+            )}
+          </div>
+        )}
+
         {/* Update Profile Form */}
         <div className="bg-white rounded-xl shadow-sm border border-slate-100 p-6 mb-8">
           <h2 className="text-xl font-semibold text-slate-800 mb-4">Update Profile</h2>
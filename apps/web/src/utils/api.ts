@@ -1,10 +1,63 @@
 // API Configuration
 const API_BASE_URL = import.meta.env.VITE_BASE_API_URL || 'http://localhost:8080'
 
+// Endpoints whose 401 means bad credentials, not an expired access token
+const NO_REFRESH_ENDPOINTS = ['api/auth/login', 'api/auth/signup', 'api/auth/refresh']
+
+interface Session {
+  token: string
+  refreshToken?: string
+}
+
+// Store the tokens from a login, signup or refresh response
+export function saveSession(session: Session) {
+  localStorage.setItem('token', session.token)
+  if (session.refreshToken) {
+    localStorage.setItem('refreshToken', session.refreshToken)
+  }
+}
+
+export function clearSession() {
+  localStorage.removeItem('token')
+  localStorage.removeItem('refreshToken')
+}
+
+// Only one refresh runs at a time: the API rotates the refresh token, and
+// presenting the old one twice looks like a stolen token and ends the session
+let refreshing: Promise<string | null> | null = null
+
+function refreshAccessToken(): Promise<string | null> {
+  const refreshToken = localStorage.getItem('refreshToken')
+  if (!refreshing && refreshToken) {
+    refreshing = (async () => {
+      try {
+        const response = await fetch(apiConfig.getUrl('api/auth/refresh'), {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refreshToken }),
+        })
+        if (!response.ok) {
+          clearSession()
+          return null
+        }
+        const data = await response.json()
+        saveSession(data)
+        return data.token as string
+      } catch (error) {
+        console.error('Failed to refresh session:', error)
+        return null
+      } finally {
+        refreshing = null
+      }
+    })()
+  }
+  return refreshing ?? Promise.resolve(null)
+}
+
 // API utility functions
 export const apiConfig = {
   baseURL: API_BASE_URL,
-  
+
   // Helper function to construct full API URLs
   getUrl: (endpoint: string): string => {
     // Remove leading slash if present to avoid double slashes
@@ -12,10 +65,11 @@ export const apiConfig = {
     return `${API_BASE_URL}/${cleanEndpoint}`
   },
 
-  // Helper function for fetch with default options
+  // Helper function for fetch with default options. Access tokens are
+  // short-lived: on a 401 the session is refreshed once and the request retried.
   fetch: async (endpoint: string, options: RequestInit = {}): Promise<Response> => {
     const url = apiConfig.getUrl(endpoint)
-    
+
     const defaultOptions: RequestInit = {
       headers: {
         'Content-Type': 'application/json',
@@ -33,7 +87,25 @@ export const apiConfig = {
       }
     }
 
-    return fetch(url, defaultOptions)
+    const response = await fetch(url, defaultOptions)
+    const cleanEndpoint = endpoint.startsWith('/') ? endpoint.slice(1) : endpoint
+    if (response.status !== 401 || !token || NO_REFRESH_ENDPOINTS.includes(cleanEndpoint)) {
+      return response
+    }
+
+    // Another request may have refreshed the session while this one ran
+    const current = localStorage.getItem('token')
+    const fresh = current && current !== token ? current : await refreshAccessToken()
+    if (!fresh) {
+      return response
+    }
+    return fetch(url, {
+      ...defaultOptions,
+      headers: {
+        ...defaultOptions.headers,
+        'Authorization': `Bearer ${fresh}`,
+      },
+    })
   }
 }
 